@@ -0,0 +1,104 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+)
+
+// ConversionDataAnnotation holds the spec fields of a v1beta1 DeviceConfig
+// that cannot be represented in v1alpha1, so that converting it to v1alpha1
+// and back does not lose them. The status is not kept, as it is recomputed by
+// the controller, and would not fit in an annotation.
+const ConversionDataAnnotation = "habana.ai/conversion-data"
+
+type conversionData struct {
+	Spec hlaiv1beta1.DeviceConfigSpec `json:"spec"`
+}
+
+// ConvertTo converts this DeviceConfig to the Hub version (v1beta1).
+func (src *DeviceConfig) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*hlaiv1beta1.DeviceConfig)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 DeviceConfig but got a %T", dstRaw)
+	}
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	restored := &conversionData{}
+	if data, ok := dst.Annotations[ConversionDataAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), restored); err != nil {
+			return fmt.Errorf("failed to unmarshal %s annotation: %w", ConversionDataAnnotation, err)
+		}
+
+		delete(dst.Annotations, ConversionDataAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	dst.Spec = restored.Spec
+	dst.Spec.Driver.Image = src.Spec.DriverImage
+	dst.Spec.Driver.Version = src.Spec.DriverVersion
+	dst.Spec.NodeSelector = src.Spec.NodeSelector
+	dst.Status.Conditions = src.Status.Conditions
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *DeviceConfig) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*hlaiv1beta1.DeviceConfig)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 DeviceConfig but got a %T", srcRaw)
+	}
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec.DriverImage = src.Spec.Driver.Image
+	dst.Spec.DriverVersion = src.Spec.Driver.Version
+	dst.Spec.NodeSelector = src.Spec.NodeSelector
+	dst.Status.Conditions = src.Status.Conditions
+
+	// Only annotate the DeviceConfig when converting it back would otherwise
+	// lose spec fields, so that plain v1alpha1 DeviceConfigs stay untouched.
+	kept := &conversionData{Spec: *src.Spec.DeepCopy()}
+	kept.Spec.Driver.Image = ""
+	kept.Spec.Driver.Version = ""
+	kept.Spec.NodeSelector = nil
+	if equality.Semantic.DeepEqual(kept, &conversionData{}) {
+		return nil
+	}
+
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s annotation: %w", ConversionDataAnnotation, err)
+	}
+
+	if dst.Annotations == nil {
+		dst.Annotations = make(map[string]string, 1)
+	}
+	dst.Annotations[ConversionDataAnnotation] = string(data)
+
+	return nil
+}
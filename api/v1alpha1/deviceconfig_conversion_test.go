@@ -0,0 +1,188 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+)
+
+var _ = Describe("DeviceConfig conversion", func() {
+	var (
+		conditions []metav1.Condition
+	)
+
+	BeforeEach(func() {
+		conditions = []metav1.Condition{
+			{
+				Type:   "Ready",
+				Status: metav1.ConditionTrue,
+				Reason: "Reconciled",
+			},
+		}
+	})
+
+	Describe("ConvertTo", func() {
+		It("should convert a v1alpha1 DeviceConfig to v1beta1", func() {
+			src := &DeviceConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-device-config", Namespace: "a-namespace"},
+				Spec: DeviceConfigSpec{
+					DriverImage:   "registry.example.com/habana-ai-driver",
+					DriverVersion: "1.6.0-439",
+					NodeSelector:  map[string]string{"some": "label"},
+				},
+				Status: DeviceConfigStatus{Conditions: conditions},
+			}
+
+			dst := &hlaiv1beta1.DeviceConfig{}
+			Expect(src.ConvertTo(dst)).To(Succeed())
+
+			Expect(dst.ObjectMeta).To(Equal(src.ObjectMeta))
+			Expect(dst.Spec.Driver.Image).To(Equal(src.Spec.DriverImage))
+			Expect(dst.Spec.Driver.Version).To(Equal(src.Spec.DriverVersion))
+			Expect(dst.Spec.NodeSelector).To(Equal(src.Spec.NodeSelector))
+			Expect(dst.Status.Conditions).To(Equal(conditions))
+		})
+
+		It("should return an error with a malformed conversion annotation", func() {
+			src := &DeviceConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "a-device-config",
+					Annotations: map[string]string{ConversionDataAnnotation: "{"},
+				},
+			}
+
+			Expect(src.ConvertTo(&hlaiv1beta1.DeviceConfig{})).ToNot(Succeed())
+		})
+	})
+
+	Describe("ConvertFrom", func() {
+		It("should not annotate a DeviceConfig without v1beta1-only fields", func() {
+			src := &hlaiv1beta1.DeviceConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-device-config"},
+				Spec: hlaiv1beta1.DeviceConfigSpec{
+					Driver: hlaiv1beta1.DriverSpec{
+						Image:   "registry.example.com/habana-ai-driver",
+						Version: "1.6.0-439",
+					},
+				},
+				Status: hlaiv1beta1.DeviceConfigStatus{Conditions: conditions},
+			}
+
+			dst := &DeviceConfig{}
+			Expect(dst.ConvertFrom(src)).To(Succeed())
+
+			Expect(dst.Annotations).To(BeEmpty())
+			Expect(dst.Spec.DriverImage).To(Equal(src.Spec.Driver.Image))
+			Expect(dst.Spec.DriverVersion).To(Equal(src.Spec.Driver.Version))
+			Expect(dst.Status.Conditions).To(Equal(conditions))
+		})
+
+		It("should annotate a DeviceConfig with v1beta1-only fields", func() {
+			src := &hlaiv1beta1.DeviceConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-device-config"},
+				Spec: hlaiv1beta1.DeviceConfigSpec{
+					Driver:       hlaiv1beta1.DriverSpec{Version: "1.6.0-439"},
+					DevicePlugin: hlaiv1beta1.DevicePluginSpec{Image: "registry.example.com/device-plugin:1.6.0"},
+				},
+			}
+
+			dst := &DeviceConfig{}
+			Expect(dst.ConvertFrom(src)).To(Succeed())
+
+			Expect(dst.Annotations).To(HaveKey(ConversionDataAnnotation))
+			Expect(src.Annotations).To(BeEmpty())
+		})
+
+		It("should only annotate a DeviceConfig with the spec fields v1alpha1 cannot represent", func() {
+			src := &hlaiv1beta1.DeviceConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-device-config"},
+				Spec: hlaiv1beta1.DeviceConfigSpec{
+					Driver:       hlaiv1beta1.DriverSpec{Version: "1.6.0-439"},
+					DevicePlugin: hlaiv1beta1.DevicePluginSpec{Image: "registry.example.com/device-plugin:1.6.0"},
+				},
+				Status: hlaiv1beta1.DeviceConfigStatus{
+					Conditions: conditions,
+					Nodes:      []hlaiv1beta1.NodeStatus{{Name: "a-node"}},
+				},
+			}
+
+			dst := &DeviceConfig{}
+			Expect(dst.ConvertFrom(src)).To(Succeed())
+
+			data := dst.Annotations[ConversionDataAnnotation]
+			Expect(data).To(ContainSubstring("registry.example.com/device-plugin:1.6.0"))
+			Expect(data).ToNot(ContainSubstring("1.6.0-439"))
+			Expect(data).ToNot(ContainSubstring("a-node"))
+		})
+	})
+
+	Describe("round trip", func() {
+		It("should not lose the v1beta1-only fields", func() {
+			hub := &hlaiv1beta1.DeviceConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "a-device-config",
+					Namespace:   "a-namespace",
+					Annotations: map[string]string{"some": "annotation"},
+				},
+				Spec: hlaiv1beta1.DeviceConfigSpec{
+					Driver: hlaiv1beta1.DriverSpec{
+						Image:   "registry.example.com/habana-ai-driver",
+						Version: "1.6.0-439",
+					},
+					DevicePlugin: hlaiv1beta1.DevicePluginSpec{Image: "registry.example.com/device-plugin:1.6.0"},
+					NodeLabeler:  hlaiv1beta1.NodeLabelerSpec{Image: "registry.example.com/node-labeler:1.6.0"},
+					NodeMetrics:  hlaiv1beta1.NodeMetricsSpec{Image: "registry.example.com/node-metrics:1.6.0"},
+					NodeSelector: map[string]string{corev1.LabelHostname: "a-node"},
+				},
+				Status: hlaiv1beta1.DeviceConfigStatus{Conditions: conditions},
+			}
+
+			spoke := &DeviceConfig{}
+			Expect(spoke.ConvertFrom(hub)).To(Succeed())
+
+			restored := &hlaiv1beta1.DeviceConfig{}
+			Expect(spoke.ConvertTo(restored)).To(Succeed())
+
+			Expect(restored).To(Equal(hub))
+		})
+
+		It("should keep the changes made to the v1alpha1 DeviceConfig", func() {
+			hub := &hlaiv1beta1.DeviceConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-device-config"},
+				Spec: hlaiv1beta1.DeviceConfigSpec{
+					Driver:      hlaiv1beta1.DriverSpec{Version: "1.6.0-439"},
+					NodeMetrics: hlaiv1beta1.NodeMetricsSpec{Image: "registry.example.com/node-metrics:1.6.0"},
+				},
+			}
+
+			spoke := &DeviceConfig{}
+			Expect(spoke.ConvertFrom(hub)).To(Succeed())
+
+			spoke.Spec.DriverVersion = "1.8.0-690"
+
+			restored := &hlaiv1beta1.DeviceConfig{}
+			Expect(spoke.ConvertTo(restored)).To(Succeed())
+
+			Expect(restored.Spec.Driver.Version).To(Equal("1.8.0-690"))
+			Expect(restored.Spec.NodeMetrics).To(Equal(hub.Spec.NodeMetrics))
+			Expect(restored.Annotations).To(BeEmpty())
+		})
+	})
+})
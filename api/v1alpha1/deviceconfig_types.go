@@ -17,22 +17,15 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"fmt"
-
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-const (
-	DeviceConfigDeletionFinalizer = "device-config-deletion-finalizer"
-
-	HabanaPCIVendorID = "1da3"
-)
-
 // DeviceConfigSpec defines the desired state of DeviceConfig
 type DeviceConfigSpec struct {
-	//+kubebuilder:validation:Required
-	// DriverImage is the Habana driver image to use
-	DriverImage string `json:"driverImage"`
+	//+kubebuilder:validation:Optional
+	// DriverImage is the Habana driver image to use. It defaults to the
+	// operator's DRIVER_HABANA_IMAGE_BASENAME.
+	DriverImage string `json:"driverImage,omitempty"`
 	//+kubebuilder:validation:Required
 	// DriverVersion is the Habana driver version deployed
 	DriverVersion string `json:"driverVersion"`
@@ -49,6 +42,7 @@ type DeviceConfigStatus struct {
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:deprecatedversion:warning="habana.ai/v1alpha1 DeviceConfig is deprecated, use habana.ai/v1beta1 DeviceConfig"
 
 // DeviceConfig is the Schema for the deviceconfigs API
 type DeviceConfig struct {
@@ -71,19 +65,3 @@ type DeviceConfigList struct {
 func init() {
 	SchemeBuilder.Register(&DeviceConfig{}, &DeviceConfigList{})
 }
-
-func (dc *DeviceConfig) GetNodeSelector(deviceType ...string) map[string]string {
-	ns := dc.Spec.NodeSelector
-	if ns == nil {
-		ns = make(map[string]string, 0)
-		// If no DeviceConfig.NodeSelector is specified, let's try adding NFD labels, otherwise
-		// the daemonset would be deployed on every schedulable node.
-		switch deviceType := ""; deviceType {
-		case "gaudi":
-			ns[fmt.Sprintf("habana.ai/hpu.%s.present", deviceType)] = "true"
-		default:
-			ns[fmt.Sprintf("feature.node.kubernetes.io/pci-%s.present", HabanaPCIVendorID)] = "true"
-		}
-	}
-	return ns
-}
@@ -0,0 +1,1369 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	DeviceConfigDeletionFinalizer = "device-config-deletion-finalizer"
+
+	HabanaPCIVendorID = "1da3"
+
+	// NodeFeaturePCIVendorLabel is set by NFD on the nodes with a Habana PCI
+	// device, when it labels the PCI devices with their vendor only.
+	NodeFeaturePCIVendorLabel = "feature.node.kubernetes.io/pci-" + HabanaPCIVendorID + ".present"
+
+	// DefaultProgressDeadlineSeconds is the progress deadline of a DeviceConfig
+	// not specifying one.
+	DefaultProgressDeadlineSeconds int32 = 1800
+
+	// DefaultDrainTimeoutSeconds and DefaultValidationTimeoutSeconds are the
+	// timeouts of an upgrade policy not specifying them.
+	DefaultDrainTimeoutSeconds      int32 = 600
+	DefaultValidationTimeoutSeconds int32 = 600
+
+	// DefaultPrePullTimeoutSeconds is the timeout of a pre-pull not
+	// specifying it.
+	DefaultPrePullTimeoutSeconds int32 = 1800
+
+	// DefaultCanaryHealthySeconds is the time the canary nodes of a canary
+	// not specifying it stay healthy before it is promoted.
+	DefaultCanaryHealthySeconds int32 = 300
+
+	// MaxDriverHistory is the number of entries kept in the driver history.
+	MaxDriverHistory = 10
+)
+
+//+kubebuilder:validation:Enum=gaudi;gaudi2;gaudi3
+
+// DeviceType is a generation of Habana AI processors
+type DeviceType string
+
+const (
+	DeviceTypeGaudi  DeviceType = "gaudi"
+	DeviceTypeGaudi2 DeviceType = "gaudi2"
+	DeviceTypeGaudi3 DeviceType = "gaudi3"
+)
+
+// DeviceTypes are the supported device types.
+var DeviceTypes = []DeviceType{DeviceTypeGaudi, DeviceTypeGaudi2, DeviceTypeGaudi3}
+
+// PCIDeviceTypes maps the PCI device IDs of the HabanaPCIVendorID devices to
+// their device type.
+var PCIDeviceTypes = map[string]DeviceType{
+	"1000": DeviceTypeGaudi,
+	"1010": DeviceTypeGaudi, // Gaudi with secured firmware
+	"1020": DeviceTypeGaudi2,
+	"1060": DeviceTypeGaudi3,
+}
+
+// GetPCIDeviceIDs returns the sorted PCI device IDs of the devices of type t.
+func (t DeviceType) GetPCIDeviceIDs() []string {
+	ids := []string{}
+	for id, dt := range PCIDeviceTypes {
+		if dt == t {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// GetNodeFeatureLabels returns the labels set by NFD on the nodes with a
+// device of type t, when it labels the PCI devices with their vendor and
+// device IDs.
+func (t DeviceType) GetNodeFeatureLabels() []string {
+	ids := t.GetPCIDeviceIDs()
+	labels := make([]string, 0, len(ids))
+	for _, id := range ids {
+		labels = append(labels, fmt.Sprintf("feature.node.kubernetes.io/pci-%s_%s.present", HabanaPCIVendorID, id))
+	}
+
+	return labels
+}
+
+// GetResourceName returns the extended resource advertised by the device
+// plugin for the devices of type t.
+func (t DeviceType) GetResourceName() corev1.ResourceName {
+	return corev1.ResourceName(fmt.Sprintf("habana.ai/%s", t))
+}
+
+//+kubebuilder:validation:Enum=rhcos;rhel;ubuntu
+
+// KernelMappingPreset is a built-in kernel mapping for a Linux distribution
+type KernelMappingPreset string
+
+const (
+	// KernelMappingPresetRHCOS matches the Red Hat CoreOS kernels the driver
+	// has always been deployed on, e.g. 4.18.0-372.26.1.el8_6.x86_64
+	KernelMappingPresetRHCOS KernelMappingPreset = "rhcos"
+	// KernelMappingPresetRHEL matches the Red Hat Enterprise Linux kernels,
+	// including the ones with a two-digit minor release, e.g. 5.14.0-570.el9_10.x86_64
+	KernelMappingPresetRHEL KernelMappingPreset = "rhel"
+	// KernelMappingPresetUbuntu matches the Ubuntu kernels of any flavour,
+	// e.g. 5.15.0-76-generic
+	KernelMappingPresetUbuntu KernelMappingPreset = "ubuntu"
+)
+
+// KernelMappingPresetRegexps are the kernel regexps of the presets.
+var KernelMappingPresetRegexps = map[KernelMappingPreset]string{
+	KernelMappingPresetRHCOS:  `^.*\.el\d_?\d?\..*$`,
+	KernelMappingPresetRHEL:   `^.*\.el\d+(_\d+)?\..*$`,
+	KernelMappingPresetUbuntu: `^\d+\.\d+\.\d+-\d+-[a-z][a-z0-9-]*$`,
+}
+
+// DefaultKernelMappingPresets are the kernel mappings of a DeviceConfig not
+// specifying any, in the order they are matched.
+var DefaultKernelMappingPresets = []KernelMappingPreset{
+	KernelMappingPresetRHCOS,
+	KernelMappingPresetRHEL,
+	KernelMappingPresetUbuntu,
+}
+
+// RegistryTLS defines how to access an image registry
+type RegistryTLS struct {
+	//+kubebuilder:validation:Optional
+	// Insecure allows accessing the registry over plain HTTP
+	Insecure bool `json:"insecure,omitempty"`
+	//+kubebuilder:validation:Optional
+	// InsecureSkipTLSVerify accepts any certificate provided by the registry
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+// BuildArg is a variable passed to the driver image build
+type BuildArg struct {
+	//+kubebuilder:validation:Required
+	// Name is the name of the build argument
+	Name string `json:"name"`
+	//+kubebuilder:validation:Optional
+	// Value is the value of the build argument
+	Value string `json:"value,omitempty"`
+}
+
+// DriverBuildSpec defines how to build the driver image in the cluster when it
+// does not exist yet
+type DriverBuildSpec struct {
+	//+kubebuilder:validation:Optional
+	// DockerfileConfigMap is the ConfigMap, in the DeviceConfig namespace,
+	// holding the Dockerfile of the driver image in its dockerfile key. It
+	// defaults to a ConfigMap managed by the operator, building the driver
+	// from the habanalabs sources.
+	DockerfileConfigMap *corev1.LocalObjectReference `json:"dockerfileConfigMap,omitempty"`
+	//+kubebuilder:validation:Optional
+	// BuildArgs are the variables passed to the build
+	BuildArgs []BuildArg `json:"buildArgs,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Secrets are made available to the build, e.g. to access private
+	// repositories
+	Secrets []corev1.LocalObjectReference `json:"secrets,omitempty"`
+	//+kubebuilder:validation:Optional
+	// BaseImageRegistryTLS defines how to access the registries of the base
+	// images of the Dockerfile
+	BaseImageRegistryTLS RegistryTLS `json:"baseImageRegistryTLS,omitempty"`
+}
+
+// DriverSignSpec defines how to sign the driver kernel modules for Secure Boot
+type DriverSignSpec struct {
+	//+kubebuilder:validation:Optional
+	// UnsignedImage is the template of the image to sign, with the same
+	// variables as the kernel mapping container image. It is ignored for the
+	// images built in the cluster, which are signed once built.
+	UnsignedImage string `json:"unsignedImage,omitempty"`
+	//+kubebuilder:validation:Optional
+	// UnsignedImageRegistryTLS defines how to access the registry of the
+	// image to sign
+	UnsignedImageRegistryTLS RegistryTLS `json:"unsignedImageRegistryTLS,omitempty"`
+	//+kubebuilder:validation:Required
+	// KeySecret is the secret, in the DeviceConfig namespace, holding the
+	// private signing key in its key key
+	KeySecret corev1.LocalObjectReference `json:"keySecret"`
+	//+kubebuilder:validation:Required
+	// CertSecret is the secret, in the DeviceConfig namespace, holding the
+	// public signing certificate in its cert key
+	CertSecret corev1.LocalObjectReference `json:"certSecret"`
+	//+kubebuilder:validation:Optional
+	// FilesToSign are the paths of the kernel modules to sign in the image,
+	// where ${KERNEL_FULL_VERSION} is replaced by the kernel version. They
+	// default to the habanalabs modules.
+	FilesToSign []string `json:"filesToSign,omitempty"`
+}
+
+// ModprobeArgs are the modprobe arguments used to load and unload the driver
+type ModprobeArgs struct {
+	//+kubebuilder:validation:Optional
+	// Load are the arguments used to load the driver
+	Load []string `json:"load,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Unload are the arguments used to unload the driver
+	Unload []string `json:"unload,omitempty"`
+}
+
+// DriverModprobeSpec defines how the driver is loaded on the nodes
+type DriverModprobeSpec struct {
+	//+kubebuilder:validation:Optional
+	// Parameters are the habanalabs module parameters, in the key=value form
+	Parameters []string `json:"parameters,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Args replace the default modprobe arguments, -v to load and -rv to unload
+	Args *ModprobeArgs `json:"args,omitempty"`
+	//+kubebuilder:validation:Optional
+	// RawArgs are passed as is to modprobe, ignoring the other fields but the
+	// firmware path
+	RawArgs *ModprobeArgs `json:"rawArgs,omitempty"`
+	//+kubebuilder:validation:Optional
+	// DirName is the root directory of the kernel modules in the driver images,
+	// /opt by default
+	DirName string `json:"dirName,omitempty"`
+	//+kubebuilder:validation:Optional
+	// FirmwarePath is the directory of the firmware in the driver images, which
+	// is copied to /var/lib/firmware on the nodes, /opt/lib/firmware by default
+	FirmwarePath string `json:"firmwarePath,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=habanalabs_cn;habanalabs_en;habanalabs_ib
+
+// CompanionModule is a kernel module of the Habana driver loaded along with
+// the habanalabs module, for the scale-out networking of Gaudi2 and later
+type CompanionModule string
+
+const (
+	// DriverModule is the main kernel module of the Habana driver.
+	DriverModule = "habanalabs"
+
+	CompanionModuleCN CompanionModule = "habanalabs_cn"
+	CompanionModuleEN CompanionModule = "habanalabs_en"
+	CompanionModuleIB CompanionModule = "habanalabs_ib"
+)
+
+// CompanionModules are the supported companion modules, in load order. The
+// Ethernet and InfiniBand modules depend on the core network one. They are
+// loaded after the habanalabs module, and unloaded in reverse order before it.
+var CompanionModules = []CompanionModule{CompanionModuleCN, CompanionModuleEN, CompanionModuleIB}
+
+// DriverPreflightSpec defines the validation of a new driver version against
+// the kernels of the selected nodes, before it is rolled out
+type DriverPreflightSpec struct {
+	//+kubebuilder:validation:Optional
+	// PushBuiltImage pushes the driver images built during the validation, so
+	// that they are not built again during the rollout
+	PushBuiltImage bool `json:"pushBuiltImage,omitempty"`
+}
+
+// DriverPrePullSpec defines the pull of the images of a new driver version on
+// the selected nodes, before they are moved to it
+type DriverPrePullSpec struct {
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:default=1800
+	// TimeoutSeconds is the maximum time, in seconds, to pull the images on
+	// all the selected nodes, after which the nodes are moved to the new
+	// version anyway. 0 waits forever.
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// GetTimeout returns the time after which the nodes are moved to a new driver
+// version whose images are not pulled on all of them. 0 never expires.
+func (p *DriverPrePullSpec) GetTimeout() time.Duration {
+	seconds := DefaultPrePullTimeoutSeconds
+	if p.TimeoutSeconds != nil {
+		seconds = *p.TimeoutSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// DriverUpgradePolicySpec defines how the selected nodes are moved to a new
+// driver version: a few at a time, cordoned and drained of the pods using
+// their HPUs before the driver is reloaded
+type DriverUpgradePolicySpec struct {
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:XIntOrString
+	//+kubebuilder:default=1
+	// MaxUnavailable is the maximum number, or percentage rounded up, of
+	// selected nodes upgraded at the same time
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:default=600
+	// DrainTimeoutSeconds is the maximum time, in seconds, to evict the pods
+	// using the HPUs of a node before its upgrade fails. 0 waits forever,
+	// e.g. for a PodDisruptionBudget to allow the evictions.
+	DrainTimeoutSeconds *int32 `json:"drainTimeoutSeconds,omitempty"`
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:default=600
+	// ValidationTimeoutSeconds is the maximum time, in seconds, for the new
+	// driver and the device plugin to get ready on a node, and for its HPUs
+	// to be advertised, before its upgrade fails. 0 waits forever.
+	ValidationTimeoutSeconds *int32 `json:"validationTimeoutSeconds,omitempty"`
+}
+
+// GetMaxUnavailable returns the maximum number of nodes upgraded at the same
+// time, out of nodes. It is at least 1.
+func (p *DriverUpgradePolicySpec) GetMaxUnavailable(nodes int) int {
+	maxUnavailable := intstr.FromInt(1)
+	if p.MaxUnavailable != nil {
+		maxUnavailable = *p.MaxUnavailable
+	}
+
+	n, err := intstr.GetScaledValueFromIntOrPercent(&maxUnavailable, nodes, true)
+	if err != nil || n < 1 {
+		return 1
+	}
+
+	return n
+}
+
+// GetDrainTimeout returns the time after which a node that is not drained
+// fails its upgrade, or 0 to wait forever.
+func (p *DriverUpgradePolicySpec) GetDrainTimeout() time.Duration {
+	seconds := DefaultDrainTimeoutSeconds
+	if p.DrainTimeoutSeconds != nil {
+		seconds = *p.DrainTimeoutSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// GetValidationTimeout returns the time after which a node whose new driver
+// is not ready fails its upgrade, or 0 to wait forever.
+func (p *DriverUpgradePolicySpec) GetValidationTimeout() time.Duration {
+	seconds := DefaultValidationTimeoutSeconds
+	if p.ValidationTimeoutSeconds != nil {
+		seconds = *p.ValidationTimeoutSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+//+kubebuilder:validation:Enum=Auto;Manual
+
+// CanaryPromotion is how a canary driver version is promoted to the other
+// selected nodes
+type CanaryPromotion string
+
+const (
+	// CanaryPromotionAuto promotes the canary once its nodes stayed healthy
+	// for HealthySeconds
+	CanaryPromotionAuto CanaryPromotion = "Auto"
+	// CanaryPromotionManual waits for an administrator to approve the canary
+	CanaryPromotionManual CanaryPromotion = "Manual"
+)
+
+// DriverCanarySpec defines the nodes a new driver version is tried on before
+// it is rolled out to the other selected nodes
+type DriverCanarySpec struct {
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:XIntOrString
+	// Nodes is the number, or percentage rounded up, of selected nodes the
+	// new version is tried on. 1 by default, or all the nodes matching
+	// NodeSelector if it is set.
+	Nodes *intstr.IntOrString `json:"nodes,omitempty"`
+	//+kubebuilder:validation:Optional
+	// NodeSelector restricts the canary nodes to the selected nodes with
+	// these labels
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:default=Auto
+	// Promotion is Auto to promote the canary once its nodes are healthy,
+	// or Manual to wait for the habana.ai/driver-canary-promote annotation of
+	// the DeviceConfig to be set to the canary version
+	Promotion CanaryPromotion `json:"promotion,omitempty"`
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:default=300
+	// HealthySeconds is the time, in seconds, the canary nodes run the new
+	// version with the driver and the device plugin ready before the canary
+	// is healthy
+	HealthySeconds *int32 `json:"healthySeconds,omitempty"`
+}
+
+// GetNodes returns the number of canary nodes, out of the nodes of candidates,
+// the ones matching the node selector, out of nodes. It is at least 1.
+func (c *DriverCanarySpec) GetNodes(nodes, candidates int) int {
+	if c.Nodes == nil && len(c.NodeSelector) > 0 {
+		return candidates
+	}
+
+	count := intstr.FromInt(1)
+	if c.Nodes != nil {
+		count = *c.Nodes
+	}
+
+	n, err := intstr.GetScaledValueFromIntOrPercent(&count, nodes, true)
+	if err != nil || n < 1 {
+		return 1
+	}
+
+	return n
+}
+
+// DriverRollbackSpec defines when a driver upgrade is rolled back to the last
+// known-good driver
+type DriverRollbackSpec struct {
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:XIntOrString
+	//+kubebuilder:default=1
+	// FailedNodesThreshold is the number, or percentage rounded up, of
+	// selected nodes failing with the new driver version that triggers the
+	// rollback. A node fails when its upgrade fails, or when the driver or
+	// the device plugin is failing on it, e.g. in CrashLoopBackOff.
+	FailedNodesThreshold *intstr.IntOrString `json:"failedNodesThreshold,omitempty"`
+}
+
+// GetFailedNodesThreshold returns the number of failing nodes, out of nodes,
+// that triggers a rollback. It is at least 1.
+func (r *DriverRollbackSpec) GetFailedNodesThreshold(nodes int) int {
+	threshold := intstr.FromInt(1)
+	if r.FailedNodesThreshold != nil {
+		threshold = *r.FailedNodesThreshold
+	}
+
+	n, err := intstr.GetScaledValueFromIntOrPercent(&threshold, nodes, true)
+	if err != nil || n < 1 {
+		return 1
+	}
+
+	return n
+}
+
+// GetHealthyDuration returns the time the canary nodes stay healthy before
+// the canary is healthy.
+func (c *DriverCanarySpec) GetHealthyDuration() time.Duration {
+	seconds := DefaultCanaryHealthySeconds
+	if c.HealthySeconds != nil {
+		seconds = *c.HealthySeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// KernelMapping pairs the node kernels matched by a preset, a regexp or a
+// literal version with a driver image
+type KernelMapping struct {
+	//+kubebuilder:validation:Optional
+	// Preset matches the kernels of a Linux distribution
+	Preset KernelMappingPreset `json:"preset,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Regexp is a regular expression matched against the node kernels
+	Regexp string `json:"regexp,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Literal is a kernel version matched exactly
+	Literal string `json:"literal,omitempty"`
+	//+kubebuilder:validation:Optional
+	// ContainerImage is the template of the driver image. ${DRIVER_IMAGE} and
+	// ${DRIVER_VERSION} are replaced by the driver image and version, and KMM
+	// replaces ${KERNEL_FULL_VERSION}, ${KERNEL_XYZ}, ${KERNEL_X}, ${KERNEL_Y}
+	// and ${KERNEL_Z} by the node kernel version. It defaults to
+	// ${DRIVER_IMAGE}:${DRIVER_VERSION}-${KERNEL_FULL_VERSION}. The companion
+	// modules are appended to it, e.g. -cn-en.
+	ContainerImage string `json:"containerImage,omitempty"`
+	//+kubebuilder:validation:Optional
+	// RegistryTLS defines how to access the registry of the driver image
+	RegistryTLS *RegistryTLS `json:"registryTLS,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Build builds the driver image in the cluster when it does not exist
+	Build *DriverBuildSpec `json:"build,omitempty"`
+}
+
+// GetRegexp returns the regexp matching the kernels of m, or an empty string
+// for a literal kernel mapping.
+func (m KernelMapping) GetRegexp() string {
+	if m.Preset != "" {
+		return KernelMappingPresetRegexps[m.Preset]
+	}
+
+	return m.Regexp
+}
+
+// DriverSpec defines the Habana driver deployed on the selected nodes
+type DriverSpec struct {
+	//+kubebuilder:validation:Optional
+	// Image is the Habana driver image to use. It defaults to the
+	// operator's DRIVER_HABANA_IMAGE_BASENAME.
+	Image string `json:"image,omitempty"`
+	//+kubebuilder:validation:Required
+	// Version is the Habana driver version deployed
+	Version string `json:"version"`
+	//+kubebuilder:validation:Optional
+	// KernelMappings pair the node kernels with driver images, the first
+	// mapping matching the kernel of a node is used. It defaults to the
+	// rhcos, rhel and ubuntu presets.
+	KernelMappings []KernelMapping `json:"kernelMappings,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Build builds the driver image of the kernels without prebuilt image in
+	// the cluster. The build settings of a kernel mapping override it.
+	Build *DriverBuildSpec `json:"build,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Sign signs the kernel modules of the driver images in the cluster, for
+	// the nodes with Secure Boot enabled
+	Sign *DriverSignSpec `json:"sign,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Modprobe customizes how the driver is loaded. A change reloads the
+	// driver on the selected nodes.
+	Modprobe *DriverModprobeSpec `json:"modprobe,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Preflight validates a new driver version with KMM against the kernel
+	// of every selected node, and keeps the previous version deployed until
+	// it is validated for all of them
+	Preflight *DriverPreflightSpec `json:"preflight,omitempty"`
+	//+kubebuilder:validation:Optional
+	// PrePull pulls the driver and device plugin images of a new driver
+	// version on the selected nodes, and keeps them on their version until
+	// the images are pulled on all of them
+	PrePull *DriverPrePullSpec `json:"prePull,omitempty"`
+	//+kubebuilder:validation:Optional
+	// UpgradePolicy moves the selected nodes to a new driver version a few
+	// at a time, cordoning and draining them first. Without it, the driver
+	// is reloaded on all the selected nodes at once.
+	UpgradePolicy *DriverUpgradePolicySpec `json:"upgradePolicy,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Canary tries a new driver version on a few selected nodes, and keeps
+	// the other ones on their version until it is promoted
+	Canary *DriverCanarySpec `json:"canary,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Rollback moves the selected nodes back to the last known-good driver
+	// image and version when a new version fails on too many of them
+	Rollback *DriverRollbackSpec `json:"rollback,omitempty"`
+	//+kubebuilder:validation:Optional
+	// CompanionModules are loaded after the habanalabs module, and unloaded
+	// before it, by a modprobe.d softdep written in the driver images built
+	// in the cluster, which are tagged with them. habanalabs_cn is loaded
+	// whenever another one is.
+	CompanionModules []CompanionModule `json:"companionModules,omitempty"`
+	//+kubebuilder:validation:Optional
+	// ImageRepoSecret is a secret, in the DeviceConfig namespace, with the
+	// credentials to pull the driver images and to push the ones built in
+	// the cluster
+	ImageRepoSecret *corev1.LocalObjectReference `json:"imageRepoSecret,omitempty"`
+}
+
+// UsesBuild returns true if d builds driver images in the cluster.
+func (d DriverSpec) UsesBuild() bool {
+	if d.Build != nil {
+		return true
+	}
+
+	for _, m := range d.KernelMappings {
+		if m.Build != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsBuilt returns true if all the driver images of d are built in the cluster
+// when they do not exist.
+func (d DriverSpec) IsBuilt() bool {
+	if d.Build != nil {
+		return true
+	}
+
+	for _, m := range d.GetKernelMappings() {
+		if m.Build == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetModules returns the kernel modules of the driver in load order, the
+// habanalabs module followed by its companion modules.
+func (d DriverSpec) GetModules() []string {
+	return append([]string{DriverModule}, d.GetCompanionModules()...)
+}
+
+// GetCompanionModules returns the enabled companion modules and their
+// dependencies, in the load order of CompanionModules.
+func (d DriverSpec) GetCompanionModules() []string {
+	enabled := map[CompanionModule]bool{}
+	for _, m := range d.CompanionModules {
+		enabled[m] = true
+	}
+	if enabled[CompanionModuleEN] || enabled[CompanionModuleIB] {
+		enabled[CompanionModuleCN] = true
+	}
+
+	modules := []string{}
+	for _, m := range CompanionModules {
+		if enabled[m] {
+			modules = append(modules, string(m))
+		}
+	}
+
+	return modules
+}
+
+// GetKernelMappings returns the kernel mappings of d, or the default presets.
+func (d DriverSpec) GetKernelMappings() []KernelMapping {
+	if len(d.KernelMappings) > 0 {
+		return d.KernelMappings
+	}
+
+	mappings := make([]KernelMapping, 0, len(DefaultKernelMappingPresets))
+	for _, p := range DefaultKernelMappingPresets {
+		mappings = append(mappings, KernelMapping{Preset: p})
+	}
+
+	return mappings
+}
+
+// FindKernelMapping returns the first kernel mapping of d matching kernel, or
+// nil if none matches it.
+func (d DriverSpec) FindKernelMapping(kernel string) (*KernelMapping, error) {
+	mappings := d.GetKernelMappings()
+	for i := range mappings {
+		m := &mappings[i]
+
+		if m.Literal != "" && m.Literal == kernel {
+			return m, nil
+		}
+
+		// Like KMM, a mapping without regexp matches no kernel.
+		if m.GetRegexp() == "" {
+			continue
+		}
+
+		matches, err := regexp.MatchString(m.GetRegexp(), kernel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kernel mapping regexp %q: %w", m.GetRegexp(), err)
+		}
+		if matches {
+			return m, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// DevicePluginSpec defines the Habana device plugin deployed on the selected nodes
+type DevicePluginSpec struct {
+	//+kubebuilder:validation:Optional
+	// Image is the Habana device plugin image to use. It defaults to the
+	// operator's DEVICE_PLUGIN_IMAGE.
+	Image string `json:"image,omitempty"`
+}
+
+// NodeLabelerSpec defines the Habana node labeler deployed on the selected nodes
+type NodeLabelerSpec struct {
+	//+kubebuilder:validation:Optional
+	// Image is the Habana node labeler image to use. It defaults to the
+	// operator's NODE_LABELER_IMAGE.
+	Image string `json:"image,omitempty"`
+}
+
+// NodeMetricsSpec defines the Habana metrics exporter deployed on the selected nodes
+type NodeMetricsSpec struct {
+	//+kubebuilder:validation:Optional
+	// Image is the Habana metrics exporter image to use. It defaults to the
+	// operator's NODE_METRICS_IMAGE.
+	Image string `json:"image,omitempty"`
+}
+
+// DeviceConfigSpec defines the desired state of DeviceConfig
+type DeviceConfigSpec struct {
+	//+kubebuilder:validation:Required
+	// Driver specifies the Habana driver
+	Driver DriverSpec `json:"driver"`
+	//+kubebuilder:validation:Optional
+	// DevicePlugin specifies the Habana device plugin
+	DevicePlugin DevicePluginSpec `json:"devicePlugin,omitempty"`
+	//+kubebuilder:validation:Optional
+	// NodeLabeler specifies the Habana node labeler
+	NodeLabeler NodeLabelerSpec `json:"nodeLabeler,omitempty"`
+	//+kubebuilder:validation:Optional
+	// NodeMetrics specifies the Habana metrics exporter
+	NodeMetrics NodeMetricsSpec `json:"nodeMetrics,omitempty"`
+	//+kubebuilder:validation:Optional
+	// DeviceType restricts the selected nodes to the ones with devices of
+	// this type, and configures the device plugin for them. A DeviceConfig
+	// without device type selects the nodes with any Habana device, and
+	// configures the device plugin for Gaudi devices.
+	DeviceType DeviceType `json:"deviceType,omitempty"`
+	//+kubebuilder:validation:Optional
+	// NodeSelector specifies a selector for the DeviceConfig
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	//+kubebuilder:validation:Optional
+	// NodeSelectorExpressions are label selector requirements the selected
+	// nodes must also match, e.g. to exclude some nodes with NotIn
+	NodeSelectorExpressions []metav1.LabelSelectorRequirement `json:"nodeSelectorExpressions,omitempty"`
+	//+kubebuilder:validation:Optional
+	// NodeAffinity further restricts the selected nodes to the ones matching
+	// its requiredDuringSchedulingIgnoredDuringExecution node selector terms
+	NodeAffinity *corev1.NodeAffinity `json:"nodeAffinity,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Tolerations are added to the node labeler and metrics exporter pods,
+	// e.g. to deploy them on tainted nodes. The KMM Module API does not
+	// support tolerations, so the driver and device plugin pods are not
+	// scheduled on nodes with NoSchedule or NoExecute taints.
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:default=1800
+	// ProgressDeadlineSeconds is the maximum time, in seconds, for all the
+	// components to become available on the selected nodes before the
+	// rollout is reported as stalled
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	//+kubebuilder:validation:Optional
+	// MaintenanceWindows are when the driver changes restarting the drivers,
+	// e.g. of version, modprobe parameters or kernel mappings, are applied
+	// to the selected nodes. They are applied right away without windows.
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty"`
+	//+kubebuilder:validation:Optional
+	// Deletion is how the deletion of the DeviceConfig, and the nodes no
+	// longer selected, handle the pods using the HPUs of the nodes. They
+	// are waited for by default.
+	Deletion *DeletionPolicySpec `json:"deletion,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=Wait;Evict
+
+// WorkloadDeletionPolicy is what the deletion of a DeviceConfig does with the
+// pods using the HPUs of its nodes
+type WorkloadDeletionPolicy string
+
+const (
+	// WorkloadDeletionPolicyWait waits for the pods to complete or to be
+	// deleted
+	WorkloadDeletionPolicyWait WorkloadDeletionPolicy = "Wait"
+	// WorkloadDeletionPolicyEvict evicts the pods, honoring their
+	// PodDisruptionBudgets
+	WorkloadDeletionPolicyEvict WorkloadDeletionPolicy = "Evict"
+)
+
+// DeletionPolicySpec defines how a DeviceConfig is deleted
+type DeletionPolicySpec struct {
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:default=Wait
+	// Workloads is what to do with the pods using the HPUs of the selected
+	// nodes, or of the nodes no longer selected, before the driver is
+	// unloaded
+	Workloads WorkloadDeletionPolicy `json:"workloads,omitempty"`
+}
+
+// GetWorkloadDeletionPolicy returns the workload deletion policy of dc, Wait by
+// default.
+func (dc *DeviceConfig) GetWorkloadDeletionPolicy() WorkloadDeletionPolicy {
+	if d := dc.Spec.Deletion; d != nil && d.Workloads != "" {
+		return d.Workloads
+	}
+
+	return WorkloadDeletionPolicyWait
+}
+
+// MaintenanceWindow is a recurring time range in which the driver changes of
+// a DeviceConfig are applied to its nodes
+type MaintenanceWindow struct {
+	//+kubebuilder:validation:Required
+	// Schedule is when the window opens, in the cron format: minute, hour,
+	// day of month, month and day of week, e.g. "0 2 * * 6" for Saturdays at
+	// 2:00
+	Schedule string `json:"schedule"`
+	//+kubebuilder:validation:Required
+	//+kubebuilder:validation:Minimum=60
+	// DurationSeconds is how long the window stays open
+	DurationSeconds int32 `json:"durationSeconds"`
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:default=UTC
+	// TimeZone is the IANA time zone of the schedule, e.g. Europe/Paris
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// GetDuration returns how long w stays open.
+func (w MaintenanceWindow) GetDuration() time.Duration {
+	return time.Duration(w.DurationSeconds) * time.Second
+}
+
+// GetTimeZone returns the time zone of the schedule of w, UTC by default.
+func (w MaintenanceWindow) GetTimeZone() string {
+	if w.TimeZone == "" {
+		return "UTC"
+	}
+
+	return w.TimeZone
+}
+
+// ComponentStatus defines the rollout state of a component deployed on the selected nodes
+type ComponentStatus struct {
+	//+optional
+	// NodesMatchingSelectorNumber is the number of nodes matching the KMM
+	// Module selector. It is only set for the driver and device plugin.
+	NodesMatchingSelectorNumber int32 `json:"nodesMatchingSelectorNumber,omitempty"`
+	// DesiredNumber is the number of nodes that should run the component
+	DesiredNumber int32 `json:"desiredNumber"`
+	// AvailableNumber is the number of nodes running an available component
+	AvailableNumber int32 `json:"availableNumber"`
+}
+
+// ComponentsStatus defines the rollout state of the components of a DeviceConfig
+type ComponentsStatus struct {
+	// Driver is the rollout state of the Habana driver
+	Driver ComponentStatus `json:"driver"`
+	// DevicePlugin is the rollout state of the Habana device plugin
+	DevicePlugin ComponentStatus `json:"devicePlugin"`
+	// NodeLabeler is the rollout state of the Habana node labeler
+	NodeLabeler ComponentStatus `json:"nodeLabeler"`
+	// NodeMetrics is the rollout state of the Habana metrics exporter
+	NodeMetrics ComponentStatus `json:"nodeMetrics"`
+}
+
+// NodeState is the state of the Habana components on a node
+type NodeState string
+
+const (
+	// NodeStateReady means that all the components are ready on the node
+	NodeStateReady NodeState = "Ready"
+	// NodeStateProgressing means that some components are not ready yet on the node
+	NodeStateProgressing NodeState = "Progressing"
+	// NodeStateFailed means that some components are failing on the node
+	NodeStateFailed NodeState = "Failed"
+)
+
+// NodeUpgradeState is the state of a node in a driver upgrade. It is also the
+// value of the habana.ai/driver-upgrade-state label of the node.
+type NodeUpgradeState string
+
+const (
+	// NodeUpgradeStateRequired means that the node waits for its turn
+	NodeUpgradeStateRequired NodeUpgradeState = "upgrade-required"
+	// NodeUpgradeStateCordonRequired means that the node is being cordoned
+	NodeUpgradeStateCordonRequired NodeUpgradeState = "cordon-required"
+	// NodeUpgradeStateDrainRequired means that the pods using the HPUs of
+	// the node are being evicted
+	NodeUpgradeStateDrainRequired NodeUpgradeState = "drain-required"
+	// NodeUpgradeStateDriverReloadRequired means that the previous driver is
+	// being unloaded from the node before the new one is loaded
+	NodeUpgradeStateDriverReloadRequired NodeUpgradeState = "driver-reload-required"
+	// NodeUpgradeStateValidationRequired means that the new driver and the
+	// device plugin are not ready yet on the node
+	NodeUpgradeStateValidationRequired NodeUpgradeState = "validation-required"
+	// NodeUpgradeStateUncordonRequired means that the node is being uncordoned
+	NodeUpgradeStateUncordonRequired NodeUpgradeState = "uncordon-required"
+	// NodeUpgradeStateDone means that the node runs the target driver version
+	NodeUpgradeStateDone NodeUpgradeState = "upgrade-done"
+	// NodeUpgradeStateFailed means that the node could not be drained or
+	// validated in time. It is left as is until an administrator sets its
+	// state back to upgrade-required.
+	NodeUpgradeStateFailed NodeUpgradeState = "upgrade-failed"
+)
+
+// NodeStatus defines the observed state of the Habana components on a node
+type NodeStatus struct {
+	// Name is the name of the node
+	Name string `json:"name"`
+	// State summarizes the state of the components on the node
+	State NodeState `json:"state"`
+	// DriverLoaded tells whether the Habana driver is loaded on the node
+	DriverLoaded bool `json:"driverLoaded"`
+	// DevicePluginReady tells whether the Habana device plugin is ready on the node
+	DevicePluginReady bool `json:"devicePluginReady"`
+	// NodeLabelerReady tells whether the Habana node labeler is ready on the node
+	NodeLabelerReady bool `json:"nodeLabelerReady"`
+	// NodeMetricsReady tells whether the Habana metrics exporter is ready on the node
+	NodeMetricsReady bool `json:"nodeMetricsReady"`
+	// HPUs is the number of HPUs advertised as allocatable by the node
+	HPUs int64 `json:"hpus"`
+	//+optional
+	// KernelVersion is the kernel version of the node
+	KernelVersion string `json:"kernelVersion,omitempty"`
+	// KernelSupported tells whether a kernel mapping matches the kernel of the node
+	KernelSupported bool `json:"kernelSupported"`
+	//+optional
+	// DriverReloadPending tells whether the driver was loaded before the last
+	// change of the modprobe configuration
+	DriverReloadPending bool `json:"driverReloadPending,omitempty"`
+	//+optional
+	// DriverVersion is the version of the driver deployed on the node
+	DriverVersion string `json:"driverVersion,omitempty"`
+	//+optional
+	// UpgradeState is the state of the node in a driver upgrade
+	UpgradeState NodeUpgradeState `json:"upgradeState,omitempty"`
+	//+optional
+	// Message details why the components are failing on the node
+	Message string `json:"message,omitempty"`
+}
+
+// SignFailure is a failure of KMM to sign the driver image of a kernel
+type SignFailure struct {
+	// Kernel is the kernel version of the driver image
+	Kernel string `json:"kernel"`
+	// Job is the name of the failed KMM signing Job
+	Job string `json:"job"`
+	//+optional
+	// Message details why the signing failed
+	Message string `json:"message,omitempty"`
+}
+
+// PreflightKernelStatus is the KMM preflight validation of a driver version
+// against a kernel
+type PreflightKernelStatus struct {
+	// Kernel is the validated kernel version
+	Kernel string `json:"kernel"`
+	// Verified tells whether the driver version is validated for the kernel
+	Verified bool `json:"verified"`
+	//+optional
+	// Stage is the current stage of the validation: Image, Build, Sign,
+	// Requeued or Done
+	Stage string `json:"stage,omitempty"`
+	//+optional
+	// Message details the result of the validation
+	Message string `json:"message,omitempty"`
+}
+
+// PreflightStatus is the preflight validation of a driver version against
+// the kernels of the selected nodes
+type PreflightStatus struct {
+	// Version is the validated driver version
+	Version string `json:"version"`
+	//+optional
+	//+listType=map
+	//+listMapKey=kernel
+	// Kernels are the results of the validation for each kernel
+	Kernels []PreflightKernelStatus `json:"kernels,omitempty"`
+}
+
+// IsVerified returns true if the driver version of s is validated for all
+// its kernels.
+func (s *PreflightStatus) IsVerified() bool {
+	for _, k := range s.Kernels {
+		if !k.Verified {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DriverPrePullStatus is the progress of the pull of the images of a new
+// driver version on the selected nodes
+type DriverPrePullStatus struct {
+	// Version is the driver version whose images are pulled
+	Version string `json:"version"`
+	//+optional
+	// Images are the pulled driver and device plugin images
+	Images []string `json:"images,omitempty"`
+	// DesiredNodes is the number of selected nodes to pull the images on
+	DesiredNodes int32 `json:"desiredNodes"`
+	// PulledNodes is the number of selected nodes with the images pulled
+	PulledNodes int32 `json:"pulledNodes"`
+	//+optional
+	// Failures describe the failing pulls, e.g. "ErrImagePull on node-a"
+	Failures []string `json:"failures,omitempty"`
+	//+optional
+	// StartTime is when the pull started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	//+optional
+	// CompletionTime is when the images were pulled on all the selected
+	// nodes, or when the pull timed out
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	//+optional
+	// Message details how the pull completed
+	Message string `json:"message,omitempty"`
+}
+
+// IsComplete returns true if the nodes can be moved to the version of s.
+func (s *DriverPrePullStatus) IsComplete() bool {
+	return s.CompletionTime != nil
+}
+
+// DriverUpgradeStatus is the progress of the selected nodes towards a driver
+// version
+type DriverUpgradeStatus struct {
+	// TargetVersion is the driver version the selected nodes are moved to
+	TargetVersion string `json:"targetVersion"`
+	// UpgradedNodes is the number of selected nodes running the target version
+	UpgradedNodes int32 `json:"upgradedNodes"`
+	//+optional
+	// UpgradingNodes is the number of selected nodes being upgraded
+	UpgradingNodes int32 `json:"upgradingNodes,omitempty"`
+	//+optional
+	// PendingNodes is the number of selected nodes waiting to be upgraded
+	PendingNodes int32 `json:"pendingNodes,omitempty"`
+	//+optional
+	// FailedNodes is the number of selected nodes whose upgrade failed
+	FailedNodes int32 `json:"failedNodes,omitempty"`
+}
+
+// IsComplete returns true if all the selected nodes run the target version.
+func (s *DriverUpgradeStatus) IsComplete() bool {
+	return s.UpgradingNodes == 0 && s.PendingNodes == 0 && s.FailedNodes == 0
+}
+
+// CanaryPhase is the state of a canary driver version
+type CanaryPhase string
+
+const (
+	// CanaryPhaseInProgress means that the canary nodes are being upgraded,
+	// or are not healthy for long enough yet
+	CanaryPhaseInProgress CanaryPhase = "InProgress"
+	// CanaryPhaseAwaitingApproval means that the canary is healthy, and
+	// waits for an administrator to promote it
+	CanaryPhaseAwaitingApproval CanaryPhase = "AwaitingApproval"
+	// CanaryPhasePromoted means that the version is rolled out to all the
+	// selected nodes
+	CanaryPhasePromoted CanaryPhase = "Promoted"
+	// CanaryPhaseAborted means that the upgrade of a canary node failed, and
+	// that the other nodes keep their version
+	CanaryPhaseAborted CanaryPhase = "Aborted"
+)
+
+// DriverCanaryStatus is the state of the canary of a driver version
+type DriverCanaryStatus struct {
+	// Version is the driver version tried on the canary nodes
+	Version string `json:"version"`
+	// Phase is the state of the canary
+	Phase CanaryPhase `json:"phase"`
+	//+optional
+	// Nodes are the canary nodes
+	Nodes []string `json:"nodes,omitempty"`
+	//+optional
+	// HealthySince is the time since when all the canary nodes are healthy
+	HealthySince *metav1.Time `json:"healthySince,omitempty"`
+	//+optional
+	// LastTransitionTime is the last time the phase changed
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+	//+optional
+	// Message details the phase, e.g. why the canary was promoted or aborted
+	Message string `json:"message,omitempty"`
+}
+
+// KnownGoodDriver is a driver image and version that ran on all the selected
+// nodes
+type KnownGoodDriver struct {
+	//+optional
+	// Image is the driver image, the operator default if empty
+	Image string `json:"image,omitempty"`
+	// Version is the driver version
+	Version string `json:"version"`
+	// Time is when the driver was ready on all the selected nodes
+	Time metav1.Time `json:"time"`
+}
+
+// DriverRollbackStatus is the rollback of a failing driver version to the
+// last known-good driver
+type DriverRollbackStatus struct {
+	//+optional
+	// FailedImage is the driver image applied to the nodes when they were
+	// rolled back
+	FailedImage string `json:"failedImage,omitempty"`
+	// FailedVersion is the driver version that was rolled back
+	FailedVersion string `json:"failedVersion"`
+	//+optional
+	// Image is the driver image rolled back to
+	Image string `json:"image,omitempty"`
+	// Version is the driver version rolled back to
+	Version string `json:"version"`
+	// Reason tells why the driver was rolled back
+	Reason string `json:"reason"`
+	// Time is when the driver was rolled back
+	Time metav1.Time `json:"time"`
+}
+
+// DriverOutcome is the outcome of a driver rollout
+type DriverOutcome string
+
+const (
+	// DriverOutcomeInProgress means that the driver version is being rolled
+	// out
+	DriverOutcomeInProgress DriverOutcome = "InProgress"
+	// DriverOutcomeSucceeded means that the driver version is ready on all
+	// the selected nodes
+	DriverOutcomeSucceeded DriverOutcome = "Succeeded"
+	// DriverOutcomeRolledBack means that the driver version was rolled back
+	DriverOutcomeRolledBack DriverOutcome = "RolledBack"
+	// DriverOutcomeSuperseded means that another driver image or version
+	// was rolled out before this one was ready on all the selected nodes
+	DriverOutcomeSuperseded DriverOutcome = "Superseded"
+)
+
+// DriverHistoryEntry is the rollout of a driver image and version
+type DriverHistoryEntry struct {
+	//+optional
+	// Image is the driver image, the operator default if empty
+	Image string `json:"image,omitempty"`
+	// Version is the driver version
+	Version string `json:"version"`
+	//+optional
+	// TriggeredBy is who changed the driver of the DeviceConfig to this
+	// version, or rollback when the operator rolled it back
+	TriggeredBy string `json:"triggeredBy,omitempty"`
+	// Outcome is the outcome of the rollout
+	Outcome DriverOutcome `json:"outcome"`
+	//+optional
+	// StartTime is when the rollout started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	//+optional
+	// CompletionTime is when the rollout reached its outcome
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	//+optional
+	// Nodes is the number of selected nodes when the rollout completed
+	Nodes int32 `json:"nodes,omitempty"`
+	//+optional
+	// UpgradedNodes is the number of nodes running the driver version when
+	// the rollout completed
+	UpgradedNodes int32 `json:"upgradedNodes,omitempty"`
+	//+optional
+	// FailedNodes is the number of nodes that failed to move to the driver
+	// version when the rollout completed
+	FailedNodes int32 `json:"failedNodes,omitempty"`
+	//+optional
+	// Message details the outcome, e.g. why the version was rolled back
+	Message string `json:"message,omitempty"`
+}
+
+// IsInProgress returns true if the rollout has not reached its outcome yet.
+func (e *DriverHistoryEntry) IsInProgress() bool {
+	return e.Outcome == DriverOutcomeInProgress
+}
+
+// DeletionPhase is a phase of the deletion of a DeviceConfig
+type DeletionPhase string
+
+const (
+	// DeletionPhaseDrainingWorkloads waits for the pods using the HPUs of
+	// the selected nodes to be gone, evicting them with the Evict policy
+	DeletionPhaseDrainingWorkloads DeletionPhase = "DrainingWorkloads"
+	// DeletionPhaseRemovingDevicePlugin removes the device plugin
+	DeletionPhaseRemovingDevicePlugin DeletionPhase = "RemovingDevicePlugin"
+	// DeletionPhaseUnloadingDriver deletes the KMM Modules, which unloads the
+	// driver
+	DeletionPhaseUnloadingDriver DeletionPhase = "UnloadingDriver"
+	// DeletionPhaseRemovingNodeComponents removes the node labeler and
+	// metrics exporter
+	DeletionPhaseRemovingNodeComponents DeletionPhase = "RemovingNodeComponents"
+	// DeletionPhaseCleaningFeatureFiles removes the NFD feature files the
+	// node labeler wrote on the selected nodes
+	DeletionPhaseCleaningFeatureFiles DeletionPhase = "CleaningFeatureFiles"
+	// DeletionPhaseComplete means that all the resources are deleted
+	DeletionPhaseComplete DeletionPhase = "Complete"
+)
+
+// DeletionStatus is the progress of the deletion of a DeviceConfig
+type DeletionStatus struct {
+	// Phase is the current phase of the deletion
+	Phase DeletionPhase `json:"phase"`
+	// StartTime is when the deletion started
+	StartTime metav1.Time `json:"startTime"`
+	// LastTransitionTime is the last time the phase changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	//+optional
+	// Message details what the phase waits for
+	Message string `json:"message,omitempty"`
+}
+
+// LeavingNode is a node no longer selected by a DeviceConfig, whose components
+// are only removed once the pods using its HPUs are gone
+type LeavingNode struct {
+	// Name is the name of the node
+	Name string `json:"name"`
+	// Since is when the node stopped being selected
+	Since metav1.Time `json:"since"`
+	//+optional
+	// Message details the pods the node waits for
+	Message string `json:"message,omitempty"`
+}
+
+// MaintenanceStatus is the driver configuration applied to the nodes of a
+// DeviceConfig with maintenance windows, and its changes waiting for a window
+type MaintenanceStatus struct {
+	// AppliedDriver is the driver configuration applied to the nodes. The
+	// preflight, upgrade, canary and rollback policies are not recorded, as
+	// they apply right away.
+	AppliedDriver DriverSpec `json:"appliedDriver"`
+	//+optional
+	// PendingChanges are the driver changes waiting for a maintenance window
+	PendingChanges []string `json:"pendingChanges,omitempty"`
+	//+optional
+	// PendingSince is when the oldest pending change was made
+	PendingSince *metav1.Time `json:"pendingSince,omitempty"`
+	//+optional
+	// NextWindow is when the next maintenance window opens
+	NextWindow *metav1.Time `json:"nextWindow,omitempty"`
+}
+
+// DeviceConfigStatus defines the observed state of DeviceConfig
+type DeviceConfigStatus struct {
+	// Conditions is a list of conditions representing the DeviceConfig's current state.
+	Conditions []metav1.Condition `json:"conditions"`
+	//+optional
+	// ObservedGeneration is the DeviceConfig generation the status was computed from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	//+optional
+	// MatchedNodes is the number of nodes selected by the DeviceConfig
+	MatchedNodes int32 `json:"matchedNodes,omitempty"`
+	//+optional
+	// ReadyNodes is the number of selected nodes with all the components ready
+	ReadyNodes int32 `json:"readyNodes,omitempty"`
+	//+optional
+	// FailedNodes is the number of selected nodes with failing components
+	FailedNodes int32 `json:"failedNodes,omitempty"`
+	//+optional
+	// Components is the rollout state of each component
+	Components ComponentsStatus `json:"components,omitempty"`
+	//+optional
+	//+listType=map
+	//+listMapKey=name
+	// Nodes is the state of the components on each selected node
+	Nodes []NodeStatus `json:"nodes,omitempty"`
+	//+optional
+	//+listType=map
+	//+listMapKey=kernel
+	// SignFailures are the kernels whose driver image could not be signed
+	SignFailures []SignFailure `json:"signFailures,omitempty"`
+	//+optional
+	// ModprobeConfigHash is the hash of the modprobe configuration of the driver
+	ModprobeConfigHash string `json:"modprobeConfigHash,omitempty"`
+	//+optional
+	// ModprobeConfigUpdateTime is the last time the modprobe configuration of
+	// the driver changed
+	ModprobeConfigUpdateTime *metav1.Time `json:"modprobeConfigUpdateTime,omitempty"`
+	//+optional
+	// Preflight is the latest preflight validation of a driver version
+	Preflight *PreflightStatus `json:"preflight,omitempty"`
+	//+optional
+	// PrePull is the progress of the pull of the images of the driver
+	// version being rolled out
+	PrePull *DriverPrePullStatus `json:"prePull,omitempty"`
+	//+optional
+	// Upgrade is the progress of the selected nodes towards the driver
+	// version being rolled out
+	Upgrade *DriverUpgradeStatus `json:"upgrade,omitempty"`
+	//+optional
+	// Canary is the canary of the driver version being rolled out
+	Canary *DriverCanaryStatus `json:"canary,omitempty"`
+	//+optional
+	// LastKnownGoodDriver is the latest driver version that was ready on all
+	// the selected nodes
+	LastKnownGoodDriver *KnownGoodDriver `json:"lastKnownGoodDriver,omitempty"`
+	//+optional
+	// Rollback is the rollback of the DeviceConfig driver version, kept
+	// until the driver image or version changes
+	Rollback *DriverRollbackStatus `json:"rollback,omitempty"`
+	//+optional
+	// DriverHistory are the latest driver rollouts with their outcome,
+	// oldest first
+	DriverHistory []DriverHistoryEntry `json:"driverHistory,omitempty"`
+	//+optional
+	// Maintenance is the driver configuration applied to the nodes, with
+	// the changes waiting for a maintenance window
+	Maintenance *MaintenanceStatus `json:"maintenance,omitempty"`
+	//+optional
+	// Deletion is the progress of the deletion of the DeviceConfig
+	Deletion *DeletionStatus `json:"deletion,omitempty"`
+	//+optional
+	// LeavingNodes are the nodes no longer selected whose workloads are
+	// drained before the components are removed from them
+	LeavingNodes []LeavingNode `json:"leavingNodes,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Driver",type=string,JSONPath=`.spec.driver.version`
+//+kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matchedNodes`
+//+kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyNodes`
+//+kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failedNodes`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DeviceConfig is the Schema for the deviceconfigs API
+type DeviceConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DeviceConfigSpec   `json:"spec,omitempty"`
+	Status DeviceConfigStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DeviceConfigList contains a list of DeviceConfig
+type DeviceConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DeviceConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DeviceConfig{}, &DeviceConfigList{})
+}
+
+// GetNodeSelector returns the labels of the nodes selected by dc. A
+// DeviceConfig without NodeSelector nor DeviceType selects the nodes with a
+// Habana PCI device, otherwise the DaemonSets would be deployed on every
+// schedulable node. The nodes of a DeviceType are selected by their PCI
+// device labels instead, see DeviceType.GetNodeFeatureLabels.
+func (dc *DeviceConfig) GetNodeSelector() map[string]string {
+	if dc.Spec.NodeSelector != nil {
+		return dc.Spec.NodeSelector
+	}
+
+	if dc.Spec.DeviceType != "" {
+		return map[string]string{}
+	}
+
+	return map[string]string{NodeFeaturePCIVendorLabel: "true"}
+}
+
+// GetDeviceType returns the device type the device plugin is configured for.
+func (dc *DeviceConfig) GetDeviceType() DeviceType {
+	if dc.Spec.DeviceType == "" {
+		return DeviceTypeGaudi
+	}
+
+	return dc.Spec.DeviceType
+}
+
+// GetProgressDeadline returns the time after which a rollout that has not
+// completed is reported as stalled.
+func (dc *DeviceConfig) GetProgressDeadline() time.Duration {
+	seconds := DefaultProgressDeadlineSeconds
+	if dc.Spec.ProgressDeadlineSeconds != nil {
+		seconds = *dc.Spec.ProgressDeadlineSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// IsDriverRolledBack returns true if the driver image and version applied to
+// the nodes of dc were rolled back, and the rollback is still enabled. An
+// unset image is the operator default, which the failed image was resolved
+// to.
+func (dc *DeviceConfig) IsDriverRolledBack() bool {
+	rb := dc.Status.Rollback
+	if rb == nil || dc.Spec.Driver.Rollback == nil {
+		return false
+	}
+
+	applied := &dc.Spec.Driver
+	if dc.Status.Maintenance != nil {
+		applied = &dc.Status.Maintenance.AppliedDriver
+	}
+
+	return rb.FailedVersion == applied.Version && (applied.Image == "" || rb.FailedImage == applied.Image)
+}
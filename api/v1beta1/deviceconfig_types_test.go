@@ -0,0 +1,273 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DeviceConfig", func() {
+	var dc *DeviceConfig
+
+	BeforeEach(func() {
+		dc = &DeviceConfig{}
+	})
+
+	Describe("GetNodeSelector", func() {
+		It("should select the nodes with a Habana PCI device by default", func() {
+			Expect(dc.GetNodeSelector()).To(Equal(map[string]string{
+				"feature.node.kubernetes.io/pci-1da3.present": "true",
+			}))
+		})
+
+		It("should not select the nodes with a Habana PCI device for a device type", func() {
+			dc.Spec.DeviceType = DeviceTypeGaudi2
+			Expect(dc.GetNodeSelector()).To(BeEmpty())
+		})
+
+		It("should return the specified node selector", func() {
+			dc.Spec.DeviceType = DeviceTypeGaudi2
+			dc.Spec.NodeSelector = map[string]string{"some": "label"}
+			Expect(dc.GetNodeSelector()).To(Equal(map[string]string{"some": "label"}))
+		})
+	})
+
+	Describe("GetDeviceType", func() {
+		It("should default to Gaudi", func() {
+			Expect(dc.GetDeviceType()).To(Equal(DeviceTypeGaudi))
+		})
+
+		It("should return the specified device type", func() {
+			dc.Spec.DeviceType = DeviceTypeGaudi3
+			Expect(dc.GetDeviceType()).To(Equal(DeviceTypeGaudi3))
+		})
+	})
+
+	Describe("IsDriverRolledBack", func() {
+		BeforeEach(func() {
+			dc.Spec.Driver.Version = "1.11.0-587"
+			dc.Spec.Driver.Rollback = &DriverRollbackSpec{}
+			dc.Status.Rollback = &DriverRollbackStatus{FailedVersion: "1.11.0-587", Version: "1.10.0-494"}
+		})
+
+		It("should return true while the version rolled back is the DeviceConfig one", func() {
+			Expect(dc.IsDriverRolledBack()).To(BeTrue())
+		})
+
+		It("should return false once the version changed", func() {
+			dc.Spec.Driver.Version = "1.11.1-12"
+			Expect(dc.IsDriverRolledBack()).To(BeFalse())
+		})
+
+		It("should return false once the image changed", func() {
+			dc.Spec.Driver.Image = "registry.example.com/habanalabs/driver"
+			Expect(dc.IsDriverRolledBack()).To(BeFalse())
+		})
+
+		It("should return true for an unset image resolved to the failed one", func() {
+			dc.Status.Rollback.FailedImage = "registry.example.com/habanalabs/driver"
+			Expect(dc.IsDriverRolledBack()).To(BeTrue())
+		})
+
+		It("should compare the driver applied in maintenance windows", func() {
+			dc.Spec.Driver.Image = "registry.example.com/habanalabs/new-driver"
+			dc.Status.Rollback.FailedImage = "registry.example.com/habanalabs/driver"
+			dc.Status.Maintenance = &MaintenanceStatus{
+				AppliedDriver: DriverSpec{Image: "registry.example.com/habanalabs/driver", Version: "1.11.0-587"},
+			}
+			Expect(dc.IsDriverRolledBack()).To(BeTrue())
+
+			dc.Status.Maintenance.AppliedDriver.Image = dc.Spec.Driver.Image
+			Expect(dc.IsDriverRolledBack()).To(BeFalse())
+		})
+
+		It("should return false once the rollback is disabled", func() {
+			dc.Spec.Driver.Rollback = nil
+			Expect(dc.IsDriverRolledBack()).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("DriverUpgradePolicySpec", func() {
+	Describe("GetMaxUnavailable", func() {
+		It("should default to one node", func() {
+			p := &DriverUpgradePolicySpec{}
+			Expect(p.GetMaxUnavailable(10)).To(Equal(1))
+		})
+
+		It("should return the specified number of nodes", func() {
+			maxUnavailable := intstr.FromInt(3)
+			p := &DriverUpgradePolicySpec{MaxUnavailable: &maxUnavailable}
+			Expect(p.GetMaxUnavailable(10)).To(Equal(3))
+		})
+
+		It("should round a percentage up", func() {
+			maxUnavailable := intstr.FromString("25%")
+			p := &DriverUpgradePolicySpec{MaxUnavailable: &maxUnavailable}
+			Expect(p.GetMaxUnavailable(10)).To(Equal(3))
+		})
+
+		It("should upgrade at least one node", func() {
+			maxUnavailable := intstr.FromString("0%")
+			p := &DriverUpgradePolicySpec{MaxUnavailable: &maxUnavailable}
+			Expect(p.GetMaxUnavailable(10)).To(Equal(1))
+		})
+	})
+})
+
+var _ = Describe("DriverRollbackSpec", func() {
+	Describe("GetFailedNodesThreshold", func() {
+		It("should default to one node", func() {
+			r := &DriverRollbackSpec{}
+			Expect(r.GetFailedNodesThreshold(10)).To(Equal(1))
+		})
+
+		It("should round a percentage up", func() {
+			threshold := intstr.FromString("25%")
+			r := &DriverRollbackSpec{FailedNodesThreshold: &threshold}
+			Expect(r.GetFailedNodesThreshold(10)).To(Equal(3))
+		})
+	})
+})
+
+var _ = Describe("DriverCanarySpec", func() {
+	Describe("GetNodes", func() {
+		It("should default to one node", func() {
+			c := &DriverCanarySpec{}
+			Expect(c.GetNodes(10, 10)).To(Equal(1))
+		})
+
+		It("should default to all the nodes matching the node selector", func() {
+			c := &DriverCanarySpec{NodeSelector: map[string]string{"canary": "true"}}
+			Expect(c.GetNodes(10, 4)).To(Equal(4))
+		})
+
+		It("should round a percentage of the selected nodes up", func() {
+			nodes := intstr.FromString("15%")
+			c := &DriverCanarySpec{Nodes: &nodes, NodeSelector: map[string]string{"canary": "true"}}
+			Expect(c.GetNodes(10, 4)).To(Equal(2))
+		})
+	})
+})
+
+var _ = Describe("DeviceType", func() {
+	It("should have PCI device IDs for every device type", func() {
+		for _, t := range DeviceTypes {
+			Expect(t.GetPCIDeviceIDs()).ToNot(BeEmpty(), string(t))
+		}
+	})
+
+	It("should return the NFD labels of its PCI devices", func() {
+		Expect(DeviceTypeGaudi.GetNodeFeatureLabels()).To(Equal([]string{
+			"feature.node.kubernetes.io/pci-1da3_1000.present",
+			"feature.node.kubernetes.io/pci-1da3_1010.present",
+		}))
+		Expect(DeviceTypeGaudi2.GetNodeFeatureLabels()).To(Equal([]string{
+			"feature.node.kubernetes.io/pci-1da3_1020.present",
+		}))
+	})
+
+	It("should return the resource advertised by the device plugin", func() {
+		Expect(DeviceTypeGaudi.GetResourceName()).To(Equal(corev1.ResourceName("habana.ai/gaudi")))
+		Expect(DeviceTypeGaudi3.GetResourceName()).To(Equal(corev1.ResourceName("habana.ai/gaudi3")))
+	})
+})
+
+var _ = Describe("DriverSpec", func() {
+	Describe("GetKernelMappings", func() {
+		It("should default to the presets", func() {
+			Expect(DriverSpec{}.GetKernelMappings()).To(Equal([]KernelMapping{
+				{Preset: KernelMappingPresetRHCOS},
+				{Preset: KernelMappingPresetRHEL},
+				{Preset: KernelMappingPresetUbuntu},
+			}))
+		})
+
+		It("should return the specified kernel mappings", func() {
+			mappings := []KernelMapping{{Literal: "5.15.0-76-generic"}}
+			Expect(DriverSpec{KernelMappings: mappings}.GetKernelMappings()).To(Equal(mappings))
+		})
+	})
+
+	DescribeTable("GetModules",
+		func(companions []CompanionModule, expected []string) {
+			Expect(DriverSpec{CompanionModules: companions}.GetModules()).To(Equal(expected))
+		},
+		Entry("without companion modules", nil, []string{"habanalabs"}),
+		Entry("with the core network module", []CompanionModule{CompanionModuleCN},
+			[]string{"habanalabs", "habanalabs_cn"}),
+		Entry("with the InfiniBand and Ethernet modules", []CompanionModule{CompanionModuleIB, CompanionModuleEN},
+			[]string{"habanalabs", "habanalabs_cn", "habanalabs_en", "habanalabs_ib"}),
+	)
+
+	It("should return the companion modules without the habanalabs module", func() {
+		Expect(DriverSpec{CompanionModules: []CompanionModule{CompanionModuleEN}}.GetCompanionModules()).
+			To(Equal([]string{"habanalabs_cn", "habanalabs_en"}))
+		Expect(DriverSpec{}.GetCompanionModules()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("FindKernelMapping", func() {
+	d := DriverSpec{KernelMappings: []KernelMapping{
+		{Literal: "5.15.0-76-generic", ContainerImage: "literal"},
+		{Preset: KernelMappingPresetUbuntu},
+		{Regexp: `^6\.`},
+	}}
+
+	DescribeTable("should return the first kernel mapping matching the kernel",
+		func(kernel string, expected *KernelMapping) {
+			m, err := d.FindKernelMapping(kernel)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(Equal(expected))
+		},
+		Entry("literal", "5.15.0-76-generic", &d.KernelMappings[0]),
+		Entry("preset", "5.15.0-78-generic", &d.KernelMappings[1]),
+		Entry("regexp", "6.12.0-55.el10.x86_64", &d.KernelMappings[2]),
+		Entry("none", "4.18.0-372.26.1.el8_6.x86_64", nil),
+	)
+
+	It("should return an error for an invalid regexp", func() {
+		_, err := DriverSpec{KernelMappings: []KernelMapping{{Regexp: "(("}}}.FindKernelMapping("5.15.0-76-generic")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("KernelMappingPresetRegexps", func() {
+	DescribeTable("should match the kernels of their distribution",
+		func(p KernelMappingPreset, kernel string, expected bool) {
+			Expect(regexp.MustCompile(KernelMapping{Preset: p}.GetRegexp()).MatchString(kernel)).To(Equal(expected))
+		},
+		Entry(nil, KernelMappingPresetRHCOS, "4.18.0-372.26.1.el8_6.x86_64", true),
+		Entry(nil, KernelMappingPresetRHCOS, "5.14.0-284.25.1.el9_2.x86_64", true),
+		Entry(nil, KernelMappingPresetRHCOS, "5.15.0-76-generic", false),
+		Entry(nil, KernelMappingPresetRHEL, "5.14.0-570.el9_10.x86_64", true),
+		Entry(nil, KernelMappingPresetRHEL, "6.12.0-55.el10.x86_64", true),
+		Entry(nil, KernelMappingPresetRHEL, "5.15.0-76-generic", false),
+		Entry(nil, KernelMappingPresetUbuntu, "5.15.0-76-generic", true),
+		Entry(nil, KernelMappingPresetUbuntu, "6.5.0-1015-aws", true),
+		Entry(nil, KernelMappingPresetUbuntu, "4.18.0-372.26.1.el8_6.x86_64", false),
+	)
+
+	It("should be defined for every preset", func() {
+		for _, p := range DefaultKernelMappingPresets {
+			Expect(KernelMappingPresetRegexps).To(HaveKey(p))
+		}
+	})
+})
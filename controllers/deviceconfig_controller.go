@@ -19,26 +19,48 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	"github.com/HabanaAI/habana-ai-operator/internal/conditions"
+	"github.com/HabanaAI/habana-ai-operator/internal/constants"
+	"github.com/HabanaAI/habana-ai-operator/internal/deletion"
 	"github.com/HabanaAI/habana-ai-operator/internal/finalizers"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
+	"github.com/HabanaAI/habana-ai-operator/internal/maintenance"
 	"github.com/HabanaAI/habana-ai-operator/internal/metrics"
 	"github.com/HabanaAI/habana-ai-operator/internal/module"
 	nodeLabeler "github.com/HabanaAI/habana-ai-operator/internal/node/labeler"
 	nodeMetrics "github.com/HabanaAI/habana-ai-operator/internal/node/metrics"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodeselector"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodestatus"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	"github.com/HabanaAI/habana-ai-operator/internal/preflight"
+	"github.com/HabanaAI/habana-ai-operator/internal/prepull"
 	s "github.com/HabanaAI/habana-ai-operator/internal/settings"
+	"github.com/HabanaAI/habana-ai-operator/internal/upgrade"
 )
 
 // Reconciler reconciles a DeviceConfig object
@@ -49,13 +71,19 @@ type Reconciler struct {
 	Recorder record.EventRecorder
 
 	mr  module.Reconciler
+	pr  preflight.Reconciler
+	ppr prepull.Reconciler
+	ur  upgrade.Reconciler
 	nmr nodeMetrics.Reconciler
 	nlr nodeLabeler.Reconciler
+	dr  deletion.Reconciler
 
 	fu finalizers.Updater
 	cu conditions.Updater
 
-	nsv NodeSelectorValidator
+	nsv nodeselector.Validator
+	nsu nodestatus.Updater
+	ntu nodetargets.Updater
 }
 
 func NewReconciler(
@@ -63,22 +91,34 @@ func NewReconciler(
 	scheme *runtime.Scheme,
 	recorder record.EventRecorder,
 	mr module.Reconciler,
+	pr preflight.Reconciler,
+	ppr prepull.Reconciler,
+	ur upgrade.Reconciler,
 	nmr nodeMetrics.Reconciler,
 	nlr nodeLabeler.Reconciler,
+	dr deletion.Reconciler,
 	fu finalizers.Updater,
 	cu conditions.Updater,
-	nsv NodeSelectorValidator,
+	nsv nodeselector.Validator,
+	nsu nodestatus.Updater,
+	ntu nodetargets.Updater,
 ) *Reconciler {
 	return &Reconciler{
 		Client:   client,
 		Scheme:   scheme,
 		Recorder: recorder,
 		mr:       mr,
+		pr:       pr,
+		ppr:      ppr,
+		ur:       ur,
 		nmr:      nmr,
 		nlr:      nlr,
+		dr:       dr,
 		fu:       fu,
 		cu:       cu,
 		nsv:      nsv,
+		nsu:      nsu,
+		ntu:      ntu,
 	}
 }
 
@@ -86,9 +126,14 @@ func NewReconciler(
 //+kubebuilder:rbac:groups=habana.ai,resources=deviceconfigs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=habana.ai,resources=deviceconfigs/finalizers,verbs=update
 //+kubebuilder:rbac:groups="kmm.sigs.x-k8s.io",resources=modules,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="kmm.sigs.x-k8s.io",resources=preflightvalidations,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="apps",resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
 //+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -102,11 +147,12 @@ func NewReconciler(
 func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
-	deviceConfig := &hlaiv1alpha1.DeviceConfig{}
+	deviceConfig := &hlaiv1beta1.DeviceConfig{}
 	err := r.Get(ctx, req.NamespacedName, deviceConfig)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			metrics.ReconciliationFailed.WithLabelValues(req.NamespacedName.Name).Set(0)
+			metrics.KernelUnsupportedNodes.WithLabelValues(req.NamespacedName.Name).Set(0)
 			logger.Info("DeviceConfig resource not found. Ignoring since object must be deleted.")
 			return ctrl.Result{}, nil
 		}
@@ -114,13 +160,25 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 		return ctrl.Result{}, err
 	}
 
+	// original is what the status patches are computed against, so that they
+	// only send the status changes made by this reconciliation.
+	original := deviceConfig.DeepCopy()
+
 	if !deviceConfig.ObjectMeta.DeletionTimestamp.IsZero() {
 		metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(0)
+		metrics.KernelUnsupportedNodes.WithLabelValues(deviceConfig.Name).Set(0)
 
 		if r.fu.ContainsDeletionFinalizer(deviceConfig) {
-			if err := r.deleteDeviceConfigResources(ctx, deviceConfig); err != nil {
+			deleted, err := r.deleteDeviceConfigResources(ctx, deviceConfig)
+			if err != nil {
 				return ctrl.Result{}, fmt.Errorf("failed to delete DeviceConfig resources: %w", err)
 			}
+			if err := r.cu.SetConditionsDeleting(ctx, deviceConfig, original); err != nil {
+				return ctrl.Result{}, err
+			}
+			if !deleted {
+				return ctrl.Result{RequeueAfter: deletion.RequeueAfter(deviceConfig)}, nil
+			}
 			if err := r.fu.RemoveDeletionFinalizer(ctx, deviceConfig); err != nil {
 				return ctrl.Result{}, err
 			}
@@ -137,7 +195,8 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 			"Conflicting DeviceConfig NodeSelectors found. Please add or update this DeviceConfig's NodeSelector accordingly.",
 		)
 		metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(1)
-		return ctrl.Result{}, nil
+		return ctrl.Result{}, r.cu.SetConditionsErrored(ctx, deviceConfig, original, conditions.Available,
+			conditions.ReasonConflictingNodeSelector, err.Error())
 	}
 
 	if !r.fu.ContainsDeletionFinalizer(deviceConfig) {
@@ -146,16 +205,94 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 		}
 	}
 
-	if err := r.mr.ReconcileModule(ctx, deviceConfig); err != nil {
-		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, conditions.ReasonModuleFailed, err.Error()); cerr != nil {
+	if err := r.ntu.SetTargetNodes(ctx, deviceConfig); err != nil {
+		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, original, conditions.Available, conditions.ReasonNodeTargetingFailed, err.Error()); cerr != nil {
+			err = fmt.Errorf("%s: %w", err.Error(), cerr)
+		}
+		metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(1)
+		return ctrl.Result{}, err
+	}
+
+	held, err := maintenance.ReconcileMaintenance(deviceConfig, time.Now())
+	if err != nil {
+		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, original, conditions.DriverLoaded, conditions.ReasonMaintenanceFailed, err.Error()); cerr != nil {
+			err = fmt.Errorf("%s: %w", err.Error(), cerr)
+		}
+		metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(1)
+		return ctrl.Result{}, err
+	}
+
+	version, err := r.pr.ReconcilePreflight(ctx, deviceConfig)
+	if err != nil {
+		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, original, conditions.PreflightValidated, conditions.ReasonPreflightFailed, err.Error()); cerr != nil {
+			err = fmt.Errorf("%s: %w", err.Error(), cerr)
+		}
+		metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(1)
+		return ctrl.Result{}, err
+	}
+
+	// The images of a validated version are pulled on the nodes before
+	// they are moved to it.
+	version, err = r.ppr.ReconcilePrePull(ctx, deviceConfig, version)
+	if err != nil {
+		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, original, conditions.DriverLoaded, conditions.ReasonPrePullFailed, err.Error()); cerr != nil {
+			err = fmt.Errorf("%s: %w", err.Error(), cerr)
+		}
+		metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(1)
+		return ctrl.Result{}, err
+	}
+
+	// A new version is validated by preflight and pulled right away, but the
+	// nodes are only moved to it in a maintenance window.
+	if applied := deviceConfig.Status.Maintenance; held && version == deviceConfig.Spec.Driver.Version {
+		version = applied.AppliedDriver.Version
+	}
+
+	// The nodes keep the deployed driver version until the new one passes
+	// the preflight validation, and are then moved to it by the upgrade.
+	revisions, err := r.ur.ReconcileUpgrade(ctx, deviceConfig, version)
+	if err != nil {
+		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, original, conditions.DriverLoaded, conditions.ReasonUpgradeFailed, err.Error()); cerr != nil {
+			err = fmt.Errorf("%s: %w", err.Error(), cerr)
+		}
+		metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(1)
+		return ctrl.Result{}, err
+	}
+
+	// The Modules are reconciled with the driver configuration applied to
+	// the nodes, and a rolled back driver version with the image it was
+	// known good with.
+	desired := deviceConfig
+	if held || deviceConfig.IsDriverRolledBack() {
+		desired = maintenance.GetAppliedDeviceConfig(deviceConfig)
+	}
+	if deviceConfig.IsDriverRolledBack() {
+		desired.Spec.Driver.Image = deviceConfig.Status.Rollback.Image
+	}
+
+	if err := r.mr.ReconcileModules(ctx, desired, revisions); err != nil {
+		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, original, conditions.DriverLoaded, conditions.ReasonModuleFailed, err.Error()); cerr != nil {
+			err = fmt.Errorf("%s: %w", err.Error(), cerr)
+		}
+		metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(1)
+		return ctrl.Result{}, err
+	}
+
+	reload, err := module.SetModprobeConfigStatus(deviceConfig, desired)
+	if err != nil {
+		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, original, conditions.DriverLoaded, conditions.ReasonModuleFailed, err.Error()); cerr != nil {
 			err = fmt.Errorf("%s: %w", err.Error(), cerr)
 		}
 		metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(1)
 		return ctrl.Result{}, err
 	}
+	if reload {
+		r.Recorder.Event(deviceConfig, v1.EventTypeNormal, "DriverReload",
+			"The driver modprobe configuration changed, reloading the driver on the selected nodes through their upgrade")
+	}
 
 	if err = r.nlr.ReconcileNodeLabeler(ctx, deviceConfig); err != nil {
-		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, conditions.ReasonNodeLabelerFailed, err.Error()); cerr != nil {
+		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, original, conditions.NodeLabelerReady, conditions.ReasonNodeLabelerFailed, err.Error()); cerr != nil {
 			err = fmt.Errorf("%s: %w", err.Error(), cerr)
 		}
 		metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(1)
@@ -163,13 +300,17 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 	}
 
 	if err = r.nmr.ReconcileNodeMetrics(ctx, deviceConfig); err != nil {
-		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, conditions.ReasonNodeMetricsFailed, err.Error()); cerr != nil {
+		if cerr := r.cu.SetConditionsErrored(ctx, deviceConfig, original, conditions.NodeMetricsReady, conditions.ReasonNodeMetricsFailed, err.Error()); cerr != nil {
 			err = fmt.Errorf("%s: %w", err.Error(), cerr)
 		}
 		metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(1)
 		return ctrl.Result{}, err
 	}
 
+	if err = r.nsu.SetNodesStatus(ctx, deviceConfig); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to get the nodes status: %w", err)
+	}
+
 	metrics.ReconciliationFailed.WithLabelValues(deviceConfig.Name).Set(0)
 
 	r.Recorder.Event(
@@ -179,7 +320,85 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 		fmt.Sprintf("Succesfully reconciled DeviceConfig %s/%s", deviceConfig.Namespace, deviceConfig.Name),
 	)
 
-	return ctrl.Result{}, r.cu.SetConditionsReady(ctx, deviceConfig, "Reconciled", "All resources have been successfully reconciled")
+	if err = r.cu.SetConditionsReconciled(ctx, deviceConfig, original); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	r.reportUnsupportedKernels(deviceConfig, original)
+	r.reportMaintenance(deviceConfig, original)
+
+	if conditions.IsRolloutStalled(deviceConfig) && !conditions.IsRolloutStalled(original) {
+		progressing := meta.FindStatusCondition(deviceConfig.Status.Conditions, conditions.Progressing)
+		r.Recorder.Event(deviceConfig, v1.EventTypeWarning, conditions.ReasonProgressDeadlineExceeded, progressing.Message)
+	}
+
+	// The Module, DaemonSets and pods are watched, but a rollout is polled
+	// too, so that a stalled one gets reported at its deadline, and so is a
+	// node being upgraded or leaving.
+	requeueAfter := conditions.RequeueAfter(deviceConfig)
+	if after := upgrade.RequeueAfter(deviceConfig); after > 0 && (requeueAfter == 0 || after < requeueAfter) {
+		requeueAfter = after
+	}
+	if after := maintenance.RequeueAfter(deviceConfig, time.Now()); after > 0 && (requeueAfter == 0 || after < requeueAfter) {
+		requeueAfter = after
+	}
+	if after := prepull.RequeueAfter(deviceConfig, time.Now()); after > 0 && (requeueAfter == 0 || after < requeueAfter) {
+		requeueAfter = after
+	}
+	if after := nodetargets.RequeueAfter(deviceConfig); after > 0 && (requeueAfter == 0 || after < requeueAfter) {
+		requeueAfter = after
+	}
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// reportUnsupportedKernels exports the number of selected nodes whose kernel
+// matches no kernel mapping, and emits an event when they change.
+func (r *Reconciler) reportUnsupportedKernels(cr, original *hlaiv1beta1.DeviceConfig) {
+	unsupported := 0
+	for _, ns := range cr.Status.Nodes {
+		if !ns.KernelSupported {
+			unsupported++
+		}
+	}
+	metrics.KernelUnsupportedNodes.WithLabelValues(cr.Name).Set(float64(unsupported))
+
+	c := meta.FindStatusCondition(cr.Status.Conditions, conditions.KernelUnsupported)
+	if c == nil || c.Status != metav1.ConditionTrue {
+		return
+	}
+
+	previous := meta.FindStatusCondition(original.Status.Conditions, conditions.KernelUnsupported)
+	if previous != nil && previous.Status == metav1.ConditionTrue && previous.Message == c.Message {
+		return
+	}
+
+	r.Recorder.Event(cr, v1.EventTypeWarning, conditions.KernelUnsupported, c.Message)
+}
+
+// reportMaintenance emits an event when driver changes start waiting for a
+// maintenance window, and when they are applied in one.
+func (r *Reconciler) reportMaintenance(cr, original *hlaiv1beta1.DeviceConfig) {
+	var pending, previous []string
+	if s := cr.Status.Maintenance; s != nil {
+		pending = s.PendingChanges
+	}
+	if s := original.Status.Maintenance; s != nil {
+		previous = s.PendingChanges
+	}
+
+	switch {
+	case len(pending) > 0 && !reflect.DeepEqual(pending, previous):
+		next := "no upcoming window"
+		if t := cr.Status.Maintenance.NextWindow; t != nil {
+			next = fmt.Sprintf("the window opening at %s", t.UTC().Format(time.RFC3339))
+		}
+		r.Recorder.Eventf(cr, v1.EventTypeNormal, "DriverChangesPending",
+			"The driver changes %s wait for %s", strings.Join(pending, ", "), next)
+	case len(pending) == 0 && len(previous) > 0 && cr.Status.Maintenance != nil:
+		r.Recorder.Eventf(cr, v1.EventTypeNormal, "DriverChangesApplied",
+			"Applying the driver changes %s in the maintenance window", strings.Join(previous, ", "))
+	}
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -190,16 +409,184 @@ func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
 	}
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("deviceconfig").
-		For(&hlaiv1alpha1.DeviceConfig{}).
+		For(&hlaiv1beta1.DeviceConfig{}).
 		Owns(&kmmv1beta1.Module{}).
 		Owns(&appsv1.DaemonSet{}).
+		Owns(&v1.ConfigMap{}).
+		Watches(
+			&source.Kind{Type: &v1.Node{}},
+			handler.EnqueueRequestsFromMapFunc(r.findDeviceConfigsForNode),
+			builder.WithPredicates(nodeChangedPredicate()),
+		).
+		Watches(
+			&source.Kind{Type: &v1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(r.findDeviceConfigForPod),
+			builder.WithPredicates(operandPodPredicate()),
+		).
+		Watches(
+			&source.Kind{Type: &kmmv1beta1.PreflightValidation{}},
+			handler.EnqueueRequestsFromMapFunc(r.findDeviceConfigForPreflightValidation),
+		).
 		Complete(r)
 }
 
-func (r *Reconciler) deleteDeviceConfigResources(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
-	if err := r.mr.DeleteModule(ctx, cr); err != nil {
-		return err
+// findDeviceConfigForPod maps an operand pod to the DeviceConfig owning its
+// DaemonSet. A pod going into CrashLoopBackOff does not change the status of
+// its DaemonSet, so the pods are watched to update the conditions.
+func (r *Reconciler) findDeviceConfigForPod(o client.Object) []reconcile.Request {
+	owner := metav1.GetControllerOf(o)
+	if owner == nil || owner.Kind != "DaemonSet" {
+		return nil
+	}
+
+	ds := &appsv1.DaemonSet{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: o.GetNamespace(), Name: owner.Name}, ds); err != nil {
+		if !errors.IsNotFound(err) {
+			log.Log.Error(err, "Failed to get DaemonSet", "pod", o.GetName(), "daemonset", owner.Name)
+		}
+		return nil
+	}
+
+	owner = metav1.GetControllerOf(ds)
+	if owner == nil || owner.Kind != "DeviceConfig" {
+		return nil
+	}
+
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: ds.Namespace, Name: owner.Name}},
+	}
+}
+
+// operandPodPredicate filters the pods of the DaemonSets created by the operator.
+func operandPodPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(o client.Object) bool {
+		return o.GetLabels()[instance.NameLabel] == constants.HabanaAIOperatorName
+	})
+}
+
+// findDeviceConfigForPreflightValidation maps a PreflightValidation to the
+// DeviceConfig validating a driver version with it. PreflightValidations are
+// cluster scoped, so that they are not owned but labelled with the
+// DeviceConfig.
+func (r *Reconciler) findDeviceConfigForPreflightValidation(o client.Object) []reconcile.Request {
+	value, ok := o.GetLabels()[nodetargets.TargetLabel]
+	if !ok {
+		return nil
+	}
+
+	dcs := &hlaiv1beta1.DeviceConfigList{}
+	if err := r.List(context.TODO(), dcs); err != nil {
+		log.Log.Error(err, "Failed to list DeviceConfigs", "preflightvalidation", o.GetName())
+		return nil
+	}
+
+	for i := range dcs.Items {
+		if dc := &dcs.Items[i]; nodetargets.GetTargetLabelValue(dc) == value {
+			return []reconcile.Request{
+				{NamespacedName: types.NamespacedName{Namespace: dc.Namespace, Name: dc.Name}},
+			}
+		}
 	}
 
 	return nil
 }
+
+// findDeviceConfigsForNode maps a Node to the DeviceConfigs selecting it, and
+// to the DeviceConfig whose target label it still has.
+func (r *Reconciler) findDeviceConfigsForNode(o client.Object) []reconcile.Request {
+	node, ok := o.(*v1.Node)
+	if !ok {
+		return nil
+	}
+
+	dcs := &hlaiv1beta1.DeviceConfigList{}
+	if err := r.List(context.TODO(), dcs); err != nil {
+		log.Log.Error(err, "Failed to list DeviceConfigs", "node", o.GetName())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for i := range dcs.Items {
+		dc := &dcs.Items[i]
+
+		selected, err := nodeselector.SelectsNode(dc, node)
+		if err != nil {
+			log.Log.Error(err, "Failed to match DeviceConfig node selector", "node", o.GetName(), "resource", dc.Name)
+		}
+
+		if selected || node.Labels[nodetargets.TargetLabel] == nodetargets.GetTargetLabelValue(dc) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: dc.Namespace, Name: dc.Name},
+			})
+		}
+	}
+
+	return requests
+}
+
+// nodeChangedPredicate filters the Node updates that may change the status of
+// a DeviceConfig: its labels, which select it, its kernel version, which
+// selects the driver image, and its allocatable HPUs.
+func nodeChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, ok := e.ObjectOld.(*v1.Node)
+			if !ok {
+				return false
+			}
+			newNode, ok := e.ObjectNew.(*v1.Node)
+			if !ok {
+				return false
+			}
+
+			if !reflect.DeepEqual(oldNode.Labels, newNode.Labels) {
+				return true
+			}
+
+			if oldNode.Status.NodeInfo.KernelVersion != newNode.Status.NodeInfo.KernelVersion {
+				return true
+			}
+
+			for _, t := range hlaiv1beta1.DeviceTypes {
+				oldHPUs := oldNode.Status.Allocatable[t.GetResourceName()]
+				newHPUs := newNode.Status.Allocatable[t.GetResourceName()]
+				if !oldHPUs.Equal(newHPUs) {
+					return true
+				}
+			}
+
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// deleteDeviceConfigResources returns true once the resources of cr are
+// deleted. The components on the nodes are deleted in order by the deletion
+// reconciler, and the nodes are only released once they are gone.
+func (r *Reconciler) deleteDeviceConfigResources(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (bool, error) {
+	if err := r.pr.DeletePreflight(ctx, cr); err != nil {
+		return false, err
+	}
+
+	if err := r.ppr.DeletePrePull(ctx, cr); err != nil {
+		return false, err
+	}
+
+	deleted, err := r.dr.ReconcileDeletion(ctx, cr)
+	if err != nil || !deleted {
+		return false, err
+	}
+
+	if err := r.ur.ClearUpgrade(ctx, cr); err != nil {
+		return false, err
+	}
+
+	if err := r.ntu.ClearTargetNodes(ctx, cr); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
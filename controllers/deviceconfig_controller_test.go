@@ -23,25 +23,41 @@ import (
 	"time"
 
 	gomock "github.com/golang/mock/gomock"
+	dto "github.com/prometheus/client_model/go"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	record "k8s.io/client-go/tools/record"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	"github.com/HabanaAI/habana-ai-operator/internal/client"
 	"github.com/HabanaAI/habana-ai-operator/internal/conditions"
+	"github.com/HabanaAI/habana-ai-operator/internal/deletion"
 	"github.com/HabanaAI/habana-ai-operator/internal/finalizers"
+	"github.com/HabanaAI/habana-ai-operator/internal/metrics"
 	"github.com/HabanaAI/habana-ai-operator/internal/module"
 	nodeLabeler "github.com/HabanaAI/habana-ai-operator/internal/node/labeler"
 	nodeMetrics "github.com/HabanaAI/habana-ai-operator/internal/node/metrics"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodeselector"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodestatus"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	"github.com/HabanaAI/habana-ai-operator/internal/preflight"
+	"github.com/HabanaAI/habana-ai-operator/internal/prepull"
+	"github.com/HabanaAI/habana-ai-operator/internal/upgrade"
 	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
 )
 
@@ -64,11 +80,17 @@ var _ = Describe("DeviceConfigReconciler", func() {
 			var (
 				gCtrl *gomock.Controller
 				mr    *module.MockReconciler
+				pr    *preflight.MockReconciler
+				ppr   *prepull.MockReconciler
+				ur    *upgrade.MockReconciler
 				nmr   *nodeMetrics.MockReconciler
 				nlr   *nodeLabeler.MockReconciler
+				dr    *deletion.MockReconciler
 				fu    *finalizers.MockUpdater
 				cu    *conditions.MockUpdater
-				nsv   *MockNodeSelectorValidator
+				nsv   *nodeselector.MockValidator
+				nsu   *nodestatus.MockUpdater
+				ntu   *nodetargets.MockUpdater
 				r     *Reconciler
 				c     *client.MockClient
 			)
@@ -76,11 +98,17 @@ var _ = Describe("DeviceConfigReconciler", func() {
 			BeforeEach(func() {
 				gCtrl = gomock.NewController(GinkgoT())
 				mr = module.NewMockReconciler(gCtrl)
+				pr = preflight.NewMockReconciler(gCtrl)
+				ppr = prepull.NewMockReconciler(gCtrl)
+				ur = upgrade.NewMockReconciler(gCtrl)
 				nmr = nodeMetrics.NewMockReconciler(gCtrl)
 				nlr = nodeLabeler.NewMockReconciler(gCtrl)
+				dr = deletion.NewMockReconciler(gCtrl)
 				fu = finalizers.NewMockUpdater(gCtrl)
 				cu = conditions.NewMockUpdater(gCtrl)
-				nsv = NewMockNodeSelectorValidator(gCtrl)
+				nsv = nodeselector.NewMockValidator(gCtrl)
+				nsu = nodestatus.NewMockUpdater(gCtrl)
+				ntu = nodetargets.NewMockUpdater(gCtrl)
 				c = client.NewMockClient(gCtrl)
 			})
 
@@ -88,7 +116,7 @@ var _ = Describe("DeviceConfigReconciler", func() {
 				BeforeEach(func() {
 					s := scheme.Scheme
 
-					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, nmr, nlr, fu, cu, nsv)
+					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
 
 					gomock.InOrder(
 						c.EXPECT().
@@ -108,7 +136,7 @@ var _ = Describe("DeviceConfigReconciler", func() {
 				BeforeEach(func() {
 					s := scheme.Scheme
 
-					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, nmr, nlr, fu, cu, nsv)
+					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
 
 					gomock.InOrder(
 						c.EXPECT().
@@ -127,26 +155,32 @@ var _ = Describe("DeviceConfigReconciler", func() {
 			When("no client error occurs", func() {
 				BeforeEach(func() {
 					s := scheme.Scheme
-					Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
-					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, nmr, nlr, fu, cu, nsv)
+					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
 
 					gomock.InOrder(
 						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
-							func(_ interface{}, _ interface{}, d *hlaiv1alpha1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
 								d.ObjectMeta = dc.ObjectMeta
 								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
 								return nil
 							},
 						),
 						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil),
 						fu.EXPECT().ContainsDeletionFinalizer(dc).Return(false),
 						fu.EXPECT().AddDeletionFinalizer(ctx, dc).Return(nil),
-						mr.EXPECT().ReconcileModule(ctx, dc).Return(nil),
+						ntu.EXPECT().SetTargetNodes(ctx, dc).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, dc).Return(dc.Spec.Driver.Version, nil),
+						ppr.EXPECT().ReconcilePrePull(ctx, dc, dc.Spec.Driver.Version).Return(dc.Spec.Driver.Version, nil),
+						ur.EXPECT().ReconcileUpgrade(ctx, dc, dc.Spec.Driver.Version).Return([]module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}, nil),
+						mr.EXPECT().ReconcileModules(ctx, dc, []module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}).Return(nil),
 						nlr.EXPECT().ReconcileNodeLabeler(ctx, dc).Return(nil),
 						nmr.EXPECT().ReconcileNodeMetrics(ctx, dc).Return(nil),
-						cu.EXPECT().SetConditionsReady(ctx, dc, "Reconciled", gomock.Any()).Return(nil),
+						nsu.EXPECT().SetNodesStatus(ctx, dc).Return(nil),
+						cu.EXPECT().SetConditionsReconciled(ctx, dc, dc).Return(nil),
 					)
 				})
 
@@ -157,27 +191,441 @@ var _ = Describe("DeviceConfigReconciler", func() {
 				})
 			})
 
+			When("a new driver version is not validated yet", func() {
+				BeforeEach(func() {
+					s := scheme.Scheme
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
+
+					gomock.InOrder(
+						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+								d.ObjectMeta = dc.ObjectMeta
+								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
+								return nil
+							},
+						),
+						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil),
+						fu.EXPECT().ContainsDeletionFinalizer(dc).Return(true),
+						ntu.EXPECT().SetTargetNodes(ctx, dc).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, dc).Return("1.8.0-1", nil),
+						ppr.EXPECT().ReconcilePrePull(ctx, dc, "1.8.0-1").Return("1.8.0-1", nil),
+						ur.EXPECT().ReconcileUpgrade(ctx, dc, "1.8.0-1").Return([]module.Revision{{Version: "1.8.0-1", Label: "1.8.0-1"}}, nil),
+						mr.EXPECT().ReconcileModules(ctx, dc, []module.Revision{{Version: "1.8.0-1", Label: "1.8.0-1"}}).Return(nil),
+						nlr.EXPECT().ReconcileNodeLabeler(ctx, dc).Return(nil),
+						nmr.EXPECT().ReconcileNodeMetrics(ctx, dc).Return(nil),
+						nsu.EXPECT().SetNodesStatus(ctx, dc).Return(nil),
+						cu.EXPECT().SetConditionsReconciled(ctx, dc, dc).Return(nil),
+					)
+				})
+
+				It("should keep upgrading the nodes to the deployed driver version", func() {
+					_, err := r.Reconcile(ctx, req)
+					Expect(err).ToNot(HaveOccurred())
+				})
+			})
+
+			When("the images of a new driver version are being pulled", func() {
+				BeforeEach(func() {
+					s := scheme.Scheme
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
+
+					gomock.InOrder(
+						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+								d.ObjectMeta = dc.ObjectMeta
+								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
+								return nil
+							},
+						),
+						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil),
+						fu.EXPECT().ContainsDeletionFinalizer(dc).Return(true),
+						ntu.EXPECT().SetTargetNodes(ctx, dc).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, dc).Return(dc.Spec.Driver.Version, nil),
+						ppr.EXPECT().ReconcilePrePull(ctx, dc, dc.Spec.Driver.Version).Return("1.8.0-1", nil),
+						ur.EXPECT().ReconcileUpgrade(ctx, dc, "1.8.0-1").Return([]module.Revision{{Version: "1.8.0-1", Label: "1.8.0-1"}}, nil),
+						mr.EXPECT().ReconcileModules(ctx, dc, []module.Revision{{Version: "1.8.0-1", Label: "1.8.0-1"}}).Return(nil),
+						nlr.EXPECT().ReconcileNodeLabeler(ctx, dc).Return(nil),
+						nmr.EXPECT().ReconcileNodeMetrics(ctx, dc).Return(nil),
+						nsu.EXPECT().SetNodesStatus(ctx, dc).Return(nil),
+						cu.EXPECT().SetConditionsReconciled(ctx, dc, dc).Return(nil),
+					)
+				})
+
+				It("should keep the nodes on the deployed driver version", func() {
+					_, err := r.Reconcile(ctx, req)
+					Expect(err).ToNot(HaveOccurred())
+				})
+			})
+
+			When("the preflight validation cannot be reconciled", func() {
+				BeforeEach(func() {
+					s := scheme.Scheme
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
+
+					gomock.InOrder(
+						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+								d.ObjectMeta = dc.ObjectMeta
+								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
+								return nil
+							},
+						),
+						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil),
+						fu.EXPECT().ContainsDeletionFinalizer(dc).Return(true),
+						ntu.EXPECT().SetTargetNodes(ctx, dc).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, dc).Return("", errors.New("some-error")),
+						cu.EXPECT().SetConditionsErrored(ctx, dc, dc, conditions.PreflightValidated, conditions.ReasonPreflightFailed, "some-error").Return(nil),
+					)
+				})
+
+				It("should not update the Module and return an error", func() {
+					_, err := r.Reconcile(ctx, req)
+					Expect(err).To(HaveOccurred())
+				})
+			})
+
+			When("the rollout stalls", func() {
+				var fakeRecorder *record.FakeRecorder
+
+				BeforeEach(func() {
+					s := scheme.Scheme
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+					fakeRecorder = record.NewFakeRecorder(2)
+					r = NewReconciler(c, s, fakeRecorder, mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
+
+					gomock.InOrder(
+						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+								d.ObjectMeta = dc.ObjectMeta
+								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
+								return nil
+							},
+						),
+						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil),
+						fu.EXPECT().ContainsDeletionFinalizer(dc).Return(true),
+						ntu.EXPECT().SetTargetNodes(ctx, dc).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, dc).Return(dc.Spec.Driver.Version, nil),
+						ppr.EXPECT().ReconcilePrePull(ctx, dc, dc.Spec.Driver.Version).Return(dc.Spec.Driver.Version, nil),
+						ur.EXPECT().ReconcileUpgrade(ctx, dc, dc.Spec.Driver.Version).Return([]module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}, nil),
+						mr.EXPECT().ReconcileModules(ctx, dc, []module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}).Return(nil),
+						nlr.EXPECT().ReconcileNodeLabeler(ctx, dc).Return(nil),
+						nmr.EXPECT().ReconcileNodeMetrics(ctx, dc).Return(nil),
+						nsu.EXPECT().SetNodesStatus(ctx, dc).Return(nil),
+						cu.EXPECT().SetConditionsReconciled(ctx, dc, dc).DoAndReturn(
+							func(_ context.Context, d, _ *hlaiv1beta1.DeviceConfig) error {
+								meta.SetStatusCondition(&d.Status.Conditions, metav1.Condition{
+									Type:    conditions.Progressing,
+									Status:  metav1.ConditionFalse,
+									Reason:  conditions.ReasonProgressDeadlineExceeded,
+									Message: "Rollout did not complete within 30m0s, stuck on a-node",
+								})
+								return nil
+							},
+						),
+					)
+				})
+
+				It("should record an event naming the stuck nodes and keep polling", func() {
+					res, err := r.Reconcile(ctx, req)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(res.RequeueAfter).To(BeNumerically(">", 0))
+
+					Expect(<-fakeRecorder.Events).To(ContainSubstring("Reconciled"))
+					Expect(<-fakeRecorder.Events).To(ContainSubstring("stuck on a-node"))
+				})
+			})
+
+			When("a node kernel matches no kernel mapping", func() {
+				var fakeRecorder *record.FakeRecorder
+
+				BeforeEach(func() {
+					s := scheme.Scheme
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+					fakeRecorder = record.NewFakeRecorder(2)
+					r = NewReconciler(c, s, fakeRecorder, mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
+
+					gomock.InOrder(
+						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+								d.ObjectMeta = dc.ObjectMeta
+								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
+								return nil
+							},
+						),
+						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil),
+						fu.EXPECT().ContainsDeletionFinalizer(dc).Return(true),
+						ntu.EXPECT().SetTargetNodes(ctx, dc).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, dc).Return(dc.Spec.Driver.Version, nil),
+						ppr.EXPECT().ReconcilePrePull(ctx, dc, dc.Spec.Driver.Version).Return(dc.Spec.Driver.Version, nil),
+						ur.EXPECT().ReconcileUpgrade(ctx, dc, dc.Spec.Driver.Version).Return([]module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}, nil),
+						mr.EXPECT().ReconcileModules(ctx, dc, []module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}).Return(nil),
+						nlr.EXPECT().ReconcileNodeLabeler(ctx, dc).Return(nil),
+						nmr.EXPECT().ReconcileNodeMetrics(ctx, dc).Return(nil),
+						nsu.EXPECT().SetNodesStatus(ctx, dc).DoAndReturn(
+							func(_ context.Context, d *hlaiv1beta1.DeviceConfig) error {
+								d.Status.Nodes = []hlaiv1beta1.NodeStatus{
+									{Name: "a-node", KernelVersion: "5.15.0-76-generic"},
+									{Name: "another-node", KernelSupported: true},
+								}
+								return nil
+							},
+						),
+						cu.EXPECT().SetConditionsReconciled(ctx, gomock.Any(), dc).DoAndReturn(
+							func(_ context.Context, d, _ *hlaiv1beta1.DeviceConfig) error {
+								meta.SetStatusCondition(&d.Status.Conditions, metav1.Condition{
+									Type:    conditions.KernelUnsupported,
+									Status:  metav1.ConditionTrue,
+									Reason:  conditions.ReasonNoKernelMapping,
+									Message: "The kernel of 1/2 selected nodes matches no kernel mapping: a-node (5.15.0-76-generic)",
+								})
+								return nil
+							},
+						),
+					)
+				})
+
+				It("should record an event naming the node and export the number of nodes", func() {
+					_, err := r.Reconcile(ctx, req)
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(<-fakeRecorder.Events).To(ContainSubstring("Reconciled"))
+					Expect(<-fakeRecorder.Events).To(ContainSubstring("a-node (5.15.0-76-generic)"))
+
+					gauge := &dto.Metric{}
+					Expect(metrics.KernelUnsupportedNodes.WithLabelValues(dc.Name).Write(gauge)).To(Succeed())
+					Expect(gauge.GetGauge().GetValue()).To(Equal(float64(1)))
+				})
+			})
+
+			When("the driver modprobe configuration changes", func() {
+				var fakeRecorder *record.FakeRecorder
+
+				BeforeEach(func() {
+					s := scheme.Scheme
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+					fakeRecorder = record.NewFakeRecorder(2)
+					r = NewReconciler(c, s, fakeRecorder, mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
+
+					gomock.InOrder(
+						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+								d.ObjectMeta = dc.ObjectMeta
+								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = "previous-hash"
+								return nil
+							},
+						),
+						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, gomock.Any()).Return(nil),
+						fu.EXPECT().ContainsDeletionFinalizer(gomock.Any()).Return(true),
+						ntu.EXPECT().SetTargetNodes(ctx, gomock.Any()).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, gomock.Any()).Return(dc.Spec.Driver.Version, nil),
+						ppr.EXPECT().ReconcilePrePull(ctx, gomock.Any(), dc.Spec.Driver.Version).Return(dc.Spec.Driver.Version, nil),
+						ur.EXPECT().ReconcileUpgrade(ctx, gomock.Any(), dc.Spec.Driver.Version).Return([]module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}, nil),
+						mr.EXPECT().ReconcileModules(ctx, gomock.Any(), []module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}).Return(nil),
+						nlr.EXPECT().ReconcileNodeLabeler(ctx, gomock.Any()).Return(nil),
+						nmr.EXPECT().ReconcileNodeMetrics(ctx, gomock.Any()).Return(nil),
+						nsu.EXPECT().SetNodesStatus(ctx, gomock.Any()).Return(nil),
+						cu.EXPECT().SetConditionsReconciled(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+							func(_ context.Context, d, _ *hlaiv1beta1.DeviceConfig) error {
+								Expect(d.Status.ModprobeConfigHash).To(Equal(dc.Status.ModprobeConfigHash))
+								Expect(d.Status.ModprobeConfigUpdateTime).ToNot(BeNil())
+								return nil
+							},
+						),
+					)
+				})
+
+				It("should record the new configuration and a reload event", func() {
+					_, err := r.Reconcile(ctx, req)
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(<-fakeRecorder.Events).To(ContainSubstring("DriverReload"))
+					Expect(<-fakeRecorder.Events).To(ContainSubstring("Reconciled"))
+				})
+			})
+
+			When("driver changes wait for a maintenance window", func() {
+				var fakeRecorder *record.FakeRecorder
+
+				BeforeEach(func() {
+					s := scheme.Scheme
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+					fakeRecorder = record.NewFakeRecorder(2)
+					r = NewReconciler(c, s, fakeRecorder, mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
+
+					gomock.InOrder(
+						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+								d.ObjectMeta = dc.ObjectMeta
+								d.Spec = *dc.Spec.DeepCopy()
+								d.Spec.Driver.Version = "1.9.0-1"
+								d.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{Parameters: []string{"timeout_locked=30"}}
+								// A window that never opens.
+								d.Spec.MaintenanceWindows = []hlaiv1beta1.MaintenanceWindow{{Schedule: "0 0 30 2 *", DurationSeconds: 3600}}
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
+								d.Status.Maintenance = &hlaiv1beta1.MaintenanceStatus{
+									AppliedDriver: hlaiv1beta1.DriverSpec{Version: "1.8.0-1"},
+								}
+								return nil
+							},
+						),
+						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, gomock.Any()).Return(nil),
+						fu.EXPECT().ContainsDeletionFinalizer(gomock.Any()).Return(true),
+						ntu.EXPECT().SetTargetNodes(ctx, gomock.Any()).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, gomock.Any()).Return("1.9.0-1", nil),
+						ppr.EXPECT().ReconcilePrePull(ctx, gomock.Any(), "1.9.0-1").Return("1.9.0-1", nil),
+						ur.EXPECT().ReconcileUpgrade(ctx, gomock.Any(), "1.8.0-1").Return([]module.Revision{{Version: "1.8.0-1", Label: "1.8.0-1"}}, nil),
+						mr.EXPECT().ReconcileModules(ctx, gomock.Any(), []module.Revision{{Version: "1.8.0-1", Label: "1.8.0-1"}}).DoAndReturn(
+							func(_ context.Context, d *hlaiv1beta1.DeviceConfig, _ []module.Revision) error {
+								Expect(d.Spec.Driver.Modprobe).To(BeNil())
+								return nil
+							},
+						),
+						nlr.EXPECT().ReconcileNodeLabeler(ctx, gomock.Any()).Return(nil),
+						nmr.EXPECT().ReconcileNodeMetrics(ctx, gomock.Any()).Return(nil),
+						nsu.EXPECT().SetNodesStatus(ctx, gomock.Any()).Return(nil),
+						cu.EXPECT().SetConditionsReconciled(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+							func(_ context.Context, d, _ *hlaiv1beta1.DeviceConfig) error {
+								Expect(d.Status.Maintenance.PendingChanges).To(Equal([]string{"version 1.8.0-1 to 1.9.0-1", "modprobe"}))
+								Expect(d.Status.ModprobeConfigHash).To(Equal(dc.Status.ModprobeConfigHash))
+								return nil
+							},
+						),
+					)
+				})
+
+				It("should keep the applied driver and record a pending changes event", func() {
+					_, err := r.Reconcile(ctx, req)
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(<-fakeRecorder.Events).To(ContainSubstring("Reconciled"))
+					Expect(<-fakeRecorder.Events).To(ContainSubstring(
+						"DriverChangesPending The driver changes version 1.8.0-1 to 1.9.0-1, modprobe wait for no upcoming window"))
+				})
+			})
+
+			When("a nodes status error occurs", func() {
+				BeforeEach(func() {
+					s := scheme.Scheme
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
+
+					gomock.InOrder(
+						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+								d.ObjectMeta = dc.ObjectMeta
+								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
+								return nil
+							},
+						),
+						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil),
+						fu.EXPECT().ContainsDeletionFinalizer(dc).Return(false),
+						fu.EXPECT().AddDeletionFinalizer(ctx, dc).Return(nil),
+						ntu.EXPECT().SetTargetNodes(ctx, dc).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, dc).Return(dc.Spec.Driver.Version, nil),
+						ppr.EXPECT().ReconcilePrePull(ctx, dc, dc.Spec.Driver.Version).Return(dc.Spec.Driver.Version, nil),
+						ur.EXPECT().ReconcileUpgrade(ctx, dc, dc.Spec.Driver.Version).Return([]module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}, nil),
+						mr.EXPECT().ReconcileModules(ctx, dc, []module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}).Return(nil),
+						nlr.EXPECT().ReconcileNodeLabeler(ctx, dc).Return(nil),
+						nmr.EXPECT().ReconcileNodeMetrics(ctx, dc).Return(nil),
+						nsu.EXPECT().SetNodesStatus(ctx, dc).Return(errors.New("some-error")),
+					)
+				})
+
+				It("should return the respective error", func() {
+					_, err := r.Reconcile(ctx, req)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("some-error"))
+				})
+			})
+
 			When("a reconcile Module error occurs", func() {
 				BeforeEach(func() {
 					s := scheme.Scheme
-					Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
-					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, nmr, nlr, fu, cu, nsv)
+					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
 
 					gomock.InOrder(
 						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
-							func(_ interface{}, _ interface{}, d *hlaiv1alpha1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
 								d.ObjectMeta = dc.ObjectMeta
 								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
 								return nil
 							},
 						),
 						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil),
 						fu.EXPECT().ContainsDeletionFinalizer(dc).Return(false),
 						fu.EXPECT().AddDeletionFinalizer(ctx, dc).Return(nil),
-						mr.EXPECT().ReconcileModule(ctx, dc).Return(errors.New("some-error")),
-						cu.EXPECT().SetConditionsErrored(ctx, dc, conditions.ReasonModuleFailed, gomock.Any()).Return(nil),
+						ntu.EXPECT().SetTargetNodes(ctx, dc).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, dc).Return(dc.Spec.Driver.Version, nil),
+						ppr.EXPECT().ReconcilePrePull(ctx, dc, dc.Spec.Driver.Version).Return(dc.Spec.Driver.Version, nil),
+						ur.EXPECT().ReconcileUpgrade(ctx, dc, dc.Spec.Driver.Version).Return([]module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}, nil),
+						mr.EXPECT().ReconcileModules(ctx, dc, []module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}).Return(errors.New("some-error")),
+						cu.EXPECT().SetConditionsErrored(ctx, dc, dc, conditions.DriverLoaded, conditions.ReasonModuleFailed, gomock.Any()).Return(nil),
+					)
+				})
+
+				It("should return the respective error", func() {
+					res, err := r.Reconcile(ctx, req)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("some-error"))
+					Expect(res.Requeue).To(BeFalse())
+				})
+			})
+
+			When("a reconcile upgrade error occurs", func() {
+				BeforeEach(func() {
+					s := scheme.Scheme
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
+
+					gomock.InOrder(
+						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+								d.ObjectMeta = dc.ObjectMeta
+								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
+								return nil
+							},
+						),
+						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil),
+						fu.EXPECT().ContainsDeletionFinalizer(dc).Return(false),
+						fu.EXPECT().AddDeletionFinalizer(ctx, dc).Return(nil),
+						ntu.EXPECT().SetTargetNodes(ctx, dc).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, dc).Return(dc.Spec.Driver.Version, nil),
+						ppr.EXPECT().ReconcilePrePull(ctx, dc, dc.Spec.Driver.Version).Return(dc.Spec.Driver.Version, nil),
+						ur.EXPECT().ReconcileUpgrade(ctx, dc, dc.Spec.Driver.Version).Return(nil, errors.New("some-error")),
+						cu.EXPECT().SetConditionsErrored(ctx, dc, dc, conditions.DriverLoaded, conditions.ReasonUpgradeFailed, "some-error").Return(nil),
 					)
 				})
 
@@ -192,26 +640,31 @@ var _ = Describe("DeviceConfigReconciler", func() {
 			When("a reconcile NodeMetrics error occurs", func() {
 				BeforeEach(func() {
 					s := scheme.Scheme
-					Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
-					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, nmr, nlr, fu, cu, nsv)
+					r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
 
 					gomock.InOrder(
 						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
-							func(_ interface{}, _ interface{}, d *hlaiv1alpha1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
 								d.ObjectMeta = dc.ObjectMeta
 								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
 								return nil
 							},
 						),
 						nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil),
 						fu.EXPECT().ContainsDeletionFinalizer(dc).Return(false),
 						fu.EXPECT().AddDeletionFinalizer(ctx, dc).Return(nil),
-						mr.EXPECT().ReconcileModule(ctx, dc).Return(nil),
+						ntu.EXPECT().SetTargetNodes(ctx, dc).Return(nil),
+						pr.EXPECT().ReconcilePreflight(ctx, dc).Return(dc.Spec.Driver.Version, nil),
+						ppr.EXPECT().ReconcilePrePull(ctx, dc, dc.Spec.Driver.Version).Return(dc.Spec.Driver.Version, nil),
+						ur.EXPECT().ReconcileUpgrade(ctx, dc, dc.Spec.Driver.Version).Return([]module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}, nil),
+						mr.EXPECT().ReconcileModules(ctx, dc, []module.Revision{{Version: dc.Spec.Driver.Version, Label: dc.Spec.Driver.Version}}).Return(nil),
 						nlr.EXPECT().ReconcileNodeLabeler(ctx, dc).Return(nil),
 						nmr.EXPECT().ReconcileNodeMetrics(ctx, dc).Return(errors.New("some-error")),
-						cu.EXPECT().SetConditionsErrored(ctx, dc, conditions.ReasonNodeMetricsFailed, gomock.Any()).Return(nil),
+						cu.EXPECT().SetConditionsErrored(ctx, dc, dc, conditions.NodeMetricsReady, conditions.ReasonNodeMetricsFailed, gomock.Any()).Return(nil),
 					)
 				})
 
@@ -227,16 +680,17 @@ var _ = Describe("DeviceConfigReconciler", func() {
 				When("an add finalizer error occurs", func() {
 					BeforeEach(func() {
 						s := scheme.Scheme
-						Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+						Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 						Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
-						r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, nmr, nlr, fu, cu, nsv)
+						r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
 
 						gomock.InOrder(
 							c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
-								func(_ interface{}, _ interface{}, d *hlaiv1alpha1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+								func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
 									d.ObjectMeta = dc.ObjectMeta
 									d.Spec = dc.Spec
+									d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
 									return nil
 								},
 							),
@@ -261,8 +715,8 @@ var _ = Describe("DeviceConfigReconciler", func() {
 				gCtrl        *gomock.Controller
 				ctx          context.Context
 				r            *Reconciler
-				dc           *hlaiv1alpha1.DeviceConfig
-				nsv          *MockNodeSelectorValidator
+				dc           *hlaiv1beta1.DeviceConfig
+				nsv          *nodeselector.MockValidator
 				c            *client.MockClient
 				fakeRecorder *record.FakeRecorder
 			)
@@ -271,24 +725,34 @@ var _ = Describe("DeviceConfigReconciler", func() {
 				gCtrl = gomock.NewController(GinkgoT())
 				ctx = context.TODO()
 				dc = makeTestDeviceConfig()
-				nsv = NewMockNodeSelectorValidator(gCtrl)
+				nsv = nodeselector.NewMockValidator(gCtrl)
 				c = client.NewMockClient(gCtrl)
 			})
 
-			It("should not return an error and record a conflicting selector event", func() {
+			It("should not return an error, record a conflicting selector event and set the conditions", func() {
 				nsv.
 					EXPECT().
 					CheckDeviceConfigForConflictingNodeSelector(ctx, dc).
 					Return(fmt.Errorf("an error"))
 
 				s := scheme.Scheme
-				Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+				Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
+				subResourceClient := client.NewMockSubResourceClient(gCtrl)
+				var patched *hlaiv1beta1.DeviceConfig
 				gomock.InOrder(
 					c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
-						func(_ interface{}, _ interface{}, d *hlaiv1alpha1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+						func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
 							d.ObjectMeta = dc.ObjectMeta
 							d.Spec = dc.Spec
+							d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
+							return nil
+						},
+					),
+					c.EXPECT().Status().Return(subResourceClient),
+					subResourceClient.EXPECT().Patch(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+						func(_ interface{}, d *hlaiv1beta1.DeviceConfig, _ ctrlclient.Patch, _ ...ctrlclient.SubResourcePatchOption) error {
+							patched = d
 							return nil
 						},
 					),
@@ -297,11 +761,17 @@ var _ = Describe("DeviceConfigReconciler", func() {
 				fakeRecorder = record.NewFakeRecorder(1)
 				r = NewReconciler(c, s, fakeRecorder,
 					module.NewReconciler(c, s),
-					nodeMetrics.NewReconciler(c, s),
-					nodeLabeler.NewReconciler(c, s),
+					preflight.NewReconciler(c, s, module.NewReconciler(c, s)),
+					prepull.NewReconciler(c, s, fakeRecorder),
+					upgrade.NewReconciler(c, c, fakeRecorder),
+					nodeMetrics.NewReconciler(c, s, fakeRecorder),
+					nodeLabeler.NewReconciler(c, s, fakeRecorder),
+					nil,
 					finalizers.NewUpdater(c),
 					conditions.NewUpdater(c),
 					nsv,
+					nodestatus.NewUpdater(c, c),
+					nodetargets.NewUpdater(c, c, fakeRecorder),
 				)
 
 				res, err := r.Reconcile(ctx, req)
@@ -309,6 +779,11 @@ var _ = Describe("DeviceConfigReconciler", func() {
 				Expect(res.Requeue).To(BeFalse())
 				msg := <-fakeRecorder.Events
 				Expect(msg).To(ContainSubstring("Conflicting DeviceConfig NodeSelectors found"))
+
+				available := meta.FindStatusCondition(patched.Status.Conditions, conditions.Available)
+				Expect(available).ToNot(BeNil())
+				Expect(available.Status).To(Equal(metav1.ConditionFalse))
+				Expect(available.Reason).To(Equal(conditions.ReasonConflictingNodeSelector))
 			})
 		})
 
@@ -319,9 +794,15 @@ var _ = Describe("DeviceConfigReconciler", func() {
 			var (
 				gCtrl *gomock.Controller
 				mr    *module.MockReconciler
+				pr    *preflight.MockReconciler
+				ppr   *prepull.MockReconciler
+				ur    *upgrade.MockReconciler
 				nmr   *nodeMetrics.MockReconciler
 				nlr   *nodeLabeler.MockReconciler
+				dr    *deletion.MockReconciler
 				fu    *finalizers.MockUpdater
+				cu    *conditions.MockUpdater
+				ntu   *nodetargets.MockUpdater
 				r     *Reconciler
 				c     *client.MockClient
 			)
@@ -329,9 +810,15 @@ var _ = Describe("DeviceConfigReconciler", func() {
 			BeforeEach(func() {
 				gCtrl = gomock.NewController(GinkgoT())
 				mr = module.NewMockReconciler(gCtrl)
+				pr = preflight.NewMockReconciler(gCtrl)
+				ppr = prepull.NewMockReconciler(gCtrl)
+				ur = upgrade.NewMockReconciler(gCtrl)
 				nmr = nodeMetrics.NewMockReconciler(gCtrl)
 				nlr = nodeLabeler.NewMockReconciler(gCtrl)
+				dr = deletion.NewMockReconciler(gCtrl)
 				fu = finalizers.NewMockUpdater(gCtrl)
+				cu = conditions.NewMockUpdater(gCtrl)
+				ntu = nodetargets.NewMockUpdater(gCtrl)
 				c = client.NewMockClient(gCtrl)
 			})
 
@@ -339,24 +826,27 @@ var _ = Describe("DeviceConfigReconciler", func() {
 				Context("and a deletion error occurs", func() {
 					It("should return an error", func() {
 						s := scheme.Scheme
-						Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+						Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 						Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
 						gomock.InOrder(
 							c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
-								func(_ interface{}, _ interface{}, d *hlaiv1alpha1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+								func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
 									d.ObjectMeta = dc.ObjectMeta
 									d.Spec = dc.Spec
+									d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
 									return nil
 								},
 							),
 						)
 
-						r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, nmr, nlr, fu, nil, nil)
+						r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nil, nil, ntu)
 
 						gomock.InOrder(
 							fu.EXPECT().ContainsDeletionFinalizer(dc).Return(true),
-							mr.EXPECT().DeleteModule(ctx, dc).Return(errors.New("something went wrong")),
+							pr.EXPECT().DeletePreflight(ctx, dc).Return(nil),
+							ppr.EXPECT().DeletePrePull(ctx, dc).Return(nil),
+							dr.EXPECT().ReconcileDeletion(ctx, dc).Return(false, errors.New("something went wrong")),
 						)
 
 						res, err := r.Reconcile(ctx, req)
@@ -368,28 +858,72 @@ var _ = Describe("DeviceConfigReconciler", func() {
 					})
 				})
 
+				Context("and the deletion is in progress", func() {
+					It("should requeue without removing the finalizer", func() {
+						s := scheme.Scheme
+						Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+						Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+						gomock.InOrder(
+							c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
+								func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+									d.ObjectMeta = dc.ObjectMeta
+									d.Spec = dc.Spec
+									d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
+									return nil
+								},
+							),
+						)
+
+						r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nil, nil, ntu)
+
+						gomock.InOrder(
+							fu.EXPECT().ContainsDeletionFinalizer(dc).Return(true),
+							pr.EXPECT().DeletePreflight(ctx, dc).Return(nil),
+							ppr.EXPECT().DeletePrePull(ctx, dc).Return(nil),
+							dr.EXPECT().ReconcileDeletion(ctx, dc).DoAndReturn(
+								func(_ interface{}, d *hlaiv1beta1.DeviceConfig) (bool, error) {
+									d.Status.Deletion = &hlaiv1beta1.DeletionStatus{Phase: hlaiv1beta1.DeletionPhaseDrainingWorkloads}
+									return false, nil
+								},
+							),
+							cu.EXPECT().SetConditionsDeleting(ctx, gomock.Any(), gomock.Any()).Return(nil),
+						)
+
+						res, err := r.Reconcile(ctx, req)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(res.RequeueAfter).To(BeNumerically(">", 0))
+					})
+				})
+
 				Context("and no deletion error occurs", func() {
 					Context("and no remove finalizer error occurs", func() {
 						It("should not requeue or return an error", func() {
 							s := scheme.Scheme
-							Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+							Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 							Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
 							gomock.InOrder(
 								c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
-									func(_ interface{}, _ interface{}, d *hlaiv1alpha1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+									func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
 										d.ObjectMeta = dc.ObjectMeta
 										d.Spec = dc.Spec
+										d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
 										return nil
 									},
 								),
 							)
 
-							r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, nmr, nlr, fu, nil, nil)
+							r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nil, nil, ntu)
 
 							gomock.InOrder(
 								fu.EXPECT().ContainsDeletionFinalizer(dc).Return(true),
-								mr.EXPECT().DeleteModule(ctx, dc).Return(nil),
+								pr.EXPECT().DeletePreflight(ctx, dc).Return(nil),
+								ppr.EXPECT().DeletePrePull(ctx, dc).Return(nil),
+								dr.EXPECT().ReconcileDeletion(ctx, dc).Return(true, nil),
+								ur.EXPECT().ClearUpgrade(ctx, dc).Return(nil),
+								ntu.EXPECT().ClearTargetNodes(ctx, dc).Return(nil),
+								cu.EXPECT().SetConditionsDeleting(ctx, dc, gomock.Any()).Return(nil),
 								fu.EXPECT().RemoveDeletionFinalizer(ctx, dc).Return(nil),
 							)
 
@@ -402,24 +936,30 @@ var _ = Describe("DeviceConfigReconciler", func() {
 					Context("and a remove finalizer error occurs", func() {
 						It("should not requeue and return an error", func() {
 							s := scheme.Scheme
-							Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+							Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 							Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
 							gomock.InOrder(
 								c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
-									func(_ interface{}, _ interface{}, d *hlaiv1alpha1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+									func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
 										d.ObjectMeta = dc.ObjectMeta
 										d.Spec = dc.Spec
+										d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
 										return nil
 									},
 								),
 							)
 
-							r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, nmr, nlr, fu, nil, nil)
+							r = NewReconciler(c, s, record.NewFakeRecorder(1), mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nil, nil, ntu)
 
 							gomock.InOrder(
 								fu.EXPECT().ContainsDeletionFinalizer(dc).Return(true),
-								mr.EXPECT().DeleteModule(ctx, dc).Return(nil),
+								pr.EXPECT().DeletePreflight(ctx, dc).Return(nil),
+								ppr.EXPECT().DeletePrePull(ctx, dc).Return(nil),
+								dr.EXPECT().ReconcileDeletion(ctx, dc).Return(true, nil),
+								ur.EXPECT().ClearUpgrade(ctx, dc).Return(nil),
+								ntu.EXPECT().ClearTargetNodes(ctx, dc).Return(nil),
+								cu.EXPECT().SetConditionsDeleting(ctx, dc, gomock.Any()).Return(nil),
 								fu.EXPECT().RemoveDeletionFinalizer(ctx, dc).Return(errors.New("some error")),
 							)
 
@@ -435,9 +975,10 @@ var _ = Describe("DeviceConfigReconciler", func() {
 				It("should do nothing", func() {
 					gomock.InOrder(
 						c.EXPECT().Get(ctx, req.NamespacedName, gomock.Any()).DoAndReturn(
-							func(_ interface{}, _ interface{}, d *hlaiv1alpha1.DeviceConfig, _ ...ctrlclient.GetOption) error {
+							func(_ interface{}, _ interface{}, d *hlaiv1beta1.DeviceConfig, _ ...ctrlclient.GetOption) error {
 								d.ObjectMeta = dc.ObjectMeta
 								d.Spec = dc.Spec
+								d.Status.ModprobeConfigHash = dc.Status.ModprobeConfigHash
 								return nil
 							},
 						),
@@ -445,10 +986,10 @@ var _ = Describe("DeviceConfigReconciler", func() {
 					)
 
 					s := scheme.Scheme
-					Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+					Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 					Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
-					r = NewReconciler(c, s, record.NewFakeRecorder(1), nil, nil, nil, fu, nil, nil)
+					r = NewReconciler(c, s, record.NewFakeRecorder(1), nil, nil, nil, nil, nil, nil, nil, fu, nil, nil, nil, ntu)
 
 					res, err := r.Reconcile(ctx, req)
 					Expect(err).ToNot(HaveOccurred())
@@ -459,35 +1000,191 @@ var _ = Describe("DeviceConfigReconciler", func() {
 	})
 })
 
-func named(name string) deviceConfigOptions {
-	return func(c *hlaiv1alpha1.DeviceConfig) {
-		c.ObjectMeta.Name = name
-	}
-}
+var _ = Describe("findDeviceConfigsForNode", func() {
+	It("should return the DeviceConfigs selecting the node", func() {
+		s := scheme.Scheme
+		Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+		selecting := makeTestDeviceConfig(func(dc *hlaiv1beta1.DeviceConfig) {
+			dc.Name = "selecting"
+			dc.Namespace = "a-namespace"
+			dc.Spec.NodeSelector = map[string]string{"some": "label"}
+		})
+		other := makeTestDeviceConfig(func(dc *hlaiv1beta1.DeviceConfig) {
+			dc.Name = "other"
+			dc.Namespace = "a-namespace"
+			dc.Spec.NodeSelector = map[string]string{"other": "label"}
+		})
+
+		c := fake.NewClientBuilder().WithScheme(s).WithObjects(selecting, other).Build()
+		r := NewReconciler(c, s, record.NewFakeRecorder(1), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   "a-node",
+			Labels: map[string]string{"some": "label"},
+		}}
+
+		Expect(r.findDeviceConfigsForNode(node)).To(Equal([]reconcile.Request{
+			{NamespacedName: types.NamespacedName{Namespace: "a-namespace", Name: "selecting"}},
+		}))
+	})
+
+	It("should return the DeviceConfig whose target label the node has", func() {
+		s := scheme.Scheme
+		Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+		excluding := makeTestDeviceConfig(func(dc *hlaiv1beta1.DeviceConfig) {
+			dc.Name = "excluding"
+			dc.Namespace = "a-namespace"
+			dc.Spec.NodeSelector = map[string]string{"some": "label"}
+			dc.Spec.NodeSelectorExpressions = []metav1.LabelSelectorRequirement{
+				{Key: "excluded", Operator: metav1.LabelSelectorOpDoesNotExist},
+			}
+		})
+
+		c := fake.NewClientBuilder().WithScheme(s).WithObjects(excluding).Build()
+		r := NewReconciler(c, s, record.NewFakeRecorder(1), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name: "a-node",
+			Labels: map[string]string{
+				"some":                  "label",
+				"excluded":              "true",
+				nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(excluding),
+			},
+		}}
+
+		Expect(r.findDeviceConfigsForNode(node)).To(Equal([]reconcile.Request{
+			{NamespacedName: types.NamespacedName{Namespace: "a-namespace", Name: "excluding"}},
+		}))
+	})
+})
+
+var _ = Describe("findDeviceConfigForPreflightValidation", func() {
+	It("should return the DeviceConfig whose target label the PreflightValidation has", func() {
+		s := scheme.Scheme
+		Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+		dc := makeTestDeviceConfig(func(dc *hlaiv1beta1.DeviceConfig) {
+			dc.Namespace = "a-namespace"
+		})
+		other := makeTestDeviceConfig(func(dc *hlaiv1beta1.DeviceConfig) {
+			dc.Namespace = "other-namespace"
+		})
+
+		c := fake.NewClientBuilder().WithScheme(s).WithObjects(dc, other).Build()
+		r := NewReconciler(c, s, record.NewFakeRecorder(1), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+		pv := &kmmv1beta1.PreflightValidation{ObjectMeta: metav1.ObjectMeta{
+			Name:   "a-preflightvalidation",
+			Labels: map[string]string{nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(dc)},
+		}}
+
+		Expect(r.findDeviceConfigForPreflightValidation(pv)).To(Equal([]reconcile.Request{
+			{NamespacedName: types.NamespacedName{Namespace: "a-namespace", Name: testDeviceConfigName}},
+		}))
+		Expect(r.findDeviceConfigForPreflightValidation(&kmmv1beta1.PreflightValidation{})).To(BeEmpty())
+	})
+})
+
+var _ = Describe("findDeviceConfigForPod", func() {
+	var (
+		r  *Reconciler
+		dc *hlaiv1beta1.DeviceConfig
+		ds *appsv1.DaemonSet
+	)
+
+	BeforeEach(func() {
+		s := scheme.Scheme
+		Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+		dc = makeTestDeviceConfig(func(dc *hlaiv1beta1.DeviceConfig) {
+			dc.Namespace = "a-namespace"
+			dc.UID = "a-uid"
+		})
+		ds = &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "a-daemonset", Namespace: "a-namespace"}}
+		Expect(controllerutil.SetControllerReference(dc, ds, s)).To(Succeed())
+
+		c := fake.NewClientBuilder().WithScheme(s).WithObjects(dc, ds).Build()
+		r = NewReconciler(c, s, record.NewFakeRecorder(1), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	})
+
+	It("should return the DeviceConfig owning the pod DaemonSet", func() {
+		p := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "a-pod", Namespace: "a-namespace"}}
+		Expect(controllerutil.SetControllerReference(ds, p, scheme.Scheme)).To(Succeed())
+
+		Expect(r.findDeviceConfigForPod(p)).To(Equal([]reconcile.Request{
+			{NamespacedName: types.NamespacedName{Namespace: "a-namespace", Name: testDeviceConfigName}},
+		}))
+	})
+
+	It("should ignore pods not owned by a DaemonSet", func() {
+		p := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "a-pod", Namespace: "a-namespace"}}
+
+		Expect(r.findDeviceConfigForPod(p)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("nodeChangedPredicate", func() {
+	var (
+		oldNode *v1.Node
+		newNode *v1.Node
+	)
+
+	BeforeEach(func() {
+		oldNode = &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "a-node",
+				Labels: map[string]string{"some": "label"},
+			},
+		}
+		newNode = oldNode.DeepCopy()
+	})
+
+	It("should ignore unrelated changes", func() {
+		newNode.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+
+		Expect(nodeChangedPredicate().Update(event.UpdateEvent{ObjectOld: oldNode, ObjectNew: newNode})).To(BeFalse())
+	})
+
+	It("should accept label changes", func() {
+		newNode.Labels["some"] = "other-label"
+
+		Expect(nodeChangedPredicate().Update(event.UpdateEvent{ObjectOld: oldNode, ObjectNew: newNode})).To(BeTrue())
+	})
+
+	It("should accept kernel version changes", func() {
+		newNode.Status.NodeInfo.KernelVersion = "5.15.0-76-generic"
+
+		Expect(nodeChangedPredicate().Update(event.UpdateEvent{ObjectOld: oldNode, ObjectNew: newNode})).To(BeTrue())
+	})
+
+	It("should accept allocatable HPUs changes", func() {
+		newNode.Status.Allocatable = v1.ResourceList{hlaiv1beta1.DeviceTypeGaudi.GetResourceName(): resource.MustParse("8")}
+
+		Expect(nodeChangedPredicate().Update(event.UpdateEvent{ObjectOld: oldNode, ObjectNew: newNode})).To(BeTrue())
+	})
+})
 
 func deletedAt(now time.Time) deviceConfigOptions {
-	return func(c *hlaiv1alpha1.DeviceConfig) {
+	return func(c *hlaiv1beta1.DeviceConfig) {
 		wrapped := metav1.NewTime(now)
 		c.ObjectMeta.DeletionTimestamp = &wrapped
 	}
 }
 
-func nodeSelector(labels map[string]string) deviceConfigOptions {
-	return func(c *hlaiv1alpha1.DeviceConfig) {
-		c.Spec.NodeSelector = labels
-	}
-}
-
-type deviceConfigOptions func(*hlaiv1alpha1.DeviceConfig)
+type deviceConfigOptions func(*hlaiv1beta1.DeviceConfig)
 
-func makeTestDeviceConfig(opts ...deviceConfigOptions) *hlaiv1alpha1.DeviceConfig {
-	c := &hlaiv1alpha1.DeviceConfig{
+func makeTestDeviceConfig(opts ...deviceConfigOptions) *hlaiv1beta1.DeviceConfig {
+	c := &hlaiv1beta1.DeviceConfig{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: testDeviceConfigName,
 		},
-		Spec: hlaiv1alpha1.DeviceConfigSpec{
-			DriverImage:   "",
-			DriverVersion: "",
+		Spec: hlaiv1beta1.DeviceConfigSpec{
+			Driver: hlaiv1beta1.DriverSpec{
+				Image:   "",
+				Version: "",
+			},
 		},
 	}
 
@@ -495,5 +1192,8 @@ func makeTestDeviceConfig(opts ...deviceConfigOptions) *hlaiv1alpha1.DeviceConfi
 		o(c)
 	}
 
+	// The driver modprobe configuration is unchanged since the last reconciliation.
+	c.Status.ModprobeConfigHash, _ = module.GetModprobeConfigHash(c)
+
 	return c
 }
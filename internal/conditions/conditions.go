@@ -1,9 +1,12 @@
 /*
 Copyright 2022.
+
 Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
 You may obtain a copy of the License at
+
     http://www.apache.org/licenses/LICENSE-2.0
+
 Unless required by applicable law or agreed to in writing, software
 distributed under the License is distributed on an "AS IS" BASIS,
 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
@@ -15,31 +18,89 @@ package conditions
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
 )
 
 const (
-	Ready = "Ready"
+	// Available is true when all the components are ready on all the selected nodes.
+	Available = "Available"
+	// Progressing is true while the components are being rolled out.
+	Progressing = "Progressing"
+	// Degraded is true when a component is failing or could not be reconciled.
+	Degraded = "Degraded"
+
+	DriverLoaded      = "DriverLoaded"
+	DevicePluginReady = "DevicePluginReady"
+	NodeLabelerReady  = "NodeLabelerReady"
+	NodeMetricsReady  = "NodeMetricsReady"
+
+	// KernelUnsupported is true when the kernel of some selected nodes matches
+	// no kernel mapping, so that KMM never loads the driver on them.
+	KernelUnsupported = "KernelUnsupported"
+	// DriverSignFailed is true when KMM failed to sign the driver image of
+	// some kernels. It is only set when the driver is signed.
+	DriverSignFailed = "DriverSignFailed"
+	// PreflightValidated is true when KMM validated the latest driver version
+	// for the kernels of all the selected nodes. It is only set when the
+	// driver versions are validated.
+	PreflightValidated = "PreflightValidated"
+
+	ReasonAllNodesReady   = "AllNodesReady"
+	ReasonNodesNotReady   = "NodesNotReady"
+	ReasonRollingOut      = "RollingOut"
+	ReasonRolloutComplete = "RolloutComplete"
+	ReasonNodesFailed     = "NodesFailed"
+	ReasonAsExpected      = "AsExpected"
+	ReasonPodsFailing     = "PodsFailing"
+	ReasonPodsMissing     = "PodsMissing"
 
-	Errored = "Errored"
+	ReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	ReasonRolledBack               = "RolledBack"
 
+	ReasonAllKernelsSupported = "AllKernelsSupported"
+	ReasonNoKernelMapping     = "NoKernelMapping"
+
+	ReasonNoSignFailure = "NoSignFailure"
+	ReasonSignJobFailed = "SignJobFailed"
+
+	ReasonAllKernelsVerified = "AllKernelsVerified"
+	ReasonKernelsNotVerified = "KernelsNotVerified"
+
+	ReasonPreflightFailed   = "PreflightFailed"
+	ReasonUpgradeFailed     = "UpgradeFailed"
+	ReasonMaintenanceFailed = "MaintenanceFailed"
+	ReasonPrePullFailed     = "PrePullFailed"
 	ReasonModuleFailed      = "ModuleFailed"
 	ReasonNodeLabelerFailed = "NodeLabelerFailed"
 	ReasonNodeMetricsFailed = "NodeMetricsFailed"
 
 	ReasonConflictingNodeSelector = "ConflictingNodeSelector"
+	ReasonNodeTargetingFailed     = "NodeTargetingFailed"
+
+	ReasonDeleting = "Deleting"
+
+	// maxListedNodes bounds the number of node names in a condition message.
+	maxListedNodes = 5
+
+	minRequeueAfter = 10 * time.Second
+	maxRequeueAfter = 5 * time.Minute
 )
 
 //go:generate mockgen -source=conditions.go -package=conditions -destination=mock_conditions.go
 
 type Updater interface {
-	SetConditionsReady(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig, reason, message string) error
-	SetConditionsErrored(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig, reason, message string) error
+	SetConditionsReconciled(ctx context.Context, cr, original *hlaiv1beta1.DeviceConfig) error
+	SetConditionsErrored(ctx context.Context, cr, original *hlaiv1beta1.DeviceConfig, conditionType, reason, message string) error
+	SetConditionsDeleting(ctx context.Context, cr, original *hlaiv1beta1.DeviceConfig) error
 }
 
 type updater struct {
@@ -50,36 +111,394 @@ func NewUpdater(c client.Client) Updater {
 	return &updater{client: c}
 }
 
-func (u *updater) SetConditionsReady(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig, reason, message string) error {
-	meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
-		Type:    Ready,
-		Status:  metav1.ConditionTrue,
-		Reason:  reason,
-		Message: message,
-	})
+// SetConditionsReconciled sets the KMM component conditions, and the
+// Available, Progressing and Degraded ones, from the nodes status of cr. It
+// then patches the status of cr from original, the DeviceConfig as it was read.
+// The NodeLabelerReady and NodeMetricsReady conditions are set by their
+// reconcilers, see SetPodsHealthCondition.
+func (u *updater) SetConditionsReconciled(ctx context.Context, cr, original *hlaiv1beta1.DeviceConfig) error {
+	for _, c := range []struct {
+		conditionType string
+		ready         func(ns *hlaiv1beta1.NodeStatus) bool
+	}{
+		{DriverLoaded, func(ns *hlaiv1beta1.NodeStatus) bool { return ns.DriverLoaded }},
+		{DevicePluginReady, func(ns *hlaiv1beta1.NodeStatus) bool { return ns.DevicePluginReady }},
+	} {
+		notReady := []string{}
+		for i := range cr.Status.Nodes {
+			if !c.ready(&cr.Status.Nodes[i]) {
+				notReady = append(notReady, cr.Status.Nodes[i].Name)
+			}
+		}
 
-	meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
-		Type:   Errored,
-		Status: metav1.ConditionFalse,
-		Reason: Ready,
-	})
+		if len(notReady) == 0 {
+			setCondition(cr, c.conditionType, metav1.ConditionTrue, ReasonAllNodesReady,
+				fmt.Sprintf("Ready on %d selected nodes", len(cr.Status.Nodes)))
+			continue
+		}
+
+		setCondition(cr, c.conditionType, metav1.ConditionFalse, ReasonNodesNotReady,
+			fmt.Sprintf("Ready on %d/%d selected nodes, not ready on %s",
+				len(cr.Status.Nodes)-len(notReady), len(cr.Status.Nodes), listNodes(notReady)))
+	}
+
+	setKernelUnsupportedCondition(cr)
+	setDriverSignFailedCondition(cr)
+	setPreflightValidatedCondition(cr)
+
+	failed := []string{}
+	progressing := []string{}
+	stuck := []string{}
+	for _, ns := range cr.Status.Nodes {
+		switch ns.State {
+		case hlaiv1beta1.NodeStateFailed:
+			failed = append(failed, fmt.Sprintf("%s (%s)", ns.Name, ns.Message))
+			stuck = append(stuck, ns.Name)
+		case hlaiv1beta1.NodeStateProgressing:
+			progressing = append(progressing, ns.Name)
+			stuck = append(stuck, ns.Name)
+		}
+	}
+
+	if cr.Status.ReadyNodes == cr.Status.MatchedNodes {
+		setCondition(cr, Available, metav1.ConditionTrue, ReasonAllNodesReady,
+			fmt.Sprintf("All components are ready on %d selected nodes", cr.Status.MatchedNodes))
+	} else {
+		setCondition(cr, Available, metav1.ConditionFalse, ReasonNodesNotReady,
+			fmt.Sprintf("All components are ready on %d/%d selected nodes", cr.Status.ReadyNodes, cr.Status.MatchedNodes))
+	}
+
+	setProgressingCondition(cr, progressing, getPendingKMMComponents(cr), stuck)
 
-	return u.client.Status().Update(ctx, cr)
+	switch {
+	case cr.IsDriverRolledBack():
+		rb := cr.Status.Rollback
+		setCondition(cr, Degraded, metav1.ConditionTrue, ReasonRolledBack,
+			fmt.Sprintf("Rolled back the driver from version %s to %s: %s", rb.FailedVersion, rb.Version, rb.Reason))
+	case len(failed) > 0:
+		setCondition(cr, Degraded, metav1.ConditionTrue, ReasonNodesFailed,
+			fmt.Sprintf("Components are failing on %s", listNodes(failed)))
+	case IsRolloutStalled(cr):
+		progressingCondition := meta.FindStatusCondition(cr.Status.Conditions, Progressing)
+		setCondition(cr, Degraded, metav1.ConditionTrue, ReasonProgressDeadlineExceeded, progressingCondition.Message)
+	default:
+		setCondition(cr, Degraded, metav1.ConditionFalse, ReasonAsExpected, "No component is failing")
+	}
+
+	return u.patchStatus(ctx, cr, original)
 }
 
-func (u *updater) SetConditionsErrored(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig, reason, message string) error {
-	meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
-		Type:   Ready,
-		Status: metav1.ConditionFalse,
-		Reason: Errored,
-	})
+// setKernelUnsupportedCondition lists the selected nodes whose kernel matches
+// no kernel mapping, see nodestatus.
+func setKernelUnsupportedCondition(cr *hlaiv1beta1.DeviceConfig) {
+	unsupported := []string{}
+	for _, ns := range cr.Status.Nodes {
+		if !ns.KernelSupported {
+			unsupported = append(unsupported, fmt.Sprintf("%s (%s)", ns.Name, ns.KernelVersion))
+		}
+	}
+
+	if len(unsupported) == 0 {
+		setCondition(cr, KernelUnsupported, metav1.ConditionFalse, ReasonAllKernelsSupported,
+			fmt.Sprintf("The kernel of %d selected nodes matches a kernel mapping", len(cr.Status.Nodes)))
+		return
+	}
+
+	setCondition(cr, KernelUnsupported, metav1.ConditionTrue, ReasonNoKernelMapping,
+		fmt.Sprintf("The kernel of %d/%d selected nodes matches no kernel mapping: %s",
+			len(unsupported), len(cr.Status.Nodes), listNodes(unsupported)))
+}
+
+// setDriverSignFailedCondition lists the kernels whose driver image KMM
+// failed to sign, see nodestatus.
+func setDriverSignFailedCondition(cr *hlaiv1beta1.DeviceConfig) {
+	if cr.Spec.Driver.Sign == nil {
+		meta.RemoveStatusCondition(&cr.Status.Conditions, DriverSignFailed)
+		return
+	}
+
+	if len(cr.Status.SignFailures) == 0 {
+		setCondition(cr, DriverSignFailed, metav1.ConditionFalse, ReasonNoSignFailure,
+			"No driver image failed to be signed")
+		return
+	}
+
+	kernels := make([]string, 0, len(cr.Status.SignFailures))
+	for _, f := range cr.Status.SignFailures {
+		kernels = append(kernels, fmt.Sprintf("%s (job %s)", f.Kernel, f.Job))
+	}
+
+	setCondition(cr, DriverSignFailed, metav1.ConditionTrue, ReasonSignJobFailed,
+		fmt.Sprintf("Failed to sign the driver image of kernels %s", listNodes(kernels)))
+}
+
+// setPreflightValidatedCondition lists the kernels the latest driver version
+// is not validated for yet, see preflight.
+func setPreflightValidatedCondition(cr *hlaiv1beta1.DeviceConfig) {
+	p := cr.Status.Preflight
+	if cr.Spec.Driver.Preflight == nil || p == nil {
+		meta.RemoveStatusCondition(&cr.Status.Conditions, PreflightValidated)
+		return
+	}
+
+	if p.IsVerified() {
+		setCondition(cr, PreflightValidated, metav1.ConditionTrue, ReasonAllKernelsVerified,
+			fmt.Sprintf("Driver version %s is validated for %d kernels", p.Version, len(p.Kernels)))
+		return
+	}
+
+	// KMM retries a failed validation forever, and does not tell it apart
+	// from a validation in progress, e.g. waiting for a build.
+	pending := []string{}
+	for _, k := range p.Kernels {
+		if !k.Verified {
+			pending = append(pending, fmt.Sprintf("%s (%s)", k.Kernel, k.Message))
+		}
+	}
+
+	setCondition(cr, PreflightValidated, metav1.ConditionFalse, ReasonKernelsNotVerified,
+		fmt.Sprintf("Driver version %s is not validated for kernels %s", p.Version, listNodes(pending)))
+}
+
+// setProgressingCondition sets the Progressing condition as true while nodes
+// are progressing or the KMM components are pending, and as false with the
+// ProgressDeadlineExceeded reason once this lasts longer than the progress
+// deadline. A stalled rollout stays so until it completes or the spec changes.
+func setProgressingCondition(cr *hlaiv1beta1.DeviceConfig, progressing, pending, stuck []string) {
+	if len(progressing) == 0 && len(pending) == 0 {
+		setCondition(cr, Progressing, metav1.ConditionFalse, ReasonRolloutComplete, "No rollout in progress")
+		return
+	}
+
+	existing := meta.FindStatusCondition(cr.Status.Conditions, Progressing)
+	if existing != nil && existing.ObservedGeneration != cr.Generation {
+		// A spec change starts a new rollout, and a new deadline.
+		meta.RemoveStatusCondition(&cr.Status.Conditions, Progressing)
+		existing = nil
+	}
+
+	details := []string{}
+	if len(progressing) > 0 {
+		details = append(details, fmt.Sprintf("rolling out on %s", listNodes(progressing)))
+	}
+	details = append(details, pending...)
+
+	if existing == nil || existing.Reason != ReasonProgressDeadlineExceeded {
+		setCondition(cr, Progressing, metav1.ConditionTrue, ReasonRollingOut, strings.Join(details, "; "))
+
+		started := meta.FindStatusCondition(cr.Status.Conditions, Progressing).LastTransitionTime
+		if time.Since(started.Time) <= cr.GetProgressDeadline() {
+			return
+		}
+	}
 
+	message := fmt.Sprintf("Rollout did not complete within %s", cr.GetProgressDeadline())
+	if len(stuck) > 0 {
+		message += fmt.Sprintf(", stuck on %s", listNodes(stuck))
+	}
+	setCondition(cr, Progressing, metav1.ConditionFalse, ReasonProgressDeadlineExceeded,
+		fmt.Sprintf("%s: %s", message, strings.Join(details, "; ")))
+}
+
+// canaryPhaseDescriptions describe the phases of a canary not promoted yet.
+var canaryPhaseDescriptions = map[hlaiv1beta1.CanaryPhase]string{
+	hlaiv1beta1.CanaryPhaseInProgress:       "in progress",
+	hlaiv1beta1.CanaryPhaseAwaitingApproval: "awaiting approval",
+	hlaiv1beta1.CanaryPhaseAborted:          "aborted",
+}
+
+// getPendingKMMComponents describes the KMM components whose rollout, as
+// reported by the Module status, is not complete on all the selected nodes,
+// the preflight validation of a driver version not rolled out yet, the
+// upgrade of the nodes to the latest one, and its canary.
+func getPendingKMMComponents(cr *hlaiv1beta1.DeviceConfig) []string {
+	pending := []string{}
+
+	for _, c := range []struct {
+		name   string
+		status hlaiv1beta1.ComponentStatus
+	}{
+		{"driver", cr.Status.Components.Driver},
+		{"device plugin", cr.Status.Components.DevicePlugin},
+	} {
+		switch {
+		case c.status.NodesMatchingSelectorNumber != cr.Status.MatchedNodes:
+			pending = append(pending, fmt.Sprintf("%s: Module matches %d/%d selected nodes",
+				c.name, c.status.NodesMatchingSelectorNumber, cr.Status.MatchedNodes))
+		case c.status.DesiredNumber != c.status.NodesMatchingSelectorNumber:
+			pending = append(pending, fmt.Sprintf("%s: scheduled on %d/%d nodes",
+				c.name, c.status.DesiredNumber, c.status.NodesMatchingSelectorNumber))
+		case c.status.AvailableNumber != c.status.DesiredNumber:
+			pending = append(pending, fmt.Sprintf("%s: available on %d/%d nodes",
+				c.name, c.status.AvailableNumber, c.status.DesiredNumber))
+		}
+	}
+
+	if p := cr.Status.Preflight; cr.Spec.Driver.Preflight != nil && p != nil && !p.IsVerified() {
+		verified := 0
+		for _, k := range p.Kernels {
+			if k.Verified {
+				verified++
+			}
+		}
+		pending = append(pending, fmt.Sprintf("preflight: driver version %s validated for %d/%d kernels",
+			p.Version, verified, len(p.Kernels)))
+	}
+
+	if u := cr.Status.Upgrade; u != nil && !u.IsComplete() {
+		detail := fmt.Sprintf("upgrade: %d/%d nodes upgraded to driver version %s", u.UpgradedNodes,
+			u.UpgradedNodes+u.UpgradingNodes+u.PendingNodes+u.FailedNodes, u.TargetVersion)
+		if u.FailedNodes > 0 {
+			detail += fmt.Sprintf(", %d failed", u.FailedNodes)
+		}
+		pending = append(pending, detail)
+	}
+
+	if c := cr.Status.Canary; c != nil && c.Phase != hlaiv1beta1.CanaryPhasePromoted {
+		pending = append(pending, fmt.Sprintf("canary: driver version %s %s on nodes %s", c.Version,
+			canaryPhaseDescriptions[c.Phase], strings.Join(c.Nodes, ", ")))
+	}
+
+	return pending
+}
+
+// IsRolloutStalled returns true if the rollout of cr exceeded its progress
+// deadline.
+func IsRolloutStalled(cr *hlaiv1beta1.DeviceConfig) bool {
+	c := meta.FindStatusCondition(cr.Status.Conditions, Progressing)
+	return c != nil && c.Reason == ReasonProgressDeadlineExceeded
+}
+
+// RequeueAfter returns when to check the rollout of cr again, or 0 if it is
+// complete. The interval grows with the rollout duration, so that long
+// rollouts, e.g. of drivers built in-cluster, are polled less often, but it
+// does not overshoot the progress deadline.
+func RequeueAfter(cr *hlaiv1beta1.DeviceConfig) time.Duration {
+	c := meta.FindStatusCondition(cr.Status.Conditions, Progressing)
+	switch {
+	case c == nil || c.Reason == ReasonRolloutComplete:
+		return 0
+	case c.Status != metav1.ConditionTrue:
+		return maxRequeueAfter
+	}
+
+	elapsed := time.Since(c.LastTransitionTime.Time)
+
+	after := elapsed / 2
+	if after < minRequeueAfter {
+		after = minRequeueAfter
+	}
+	if after > maxRequeueAfter {
+		after = maxRequeueAfter
+	}
+
+	if remaining := cr.GetProgressDeadline() - elapsed; remaining > 0 && remaining < after {
+		after = remaining + time.Second
+	}
+
+	return after
+}
+
+// SetConditionsErrored sets the conditionType condition to false and the
+// Degraded condition to true, with the given reason and message, then patches
+// the status of cr from original, the DeviceConfig as it was read.
+func (u *updater) SetConditionsErrored(ctx context.Context, cr, original *hlaiv1beta1.DeviceConfig, conditionType, reason, message string) error {
+	if conditionType != Degraded {
+		setCondition(cr, conditionType, metav1.ConditionFalse, reason, message)
+	}
+
+	setCondition(cr, Degraded, metav1.ConditionTrue, reason, message)
+
+	return u.patchStatus(ctx, cr, original)
+}
+
+// SetConditionsDeleting sets the Available condition to false and the
+// Progressing one to true with the deletion phase of cr, then patches the
+// status of cr from original, the DeviceConfig as it was read.
+func (u *updater) SetConditionsDeleting(ctx context.Context, cr, original *hlaiv1beta1.DeviceConfig) error {
+	message := "Deleting the components from the selected nodes"
+	if d := cr.Status.Deletion; d != nil {
+		message = fmt.Sprintf("Deletion phase %s", d.Phase)
+		if d.Message != "" {
+			message = fmt.Sprintf("%s, waiting for %s", message, d.Message)
+		}
+	}
+
+	setCondition(cr, Available, metav1.ConditionFalse, ReasonDeleting, message)
+	setCondition(cr, Progressing, metav1.ConditionTrue, ReasonDeleting, message)
+
+	return u.patchStatus(ctx, cr, original)
+}
+
+// patchStatus only sends the status changes made since original was read.
+// The optimistic lock makes the patch fail with a conflict, rather than
+// overwrite a newer status computed from a newer DeviceConfig.
+func (u *updater) patchStatus(ctx context.Context, cr, original *hlaiv1beta1.DeviceConfig) error {
+	cr.Status.ObservedGeneration = cr.Generation
+
+	patch := client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})
+	if err := u.client.Status().Patch(ctx, cr, patch); err != nil {
+		return fmt.Errorf("failed to patch status of %s: %w", cr.Name, err)
+	}
+
+	return nil
+}
+
+// SetPodsHealthCondition sets conditionType from the health of the pods of a
+// component DaemonSet, and returns true if its status, reason or message
+// changed. It does not write the status of cr.
+func SetPodsHealthCondition(cr *hlaiv1beta1.DeviceConfig, conditionType string, h *pods.Health) bool {
+	var before metav1.Condition
+	if c := meta.FindStatusCondition(cr.Status.Conditions, conditionType); c != nil {
+		before = *c
+	}
+
+	setPodsHealthCondition(cr, conditionType, h)
+
+	after := meta.FindStatusCondition(cr.Status.Conditions, conditionType)
+	return before.Status != after.Status || before.Reason != after.Reason || before.Message != after.Message
+}
+
+func setPodsHealthCondition(cr *hlaiv1beta1.DeviceConfig, conditionType string, h *pods.Health) {
+	if h.IsReady() {
+		setCondition(cr, conditionType, metav1.ConditionTrue, ReasonAllNodesReady,
+			fmt.Sprintf("Ready on %d selected nodes", h.Nodes))
+		return
+	}
+
+	details := h.Problems()
+	if len(h.Starting) > 0 {
+		details = append(details, fmt.Sprintf("starting on %s", listNodes(h.Starting)))
+	}
+
+	reason := ReasonNodesNotReady
+	switch {
+	case len(h.Failing) > 0:
+		reason = ReasonPodsFailing
+	case len(h.Missing) > 0:
+		reason = ReasonPodsMissing
+	}
+
+	setCondition(cr, conditionType, metav1.ConditionFalse, reason,
+		fmt.Sprintf("Ready on %d/%d selected nodes: %s", len(h.Ready), h.Nodes, strings.Join(details, "; ")))
+}
+
+func setCondition(cr *hlaiv1beta1.DeviceConfig, conditionType string, status metav1.ConditionStatus, reason, message string) {
 	meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
-		Type:    Errored,
-		Status:  metav1.ConditionTrue,
-		Reason:  reason,
-		Message: message,
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: cr.Generation,
 	})
+}
+
+// listNodes joins the first node names, so that condition messages stay
+// readable on large clusters.
+func listNodes(names []string) string {
+	if len(names) <= maxListedNodes {
+		return strings.Join(names, ", ")
+	}
 
-	return u.client.Status().Update(ctx, cr)
+	return fmt.Sprintf("%s and %d more", strings.Join(names[:maxListedNodes], ", "), len(names)-maxListedNodes)
 }
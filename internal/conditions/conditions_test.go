@@ -16,88 +16,430 @@ package conditions
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	gomock "github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	mockClient "github.com/HabanaAI/habana-ai-operator/internal/client"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
 )
 
 var _ = Describe("ConditionsUpdater", func() {
 	var (
-		ctrl *gomock.Controller
-		dc   *hlaiv1alpha1.DeviceConfig
-		c    *mockClient.MockClient
-		u    Updater
+		ctrl              *gomock.Controller
+		dc                *hlaiv1beta1.DeviceConfig
+		original          *hlaiv1beta1.DeviceConfig
+		c                 *mockClient.MockClient
+		subResourceClient *mockClient.MockSubResourceClient
+		u                 Updater
 	)
 
 	BeforeEach(func() {
 		ctrl = gomock.NewController(GinkgoT())
-		dc = &hlaiv1alpha1.DeviceConfig{ObjectMeta: metav1.ObjectMeta{Name: "a-device-config"}}
+		dc = &hlaiv1beta1.DeviceConfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "a-device-config",
+				Generation:      3,
+				ResourceVersion: "42",
+			},
+		}
+		original = dc.DeepCopy()
 		c = mockClient.NewMockClient(ctrl)
+		subResourceClient = mockClient.NewMockSubResourceClient(ctrl)
 
 		u = NewUpdater(c)
 	})
 
-	Describe("SetConditionsReady", func() {
-		Context("with successful status update", func() {
+	expectPatch := func(err error) {
+		c.EXPECT().Status().Return(subResourceClient)
+		subResourceClient.EXPECT().Patch(context.TODO(), dc, gomock.Any()).DoAndReturn(
+			func(_ context.Context, _ client.Object, p client.Patch, _ ...client.SubResourcePatchOption) error {
+				Expect(p.Type()).To(Equal(types.MergePatchType))
+
+				data, perr := p.Data(dc)
+				Expect(perr).ToNot(HaveOccurred())
+				Expect(string(data)).To(ContainSubstring(`"resourceVersion":"42"`))
+
+				return err
+			},
+		)
+	}
+
+	Describe("SetConditionsReconciled", func() {
+		Context("with all nodes ready", func() {
 			BeforeEach(func() {
-				subResourceClient := mockClient.NewMockSubResourceClient(ctrl)
-				c.EXPECT().Status().Return(subResourceClient)
-				subResourceClient.EXPECT().Update(context.TODO(), dc).Return(nil)
+				dc.Status.MatchedNodes = 2
+				dc.Status.ReadyNodes = 2
+				dc.Status.Components = rolledOutComponents(2)
+				dc.Status.Nodes = []hlaiv1beta1.NodeStatus{
+					readyNode("node-a"),
+					readyNode("node-b"),
+				}
 
-				err := u.SetConditionsReady(context.TODO(), dc, "test reason", "test message")
-				Expect(err).ToNot(HaveOccurred())
+				expectPatch(nil)
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
 			})
 
-			It("should have set 2 conditions in the DeviceConfig .status.conditions", func() {
-				Expect(dc.Status.Conditions).To(HaveLen(2))
+			It("should set the KMM component conditions as true", func() {
+				for _, t := range []string{DriverLoaded, DevicePluginReady} {
+					Expect(meta.IsStatusConditionTrue(dc.Status.Conditions, t)).To(BeTrue(), t)
+				}
+			})
+
+			It("should be available, not progressing nor degraded", func() {
+				Expect(meta.IsStatusConditionTrue(dc.Status.Conditions, Available)).To(BeTrue())
+				Expect(meta.IsStatusConditionFalse(dc.Status.Conditions, Progressing)).To(BeTrue())
+				Expect(meta.IsStatusConditionFalse(dc.Status.Conditions, Degraded)).To(BeTrue())
+			})
+
+			It("should not report unsupported kernels", func() {
+				kernel := meta.FindStatusCondition(dc.Status.Conditions, KernelUnsupported)
+				Expect(kernel.Status).To(Equal(metav1.ConditionFalse))
+				Expect(kernel.Reason).To(Equal(ReasonAllKernelsSupported))
+			})
+
+			It("should not set the DriverSignFailed condition without driver signing", func() {
+				Expect(meta.FindStatusCondition(dc.Status.Conditions, DriverSignFailed)).To(BeNil())
+			})
+
+			It("should track the observed generation", func() {
+				Expect(dc.Status.ObservedGeneration).To(Equal(int64(3)))
+				for _, cond := range dc.Status.Conditions {
+					Expect(cond.ObservedGeneration).To(Equal(int64(3)))
+				}
+			})
+		})
+
+		Context("with progressing and failed nodes", func() {
+			BeforeEach(func() {
+				progressing := readyNode("node-b")
+				progressing.State = hlaiv1beta1.NodeStateProgressing
+				progressing.DevicePluginReady = false
+
+				failed := readyNode("node-c")
+				failed.State = hlaiv1beta1.NodeStateFailed
+				failed.NodeMetricsReady = false
+				failed.Message = "node metrics: ImagePullBackOff"
+
+				dc.Status.MatchedNodes = 3
+				dc.Status.ReadyNodes = 1
+				dc.Status.FailedNodes = 1
+				dc.Status.Components = rolledOutComponents(3)
+				dc.Status.Nodes = []hlaiv1beta1.NodeStatus{readyNode("node-a"), progressing, failed}
+
+				expectPatch(nil)
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+			})
+
+			It("should set the KMM component conditions from the nodes status", func() {
+				Expect(meta.IsStatusConditionTrue(dc.Status.Conditions, DriverLoaded)).To(BeTrue())
+
+				devicePlugin := meta.FindStatusCondition(dc.Status.Conditions, DevicePluginReady)
+				Expect(devicePlugin.Status).To(Equal(metav1.ConditionFalse))
+				Expect(devicePlugin.Reason).To(Equal(ReasonNodesNotReady))
+				Expect(devicePlugin.Message).To(Equal("Ready on 2/3 selected nodes, not ready on node-b"))
+			})
+
+			It("should leave the operand DaemonSet conditions to their reconcilers", func() {
+				Expect(meta.FindStatusCondition(dc.Status.Conditions, NodeLabelerReady)).To(BeNil())
+				Expect(meta.FindStatusCondition(dc.Status.Conditions, NodeMetricsReady)).To(BeNil())
+			})
+
+			It("should be progressing and degraded, not available", func() {
+				Expect(meta.IsStatusConditionFalse(dc.Status.Conditions, Available)).To(BeTrue())
+
+				progressing := meta.FindStatusCondition(dc.Status.Conditions, Progressing)
+				Expect(progressing.Status).To(Equal(metav1.ConditionTrue))
+				Expect(progressing.Message).To(ContainSubstring("node-b"))
+
+				degraded := meta.FindStatusCondition(dc.Status.Conditions, Degraded)
+				Expect(degraded.Status).To(Equal(metav1.ConditionTrue))
+				Expect(degraded.Reason).To(Equal(ReasonNodesFailed))
+				Expect(degraded.Message).To(ContainSubstring("node-c (node metrics: ImagePullBackOff)"))
+			})
+		})
+
+		Context("with a node kernel matching no kernel mapping", func() {
+			It("should list the node in the KernelUnsupported condition", func() {
+				unsupported := readyNode("node-b")
+				unsupported.State = hlaiv1beta1.NodeStateFailed
+				unsupported.DriverLoaded = false
+				unsupported.KernelVersion = "5.15.0-76-generic"
+				unsupported.KernelSupported = false
+
+				dc.Status.MatchedNodes = 2
+				dc.Status.ReadyNodes = 1
+				dc.Status.FailedNodes = 1
+				dc.Status.Components = rolledOutComponents(2)
+				dc.Status.Nodes = []hlaiv1beta1.NodeStatus{readyNode("node-a"), unsupported}
+
+				expectPatch(nil)
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				kernel := meta.FindStatusCondition(dc.Status.Conditions, KernelUnsupported)
+				Expect(kernel.Status).To(Equal(metav1.ConditionTrue))
+				Expect(kernel.Reason).To(Equal(ReasonNoKernelMapping))
+				Expect(kernel.Message).To(Equal(
+					"The kernel of 1/2 selected nodes matches no kernel mapping: node-b (5.15.0-76-generic)"))
+			})
+		})
+
+		Context("with driver signing", func() {
+			BeforeEach(func() {
+				dc.Spec.Driver.Sign = &hlaiv1beta1.DriverSignSpec{
+					KeySecret:  corev1.LocalObjectReference{Name: "signing-key"},
+					CertSecret: corev1.LocalObjectReference{Name: "signing-cert"},
+				}
+				dc.Status.MatchedNodes = 1
+				dc.Status.ReadyNodes = 1
+				dc.Status.Components = rolledOutComponents(1)
+				dc.Status.Nodes = []hlaiv1beta1.NodeStatus{readyNode("node-a")}
+				expectPatch(nil)
+			})
+
+			It("should not report a signing failure", func() {
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				signFailed := meta.FindStatusCondition(dc.Status.Conditions, DriverSignFailed)
+				Expect(signFailed.Status).To(Equal(metav1.ConditionFalse))
+				Expect(signFailed.Reason).To(Equal(ReasonNoSignFailure))
+			})
+
+			It("should list the kernels whose signing failed", func() {
+				dc.Status.SignFailures = []hlaiv1beta1.SignFailure{
+					{Kernel: "5.15.0-76-generic", Job: "sign-job", Message: "job sign-job failed"},
+				}
+
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				signFailed := meta.FindStatusCondition(dc.Status.Conditions, DriverSignFailed)
+				Expect(signFailed.Status).To(Equal(metav1.ConditionTrue))
+				Expect(signFailed.Reason).To(Equal(ReasonSignJobFailed))
+				Expect(signFailed.Message).To(Equal(
+					"Failed to sign the driver image of kernels 5.15.0-76-generic (job sign-job)"))
+			})
+		})
+
+		Context("with driver preflight", func() {
+			BeforeEach(func() {
+				dc.Spec.Driver.Preflight = &hlaiv1beta1.DriverPreflightSpec{}
+				dc.Status.MatchedNodes = 2
+				dc.Status.ReadyNodes = 2
+				dc.Status.Components = rolledOutComponents(2)
+				dc.Status.Nodes = []hlaiv1beta1.NodeStatus{readyNode("node-a"), readyNode("node-b")}
+				expectPatch(nil)
+			})
+
+			It("should not set the PreflightValidated condition before a version is validated", func() {
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				Expect(meta.FindStatusCondition(dc.Status.Conditions, PreflightValidated)).To(BeNil())
+			})
+
+			It("should be progressing until the new version is validated for every kernel", func() {
+				dc.Status.Preflight = &hlaiv1beta1.PreflightStatus{
+					Version: "1.11.0-1",
+					Kernels: []hlaiv1beta1.PreflightKernelStatus{
+						{Kernel: "5.14.0-284.el9.x86_64", Verified: true},
+						{Kernel: "5.15.0-76-generic", Stage: "Requeued", Message: "Waiting for build verification"},
+					},
+				}
+
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				validated := meta.FindStatusCondition(dc.Status.Conditions, PreflightValidated)
+				Expect(validated.Status).To(Equal(metav1.ConditionFalse))
+				Expect(validated.Reason).To(Equal(ReasonKernelsNotVerified))
+				Expect(validated.Message).To(Equal(
+					"Driver version 1.11.0-1 is not validated for kernels 5.15.0-76-generic (Waiting for build verification)"))
+
+				progressing := meta.FindStatusCondition(dc.Status.Conditions, Progressing)
+				Expect(progressing.Status).To(Equal(metav1.ConditionTrue))
+				Expect(progressing.Message).To(Equal("preflight: driver version 1.11.0-1 validated for 1/2 kernels"))
+			})
+
+			It("should report the validated version", func() {
+				dc.Status.Preflight = &hlaiv1beta1.PreflightStatus{
+					Version: "1.11.0-1",
+					Kernels: []hlaiv1beta1.PreflightKernelStatus{{Kernel: "5.15.0-76-generic", Verified: true}},
+				}
+
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				validated := meta.FindStatusCondition(dc.Status.Conditions, PreflightValidated)
+				Expect(validated.Status).To(Equal(metav1.ConditionTrue))
+				Expect(validated.Reason).To(Equal(ReasonAllKernelsVerified))
+				Expect(meta.IsStatusConditionFalse(dc.Status.Conditions, Progressing)).To(BeTrue())
+			})
+		})
+
+		Context("with a driver upgrade in progress", func() {
+			It("should be progressing until all the nodes are upgraded", func() {
+				dc.Status.MatchedNodes = 4
+				dc.Status.ReadyNodes = 4
+				dc.Status.Components = rolledOutComponents(4)
+				dc.Status.Nodes = []hlaiv1beta1.NodeStatus{
+					readyNode("node-a"), readyNode("node-b"), readyNode("node-c"), readyNode("node-d"),
+				}
+				dc.Status.Upgrade = &hlaiv1beta1.DriverUpgradeStatus{
+					TargetVersion:  "1.11.0-1",
+					UpgradedNodes:  1,
+					UpgradingNodes: 1,
+					PendingNodes:   1,
+					FailedNodes:    1,
+				}
+				expectPatch(nil)
+
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				progressing := meta.FindStatusCondition(dc.Status.Conditions, Progressing)
+				Expect(progressing.Status).To(Equal(metav1.ConditionTrue))
+				Expect(progressing.Message).To(Equal("upgrade: 1/4 nodes upgraded to driver version 1.11.0-1, 1 failed"))
 			})
 
-			It("should have set the Ready condition as true", func() {
-				ready := dc.Status.Conditions[0]
+			It("should be progressing until the canary is promoted", func() {
+				dc.Status.MatchedNodes = 2
+				dc.Status.ReadyNodes = 2
+				dc.Status.Components = rolledOutComponents(2)
+				dc.Status.Nodes = []hlaiv1beta1.NodeStatus{readyNode("node-a"), readyNode("node-b")}
+				dc.Status.Upgrade = &hlaiv1beta1.DriverUpgradeStatus{
+					TargetVersion: "1.11.0-1",
+					UpgradedNodes: 1,
+					PendingNodes:  1,
+				}
+				dc.Status.Canary = &hlaiv1beta1.DriverCanaryStatus{
+					Version: "1.11.0-1",
+					Phase:   hlaiv1beta1.CanaryPhaseAwaitingApproval,
+					Nodes:   []string{"node-a"},
+				}
+				expectPatch(nil)
 
-				Expect(ready.Type).To(Equal("Ready"))
-				Expect(ready.Status).To(Equal(metav1.ConditionTrue))
-				Expect(ready.Reason).To(Equal("test reason"))
-				Expect(ready.Message).To(Equal("test message"))
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				progressing := meta.FindStatusCondition(dc.Status.Conditions, Progressing)
+				Expect(progressing.Status).To(Equal(metav1.ConditionTrue))
+				Expect(progressing.Message).To(Equal(
+					"upgrade: 1/2 nodes upgraded to driver version 1.11.0-1; canary: driver version 1.11.0-1 awaiting approval on nodes node-a"))
 			})
+		})
 
-			It("should have set the Errored condition as false", func() {
-				errored := dc.Status.Conditions[1]
+		Context("with a rolled back driver version", func() {
+			It("should be degraded with the reason of the rollback", func() {
+				dc.Spec.Driver.Version = "1.11.0-1"
+				dc.Spec.Driver.Rollback = &hlaiv1beta1.DriverRollbackSpec{}
+				dc.Status.MatchedNodes = 2
+				dc.Status.ReadyNodes = 2
+				dc.Status.Components = rolledOutComponents(2)
+				dc.Status.Nodes = []hlaiv1beta1.NodeStatus{readyNode("node-a"), readyNode("node-b")}
+				dc.Status.Rollback = &hlaiv1beta1.DriverRollbackStatus{
+					FailedVersion: "1.11.0-1",
+					Version:       "1.10.0-1",
+					Reason:        "failing on 1 of 2 nodes: node-a (driver: CrashLoopBackOff)",
+				}
+				expectPatch(nil)
 
-				Expect(errored.Type).To(Equal("Errored"))
-				Expect(errored.Status).To(Equal(metav1.ConditionFalse))
-				Expect(errored.Reason).To(Equal("Ready"))
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				degraded := meta.FindStatusCondition(dc.Status.Conditions, Degraded)
+				Expect(degraded.Status).To(Equal(metav1.ConditionTrue))
+				Expect(degraded.Reason).To(Equal(ReasonRolledBack))
+				Expect(degraded.Message).To(Equal(
+					"Rolled back the driver from version 1.11.0-1 to 1.10.0-1: failing on 1 of 2 nodes: node-a (driver: CrashLoopBackOff)"))
 			})
 		})
 
-		Context("with failed status update", func() {
+		Context("with a KMM Module rollout in progress", func() {
 			BeforeEach(func() {
-				subResourceClient := mockClient.NewMockSubResourceClient(ctrl)
-				c.EXPECT().Status().Return(subResourceClient)
-				subResourceClient.EXPECT().Update(context.TODO(), dc).Return(errors.New("some error"))
+				dc.Status.MatchedNodes = 2
+				dc.Status.ReadyNodes = 2
+				dc.Status.Components = rolledOutComponents(2)
+				dc.Status.Components.Driver.AvailableNumber = 1
+				dc.Status.Components.DevicePlugin.NodesMatchingSelectorNumber = 0
+				dc.Status.Nodes = []hlaiv1beta1.NodeStatus{readyNode("node-a"), readyNode("node-b")}
+			})
+
+			It("should be progressing until the Module status is complete", func() {
+				expectPatch(nil)
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				progressing := meta.FindStatusCondition(dc.Status.Conditions, Progressing)
+				Expect(progressing.Status).To(Equal(metav1.ConditionTrue))
+				Expect(progressing.Reason).To(Equal(ReasonRollingOut))
+				Expect(progressing.Message).To(Equal(
+					"driver: available on 1/2 nodes; device plugin: Module matches 0/2 selected nodes"))
+				Expect(RequeueAfter(dc)).To(Equal(minRequeueAfter))
 			})
 
+			It("should report the rollout as stalled past the progress deadline", func() {
+				dc.Status.Nodes[1].State = hlaiv1beta1.NodeStateProgressing
+				dc.Status.Conditions = []metav1.Condition{{
+					Type:               Progressing,
+					Status:             metav1.ConditionTrue,
+					Reason:             ReasonRollingOut,
+					ObservedGeneration: dc.Generation,
+					LastTransitionTime: metav1.NewTime(time.Now().Add(-dc.GetProgressDeadline() - time.Minute)),
+				}}
+
+				expectPatch(nil)
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				Expect(IsRolloutStalled(dc)).To(BeTrue())
+				progressing := meta.FindStatusCondition(dc.Status.Conditions, Progressing)
+				Expect(progressing.Status).To(Equal(metav1.ConditionFalse))
+				Expect(progressing.Message).To(HavePrefix("Rollout did not complete within 30m0s, stuck on node-b: "))
+
+				degraded := meta.FindStatusCondition(dc.Status.Conditions, Degraded)
+				Expect(degraded.Status).To(Equal(metav1.ConditionTrue))
+				Expect(degraded.Reason).To(Equal(ReasonProgressDeadlineExceeded))
+
+				Expect(RequeueAfter(dc)).To(Equal(maxRequeueAfter))
+			})
+
+			It("should restart the deadline of a stalled rollout on a spec change", func() {
+				dc.Status.Conditions = []metav1.Condition{{
+					Type:               Progressing,
+					Status:             metav1.ConditionFalse,
+					Reason:             ReasonProgressDeadlineExceeded,
+					ObservedGeneration: dc.Generation - 1,
+					LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Hour)),
+				}}
+
+				expectPatch(nil)
+				Expect(u.SetConditionsReconciled(context.TODO(), dc, original)).To(Succeed())
+
+				Expect(IsRolloutStalled(dc)).To(BeFalse())
+				Expect(meta.IsStatusConditionTrue(dc.Status.Conditions, Progressing)).To(BeTrue())
+			})
+		})
+
+		Context("with failed status patch", func() {
 			It("should return an error", func() {
-				err := u.SetConditionsReady(context.TODO(), dc, "test reason", "test message")
+				expectPatch(errors.New("some error"))
+
+				err := u.SetConditionsReconciled(context.TODO(), dc, original)
 				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("some error"))
 			})
 		})
 	})
 
 	Describe("SetConditionsErrored", func() {
-		Context("with successful status update", func() {
+		Context("with successful status patch", func() {
 			BeforeEach(func() {
-				subResourceClient := mockClient.NewMockSubResourceClient(ctrl)
-				c.EXPECT().Status().Return(subResourceClient)
-				subResourceClient.EXPECT().Update(context.TODO(), dc).Return(nil)
+				expectPatch(nil)
 
-				err := u.SetConditionsErrored(context.TODO(), dc, "test reason", "test message")
+				err := u.SetConditionsErrored(context.TODO(), dc, original, DriverLoaded, ReasonModuleFailed, "test message")
 				Expect(err).ToNot(HaveOccurred())
 			})
 
@@ -105,35 +447,165 @@ var _ = Describe("ConditionsUpdater", func() {
 				Expect(dc.Status.Conditions).To(HaveLen(2))
 			})
 
-			It("should have set the Ready condition as false", func() {
-				ready := dc.Status.Conditions[0]
+			It("should have set the given condition as false", func() {
+				driver := meta.FindStatusCondition(dc.Status.Conditions, DriverLoaded)
 
-				Expect(ready.Type).To(Equal("Ready"))
-				Expect(ready.Status).To(Equal(metav1.ConditionFalse))
-				Expect(ready.Reason).To(Equal("Errored"))
+				Expect(driver.Status).To(Equal(metav1.ConditionFalse))
+				Expect(driver.Reason).To(Equal(ReasonModuleFailed))
+				Expect(driver.Message).To(Equal("test message"))
 			})
 
-			It("should have set the Errored condition as true", func() {
-				errored := dc.Status.Conditions[1]
+			It("should have set the Degraded condition as true", func() {
+				degraded := meta.FindStatusCondition(dc.Status.Conditions, Degraded)
 
-				Expect(errored.Type).To(Equal("Errored"))
-				Expect(errored.Status).To(Equal(metav1.ConditionTrue))
-				Expect(errored.Reason).To(Equal("test reason"))
-				Expect(errored.Message).To(Equal("test message"))
+				Expect(degraded.Status).To(Equal(metav1.ConditionTrue))
+				Expect(degraded.Reason).To(Equal(ReasonModuleFailed))
+				Expect(degraded.Message).To(Equal("test message"))
+				Expect(degraded.ObservedGeneration).To(Equal(int64(3)))
 			})
 		})
 
-		Context("with failed status update", func() {
-			BeforeEach(func() {
-				subResourceClient := mockClient.NewMockSubResourceClient(ctrl)
-				c.EXPECT().Status().Return(subResourceClient)
-				subResourceClient.EXPECT().Update(context.TODO(), dc).Return(errors.New("some error"))
-			})
-
+		Context("with failed status patch", func() {
 			It("should return an error", func() {
-				err := u.SetConditionsErrored(context.TODO(), dc, "test reason", "test message")
+				expectPatch(errors.New("some error"))
+
+				err := u.SetConditionsErrored(context.TODO(), dc, original, DriverLoaded, ReasonModuleFailed, "test message")
 				Expect(err).To(HaveOccurred())
 			})
 		})
 	})
+
+	Describe("SetConditionsDeleting", func() {
+		It("should report the deletion phase in the Available and Progressing conditions", func() {
+			expectPatch(nil)
+			dc.Status.Deletion = &hlaiv1beta1.DeletionStatus{
+				Phase:   hlaiv1beta1.DeletionPhaseDrainingWorkloads,
+				Message: "1 pods using habana.ai/gaudi: w-0",
+			}
+
+			err := u.SetConditionsDeleting(context.TODO(), dc, original)
+			Expect(err).ToNot(HaveOccurred())
+
+			available := meta.FindStatusCondition(dc.Status.Conditions, Available)
+			Expect(available.Status).To(Equal(metav1.ConditionFalse))
+			Expect(available.Reason).To(Equal(ReasonDeleting))
+
+			progressing := meta.FindStatusCondition(dc.Status.Conditions, Progressing)
+			Expect(progressing.Status).To(Equal(metav1.ConditionTrue))
+			Expect(progressing.Message).To(Equal("Deletion phase DrainingWorkloads, waiting for 1 pods using habana.ai/gaudi: w-0"))
+		})
+	})
+
+	Describe("RequeueAfter", func() {
+		progressingSince := func(d time.Duration) {
+			meta.RemoveStatusCondition(&dc.Status.Conditions, Progressing)
+			meta.SetStatusCondition(&dc.Status.Conditions, metav1.Condition{
+				Type:               Progressing,
+				Status:             metav1.ConditionTrue,
+				Reason:             ReasonRollingOut,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-d)),
+			})
+		}
+
+		It("should not requeue a complete rollout", func() {
+			meta.SetStatusCondition(&dc.Status.Conditions, metav1.Condition{
+				Type:   Progressing,
+				Status: metav1.ConditionFalse,
+				Reason: ReasonRolloutComplete,
+			})
+
+			Expect(RequeueAfter(dc)).To(BeZero())
+		})
+
+		It("should back off as the rollout lasts", func() {
+			progressingSince(2 * time.Minute)
+			Expect(RequeueAfter(dc)).To(BeNumerically("~", time.Minute, time.Second))
+
+			progressingSince(20 * time.Minute)
+			Expect(RequeueAfter(dc)).To(Equal(maxRequeueAfter))
+		})
+
+		It("should not overshoot the progress deadline", func() {
+			dc.Spec.ProgressDeadlineSeconds = pointer.Int32(600)
+			progressingSince(9 * time.Minute)
+
+			Expect(RequeueAfter(dc)).To(BeNumerically("~", time.Minute, 2*time.Second))
+		})
+	})
+
+	Describe("SetPodsHealthCondition", func() {
+		It("should set the condition as true when all pods are ready", func() {
+			SetPodsHealthCondition(dc, NodeMetricsReady, &pods.Health{Nodes: 2, Ready: []string{"node-a", "node-b"}})
+
+			cond := meta.FindStatusCondition(dc.Status.Conditions, NodeMetricsReady)
+			Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+			Expect(cond.Reason).To(Equal(ReasonAllNodesReady))
+			Expect(cond.ObservedGeneration).To(Equal(int64(3)))
+		})
+
+		It("should name the nodes with failing, missing and starting pods", func() {
+			SetPodsHealthCondition(dc, NodeMetricsReady, &pods.Health{
+				Nodes:    4,
+				Ready:    []string{"node-a"},
+				Starting: []string{"node-b"},
+				Missing:  []string{"node-c"},
+				Failing:  map[string][]string{"CrashLoopBackOff": {"node-d"}},
+			})
+
+			cond := meta.FindStatusCondition(dc.Status.Conditions, NodeMetricsReady)
+			Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			Expect(cond.Reason).To(Equal(ReasonPodsFailing))
+			Expect(cond.Message).To(Equal(
+				"Ready on 1/4 selected nodes: CrashLoopBackOff on node-d; no pod on node-c; starting on node-b"))
+		})
+
+		It("should report missing pods when none is failing", func() {
+			SetPodsHealthCondition(dc, NodeLabelerReady, &pods.Health{Nodes: 1, Missing: []string{"node-a"}})
+
+			Expect(meta.FindStatusCondition(dc.Status.Conditions, NodeLabelerReady).Reason).To(Equal(ReasonPodsMissing))
+		})
+
+		It("should only report the condition changes", func() {
+			h := &pods.Health{Nodes: 2, Ready: []string{"node-a"}, Failing: map[string][]string{"CrashLoopBackOff": {"node-b"}}}
+			Expect(SetPodsHealthCondition(dc, NodeLabelerReady, h)).To(BeTrue())
+			Expect(SetPodsHealthCondition(dc, NodeLabelerReady, h)).To(BeFalse())
+
+			h = &pods.Health{Nodes: 2, Failing: map[string][]string{"CrashLoopBackOff": {"node-a", "node-b"}}}
+			Expect(SetPodsHealthCondition(dc, NodeLabelerReady, h)).To(BeTrue())
+		})
+	})
+
+	Describe("listNodes", func() {
+		It("should only list the first nodes", func() {
+			names := []string{}
+			for i := 0; i < maxListedNodes+2; i++ {
+				names = append(names, fmt.Sprintf("node-%d", i))
+			}
+
+			Expect(listNodes(names)).To(Equal("node-0, node-1, node-2, node-3, node-4 and 2 more"))
+		})
+	})
 })
+
+func rolledOutComponents(nodes int32) hlaiv1beta1.ComponentsStatus {
+	rolledOut := hlaiv1beta1.ComponentStatus{
+		NodesMatchingSelectorNumber: nodes,
+		DesiredNumber:               nodes,
+		AvailableNumber:             nodes,
+	}
+
+	return hlaiv1beta1.ComponentsStatus{Driver: rolledOut, DevicePlugin: rolledOut}
+}
+
+func readyNode(name string) hlaiv1beta1.NodeStatus {
+	return hlaiv1beta1.NodeStatus{
+		Name:              name,
+		State:             hlaiv1beta1.NodeStateReady,
+		DriverLoaded:      true,
+		DevicePluginReady: true,
+		NodeLabelerReady:  true,
+		NodeMetricsReady:  true,
+		KernelVersion:     "4.18.0-372.26.1.el8_6.x86_64",
+		KernelSupported:   true,
+	}
+}
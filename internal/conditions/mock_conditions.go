@@ -8,7 +8,7 @@ import (
 	context "context"
 	reflect "reflect"
 
-	v1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	v1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -35,30 +35,44 @@ func (m *MockUpdater) EXPECT() *MockUpdaterMockRecorder {
 	return m.recorder
 }
 
+// SetConditionsDeleting mocks base method.
+func (m *MockUpdater) SetConditionsDeleting(ctx context.Context, cr, original *v1beta1.DeviceConfig) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetConditionsDeleting", ctx, cr, original)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetConditionsDeleting indicates an expected call of SetConditionsDeleting.
+func (mr *MockUpdaterMockRecorder) SetConditionsDeleting(ctx, cr, original interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetConditionsDeleting", reflect.TypeOf((*MockUpdater)(nil).SetConditionsDeleting), ctx, cr, original)
+}
+
 // SetConditionsErrored mocks base method.
-func (m *MockUpdater) SetConditionsErrored(ctx context.Context, cr *v1alpha1.DeviceConfig, reason, message string) error {
+func (m *MockUpdater) SetConditionsErrored(ctx context.Context, cr, original *v1beta1.DeviceConfig, conditionType, reason, message string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SetConditionsErrored", ctx, cr, reason, message)
+	ret := m.ctrl.Call(m, "SetConditionsErrored", ctx, cr, original, conditionType, reason, message)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SetConditionsErrored indicates an expected call of SetConditionsErrored.
-func (mr *MockUpdaterMockRecorder) SetConditionsErrored(ctx, cr, reason, message interface{}) *gomock.Call {
+func (mr *MockUpdaterMockRecorder) SetConditionsErrored(ctx, cr, original, conditionType, reason, message interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetConditionsErrored", reflect.TypeOf((*MockUpdater)(nil).SetConditionsErrored), ctx, cr, reason, message)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetConditionsErrored", reflect.TypeOf((*MockUpdater)(nil).SetConditionsErrored), ctx, cr, original, conditionType, reason, message)
 }
 
-// SetConditionsReady mocks base method.
-func (m *MockUpdater) SetConditionsReady(ctx context.Context, cr *v1alpha1.DeviceConfig, reason, message string) error {
+// SetConditionsReconciled mocks base method.
+func (m *MockUpdater) SetConditionsReconciled(ctx context.Context, cr, original *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SetConditionsReady", ctx, cr, reason, message)
+	ret := m.ctrl.Call(m, "SetConditionsReconciled", ctx, cr, original)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// SetConditionsReady indicates an expected call of SetConditionsReady.
-func (mr *MockUpdaterMockRecorder) SetConditionsReady(ctx, cr, reason, message interface{}) *gomock.Call {
+// SetConditionsReconciled indicates an expected call of SetConditionsReconciled.
+func (mr *MockUpdaterMockRecorder) SetConditionsReconciled(ctx, cr, original interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetConditionsReady", reflect.TypeOf((*MockUpdater)(nil).SetConditionsReady), ctx, cr, reason, message)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetConditionsReconciled", reflect.TypeOf((*MockUpdater)(nil).SetConditionsReconciled), ctx, cr, original)
 }
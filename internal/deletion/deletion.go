@@ -0,0 +1,380 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/constants"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+	nodeLabeler "github.com/HabanaAI/habana-ai-operator/internal/node/labeler"
+	nodeMetrics "github.com/HabanaAI/habana-ai-operator/internal/node/metrics"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
+)
+
+const (
+	// ForceAnnotation is set to true on a DeviceConfig being deleted to go
+	// through the deletion phases without waiting, e.g. for pods using the
+	// HPUs that never complete. The driver may then be unloaded under them.
+	ForceAnnotation = "habana.ai/force-deletion"
+
+	// cordonedAnnotation marks the nodes cordoned while their workloads are
+	// drained, which are uncordoned once they are. The nodes cordoned by an
+	// administrator are left cordoned.
+	cordonedAnnotation = "habana.ai/deletion-cordoned"
+
+	// requeueAfter is how often a deletion is checked, since the pods it
+	// waits for are not all watched.
+	requeueAfter = 10 * time.Second
+)
+
+// phases are the deletion phases, in order.
+var phases = []hlaiv1beta1.DeletionPhase{
+	hlaiv1beta1.DeletionPhaseDrainingWorkloads,
+	hlaiv1beta1.DeletionPhaseRemovingDevicePlugin,
+	hlaiv1beta1.DeletionPhaseUnloadingDriver,
+	hlaiv1beta1.DeletionPhaseRemovingNodeComponents,
+	hlaiv1beta1.DeletionPhaseCleaningFeatureFiles,
+	hlaiv1beta1.DeletionPhaseComplete,
+}
+
+//go:generate mockgen -source=deletion.go -package=deletion -destination=mock_deletion.go
+
+type Reconciler interface {
+	ReconcileDeletion(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (bool, error)
+}
+
+type deletionReconciler struct {
+	client client.Client
+	// reader lists the pods the manager cache does not hold: the cache is
+	// restricted to the operator namespace, and to the operand pods.
+	reader   client.Reader
+	recorder record.EventRecorder
+
+	mr  module.Reconciler
+	nlr nodeLabeler.Reconciler
+	nmr nodeMetrics.Reconciler
+}
+
+func NewReconciler(
+	c client.Client,
+	reader client.Reader,
+	recorder record.EventRecorder,
+	mr module.Reconciler,
+	nlr nodeLabeler.Reconciler,
+	nmr nodeMetrics.Reconciler,
+) Reconciler {
+	return &deletionReconciler{client: c, reader: reader, recorder: recorder, mr: mr, nlr: nlr, nmr: nmr}
+}
+
+// RequeueAfter returns when to check the deletion of cr again, or 0 if it is
+// not being deleted or its deletion is complete.
+func RequeueAfter(cr *hlaiv1beta1.DeviceConfig) time.Duration {
+	if d := cr.Status.Deletion; d != nil && d.Phase != hlaiv1beta1.DeletionPhaseComplete {
+		return requeueAfter
+	}
+
+	return 0
+}
+
+// ReconcileDeletion deletes the components of cr in order, and returns true
+// once they are all deleted. The pods using the HPUs of the selected nodes
+// are waited for, or evicted, first. Then the device plugin is removed, so
+// that no new pod gets the HPUs, the driver is unloaded, the node labeler and
+// metrics exporter are removed, and the feature files of the labeler are
+// cleaned up. Each phase waits for the pods of the previous one to be gone,
+// unless ForceAnnotation is set, and is reported in cr.Status.Deletion.
+func (r *deletionReconciler) ReconcileDeletion(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (bool, error) {
+	status := cr.Status.Deletion
+	if status == nil {
+		now := metav1.Now()
+		status = &hlaiv1beta1.DeletionStatus{StartTime: now}
+		cr.Status.Deletion = status
+		r.setPhase(ctx, cr, hlaiv1beta1.DeletionPhaseDrainingWorkloads)
+	}
+
+	force := cr.Annotations[ForceAnnotation] == "true"
+	for status.Phase != hlaiv1beta1.DeletionPhaseComplete {
+		waiting, err := r.step(ctx, cr, status.Phase)
+		if err != nil {
+			return false, fmt.Errorf("failed to delete DeviceConfig resources in phase %s: %w", status.Phase, err)
+		}
+
+		if waiting != "" {
+			if !force {
+				status.Message = waiting
+				return false, nil
+			}
+
+			r.recorder.Eventf(cr, corev1.EventTypeWarning, "DeletionForced",
+				"Not waiting for %s, as %s is set", waiting, ForceAnnotation)
+		}
+
+		if status.Phase == hlaiv1beta1.DeletionPhaseDrainingWorkloads {
+			if err := r.uncordonNodes(ctx, cr); err != nil {
+				return false, err
+			}
+		}
+
+		r.setPhase(ctx, cr, getNextPhase(status.Phase))
+	}
+
+	return true, nil
+}
+
+// step performs the action of phase, and returns what it waits for before
+// the next phase, or an empty string.
+func (r *deletionReconciler) step(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, phase hlaiv1beta1.DeletionPhase) (string, error) {
+	switch phase {
+	case hlaiv1beta1.DeletionPhaseDrainingWorkloads:
+		return r.drainWorkloads(ctx, cr)
+
+	case hlaiv1beta1.DeletionPhaseRemovingDevicePlugin:
+		if err := r.mr.DeleteDevicePlugin(ctx, cr); err != nil {
+			return "", err
+		}
+		return r.waitForKMMPods(ctx, cr, module.KMMDevicePluginRole, "device plugin")
+
+	case hlaiv1beta1.DeletionPhaseUnloadingDriver:
+		if err := r.mr.DeleteModules(ctx, cr); err != nil {
+			return "", err
+		}
+		modules, err := module.ListModules(ctx, r.client, cr)
+		if err != nil {
+			return "", err
+		}
+		if len(modules) > 0 {
+			return fmt.Sprintf("the deletion of the Modules %s", strings.Join(module.GetModuleNames(modules), ", ")), nil
+		}
+		return r.waitForKMMPods(ctx, cr, module.KMMModuleLoaderRole, "driver")
+
+	case hlaiv1beta1.DeletionPhaseRemovingNodeComponents:
+		if err := r.nlr.DeleteNodeLabeler(ctx, cr); err != nil {
+			return "", err
+		}
+		if err := r.nmr.DeleteNodeMetrics(ctx, cr); err != nil {
+			return "", err
+		}
+		return r.waitForOperandPods(ctx, cr)
+
+	case hlaiv1beta1.DeletionPhaseCleaningFeatureFiles:
+		done, err := r.nlr.CleanupFeatureFiles(ctx, cr)
+		if err != nil || done {
+			return "", err
+		}
+		return "the removal of the node labeler feature files from the nodes", nil
+	}
+
+	return "", nil
+}
+
+// drainWorkloads returns the pods using the HPUs of the nodes of cr that are
+// waited for, evicting them with the Evict policy. The nodes are cordoned
+// first, so that the pods using the HPUs are not scheduled on them again.
+func (r *deletionReconciler) drainWorkloads(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (string, error) {
+	nodeList, err := r.listNodes(ctx, cr)
+	if err != nil {
+		return "", err
+	}
+
+	resourceName := cr.GetDeviceType().GetResourceName()
+	evict := cr.GetWorkloadDeletionPolicy() == hlaiv1beta1.WorkloadDeletionPolicyEvict
+
+	remaining := []string{}
+	for i := range nodeList.Items {
+		n := &nodeList.Items[i]
+		if err := r.cordon(ctx, n); err != nil {
+			return "", err
+		}
+
+		running, err := pods.Drain(ctx, r.client, r.reader, n.Name, resourceName, evict)
+		if err != nil {
+			return "", fmt.Errorf("failed to drain node %s: %w", n.Name, err)
+		}
+		remaining = append(remaining, running...)
+	}
+
+	if len(remaining) == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprintf("%d pods using %s: %s", len(remaining), resourceName, pods.JoinNames(remaining)), nil
+}
+
+// listNodes returns the nodes labelled with the target label of cr, which are
+// all the nodes its driver is loaded on, including the ones leaving it.
+func (r *deletionReconciler) listNodes(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (*corev1.NodeList, error) {
+	nodeList := &corev1.NodeList{}
+	opts := []client.ListOption{
+		client.MatchingLabels{nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(cr)},
+	}
+	if err := r.client.List(ctx, nodeList, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	return nodeList, nil
+}
+
+// cordon marks n unschedulable while its workloads are drained.
+func (r *deletionReconciler) cordon(ctx context.Context, n *corev1.Node) error {
+	if n.Spec.Unschedulable {
+		return nil
+	}
+
+	patch := client.MergeFrom(n.DeepCopy())
+	n.Spec.Unschedulable = true
+	metav1.SetMetaDataAnnotation(&n.ObjectMeta, cordonedAnnotation, "true")
+	if err := r.client.Patch(ctx, n, patch); err != nil {
+		return fmt.Errorf("failed to cordon node %s: %w", n.Name, err)
+	}
+
+	log.FromContext(ctx).Info("Cordoned node", "node", n.Name)
+
+	return nil
+}
+
+// uncordonNodes marks the nodes of cr schedulable again, if their deletion
+// cordoned them.
+func (r *deletionReconciler) uncordonNodes(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	nodeList, err := r.listNodes(ctx, cr)
+	if err != nil {
+		return err
+	}
+
+	for i := range nodeList.Items {
+		n := &nodeList.Items[i]
+		if _, ok := n.Annotations[cordonedAnnotation]; !ok {
+			continue
+		}
+
+		patch := client.MergeFrom(n.DeepCopy())
+		n.Spec.Unschedulable = false
+		delete(n.Annotations, cordonedAnnotation)
+		if err := r.client.Patch(ctx, n, patch); err != nil {
+			return fmt.Errorf("failed to uncordon node %s: %w", n.Name, err)
+		}
+
+		log.FromContext(ctx).Info("Uncordoned node", "node", n.Name)
+	}
+
+	return nil
+}
+
+// waitForKMMPods returns the KMM pods of cr with role still running, as the
+// pods of component.
+func (r *deletionReconciler) waitForKMMPods(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, role, component string) (string, error) {
+	podList := &corev1.PodList{}
+	opts := []client.ListOption{
+		client.InNamespace(cr.Namespace),
+		client.MatchingLabels{module.KMMRoleLabel: role},
+	}
+	if err := r.reader.List(ctx, podList, opts...); err != nil {
+		return "", fmt.Errorf("failed to list KMM pods: %w", err)
+	}
+
+	remaining := []string{}
+	for _, p := range podList.Items {
+		if module.IsModuleName(cr, p.Labels[module.KMMModuleNameLabel]) {
+			remaining = append(remaining, p.Name)
+		}
+	}
+
+	if len(remaining) == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprintf("%d %s pods: %s", len(remaining), component, pods.JoinNames(remaining)), nil
+}
+
+// waitForOperandPods returns the pods of the DaemonSets of cr still running.
+func (r *deletionReconciler) waitForOperandPods(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (string, error) {
+	podList := &corev1.PodList{}
+	opts := []client.ListOption{
+		client.InNamespace(cr.Namespace),
+		client.MatchingLabels{
+			instance.NameLabel:     constants.HabanaAIOperatorName,
+			instance.InstanceLabel: instance.LabelValue(cr.Name),
+		},
+	}
+	if err := r.client.List(ctx, podList, opts...); err != nil {
+		return "", fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	if len(podList.Items) == 0 {
+		return "", nil
+	}
+
+	remaining := make([]string, 0, len(podList.Items))
+	for _, p := range podList.Items {
+		remaining = append(remaining, p.Name)
+	}
+
+	return fmt.Sprintf("%d node labeler and metrics exporter pods: %s", len(remaining), pods.JoinNames(remaining)), nil
+}
+
+// setPhase moves the deletion of cr to phase, and records it in an event.
+func (r *deletionReconciler) setPhase(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, phase hlaiv1beta1.DeletionPhase) {
+	status := cr.Status.Deletion
+	status.Phase = phase
+	status.LastTransitionTime = metav1.Now()
+	status.Message = ""
+
+	message := ""
+	switch phase {
+	case hlaiv1beta1.DeletionPhaseDrainingWorkloads:
+		message = "Waiting for the pods using the HPUs to complete"
+		if cr.GetWorkloadDeletionPolicy() == hlaiv1beta1.WorkloadDeletionPolicyEvict {
+			message = "Evicting the pods using the HPUs"
+		}
+	case hlaiv1beta1.DeletionPhaseRemovingDevicePlugin:
+		message = "Removing the device plugin"
+	case hlaiv1beta1.DeletionPhaseUnloadingDriver:
+		message = "Unloading the driver"
+	case hlaiv1beta1.DeletionPhaseRemovingNodeComponents:
+		message = "Removing the node labeler and metrics exporter"
+	case hlaiv1beta1.DeletionPhaseCleaningFeatureFiles:
+		message = "Removing the node labeler feature files"
+	case hlaiv1beta1.DeletionPhaseComplete:
+		message = "Deleted the components from the selected nodes"
+	}
+
+	r.recorder.Eventf(cr, corev1.EventTypeNormal, string(phase), message)
+	log.FromContext(ctx).Info("Deletion phase changed", "phase", phase)
+}
+
+// getNextPhase returns the phase following phase.
+func getNextPhase(phase hlaiv1beta1.DeletionPhase) hlaiv1beta1.DeletionPhase {
+	for i := range phases[:len(phases)-1] {
+		if phases[i] == phase {
+			return phases[i+1]
+		}
+	}
+
+	return hlaiv1beta1.DeletionPhaseComplete
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deletion
+
+import (
+	"context"
+
+	"github.com/golang/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+	nodeLabeler "github.com/HabanaAI/habana-ai-operator/internal/node/labeler"
+	nodeMetrics "github.com/HabanaAI/habana-ai-operator/internal/node/metrics"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
+	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+)
+
+const testNamespace = "a-namespace"
+
+// evictingClient evicts pods by deleting them, which the fake client does not
+// support.
+type evictingClient struct {
+	ctrlclient.Client
+}
+
+func (c *evictingClient) SubResource(subResource string) ctrlclient.SubResourceClient {
+	if subResource != "eviction" {
+		return c.Client.SubResource(subResource)
+	}
+
+	return &evictionClient{SubResourceClient: c.Client.SubResource(subResource), c: c}
+}
+
+type evictionClient struct {
+	ctrlclient.SubResourceClient
+	c *evictingClient
+}
+
+func (e *evictionClient) Create(ctx context.Context, obj ctrlclient.Object, _ ctrlclient.Object, _ ...ctrlclient.SubResourceCreateOption) error {
+	return e.c.Delete(ctx, obj)
+}
+
+var _ = Describe("ReconcileDeletion", func() {
+	var (
+		ctx      context.Context
+		s        *runtime.Scheme
+		dc       *hlaiv1beta1.DeviceConfig
+		recorder *record.FakeRecorder
+		gCtrl    *gomock.Controller
+		mr       *module.MockReconciler
+		nlr      *nodeLabeler.MockReconciler
+		nmr      *nodeMetrics.MockReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+
+		s = scheme.Scheme
+		Expect(hlaiv1beta1.AddToScheme(s)).To(Succeed())
+		Expect(kmmv1beta1.AddToScheme(s)).To(Succeed())
+
+		dc = &hlaiv1beta1.DeviceConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-device-config", Namespace: testNamespace, UID: "a-uid"},
+			Spec: hlaiv1beta1.DeviceConfigSpec{
+				NodeSelector: map[string]string{"hpu": "true"},
+			},
+		}
+
+		recorder = record.NewFakeRecorder(20)
+
+		gCtrl = gomock.NewController(GinkgoT())
+		mr = module.NewMockReconciler(gCtrl)
+		nlr = nodeLabeler.NewMockReconciler(gCtrl)
+		nmr = nodeMetrics.NewMockReconciler(gCtrl)
+	})
+
+	newReconciler := func(objs ...ctrlclient.Object) (Reconciler, ctrlclient.Client) {
+		c := &evictingClient{
+			Client: fake.NewClientBuilder().
+				WithScheme(s).
+				WithObjects(objs...).
+				WithIndex(&corev1.Pod{}, pods.NodeNameField, func(o ctrlclient.Object) []string {
+					return []string{o.(*corev1.Pod).Spec.NodeName}
+				}).
+				Build(),
+		}
+
+		return NewReconciler(c, c, recorder, mr, nlr, nmr), c
+	}
+
+	var node *corev1.Node
+
+	BeforeEach(func() {
+		node = &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "node-a",
+				Labels: map[string]string{nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(dc)},
+			},
+		}
+	})
+
+	getNode := func(c ctrlclient.Client) *corev1.Node {
+		n := &corev1.Node{}
+		Expect(c.Get(ctx, ctrlclient.ObjectKeyFromObject(node), n)).To(Succeed())
+		return n
+	}
+
+	workload := func() *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "training", Namespace: "user"},
+			Spec: corev1.PodSpec{
+				NodeName: node.Name,
+				Containers: []corev1.Container{
+					{
+						Name: "train",
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{"habana.ai/gaudi": resource.MustParse("8")},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	expectComponentsDeleted := func() {
+		gomock.InOrder(
+			mr.EXPECT().DeleteDevicePlugin(ctx, dc).Return(nil),
+			mr.EXPECT().DeleteModules(ctx, dc).Return(nil),
+			nlr.EXPECT().DeleteNodeLabeler(ctx, dc).Return(nil),
+			nmr.EXPECT().DeleteNodeMetrics(ctx, dc).Return(nil),
+			nlr.EXPECT().CleanupFeatureFiles(ctx, dc).Return(true, nil),
+		)
+	}
+
+	It("should delete the components in order without workloads", func() {
+		r, _ := newReconciler(node.DeepCopy())
+		expectComponentsDeleted()
+
+		deleted, err := r.ReconcileDeletion(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(BeTrue())
+		Expect(dc.Status.Deletion.Phase).To(Equal(hlaiv1beta1.DeletionPhaseComplete))
+		Expect(RequeueAfter(dc)).To(BeZero())
+
+		for _, phase := range phases {
+			Expect(recorder.Events).To(Receive(HavePrefix("Normal %s", phase)))
+		}
+	})
+
+	It("should wait for the workloads to complete with the Wait policy", func() {
+		r, c := newReconciler(node.DeepCopy(), workload())
+
+		deleted, err := r.ReconcileDeletion(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(BeFalse())
+		Expect(dc.Status.Deletion.Phase).To(Equal(hlaiv1beta1.DeletionPhaseDrainingWorkloads))
+		Expect(dc.Status.Deletion.Message).To(Equal("1 pods using habana.ai/gaudi: user/training"))
+		Expect(RequeueAfter(dc)).ToNot(BeZero())
+
+		Expect(c.Get(ctx, ctrlclient.ObjectKeyFromObject(workload()), &corev1.Pod{})).To(Succeed())
+		Expect(getNode(c).Spec.Unschedulable).To(BeTrue())
+
+		Expect(c.Delete(ctx, workload())).To(Succeed())
+		expectComponentsDeleted()
+
+		deleted, err = r.ReconcileDeletion(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(BeTrue())
+
+		n := getNode(c)
+		Expect(n.Spec.Unschedulable).To(BeFalse())
+		Expect(n.Annotations).ToNot(HaveKey(cordonedAnnotation))
+	})
+
+	It("should drain the nodes of the DeviceConfig no longer selected", func() {
+		dc.Spec.NodeSelector = map[string]string{"hpu": "true"}
+		r, _ := newReconciler(node.DeepCopy(), workload())
+
+		deleted, err := r.ReconcileDeletion(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(BeFalse())
+		Expect(dc.Status.Deletion.Message).To(Equal("1 pods using habana.ai/gaudi: user/training"))
+	})
+
+	It("should leave the nodes cordoned by an administrator cordoned", func() {
+		node.Spec.Unschedulable = true
+		r, c := newReconciler(node.DeepCopy())
+		expectComponentsDeleted()
+
+		deleted, err := r.ReconcileDeletion(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(BeTrue())
+		Expect(getNode(c).Spec.Unschedulable).To(BeTrue())
+	})
+
+	It("should evict the workloads and wait for the device plugin pods with the Evict policy", func() {
+		dc.Spec.Deletion = &hlaiv1beta1.DeletionPolicySpec{Workloads: hlaiv1beta1.WorkloadDeletionPolicyEvict}
+		devicePlugin := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "device-plugin",
+				Namespace: testNamespace,
+				Labels: map[string]string{
+					module.KMMRoleLabel:       module.KMMDevicePluginRole,
+					module.KMMModuleNameLabel: module.GetModuleName(dc),
+				},
+			},
+		}
+		r, c := newReconciler(node.DeepCopy(), workload(), devicePlugin)
+
+		// The evicted pods are waited for until they are gone.
+		deleted, err := r.ReconcileDeletion(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(BeFalse())
+		Expect(dc.Status.Deletion.Phase).To(Equal(hlaiv1beta1.DeletionPhaseDrainingWorkloads))
+
+		podList := &corev1.PodList{}
+		Expect(c.List(ctx, podList, ctrlclient.InNamespace("user"))).To(Succeed())
+		Expect(podList.Items).To(BeEmpty())
+
+		mr.EXPECT().DeleteDevicePlugin(ctx, dc).Return(nil)
+
+		deleted, err = r.ReconcileDeletion(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(BeFalse())
+		Expect(dc.Status.Deletion.Phase).To(Equal(hlaiv1beta1.DeletionPhaseRemovingDevicePlugin))
+		Expect(dc.Status.Deletion.Message).To(Equal("1 device plugin pods: device-plugin"))
+	})
+
+	It("should not wait with the force annotation", func() {
+		dc.Annotations = map[string]string{ForceAnnotation: "true"}
+		r, c := newReconciler(node.DeepCopy(), workload())
+		expectComponentsDeleted()
+
+		deleted, err := r.ReconcileDeletion(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(BeTrue())
+		Expect(getNode(c).Spec.Unschedulable).To(BeFalse())
+
+		Expect(recorder.Events).To(Receive(HavePrefix("Normal DrainingWorkloads")))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning DeletionForced")))
+	})
+})
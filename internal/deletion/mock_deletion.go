@@ -0,0 +1,51 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: deletion.go
+
+// Package deletion is a generated GoMock package.
+package deletion
+
+import (
+	context "context"
+	reflect "reflect"
+
+	v1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockReconciler is a mock of Reconciler interface.
+type MockReconciler struct {
+	ctrl     *gomock.Controller
+	recorder *MockReconcilerMockRecorder
+}
+
+// MockReconcilerMockRecorder is the mock recorder for MockReconciler.
+type MockReconcilerMockRecorder struct {
+	mock *MockReconciler
+}
+
+// NewMockReconciler creates a new mock instance.
+func NewMockReconciler(ctrl *gomock.Controller) *MockReconciler {
+	mock := &MockReconciler{ctrl: ctrl}
+	mock.recorder = &MockReconcilerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReconciler) EXPECT() *MockReconcilerMockRecorder {
+	return m.recorder
+}
+
+// ReconcileDeletion mocks base method.
+func (m *MockReconciler) ReconcileDeletion(ctx context.Context, cr *v1beta1.DeviceConfig) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReconcileDeletion", ctx, cr)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReconcileDeletion indicates an expected call of ReconcileDeletion.
+func (mr *MockReconcilerMockRecorder) ReconcileDeletion(ctx, cr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReconcileDeletion", reflect.TypeOf((*MockReconciler)(nil).ReconcileDeletion), ctx, cr)
+}
@@ -20,15 +20,15 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 )
 
 //go:generate mockgen -source=finalizers.go -package=finalizers -destination=mock_finalizers.go
 
 type Updater interface {
-	AddDeletionFinalizer(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error
-	RemoveDeletionFinalizer(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error
-	ContainsDeletionFinalizer(cr *hlaiv1alpha1.DeviceConfig) bool
+	AddDeletionFinalizer(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error
+	RemoveDeletionFinalizer(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error
+	ContainsDeletionFinalizer(cr *hlaiv1beta1.DeviceConfig) bool
 }
 
 type updater struct {
@@ -39,22 +39,22 @@ func NewUpdater(client client.Client) Updater {
 	return &updater{client: client}
 }
 
-func (u *updater) AddDeletionFinalizer(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
-	controllerutil.AddFinalizer(cr, hlaiv1alpha1.DeviceConfigDeletionFinalizer)
+func (u *updater) AddDeletionFinalizer(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	controllerutil.AddFinalizer(cr, hlaiv1beta1.DeviceConfigDeletionFinalizer)
 	if err := u.client.Update(ctx, cr); err != nil {
 		return fmt.Errorf("failed to add deletion finalizer for %s: %w", cr.Name, err)
 	}
 	return nil
 }
 
-func (u *updater) RemoveDeletionFinalizer(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
-	controllerutil.RemoveFinalizer(cr, hlaiv1alpha1.DeviceConfigDeletionFinalizer)
+func (u *updater) RemoveDeletionFinalizer(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	controllerutil.RemoveFinalizer(cr, hlaiv1beta1.DeviceConfigDeletionFinalizer)
 	if err := u.client.Update(ctx, cr); err != nil {
 		return fmt.Errorf("failed to remove deletion finalizer for %s: %w", cr.Name, err)
 	}
 	return nil
 }
 
-func (u *updater) ContainsDeletionFinalizer(cr *hlaiv1alpha1.DeviceConfig) bool {
-	return controllerutil.ContainsFinalizer(cr, hlaiv1alpha1.DeviceConfigDeletionFinalizer)
+func (u *updater) ContainsDeletionFinalizer(cr *hlaiv1beta1.DeviceConfig) bool {
+	return controllerutil.ContainsFinalizer(cr, hlaiv1beta1.DeviceConfigDeletionFinalizer)
 }
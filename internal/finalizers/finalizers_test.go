@@ -23,19 +23,19 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	"github.com/HabanaAI/habana-ai-operator/internal/client"
 )
 
 var _ = Describe("FinalizersUpdater", func() {
 	var (
-		dc *hlaiv1alpha1.DeviceConfig
+		dc *hlaiv1beta1.DeviceConfig
 		c  *client.MockClient
 		u  Updater
 	)
 
 	BeforeEach(func() {
-		dc = &hlaiv1alpha1.DeviceConfig{ObjectMeta: metav1.ObjectMeta{Name: "a-device-config"}}
+		dc = &hlaiv1beta1.DeviceConfig{ObjectMeta: metav1.ObjectMeta{Name: "a-device-config"}}
 		c = client.NewMockClient(gomock.NewController(GinkgoT()))
 		u = NewUpdater(c)
 	})
@@ -49,7 +49,7 @@ var _ = Describe("FinalizersUpdater", func() {
 			It("should add the deletion finalizer to the DeviceConfig", func() {
 				err := u.AddDeletionFinalizer(context.TODO(), dc)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(dc.Finalizers).To(ContainElement(hlaiv1alpha1.DeviceConfigDeletionFinalizer))
+				Expect(dc.Finalizers).To(ContainElement(hlaiv1beta1.DeviceConfigDeletionFinalizer))
 			})
 		})
 
@@ -67,7 +67,7 @@ var _ = Describe("FinalizersUpdater", func() {
 
 	Describe("RemoveDeletionFinalizer", func() {
 		BeforeEach(func() {
-			dc.SetFinalizers([]string{hlaiv1alpha1.DeviceConfigDeletionFinalizer})
+			dc.SetFinalizers([]string{hlaiv1beta1.DeviceConfigDeletionFinalizer})
 		})
 
 		Context("with a successful update", func() {
@@ -78,7 +78,7 @@ var _ = Describe("FinalizersUpdater", func() {
 			It("should remove the finalizer from the DeviceConfig", func() {
 				err := u.RemoveDeletionFinalizer(context.TODO(), dc)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(dc.Finalizers).ToNot(ContainElement(hlaiv1alpha1.DeviceConfigDeletionFinalizer))
+				Expect(dc.Finalizers).ToNot(ContainElement(hlaiv1beta1.DeviceConfigDeletionFinalizer))
 			})
 		})
 
@@ -97,7 +97,7 @@ var _ = Describe("FinalizersUpdater", func() {
 	Describe("ContainsDeletionFinalizer", func() {
 		Context("for a DeviceConfig with a deletion finalizer", func() {
 			BeforeEach(func() {
-				dc.SetFinalizers([]string{hlaiv1alpha1.DeviceConfigDeletionFinalizer})
+				dc.SetFinalizers([]string{hlaiv1beta1.DeviceConfigDeletionFinalizer})
 			})
 
 			It("should return true", func() {
@@ -8,7 +8,7 @@ import (
 	context "context"
 	reflect "reflect"
 
-	v1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	v1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -36,7 +36,7 @@ func (m *MockUpdater) EXPECT() *MockUpdaterMockRecorder {
 }
 
 // AddDeletionFinalizer mocks base method.
-func (m *MockUpdater) AddDeletionFinalizer(ctx context.Context, cr *v1alpha1.DeviceConfig) error {
+func (m *MockUpdater) AddDeletionFinalizer(ctx context.Context, cr *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddDeletionFinalizer", ctx, cr)
 	ret0, _ := ret[0].(error)
@@ -50,7 +50,7 @@ func (mr *MockUpdaterMockRecorder) AddDeletionFinalizer(ctx, cr interface{}) *go
 }
 
 // ContainsDeletionFinalizer mocks base method.
-func (m *MockUpdater) ContainsDeletionFinalizer(cr *v1alpha1.DeviceConfig) bool {
+func (m *MockUpdater) ContainsDeletionFinalizer(cr *v1beta1.DeviceConfig) bool {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "ContainsDeletionFinalizer", cr)
 	ret0, _ := ret[0].(bool)
@@ -64,7 +64,7 @@ func (mr *MockUpdaterMockRecorder) ContainsDeletionFinalizer(cr interface{}) *go
 }
 
 // RemoveDeletionFinalizer mocks base method.
-func (m *MockUpdater) RemoveDeletionFinalizer(ctx context.Context, cr *v1alpha1.DeviceConfig) error {
+func (m *MockUpdater) RemoveDeletionFinalizer(ctx context.Context, cr *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "RemoveDeletionFinalizer", ctx, cr)
 	ret0, _ := ret[0].(error)
@@ -0,0 +1,103 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/constants"
+)
+
+const (
+	NameLabel      = "app.kubernetes.io/name"
+	InstanceLabel  = "app.kubernetes.io/instance"
+	ComponentLabel = "app.kubernetes.io/component"
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+
+	// hashedLabelValuePrefixLength leaves room for a dash and 8 hex digits
+	// in a 63 characters label value.
+	hashedLabelValuePrefixLength = validation.LabelValueMaxLength - 9
+)
+
+// GetSelectorLabels returns the labels selecting the pods of a component of cr.
+// They never change for a given DeviceConfig, since DaemonSet selectors are
+// immutable.
+func GetSelectorLabels(cr *hlaiv1beta1.DeviceConfig, component string) map[string]string {
+	return map[string]string{
+		NameLabel:      constants.HabanaAIOperatorName,
+		InstanceLabel:  LabelValue(cr.Name),
+		ComponentLabel: component,
+	}
+}
+
+// GetSelector returns the selector of the DaemonSet of a component of cr.
+func GetSelector(cr *hlaiv1beta1.DeviceConfig, component string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{MatchLabels: GetSelectorLabels(cr, component)}
+}
+
+// GetLabels returns the labels of the objects generated for a component of
+// cr, and of their pods. They leave the driver version out, so that a driver
+// upgrade does not relabel the objects nor restart their pods.
+func GetLabels(cr *hlaiv1beta1.DeviceConfig, component string) map[string]string {
+	l := GetSelectorLabels(cr, component)
+	l[ManagedByLabel] = constants.HabanaAIOperatorName
+
+	return l
+}
+
+// LabelValue returns s if it is a valid label value. Otherwise, it returns a
+// prefix of s followed by a hash of s, which is a valid label value for the
+// DeviceConfig names and driver versions.
+func LabelValue(s string) string {
+	if len(validation.IsValidLabelValue(s)) == 0 {
+		return s
+	}
+
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(s)))[:8]
+
+	prefix := s
+	if len(prefix) > hashedLabelValuePrefixLength {
+		prefix = prefix[:hashedLabelValuePrefixLength]
+	}
+	prefix = strings.Trim(prefix, "-_.")
+	if prefix == "" {
+		return hash
+	}
+
+	return fmt.Sprintf("%s-%s", prefix, hash)
+}
+
+// SetLabels adds the labels of a component of cr to the labels of obj,
+// keeping the labels set by others.
+func SetLabels(obj metav1.Object, cr *hlaiv1beta1.DeviceConfig, component string) {
+	l := obj.GetLabels()
+	if l == nil {
+		l = map[string]string{}
+	}
+
+	for k, v := range GetLabels(cr, component) {
+		l[k] = v
+	}
+
+	obj.SetLabels(l)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+)
+
+var _ = Describe("Instance", func() {
+	var dc *hlaiv1beta1.DeviceConfig
+
+	BeforeEach(func() {
+		dc = &hlaiv1beta1.DeviceConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-device-config"},
+			Spec: hlaiv1beta1.DeviceConfigSpec{
+				Driver: hlaiv1beta1.DriverSpec{Version: "1.6.0-439"},
+			},
+		}
+	})
+
+	Describe("Labels", func() {
+		It("should identify the instance, component and manager", func() {
+			Expect(GetLabels(dc, "a-component")).To(Equal(map[string]string{
+				"app.kubernetes.io/name":       "habana-ai-operator",
+				"app.kubernetes.io/instance":   "a-device-config",
+				"app.kubernetes.io/component":  "a-component",
+				"app.kubernetes.io/managed-by": "habana-ai-operator",
+			}))
+		})
+
+		It("should be a superset of the selector labels", func() {
+			labels := GetLabels(dc, "a-component")
+
+			for k, v := range GetSelectorLabels(dc, "a-component") {
+				Expect(labels).To(HaveKeyWithValue(k, v))
+			}
+			Expect(GetSelectorLabels(dc, "a-component")).ToNot(HaveKey(ManagedByLabel))
+		})
+
+		It("should differ between DeviceConfigs", func() {
+			other := dc.DeepCopy()
+			other.Name = "another-device-config"
+
+			Expect(GetSelectorLabels(dc, "a-component")).ToNot(Equal(GetSelectorLabels(other, "a-component")))
+		})
+	})
+
+	Describe("LabelValue", func() {
+		It("should keep valid label values", func() {
+			Expect(LabelValue("1.6.0-439")).To(Equal("1.6.0-439"))
+		})
+
+		It("should shorten long values into distinct valid label values", func() {
+			long := strings.Repeat("a", 100)
+			longer := strings.Repeat("a", 101)
+
+			Expect(validation.IsValidLabelValue(LabelValue(long))).To(BeEmpty())
+			Expect(LabelValue(long)).To(HavePrefix("aaaa"))
+			Expect(LabelValue(long)).ToNot(Equal(LabelValue(longer)))
+		})
+
+		It("should fix values not starting with an alphanumeric character", func() {
+			Expect(validation.IsValidLabelValue(LabelValue("_1.6.0"))).To(BeEmpty())
+		})
+	})
+})
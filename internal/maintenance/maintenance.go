@@ -0,0 +1,192 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package maintenance
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+)
+
+// ReconcileMaintenance records in cr.Status.Maintenance the driver
+// configuration applied to the nodes of cr, and returns true if changes to it
+// wait for a maintenance window. Without windows, or while one is open, the
+// DeviceConfig driver configuration is applied right away. It is recorded as
+// applied when the windows are set, since its changes were applied until then.
+func ReconcileMaintenance(cr *hlaiv1beta1.DeviceConfig, now time.Time) (bool, error) {
+	windows := cr.Spec.MaintenanceWindows
+	if len(windows) == 0 {
+		cr.Status.Maintenance = nil
+		return false, nil
+	}
+
+	open, err := IsOpen(windows, now)
+	if err != nil {
+		return false, err
+	}
+
+	next, err := NextWindow(windows, now)
+	if err != nil {
+		return false, err
+	}
+
+	driver := getDisruptiveDriver(cr.Spec.Driver)
+	status := cr.Status.Maintenance
+	if status == nil {
+		status = &hlaiv1beta1.MaintenanceStatus{AppliedDriver: driver}
+		cr.Status.Maintenance = status
+	}
+
+	status.NextWindow = nil
+	if !next.IsZero() {
+		t := metav1.NewTime(next)
+		status.NextWindow = &t
+	}
+
+	changes := getChanges(status.AppliedDriver, driver)
+	if open || len(changes) == 0 {
+		status.AppliedDriver = driver
+		status.PendingChanges = nil
+		status.PendingSince = nil
+		return false, nil
+	}
+
+	status.PendingChanges = changes
+	if status.PendingSince == nil {
+		t := metav1.NewTime(now)
+		status.PendingSince = &t
+	}
+
+	return true, nil
+}
+
+// GetAppliedDeviceConfig returns a copy of cr with the driver configuration
+// applied to its nodes.
+func GetAppliedDeviceConfig(cr *hlaiv1beta1.DeviceConfig) *hlaiv1beta1.DeviceConfig {
+	applied := cr.DeepCopy()
+	if s := cr.Status.Maintenance; s != nil {
+		d := s.AppliedDriver.DeepCopy()
+		d.Preflight = applied.Spec.Driver.Preflight
+		d.PrePull = applied.Spec.Driver.PrePull
+		d.UpgradePolicy = applied.Spec.Driver.UpgradePolicy
+		d.Canary = applied.Spec.Driver.Canary
+		d.Rollback = applied.Spec.Driver.Rollback
+		applied.Spec.Driver = *d
+	}
+
+	return applied
+}
+
+// RequeueAfter returns when the next maintenance window of cr opens, if
+// changes wait for it, or 0.
+func RequeueAfter(cr *hlaiv1beta1.DeviceConfig, now time.Time) time.Duration {
+	s := cr.Status.Maintenance
+	if s == nil || len(s.PendingChanges) == 0 || s.NextWindow == nil {
+		return 0
+	}
+
+	if after := s.NextWindow.Sub(now); after > 0 {
+		return after
+	}
+
+	return time.Second
+}
+
+// IsOpen returns true if one of windows is open at now.
+func IsOpen(windows []hlaiv1beta1.MaintenanceWindow, now time.Time) (bool, error) {
+	for _, w := range windows {
+		s, err := ParseSchedule(w.Schedule, w.GetTimeZone())
+		if err != nil {
+			return false, err
+		}
+
+		// The window opened after now - duration if it is still open.
+		if start := s.Next(now.Add(-w.GetDuration())); !start.IsZero() && !start.After(now) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// NextWindow returns when the first of windows opens after now, or the zero
+// time if none does.
+func NextWindow(windows []hlaiv1beta1.MaintenanceWindow, now time.Time) (time.Time, error) {
+	next := time.Time{}
+	for _, w := range windows {
+		s, err := ParseSchedule(w.Schedule, w.GetTimeZone())
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if t := s.Next(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+
+	return next, nil
+}
+
+// getDisruptiveDriver returns d without its policies, which apply right away
+// and do not restart the drivers.
+func getDisruptiveDriver(d hlaiv1beta1.DriverSpec) hlaiv1beta1.DriverSpec {
+	driver := *d.DeepCopy()
+	driver.Preflight = nil
+	driver.PrePull = nil
+	driver.UpgradePolicy = nil
+	driver.Canary = nil
+	driver.Rollback = nil
+
+	return driver
+}
+
+// getChanges describes the changes from the applied driver configuration to
+// the desired one.
+func getChanges(applied, desired hlaiv1beta1.DriverSpec) []string {
+	changes := []string{}
+	if applied.Version != desired.Version {
+		changes = append(changes, fmt.Sprintf("version %s to %s", applied.Version, desired.Version))
+	}
+
+	for _, f := range []struct {
+		name    string
+		changed bool
+	}{
+		{"image", applied.Image != desired.Image},
+		{"kernelMappings", !equality.Semantic.DeepEqual(applied.KernelMappings, desired.KernelMappings)},
+		{"build", !equality.Semantic.DeepEqual(applied.Build, desired.Build)},
+		{"sign", !equality.Semantic.DeepEqual(applied.Sign, desired.Sign)},
+		{"modprobe", !equality.Semantic.DeepEqual(applied.Modprobe, desired.Modprobe)},
+		{"companionModules", !equality.Semantic.DeepEqual(applied.CompanionModules, desired.CompanionModules)},
+		{"imageRepoSecret", !equality.Semantic.DeepEqual(applied.ImageRepoSecret, desired.ImageRepoSecret)},
+	} {
+		if f.changed {
+			changes = append(changes, f.name)
+		}
+	}
+
+	// A field missing from the list above still waits for a window.
+	if len(changes) == 0 && !equality.Semantic.DeepEqual(applied, desired) {
+		changes = append(changes, "driver")
+	}
+
+	return changes
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package maintenance
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReconcileMaintenance", func() {
+	const (
+		oldVersion = "1.10.0-494"
+		newVersion = "1.11.0-587"
+	)
+
+	var (
+		dc *hlaiv1beta1.DeviceConfig
+		// A Friday, outside the window.
+		now = time.Date(2023, time.March, 10, 14, 30, 0, 0, time.UTC)
+		// The window opening on Saturday at 2:00.
+		window = time.Date(2023, time.March, 11, 2, 0, 0, 0, time.UTC)
+	)
+
+	BeforeEach(func() {
+		dc = &hlaiv1beta1.DeviceConfig{
+			Spec: hlaiv1beta1.DeviceConfigSpec{
+				Driver: hlaiv1beta1.DriverSpec{Version: oldVersion},
+				MaintenanceWindows: []hlaiv1beta1.MaintenanceWindow{
+					{Schedule: "0 2 * * 6", DurationSeconds: 4 * 3600},
+				},
+			},
+		}
+	})
+
+	It("should apply the changes right away without windows", func() {
+		dc.Spec.MaintenanceWindows = nil
+		dc.Status.Maintenance = &hlaiv1beta1.MaintenanceStatus{}
+
+		held, err := ReconcileMaintenance(dc, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(held).To(BeFalse())
+		Expect(dc.Status.Maintenance).To(BeNil())
+	})
+
+	It("should record the driver configuration as applied when the windows are set", func() {
+		held, err := ReconcileMaintenance(dc, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(held).To(BeFalse())
+		Expect(dc.Status.Maintenance.AppliedDriver.Version).To(Equal(oldVersion))
+		Expect(dc.Status.Maintenance.PendingChanges).To(BeEmpty())
+		Expect(dc.Status.Maintenance.NextWindow.Time).To(BeTemporally("==", window))
+	})
+
+	It("should hold the driver changes until a window opens", func() {
+		_, err := ReconcileMaintenance(dc, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		dc.Spec.Driver.Version = newVersion
+		dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{Parameters: []string{"timeout_locked=30"}}
+
+		held, err := ReconcileMaintenance(dc, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(held).To(BeTrue())
+		Expect(dc.Status.Maintenance.AppliedDriver.Version).To(Equal(oldVersion))
+		Expect(dc.Status.Maintenance.PendingChanges).To(Equal([]string{"version 1.10.0-494 to 1.11.0-587", "modprobe"}))
+		Expect(dc.Status.Maintenance.PendingSince.Time).To(BeTemporally("==", now))
+		Expect(RequeueAfter(dc, now)).To(Equal(window.Sub(now)))
+
+		applied := GetAppliedDeviceConfig(dc)
+		Expect(applied.Spec.Driver.Version).To(Equal(oldVersion))
+		Expect(applied.Spec.Driver.Modprobe).To(BeNil())
+
+		held, err = ReconcileMaintenance(dc, window.Add(time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(held).To(BeFalse())
+		Expect(dc.Status.Maintenance.AppliedDriver.Version).To(Equal(newVersion))
+		Expect(dc.Status.Maintenance.PendingChanges).To(BeEmpty())
+		Expect(dc.Status.Maintenance.PendingSince).To(BeNil())
+		Expect(RequeueAfter(dc, now)).To(BeZero())
+	})
+
+	It("should apply the policies right away", func() {
+		dc.Status.Maintenance = &hlaiv1beta1.MaintenanceStatus{
+			AppliedDriver: hlaiv1beta1.DriverSpec{Version: oldVersion},
+		}
+		dc.Spec.Driver.UpgradePolicy = &hlaiv1beta1.DriverUpgradePolicySpec{}
+		dc.Spec.Driver.Canary = &hlaiv1beta1.DriverCanarySpec{}
+		dc.Spec.Driver.PrePull = &hlaiv1beta1.DriverPrePullSpec{}
+
+		held, err := ReconcileMaintenance(dc, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(held).To(BeFalse())
+
+		dc.Spec.Driver.Version = newVersion
+		_, err = ReconcileMaintenance(dc, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		applied := GetAppliedDeviceConfig(dc)
+		Expect(applied.Spec.Driver.Version).To(Equal(oldVersion))
+		Expect(applied.Spec.Driver.UpgradePolicy).ToNot(BeNil())
+		Expect(applied.Spec.Driver.Canary).ToNot(BeNil())
+		Expect(applied.Spec.Driver.PrePull).ToNot(BeNil())
+	})
+
+	It("should forget the pending changes once they are reverted", func() {
+		since := metav1.NewTime(now.Add(-time.Hour))
+		dc.Status.Maintenance = &hlaiv1beta1.MaintenanceStatus{
+			AppliedDriver:  hlaiv1beta1.DriverSpec{Version: oldVersion},
+			PendingChanges: []string{"image"},
+			PendingSince:   &since,
+		}
+
+		held, err := ReconcileMaintenance(dc, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(held).To(BeFalse())
+		Expect(dc.Status.Maintenance.PendingChanges).To(BeEmpty())
+		Expect(dc.Status.Maintenance.PendingSince).To(BeNil())
+	})
+})
+
+var _ = Describe("IsOpen", func() {
+	windows := []hlaiv1beta1.MaintenanceWindow{
+		{Schedule: "0 2 * * 6", DurationSeconds: 4 * 3600, TimeZone: "Europe/Paris"},
+		{Schedule: "0 12 1 * *", DurationSeconds: 3600},
+	}
+
+	DescribeTable("should tell whether a window is open",
+		func(now time.Time, expected bool) {
+			open, err := IsOpen(windows, now)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(open).To(Equal(expected))
+		},
+		Entry("before a window", time.Date(2023, time.March, 11, 0, 59, 0, 0, time.UTC), false),
+		Entry("when a window opens", time.Date(2023, time.March, 11, 1, 0, 0, 0, time.UTC), true),
+		Entry("in a window", time.Date(2023, time.March, 11, 4, 59, 0, 0, time.UTC), true),
+		Entry("when a window closes", time.Date(2023, time.March, 11, 5, 0, 0, 0, time.UTC), false),
+		Entry("in another window", time.Date(2023, time.April, 1, 12, 30, 0, 0, time.UTC), true),
+	)
+
+	It("should return the first window to open", func() {
+		next, err := NextWindow(windows, time.Date(2023, time.March, 28, 0, 0, 0, 0, time.UTC))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(next).To(BeTemporally("==", time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC)))
+	})
+})
@@ -0,0 +1,70 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package maintenance
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schedule", func() {
+	// A Friday.
+	now := time.Date(2023, time.March, 10, 14, 30, 15, 0, time.UTC)
+
+	DescribeTable("Next",
+		func(spec, tz string, expected time.Time) {
+			s, err := ParseSchedule(spec, tz)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Next(now)).To(BeTemporally("==", expected))
+		},
+		Entry("every minute", "* * * * *", "UTC", time.Date(2023, time.March, 10, 14, 31, 0, 0, time.UTC)),
+		Entry("daily", "@daily", "UTC", time.Date(2023, time.March, 11, 0, 0, 0, 0, time.UTC)),
+		Entry("on Saturdays at 2:00", "0 2 * * 6", "UTC", time.Date(2023, time.March, 11, 2, 0, 0, 0, time.UTC)),
+		Entry("on Sundays, as 7", "0 2 * * 7", "UTC", time.Date(2023, time.March, 12, 2, 0, 0, 0, time.UTC)),
+		Entry("with lists and steps", "15,45 */4 * * *", "UTC", time.Date(2023, time.March, 10, 16, 15, 0, 0, time.UTC)),
+		Entry("with ranges", "0 22-23 * * 1-5", "UTC", time.Date(2023, time.March, 10, 22, 0, 0, 0, time.UTC)),
+		Entry("on the first of the month or on Mondays", "0 0 1 * 1", "UTC", time.Date(2023, time.March, 13, 0, 0, 0, 0, time.UTC)),
+		Entry("in another month", "0 0 1 6 *", "UTC", time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)),
+		Entry("in a time zone", "0 2 * * *", "Asia/Kolkata", time.Date(2023, time.March, 10, 20, 30, 0, 0, time.UTC)),
+	)
+
+	It("should skip the hour missing on a daylight saving time change", func() {
+		s, err := ParseSchedule("30 2 * * *", "Europe/Paris")
+		Expect(err).ToNot(HaveOccurred())
+
+		next := s.Next(time.Date(2023, time.March, 25, 12, 0, 0, 0, time.UTC))
+		Expect(next).To(BeTemporally("==", time.Date(2023, time.March, 27, 0, 30, 0, 0, time.UTC)))
+	})
+
+	It("should not match a day that does not exist", func() {
+		s, err := ParseSchedule("0 0 30 2 *", "UTC")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Next(now).IsZero()).To(BeTrue())
+	})
+
+	DescribeTable("should reject an invalid schedule",
+		func(spec, tz string) {
+			_, err := ParseSchedule(spec, tz)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("with too few fields", "0 2 * *", "UTC"),
+		Entry("with an out of range value", "0 24 * * *", "UTC"),
+		Entry("with an inverted range", "0 5-2 * * *", "UTC"),
+		Entry("with an invalid step", "*/0 * * * *", "UTC"),
+		Entry("with a name", "0 2 * * SAT", "UTC"),
+		Entry("with an unknown time zone", "0 2 * * *", "Mars/Olympus_Mons"),
+	)
+})
@@ -26,10 +26,19 @@ var (
 		},
 		[]string{"device_config"},
 	)
+
+	KernelUnsupportedNodes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "habana_ai_operator_kernel_unsupported_nodes",
+			Help: "Reports the number of nodes selected by a DeviceConfig whose kernel matches no kernel mapping.",
+		},
+		[]string{"device_config"},
+	)
 )
 
 func init() {
 	metrics.Registry.MustRegister(
 		ReconciliationFailed,
+		KernelUnsupportedNodes,
 	)
 }
@@ -8,9 +8,10 @@ import (
 	context "context"
 	reflect "reflect"
 
-	v1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	v1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	gomock "github.com/golang/mock/gomock"
-	v1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+	v1beta10 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+	v1 "k8s.io/api/core/v1"
 )
 
 // MockReconciler is a mock of Reconciler interface.
@@ -36,36 +37,64 @@ func (m *MockReconciler) EXPECT() *MockReconcilerMockRecorder {
 	return m.recorder
 }
 
-// DeleteModule mocks base method.
-func (m *MockReconciler) DeleteModule(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+// DeleteDevicePlugin mocks base method.
+func (m *MockReconciler) DeleteDevicePlugin(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteModule", ctx, dc)
+	ret := m.ctrl.Call(m, "DeleteDevicePlugin", ctx, dc)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// DeleteModule indicates an expected call of DeleteModule.
-func (mr *MockReconcilerMockRecorder) DeleteModule(ctx, dc interface{}) *gomock.Call {
+// DeleteDevicePlugin indicates an expected call of DeleteDevicePlugin.
+func (mr *MockReconcilerMockRecorder) DeleteDevicePlugin(ctx, dc interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteModule", reflect.TypeOf((*MockReconciler)(nil).DeleteModule), ctx, dc)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteDevicePlugin", reflect.TypeOf((*MockReconciler)(nil).DeleteDevicePlugin), ctx, dc)
 }
 
-// ReconcileModule mocks base method.
-func (m *MockReconciler) ReconcileModule(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+// DeleteModules mocks base method.
+func (m *MockReconciler) DeleteModules(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ReconcileModule", ctx, dc)
+	ret := m.ctrl.Call(m, "DeleteModules", ctx, dc)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// ReconcileModule indicates an expected call of ReconcileModule.
-func (mr *MockReconcilerMockRecorder) ReconcileModule(ctx, dc interface{}) *gomock.Call {
+// DeleteModules indicates an expected call of DeleteModules.
+func (mr *MockReconcilerMockRecorder) DeleteModules(ctx, dc interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReconcileModule", reflect.TypeOf((*MockReconciler)(nil).ReconcileModule), ctx, dc)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteModules", reflect.TypeOf((*MockReconciler)(nil).DeleteModules), ctx, dc)
+}
+
+// ReconcileModules mocks base method.
+func (m *MockReconciler) ReconcileModules(ctx context.Context, dc *v1beta1.DeviceConfig, revisions []Revision) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReconcileModules", ctx, dc, revisions)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReconcileModules indicates an expected call of ReconcileModules.
+func (mr *MockReconcilerMockRecorder) ReconcileModules(ctx, dc, revisions interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReconcileModules", reflect.TypeOf((*MockReconciler)(nil).ReconcileModules), ctx, dc, revisions)
+}
+
+// SetDesiredDockerfileConfigMap mocks base method.
+func (m *MockReconciler) SetDesiredDockerfileConfigMap(cm *v1.ConfigMap, cr *v1beta1.DeviceConfig) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetDesiredDockerfileConfigMap", cm, cr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetDesiredDockerfileConfigMap indicates an expected call of SetDesiredDockerfileConfigMap.
+func (mr *MockReconcilerMockRecorder) SetDesiredDockerfileConfigMap(cm, cr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDesiredDockerfileConfigMap", reflect.TypeOf((*MockReconciler)(nil).SetDesiredDockerfileConfigMap), cm, cr)
 }
 
 // SetDesiredModule mocks base method.
-func (m_2 *MockReconciler) SetDesiredModule(m *v1beta1.Module, cr *v1alpha1.DeviceConfig) error {
+func (m_2 *MockReconciler) SetDesiredModule(m *v1beta10.Module, cr *v1beta1.DeviceConfig) error {
 	m_2.ctrl.T.Helper()
 	ret := m_2.ctrl.Call(m_2, "SetDesiredModule", m, cr)
 	ret0, _ := ret[0].(error)
@@ -15,26 +15,57 @@ package module
 
 import (
 	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/selection"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
 	s "github.com/HabanaAI/habana-ai-operator/internal/settings"
 	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
 )
 
 const (
+	// DriverVersionAnnotation records the driver version of a Module, which
+	// lags behind the DeviceConfig one until a new version is validated.
+	DriverVersionAnnotation = "habana.ai/driver-version"
+
+	// DriverModprobeAnnotation records the modprobe configuration hash of a
+	// Module, see GetModprobeConfigHash.
+	DriverModprobeAnnotation = "habana.ai/driver-modprobe-hash"
+
+	// NodeVersionLabel is set on the selected nodes to the label of the
+	// driver revision they run, see Revision and upgrade. Each Module of a
+	// DeviceConfig selects the nodes of its revision, so that the nodes are
+	// moved to a new one one at a time rather than by KMM all at once.
+	NodeVersionLabel = "habana.ai/driver-version"
+
+	// The labels set by KMM on the pods of the DaemonSets it creates for a
+	// Module.
+	KMMModuleNameLabel = "kmm.node.kubernetes.io/module.name"
+	KMMRoleLabel       = "kmm.node.kubernetes.io/role"
+
+	KMMModuleLoaderRole = "module-loader"
+	KMMDevicePluginRole = "device-plugin"
+
 	moduleSuffix = "module"
 
 	driverServiceAccount = "driver-habana"
@@ -44,14 +75,56 @@ const (
 	devicePluginRequestsCpu    = "100m"
 	devicePluginRequestsMemory = "50Mi"
 	devicePluginServiceAccount = "device-plugin"
+
+	// defaultDriverImageTemplate is the driver image of the kernel mappings
+	// without container image.
+	defaultDriverImageTemplate = "${DRIVER_IMAGE}:${DRIVER_VERSION}-${KERNEL_FULL_VERSION}"
+
+	dockerfileSuffix = "driver-dockerfile"
+	// dockerfileKey is the ConfigMap key KMM reads the Dockerfile from.
+	dockerfileKey = "dockerfile"
+	// driverVersionBuildArg and companionModulesBuildArg are passed to the
+	// driver image builds, unless overridden by their build arguments.
+	driverVersionBuildArg    = "DRIVER_VERSION"
+	companionModulesBuildArg = "COMPANION_MODULES"
+
+	// driverModulePathTemplate is the path of a driver kernel module in the
+	// driver images, where KMM replaces ${KERNEL_FULL_VERSION}.
+	driverModulePathTemplate = "/opt/lib/modules/${KERNEL_FULL_VERSION}/extra/%s.ko"
+
+	// The defaults of the driver modprobe configuration, matching the layout
+	// of the driver images.
+	defaultModprobeDirName      = "/opt"
+	defaultModprobeFirmwarePath = "/opt/lib/firmware"
 )
 
+// kernelVersionRegexp matches the major, minor and patch versions of a
+// kernel, e.g. 5, 14 and 0 in 5.14.0-284.el9.x86_64.
+var kernelVersionRegexp = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)`)
+
+// driverDockerfile builds the driver image from the habanalabs sources. It is
+// the Dockerfile of the builds not specifying one.
+//
+//go:embed driver.Dockerfile
+var driverDockerfile string
+
 //go:generate mockgen -source=module.go -package=module -destination=mock_module.go
 
 type Reconciler interface {
-	ReconcileModule(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
-	SetDesiredModule(m *kmmv1beta1.Module, cr *hlaiv1alpha1.DeviceConfig) error
-	DeleteModule(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
+	ReconcileModules(ctx context.Context, dc *hlaiv1beta1.DeviceConfig, revisions []Revision) error
+	SetDesiredModule(m *kmmv1beta1.Module, cr *hlaiv1beta1.DeviceConfig) error
+	DeleteModules(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
+	DeleteDevicePlugin(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
+	SetDesiredDockerfileConfigMap(cm *corev1.ConfigMap, cr *hlaiv1beta1.DeviceConfig) error
+}
+
+// Revision is a driver version deployed with a modprobe configuration, on the
+// nodes labelled with its Label. A change of the modprobe configuration is a
+// new revision of the same version, so that the driver is reloaded on the
+// nodes by their upgrade, like for a new version.
+type Revision struct {
+	Version string
+	Label   string
 }
 
 type moduleReconciler struct {
@@ -66,77 +139,396 @@ func NewReconciler(c client.Client, s *runtime.Scheme) *moduleReconciler {
 	}
 }
 
-func GetModuleName(cr *hlaiv1alpha1.DeviceConfig) string {
+// GetModuleName returns the name of the first Module of cr. The Modules of the
+// other driver versions deployed during an upgrade are suffixed with a number.
+func GetModuleName(cr *hlaiv1beta1.DeviceConfig) string {
 	return fmt.Sprintf("%s-%s", cr.Name, moduleSuffix)
 }
 
-func (r *moduleReconciler) ReconcileModule(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
+func getModuleSlotName(cr *hlaiv1beta1.DeviceConfig, slot int) string {
+	if slot == 0 {
+		return GetModuleName(cr)
+	}
+
+	return fmt.Sprintf("%s-%d", GetModuleName(cr), slot)
+}
+
+// IsModuleName returns true if name is the name of a Module of cr, rather
+// than e.g. of its preflight candidate Module.
+func IsModuleName(cr *hlaiv1beta1.DeviceConfig, name string) bool {
+	if name == GetModuleName(cr) {
+		return true
+	}
+
+	slot, err := strconv.Atoi(strings.TrimPrefix(name, GetModuleName(cr)+"-"))
+	return err == nil && slot > 0 && name == getModuleSlotName(cr, slot)
+}
+
+// GetModuleVersion returns the driver version of m, which is the DeviceConfig
+// one for a Module created before its version was recorded.
+func GetModuleVersion(m *kmmv1beta1.Module, cr *hlaiv1beta1.DeviceConfig) string {
+	if v, ok := m.Annotations[DriverVersionAnnotation]; ok {
+		return v
+	}
+
+	return cr.Spec.Driver.Version
+}
+
+// GetModuleRevision returns the driver revision of m. A Module created before
+// the nodes were labelled with their revision selects its version.
+func GetModuleRevision(m *kmmv1beta1.Module, cr *hlaiv1beta1.DeviceConfig) Revision {
+	rev := Revision{Version: GetModuleVersion(m, cr)}
+	if l, ok := m.Spec.Selector[NodeVersionLabel]; ok {
+		rev.Label = l
+	} else {
+		rev.Label = instance.LabelValue(rev.Version)
+	}
+
+	return rev
+}
+
+// GetModuleModprobeHash returns the modprobe configuration hash of m, which is
+// computed from its spec for a Module created before it was recorded.
+func GetModuleModprobeHash(m *kmmv1beta1.Module) (string, error) {
+	if h, ok := m.Annotations[DriverModprobeAnnotation]; ok {
+		return h, nil
+	}
+
+	return hashModprobe(m.Spec.ModuleLoader.Container.Modprobe, nil)
+}
+
+// GetRevision returns the revision of version with the modprobe configuration
+// of cr, among modules, the Modules of cr. It is labelled with version unless
+// a Module of another configuration already is, and with version suffixed by
+// the configuration hash then.
+func GetRevision(cr *hlaiv1beta1.DeviceConfig, modules []kmmv1beta1.Module, version string) (Revision, error) {
+	hash, err := GetModprobeConfigHash(cr)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	rev := Revision{Version: version, Label: instance.LabelValue(version)}
+	taken := false
+	for i := range modules {
+		m := &modules[i]
+		if !m.DeletionTimestamp.IsZero() || GetModuleVersion(m, cr) != version {
+			continue
+		}
+
+		h, err := GetModuleModprobeHash(m)
+		if err != nil {
+			return Revision{}, err
+		}
+		if h == hash {
+			return GetModuleRevision(m, cr), nil
+		}
+
+		taken = taken || GetModuleRevision(m, cr).Label == rev.Label
+	}
+
+	if taken {
+		rev.Label = instance.LabelValue(fmt.Sprintf("%s-%s", version, hash[:8]))
+	}
+
+	return rev, nil
+}
+
+// ListModules returns the Modules of cr, one per driver version deployed.
+func ListModules(ctx context.Context, c client.Client, cr *hlaiv1beta1.DeviceConfig) ([]kmmv1beta1.Module, error) {
+	moduleList := &kmmv1beta1.ModuleList{}
+	if err := c.List(ctx, moduleList, client.InNamespace(cr.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list Modules: %w", err)
+	}
+
+	modules := []kmmv1beta1.Module{}
+	for _, m := range moduleList.Items {
+		if metav1.IsControlledBy(&m, cr) && IsModuleName(cr, m.Name) {
+			modules = append(modules, m)
+		}
+	}
+
+	return modules, nil
+}
+
+// GetModuleNames returns the names of modules, e.g. to select their pods or
+// Jobs by KMMModuleNameLabel.
+func GetModuleNames(modules []kmmv1beta1.Module) []string {
+	names := make([]string, 0, len(modules))
+	for _, m := range modules {
+		names = append(names, m.Name)
+	}
+
+	return names
+}
+
+// ListPods returns the pods KMM runs with role for modules, the Modules of cr.
+// An empty role selects the pods of all the roles. The manager cache only
+// holds the operand pods, so that c is an uncached reader.
+func ListPods(ctx context.Context, c client.Reader, cr *hlaiv1beta1.DeviceConfig, modules []kmmv1beta1.Module, role string) ([]corev1.Pod, error) {
+	if len(modules) == 0 {
+		return nil, nil
+	}
+
+	selector := labels.NewSelector()
+	req, err := labels.NewRequirement(KMMModuleNameLabel, selection.In, GetModuleNames(modules))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Module names: %w", err)
+	}
+	selector = selector.Add(*req)
+
+	if role != "" {
+		req, err := labels.NewRequirement(KMMRoleLabel, selection.Equals, []string{role})
+		if err != nil {
+			return nil, fmt.Errorf("invalid KMM role %s: %w", role, err)
+		}
+		selector = selector.Add(*req)
+	}
+
+	podList := &corev1.PodList{}
+	opts := []client.ListOption{
+		client.InNamespace(cr.Namespace),
+		client.MatchingLabelsSelector{Selector: selector},
+	}
+	if err := c.List(ctx, podList, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list KMM pods: %w", err)
+	}
+
+	return podList.Items, nil
+}
+
+// GetDockerfileConfigMapName returns the name of the ConfigMap holding the
+// Dockerfile of the driver image builds not specifying one.
+func GetDockerfileConfigMapName(cr *hlaiv1beta1.DeviceConfig) string {
+	return fmt.Sprintf("%s-%s", cr.Name, dockerfileSuffix)
+}
+
+// ReconcileModules deploys a Module for each driver revision of revisions, on
+// the nodes labelled with it, and deletes the Modules of the other revisions.
+// A Module keeps its name while its revision is deployed, so that its pods
+// are not restarted. The Modules of a previous modprobe configuration are
+// kept as they are until their nodes are moved to the current one.
+func (r *moduleReconciler) ReconcileModules(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, revisions []Revision) error {
 	logger := log.FromContext(ctx)
 
-	existingModule := &kmmv1beta1.Module{}
-	err := r.client.Get(ctx, types.NamespacedName{
-		Namespace: cr.Namespace,
-		Name:      GetModuleName(cr),
-	}, existingModule)
-	exists := !apierrors.IsNotFound(err)
-	if err != nil && !apierrors.IsNotFound(err) {
+	// The Dockerfile must exist before KMM starts a build.
+	if err := r.reconcileDockerfileConfigMap(ctx, cr); err != nil {
 		return err
 	}
 
-	m := &kmmv1beta1.Module{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      GetModuleName(cr),
-			Namespace: cr.ObjectMeta.Namespace,
-		},
+	modules, err := ListModules(ctx, r.client, cr)
+	if err != nil {
+		return err
+	}
+
+	hash, err := GetModprobeConfigHash(cr)
+	if err != nil {
+		return err
 	}
 
-	if exists {
-		m = existingModule
+	wanted := make(map[string]bool, len(revisions))
+	for _, rev := range revisions {
+		wanted[rev.Label] = true
 	}
 
-	res, err := controllerutil.CreateOrPatch(ctx, r.client, m, func() error {
-		return r.SetDesiredModule(m, cr)
-	})
+	// The names of the Modules being deleted are not reused until KMM is
+	// done with them.
+	taken := map[string]bool{}
+	byLabel := map[string]*kmmv1beta1.Module{}
+	for i := range modules {
+		m := &modules[i]
+		taken[m.Name] = true
+
+		if !m.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		rev := GetModuleRevision(m, cr)
+		if wanted[rev.Label] && byLabel[rev.Label] == nil {
+			byLabel[rev.Label] = m
+			continue
+		}
+
+		if err := r.client.Delete(ctx, m); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete Module %s: %w", m.Name, err)
+		}
+		logger.Info("Deleted Module", "resource", m.Name, "version", rev.Version, "revision", rev.Label)
+	}
+
+	slot := 0
+	for _, rev := range revisions {
+		m, ok := byLabel[rev.Label]
+		current := !ok
+		if ok {
+			h, err := GetModuleModprobeHash(m)
+			if err != nil {
+				return err
+			}
+			current = h == hash
+		} else {
+			for taken[getModuleSlotName(cr, slot)] {
+				slot++
+			}
+			taken[getModuleSlotName(cr, slot)] = true
+
+			m = &kmmv1beta1.Module{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      getModuleSlotName(cr, slot),
+					Namespace: cr.Namespace,
+				},
+			}
+		}
+
+		desired := cr.DeepCopy()
+		desired.Spec.Driver.Version = rev.Version
+
+		res, err := controllerutil.CreateOrPatch(ctx, r.client, m, func() error {
+			if current {
+				if err := r.SetDesiredModule(m, desired); err != nil {
+					return err
+				}
+			}
+			if m.Spec.Selector == nil {
+				m.Spec.Selector = map[string]string{}
+			}
+			m.Spec.Selector[NodeVersionLabel] = rev.Label
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("could not create or patch Module: %v", err)
+		}
+
+		logger.Info("Reconciled Module", "resource", m.Name, "version", rev.Version, "revision", rev.Label, "result", res)
+	}
+
+	return nil
+}
+
+// DeleteModules deletes all the Modules of cr.
+func (r *moduleReconciler) DeleteModules(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	modules, err := ListModules(ctx, r.client, cr)
+	if err != nil {
+		return err
+	}
+
+	for i := range modules {
+		err := r.client.Delete(ctx, &modules[i])
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete Module %s: %w", modules[i].Name, err)
+		}
+	}
+
+	return r.deleteDockerfileConfigMap(ctx, cr)
+}
+
+// DeleteDevicePlugin removes the device plugin from the Modules of cr, so that
+// KMM deletes its pods while the driver stays loaded.
+func (r *moduleReconciler) DeleteDevicePlugin(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	modules, err := ListModules(ctx, r.client, cr)
+	if err != nil {
+		return err
+	}
+
+	for i := range modules {
+		m := &modules[i]
+		if m.Spec.DevicePlugin == nil {
+			continue
+		}
+
+		patch := client.MergeFrom(m.DeepCopy())
+		m.Spec.DevicePlugin = nil
+		if err := r.client.Patch(ctx, m, patch); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to remove the device plugin of Module %s: %w", m.Name, err)
+		}
+		log.FromContext(ctx).Info("Removed device plugin", "resource", m.Name)
+	}
+
+	return nil
+}
+
+// reconcileDockerfileConfigMap creates the Dockerfile ConfigMap while cr
+// builds driver images, and deletes it afterwards.
+func (r *moduleReconciler) reconcileDockerfileConfigMap(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	logger := log.FromContext(ctx)
+
+	if !cr.Spec.Driver.UsesBuild() {
+		return r.deleteDockerfileConfigMap(ctx, cr)
+	}
 
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      GetDockerfileConfigMapName(cr),
+			Namespace: cr.Namespace,
+		},
+	}
+
+	res, err := controllerutil.CreateOrPatch(ctx, r.client, cm, func() error {
+		return r.SetDesiredDockerfileConfigMap(cm, cr)
+	})
 	if err != nil {
-		return fmt.Errorf("could not create or patch Module: %v", err)
+		return fmt.Errorf("could not create or patch ConfigMap: %v", err)
 	}
 
-	logger.Info("Reconciled Module", "resource", m.Name, "result", res)
+	logger.Info("Reconciled ConfigMap", "resource", cm.Name, "result", res)
 
 	return nil
 }
 
-func (r *moduleReconciler) DeleteModule(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
-	m := &kmmv1beta1.Module{
+func (r *moduleReconciler) deleteDockerfileConfigMap(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	cm := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      GetModuleName(cr),
-			Namespace: cr.ObjectMeta.Namespace,
+			Name:      GetDockerfileConfigMapName(cr),
+			Namespace: cr.Namespace,
 		},
 	}
 
-	err := r.client.Delete(ctx, m)
+	err := r.client.Delete(ctx, cm)
 	if err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete Module %s: %w", m.Name, err)
+		return fmt.Errorf("failed to delete ConfigMap %s: %w", cm.Name, err)
 	}
 
 	return nil
 }
 
-func (r *moduleReconciler) SetDesiredModule(m *kmmv1beta1.Module, cr *hlaiv1alpha1.DeviceConfig) error {
+func (r *moduleReconciler) SetDesiredDockerfileConfigMap(cm *corev1.ConfigMap, cr *hlaiv1beta1.DeviceConfig) error {
+	if cm == nil {
+		return errors.New("configmap cannot be nil")
+	}
+
+	instance.SetLabels(cm, cr, dockerfileSuffix)
+
+	cm.Data = map[string]string{dockerfileKey: driverDockerfile}
+
+	return ctrl.SetControllerReference(cr, cm, r.scheme)
+}
+
+func (r *moduleReconciler) SetDesiredModule(m *kmmv1beta1.Module, cr *hlaiv1beta1.DeviceConfig) error {
 	if m == nil {
 		return errors.New("module cannot be nil")
 	}
 
-	deviceType := "gaudi"
-	devicePlugin := r.makeDevicePlugin(cr, deviceType)
+	devicePlugin := r.makeDevicePlugin(cr)
 	ModuleLoader := r.makeModuleLoader(cr)
-	selector := cr.GetNodeSelector(deviceType)
+	// All the selected nodes are labelled with the target label, see
+	// nodetargets, and with their driver version.
+	selector := map[string]string{
+		nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(cr),
+		NodeVersionLabel:        instance.LabelValue(cr.Spec.Driver.Version),
+	}
+
+	hash, err := GetModprobeConfigHash(cr)
+	if err != nil {
+		return err
+	}
+
+	instance.SetLabels(m, cr, moduleSuffix)
+	metav1.SetMetaDataAnnotation(&m.ObjectMeta, DriverVersionAnnotation, cr.Spec.Driver.Version)
+	metav1.SetMetaDataAnnotation(&m.ObjectMeta, DriverModprobeAnnotation, hash)
 
 	m.Spec = kmmv1beta1.ModuleSpec{
-		DevicePlugin: &devicePlugin,
-		ModuleLoader: ModuleLoader,
-		Selector:     selector,
+		DevicePlugin:    &devicePlugin,
+		ModuleLoader:    ModuleLoader,
+		Selector:        selector,
+		ImageRepoSecret: cr.Spec.Driver.ImageRepoSecret.DeepCopy(),
 	}
 
 	if err := ctrl.SetControllerReference(cr, m, r.scheme); err != nil {
@@ -146,33 +538,38 @@ func (r *moduleReconciler) SetDesiredModule(m *kmmv1beta1.Module, cr *hlaiv1alph
 	return nil
 }
 
-func (r *moduleReconciler) makeModuleLoader(cr *hlaiv1alpha1.DeviceConfig) kmmv1beta1.ModuleLoaderSpec {
+func (r *moduleReconciler) makeModuleLoader(cr *hlaiv1beta1.DeviceConfig) kmmv1beta1.ModuleLoaderSpec {
 	moduleLoader := kmmv1beta1.ModuleLoaderSpec{
 		Container: kmmv1beta1.ModuleLoaderContainerSpec{
 			ImagePullPolicy: corev1.PullAlways,
 			KernelMappings:  r.makeKernelMappings(cr),
-			Modprobe: kmmv1beta1.ModprobeSpec{
-				ModuleName:   "habanalabs",
-				FirmwarePath: "/opt/lib/firmware",
-			},
+			Modprobe:        makeModprobe(cr),
 		},
 		ServiceAccountName: driverServiceAccount,
 	}
 
+	if cr.Spec.Driver.Build != nil {
+		moduleLoader.Container.Build = makeBuild(cr, *cr.Spec.Driver.Build)
+	}
+
+	if cr.Spec.Driver.Sign != nil {
+		moduleLoader.Container.Sign = makeSign(cr, *cr.Spec.Driver.Sign)
+	}
+
 	return moduleLoader
 }
 
-func (r *moduleReconciler) makeDevicePlugin(cr *hlaiv1alpha1.DeviceConfig, deviceType string) kmmv1beta1.DevicePluginSpec {
+func (r *moduleReconciler) makeDevicePlugin(cr *hlaiv1beta1.DeviceConfig) kmmv1beta1.DevicePluginSpec {
 	devicePlugin := kmmv1beta1.DevicePluginSpec{
 		Container: kmmv1beta1.DevicePluginContainerSpec{
 			Args: []string{
 				"--dev_type",
-				deviceType,
+				string(cr.GetDeviceType()),
 			},
 			Command: []string{
 				"habanalabs-device-plugin",
 			},
-			Image:           s.Settings.DevicePluginImage,
+			Image:           GetDevicePluginImage(cr),
 			ImagePullPolicy: corev1.PullAlways,
 			Resources: corev1.ResourceRequirements{
 				Limits: corev1.ResourceList{
@@ -191,13 +588,257 @@ func (r *moduleReconciler) makeDevicePlugin(cr *hlaiv1alpha1.DeviceConfig, devic
 	return devicePlugin
 }
 
-func (r *moduleReconciler) makeKernelMappings(cr *hlaiv1alpha1.DeviceConfig) []kmmv1beta1.KernelMapping {
-	kernelMappings := []kmmv1beta1.KernelMapping{
-		{
-			ContainerImage: fmt.Sprintf("%s:%s-${KERNEL_FULL_VERSION}", cr.Spec.DriverImage, cr.Spec.DriverVersion),
-			Regexp:         `^.*\.el\d_?\d?\..*$`,
-		},
+func (r *moduleReconciler) makeKernelMappings(cr *hlaiv1beta1.DeviceConfig) []kmmv1beta1.KernelMapping {
+	driverImage := newDriverImageReplacer(cr)
+
+	mappings := cr.Spec.Driver.GetKernelMappings()
+	kernelMappings := make([]kmmv1beta1.KernelMapping, 0, len(mappings))
+	for _, m := range mappings {
+		km := kmmv1beta1.KernelMapping{
+			ContainerImage: driverImage.Replace(getContainerImageTemplate(cr, m)),
+			Literal:        m.Literal,
+			Regexp:         m.GetRegexp(),
+		}
+
+		if m.RegistryTLS != nil {
+			km.RegistryTLS = makeTLSOptions(*m.RegistryTLS)
+		}
+
+		if m.Build != nil {
+			km.Build = makeBuild(cr, *m.Build)
+		}
+
+		kernelMappings = append(kernelMappings, km)
 	}
 
 	return kernelMappings
 }
+
+func makeTLSOptions(tls hlaiv1beta1.RegistryTLS) *kmmv1beta1.TLSOptions {
+	return &kmmv1beta1.TLSOptions{
+		Insecure:              tls.Insecure,
+		InsecureSkipTLSVerify: tls.InsecureSkipTLSVerify,
+	}
+}
+
+// makeBuild returns the KMM build of b, using the operator Dockerfile unless
+// b specifies one. KMM replaces the Dockerfile of the Module build by the one
+// of a kernel mapping build, so that both need to be set.
+func makeBuild(cr *hlaiv1beta1.DeviceConfig, b hlaiv1beta1.DriverBuildSpec) *kmmv1beta1.Build {
+	build := &kmmv1beta1.Build{
+		BaseImageRegistryTLS: *makeTLSOptions(b.BaseImageRegistryTLS),
+		BuildArgs:            []kmmv1beta1.BuildArg{{Name: driverVersionBuildArg, Value: cr.Spec.Driver.Version}},
+		DockerfileConfigMap:  &corev1.LocalObjectReference{Name: GetDockerfileConfigMapName(cr)},
+		Secrets:              append([]corev1.LocalObjectReference(nil), b.Secrets...),
+	}
+
+	if b.DockerfileConfigMap != nil {
+		build.DockerfileConfigMap = &corev1.LocalObjectReference{Name: b.DockerfileConfigMap.Name}
+	}
+
+	// The default Dockerfile declares the companion modules in a softdep of
+	// the habanalabs module, see makeModprobe.
+	if modules := cr.Spec.Driver.GetCompanionModules(); len(modules) > 0 {
+		build.BuildArgs = append(build.BuildArgs, kmmv1beta1.BuildArg{Name: companionModulesBuildArg, Value: strings.Join(modules, " ")})
+	}
+
+	defaults := len(build.BuildArgs)
+	for _, a := range b.BuildArgs {
+		overridden := false
+		for i := range build.BuildArgs[:defaults] {
+			if build.BuildArgs[i].Name == a.Name {
+				build.BuildArgs[i].Value = a.Value
+				overridden = true
+			}
+		}
+		if !overridden {
+			build.BuildArgs = append(build.BuildArgs, kmmv1beta1.BuildArg{Name: a.Name, Value: a.Value})
+		}
+	}
+
+	return build
+}
+
+// makeModprobe returns the KMM modprobe configuration of the driver. KMM
+// loads a single module, with its parameters, so the companion modules are
+// declared in a modprobe.d file of the driver images instead, as a softdep
+// of the habanalabs module:
+//
+//	softdep habanalabs post: habanalabs_cn habanalabs_en habanalabs_ib
+//
+// modprobe then loads them in that order after habanalabs, and unloads them
+// in reverse order before it, whatever the modprobe arguments.
+func makeModprobe(cr *hlaiv1beta1.DeviceConfig) kmmv1beta1.ModprobeSpec {
+	modprobe := kmmv1beta1.ModprobeSpec{
+		ModuleName:   hlaiv1beta1.DriverModule,
+		DirName:      defaultModprobeDirName,
+		FirmwarePath: defaultModprobeFirmwarePath,
+	}
+
+	mp := cr.Spec.Driver.Modprobe
+	if mp != nil {
+		modprobe.Parameters = append([]string(nil), mp.Parameters...)
+		modprobe.Args = makeModprobeArgs(mp.Args)
+		modprobe.RawArgs = makeModprobeArgs(mp.RawArgs)
+
+		if mp.DirName != "" {
+			modprobe.DirName = mp.DirName
+		}
+
+		if mp.FirmwarePath != "" {
+			modprobe.FirmwarePath = mp.FirmwarePath
+		}
+	}
+
+	return modprobe
+}
+
+func makeModprobeArgs(args *hlaiv1beta1.ModprobeArgs) *kmmv1beta1.ModprobeArgs {
+	if args == nil {
+		return nil
+	}
+
+	return &kmmv1beta1.ModprobeArgs{
+		Load:   append([]string(nil), args.Load...),
+		Unload: append([]string(nil), args.Unload...),
+	}
+}
+
+// GetModprobeConfigHash returns a hash of the modprobe configuration of the
+// driver of cr, and of its companion modules, which changes whenever the
+// driver needs to be reloaded.
+func GetModprobeConfigHash(cr *hlaiv1beta1.DeviceConfig) (string, error) {
+	return hashModprobe(makeModprobe(cr), cr.Spec.Driver.GetCompanionModules())
+}
+
+// hashModprobe returns a hash of modprobe and companions. Without companion
+// modules, only modprobe is hashed, like for the Modules created before they
+// were supported.
+func hashModprobe(modprobe kmmv1beta1.ModprobeSpec, companions []string) (string, error) {
+	var config interface{} = modprobe
+	if len(companions) > 0 {
+		config = struct {
+			Modprobe         kmmv1beta1.ModprobeSpec `json:"modprobe"`
+			CompanionModules []string                `json:"companionModules"`
+		}{modprobe, companions}
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal the modprobe configuration: %w", err)
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(b))[:16], nil
+}
+
+// SetModprobeConfigStatus records the modprobe configuration hash of applied,
+// the DeviceConfig the Modules of cr were reconciled with, in the status of
+// cr, along with the time it changed, after which the driver pods loaded with
+// the previous configuration are pending a reload by their node upgrade. It
+// returns true if a previous configuration changed.
+func SetModprobeConfigStatus(cr, applied *hlaiv1beta1.DeviceConfig) (bool, error) {
+	hash, err := GetModprobeConfigHash(applied)
+	if err != nil {
+		return false, err
+	}
+
+	if cr.Status.ModprobeConfigHash == hash {
+		return false, nil
+	}
+
+	changed := cr.Status.ModprobeConfigHash != ""
+	if changed {
+		now := metav1.Now()
+		cr.Status.ModprobeConfigUpdateTime = &now
+	}
+	cr.Status.ModprobeConfigHash = hash
+
+	return changed, nil
+}
+
+// makeSign returns the KMM signing of sg, signing the driver kernel modules
+// unless sg names the files to sign.
+func makeSign(cr *hlaiv1beta1.DeviceConfig, sg hlaiv1beta1.DriverSignSpec) *kmmv1beta1.Sign {
+	sign := &kmmv1beta1.Sign{
+		UnsignedImageRegistryTLS: *makeTLSOptions(sg.UnsignedImageRegistryTLS),
+		KeySecret:                &corev1.LocalObjectReference{Name: sg.KeySecret.Name},
+		CertSecret:               &corev1.LocalObjectReference{Name: sg.CertSecret.Name},
+		FilesToSign:              append([]string(nil), sg.FilesToSign...),
+	}
+
+	if sg.UnsignedImage != "" {
+		sign.UnsignedImage = newDriverImageReplacer(cr).Replace(sg.UnsignedImage)
+	}
+
+	if len(sign.FilesToSign) == 0 {
+		for _, m := range cr.Spec.Driver.GetModules() {
+			sign.FilesToSign = append(sign.FilesToSign, fmt.Sprintf(driverModulePathTemplate, m))
+		}
+	}
+
+	return sign
+}
+
+// newDriverImageReplacer replaces the operator variables of the driver image
+// templates, leaving the kernel variables to KMM.
+func newDriverImageReplacer(cr *hlaiv1beta1.DeviceConfig) *strings.Replacer {
+	return strings.NewReplacer(
+		"${DRIVER_IMAGE}", GetDriverImage(cr),
+		"${DRIVER_VERSION}", cr.Spec.Driver.Version,
+	)
+}
+
+// getContainerImageTemplate returns the driver image template of m. Its tag
+// is suffixed with the companion modules of cr, which are declared in the
+// built images, so that enabling others builds new images.
+func getContainerImageTemplate(cr *hlaiv1beta1.DeviceConfig, m hlaiv1beta1.KernelMapping) string {
+	image := m.ContainerImage
+	if image == "" {
+		image = defaultDriverImageTemplate
+	}
+
+	for _, c := range cr.Spec.Driver.GetCompanionModules() {
+		image += "-" + strings.TrimPrefix(c, hlaiv1beta1.DriverModule+"_")
+	}
+
+	return image
+}
+
+// GetKernelDriverImage returns the driver image of the kernel mapping m of cr
+// on kernel, replacing the kernel variables like KMM does.
+func GetKernelDriverImage(cr *hlaiv1beta1.DeviceConfig, m hlaiv1beta1.KernelMapping, kernel string) string {
+	x, y, z := "", "", ""
+	if parts := kernelVersionRegexp.FindStringSubmatch(kernel); parts != nil {
+		x, y, z = parts[1], parts[2], parts[3]
+	}
+
+	kernelReplacer := strings.NewReplacer(
+		"${KERNEL_FULL_VERSION}", kernel,
+		"${KERNEL_XYZ}", fmt.Sprintf("%s.%s.%s", x, y, z),
+		"${KERNEL_X}", x,
+		"${KERNEL_Y}", y,
+		"${KERNEL_Z}", z,
+	)
+
+	return kernelReplacer.Replace(newDriverImageReplacer(cr).Replace(getContainerImageTemplate(cr, m)))
+}
+
+// GetDriverImage returns the DeviceConfig driver image, falling back to the
+// operator default for DeviceConfigs admitted without the defaulting webhook.
+func GetDriverImage(cr *hlaiv1beta1.DeviceConfig) string {
+	if cr.Spec.Driver.Image != "" {
+		return cr.Spec.Driver.Image
+	}
+
+	return s.Settings.DriverHabanaImageBasename
+}
+
+// GetDevicePluginImage returns the DeviceConfig device plugin image, falling
+// back to the operator default.
+func GetDevicePluginImage(cr *hlaiv1beta1.DeviceConfig) string {
+	if cr.Spec.DevicePlugin.Image != "" {
+		return cr.Spec.DevicePlugin.Image
+	}
+
+	return s.Settings.DevicePluginImage
+}
@@ -23,34 +23,53 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	mockClient "github.com/HabanaAI/habana-ai-operator/internal/client"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	s "github.com/HabanaAI/habana-ai-operator/internal/settings"
 	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
 )
 
 const (
-	testDriverImage   = "driver"
-	testDriverVersion = "test"
+	testDriverImage         = "driver"
+	testDriverImageBasename = "registry.example.com/habana-ai-driver"
+	testDriverVersion       = "test"
 
 	testLabelKey   = "habana.ai/hpu.gaudi.present"
 	testLabelValue = "true"
 )
 
+// getRevisions returns the revisions of versions with an unchanged modprobe
+// configuration.
+func getRevisions(versions ...string) []Revision {
+	revisions := make([]Revision, 0, len(versions))
+	for _, v := range versions {
+		revisions = append(revisions, Revision{Version: v, Label: instance.LabelValue(v)})
+	}
+
+	return revisions
+}
+
 var _ = Describe("ModuleReconciler", func() {
 	var (
-		dc  *hlaiv1alpha1.DeviceConfig
+		dc  *hlaiv1beta1.DeviceConfig
 		r   *moduleReconciler
 		c   *mockClient.MockClient
 		ctx context.Context
 	)
 
 	BeforeEach(func() {
-		dc = &hlaiv1alpha1.DeviceConfig{
+		dc = &hlaiv1beta1.DeviceConfig{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "a-device-config",
 				Namespace: "a-namespace",
@@ -59,100 +78,208 @@ var _ = Describe("ModuleReconciler", func() {
 		c = mockClient.NewMockClient(gomock.NewController(GinkgoT()))
 
 		s := scheme.Scheme
-		Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+		Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 		Expect(kmmv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 		r = NewReconciler(c, s)
 
 		ctx = context.TODO()
 	})
 
-	Describe("ReconcileModule", func() {
-		Context("with no client Get error", func() {
-			BeforeEach(func() {
-				gomock.InOrder(
-					c.EXPECT().
-						Get(ctx, gomock.Any(), gomock.Any()).
-						Return(apierrors.NewNotFound(schema.GroupResource{Resource: "modules"}, GetModuleName(dc))).
-						AnyTimes(),
-				)
-			})
+	Describe("ReconcileModules", func() {
+		var fc client.Client
 
-			Context("with no client Create error", func() {
-				BeforeEach(func() {
-					gomock.InOrder(
-						c.EXPECT().Create(ctx, gomock.Any()).Return(nil),
-					)
-				})
-				It("should not return an error", func() {
-					Expect(r.ReconcileModule(ctx, dc)).ToNot(HaveOccurred())
-				})
-			})
+		BeforeEach(func() {
+			dc.UID = "a-uid"
+			dc.Spec.Driver.Version = testDriverVersion
+			fc = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(dc).Build()
+			r = NewReconciler(fc, scheme.Scheme)
+		})
 
-			Context("with client Create error", func() {
-				BeforeEach(func() {
-					gomock.InOrder(
-						c.EXPECT().Create(ctx, gomock.Any()).Return(errors.New("some-error")),
-					)
-				})
-				It("should return an error", func() {
-					Expect(r.ReconcileModule(ctx, dc)).To(HaveOccurred())
-				})
-			})
+		getVersions := func() map[string]string {
+			modules, err := ListModules(ctx, fc, dc)
+			Expect(err).ToNot(HaveOccurred())
+
+			versions := map[string]string{}
+			for i := range modules {
+				versions[modules[i].Name] = GetModuleVersion(&modules[i], dc)
+			}
+			return versions
+		}
+
+		It("should create a Module selecting the nodes of the driver version", func() {
+			Expect(r.ReconcileModules(ctx, dc, getRevisions(testDriverVersion))).To(Succeed())
+
+			m := &kmmv1beta1.Module{}
+			Expect(fc.Get(ctx, types.NamespacedName{Namespace: dc.Namespace, Name: GetModuleName(dc)}, m)).To(Succeed())
+			Expect(m.Spec.Selector).To(HaveKeyWithValue(NodeVersionLabel, testDriverVersion))
+			Expect(m.Annotations).To(HaveKeyWithValue(DriverVersionAnnotation, testDriverVersion))
 		})
 
-		Context("with client Get error", func() {
-			BeforeEach(func() {
-				gomock.InOrder(
-					c.EXPECT().Get(ctx, gomock.Any(), gomock.Any()).Return(errors.New("some-other-that-not-found-error")),
-				)
-			})
+		It("should keep the Module of a version deployed along with a new one, then delete it", func() {
+			Expect(r.ReconcileModules(ctx, dc, getRevisions("1.0.0"))).To(Succeed())
+			Expect(r.ReconcileModules(ctx, dc, getRevisions(testDriverVersion, "1.0.0"))).To(Succeed())
+			Expect(getVersions()).To(Equal(map[string]string{
+				GetModuleName(dc):        "1.0.0",
+				GetModuleName(dc) + "-1": testDriverVersion,
+			}))
+
+			Expect(r.ReconcileModules(ctx, dc, getRevisions(testDriverVersion))).To(Succeed())
+			Expect(getVersions()).To(Equal(map[string]string{
+				GetModuleName(dc) + "-1": testDriverVersion,
+			}))
+		})
 
-			It("should return an error", func() {
-				Expect(r.ReconcileModule(ctx, dc)).To(HaveOccurred())
-			})
+		It("should keep the Module of a previous modprobe configuration as it is until its nodes are moved", func() {
+			Expect(r.ReconcileModules(ctx, dc, getRevisions(testDriverVersion))).To(Succeed())
+			previous := getRevisions(testDriverVersion)[0]
+
+			dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{Parameters: []string{"timeout_locked=30"}}
+			modules, err := ListModules(ctx, fc, dc)
+			Expect(err).ToNot(HaveOccurred())
+			rev, err := GetRevision(dc, modules, testDriverVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rev.Version).To(Equal(testDriverVersion))
+			Expect(rev.Label).ToNot(Equal(previous.Label))
+
+			Expect(r.ReconcileModules(ctx, dc, []Revision{rev, previous})).To(Succeed())
+			m := &kmmv1beta1.Module{}
+			Expect(fc.Get(ctx, types.NamespacedName{Namespace: dc.Namespace, Name: GetModuleName(dc)}, m)).To(Succeed())
+			Expect(m.Spec.Selector).To(HaveKeyWithValue(NodeVersionLabel, previous.Label))
+			Expect(m.Spec.ModuleLoader.Container.Modprobe.Parameters).To(BeEmpty())
+			Expect(fc.Get(ctx, types.NamespacedName{Namespace: dc.Namespace, Name: GetModuleName(dc) + "-1"}, m)).To(Succeed())
+			Expect(m.Spec.Selector).To(HaveKeyWithValue(NodeVersionLabel, rev.Label))
+			Expect(m.Spec.ModuleLoader.Container.Modprobe.Parameters).To(Equal([]string{"timeout_locked=30"}))
+
+			Expect(r.ReconcileModules(ctx, dc, []Revision{rev})).To(Succeed())
+			Expect(getVersions()).To(Equal(map[string]string{
+				GetModuleName(dc) + "-1": testDriverVersion,
+			}))
 		})
-	})
 
-	Describe("DeleteModule", func() {
-		Context("without a client Delete error", func() {
-			BeforeEach(func() {
-				gomock.InOrder(
-					c.EXPECT().Delete(ctx, gomock.Any()).Return(nil),
-				)
-			})
+		It("should not reuse the name of a Module being deleted", func() {
+			now := metav1.Now()
+			m := &kmmv1beta1.Module{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              GetModuleName(dc),
+					Namespace:         dc.Namespace,
+					Annotations:       map[string]string{DriverVersionAnnotation: "1.0.0"},
+					DeletionTimestamp: &now,
+					Finalizers:        []string{"a-finalizer"},
+				},
+			}
+			Expect(ctrl.SetControllerReference(dc, m, scheme.Scheme)).To(Succeed())
+			fc = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(dc, m).Build()
+			r = NewReconciler(fc, scheme.Scheme)
+
+			Expect(r.ReconcileModules(ctx, dc, getRevisions(testDriverVersion))).To(Succeed())
+			Expect(getVersions()).To(Equal(map[string]string{
+				GetModuleName(dc):        "1.0.0",
+				GetModuleName(dc) + "-1": testDriverVersion,
+			}))
+		})
 
-			It("should not return an error", func() {
-				Expect(r.DeleteModule(ctx, dc)).ToNot(HaveOccurred())
-			})
+		It("should leave the preflight candidate Module alone", func() {
+			m := &kmmv1beta1.Module{
+				ObjectMeta: metav1.ObjectMeta{Name: GetModuleName(dc) + "-preflight", Namespace: dc.Namespace},
+			}
+			Expect(ctrl.SetControllerReference(dc, m, scheme.Scheme)).To(Succeed())
+			Expect(fc.Create(ctx, m)).To(Succeed())
+
+			Expect(r.ReconcileModules(ctx, dc, getRevisions(testDriverVersion))).To(Succeed())
+			Expect(fc.Get(ctx, client.ObjectKeyFromObject(m), m)).To(Succeed())
 		})
 
-		Context("with a NotFound client Delete error", func() {
-			BeforeEach(func() {
+		It("should create the Dockerfile ConfigMap, and delete it once the build is removed", func() {
+			dc.Spec.Driver.Build = &hlaiv1beta1.DriverBuildSpec{}
+
+			Expect(r.ReconcileModules(ctx, dc, getRevisions(testDriverVersion))).To(Succeed())
+
+			cm := &corev1.ConfigMap{}
+			key := types.NamespacedName{Namespace: dc.Namespace, Name: GetDockerfileConfigMapName(dc)}
+			Expect(fc.Get(ctx, key, cm)).To(Succeed())
+			Expect(cm.Data).To(HaveKeyWithValue("dockerfile", ContainSubstring("habanalabs")))
+
+			dc.Spec.Driver.Build = nil
+			Expect(r.ReconcileModules(ctx, dc, getRevisions(testDriverVersion))).To(Succeed())
+
+			err := fc.Get(ctx, key, cm)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		Context("with a client List error", func() {
+			It("should return an error", func() {
 				gomock.InOrder(
 					c.EXPECT().
 						Delete(ctx, gomock.Any()).
-						Return(apierrors.NewNotFound(schema.GroupResource{Resource: "modules"}, GetModuleName(dc))),
+						Return(apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, GetDockerfileConfigMapName(dc))),
+					c.EXPECT().List(ctx, gomock.Any(), gomock.Any()).Return(errors.New("some-error")),
 				)
-			})
+				r = NewReconciler(c, scheme.Scheme)
 
-			It("should not return an error", func() {
-				Expect(r.DeleteModule(ctx, dc)).ToNot(HaveOccurred())
+				Expect(r.ReconcileModules(ctx, dc, getRevisions(testDriverVersion))).To(HaveOccurred())
 			})
 		})
+	})
+
+	Describe("DeleteModules", func() {
+		It("should delete the Modules and the Dockerfile ConfigMap", func() {
+			dc.UID = "a-uid"
+			dc.Spec.Driver.Build = &hlaiv1beta1.DriverBuildSpec{}
+			fc := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(dc).Build()
+			r = NewReconciler(fc, scheme.Scheme)
+
+			Expect(r.ReconcileModules(ctx, dc, getRevisions("1.0.0", testDriverVersion))).To(Succeed())
+			Expect(r.DeleteModules(ctx, dc)).To(Succeed())
+
+			modules, err := ListModules(ctx, fc, dc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(modules).To(BeEmpty())
+
+			err = fc.Get(ctx, types.NamespacedName{Namespace: dc.Namespace, Name: GetDockerfileConfigMapName(dc)}, &corev1.ConfigMap{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
 
 		Context("with a generic client Delete error", func() {
 			BeforeEach(func() {
+				m := kmmv1beta1.Module{ObjectMeta: metav1.ObjectMeta{Name: GetModuleName(dc), Namespace: dc.Namespace}}
+				Expect(ctrl.SetControllerReference(dc, &m, scheme.Scheme)).To(Succeed())
+
 				gomock.InOrder(
+					c.EXPECT().List(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+						func(_ context.Context, list *kmmv1beta1.ModuleList, _ ...client.ListOption) error {
+							list.Items = []kmmv1beta1.Module{m}
+							return nil
+						},
+					),
 					c.EXPECT().Delete(ctx, gomock.Any()).Return(errors.New("some-error")),
 				)
 			})
 
 			It("should return an error", func() {
-				Expect(r.DeleteModule(ctx, dc)).To(HaveOccurred())
+				Expect(r.DeleteModules(ctx, dc)).To(HaveOccurred())
 			})
 		})
 	})
 
+	Describe("DeleteDevicePlugin", func() {
+		It("should remove the device plugin from the Modules", func() {
+			dc.UID = "a-uid"
+			fc := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(dc).Build()
+			r = NewReconciler(fc, scheme.Scheme)
+
+			Expect(r.ReconcileModules(ctx, dc, getRevisions("1.0.0", testDriverVersion))).To(Succeed())
+			Expect(r.DeleteDevicePlugin(ctx, dc)).To(Succeed())
+
+			modules, err := ListModules(ctx, fc, dc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(modules).To(HaveLen(2))
+			for _, m := range modules {
+				Expect(m.Spec.DevicePlugin).To(BeNil())
+				Expect(m.Spec.ModuleLoader.Container.Modprobe.ModuleName).ToNot(BeEmpty())
+			}
+		})
+	})
+
 	Describe("SetDesiredModule", func() {
 		var (
 			m *kmmv1beta1.Module
@@ -171,11 +298,270 @@ var _ = Describe("ModuleReconciler", func() {
 			})
 		})
 
+		Context("with a DeviceConfig without driver image", func() {
+			BeforeEach(func() {
+				s.Settings.DriverHabanaImageBasename = testDriverImageBasename
+				DeferCleanup(func() {
+					s.Settings.DriverHabanaImageBasename = ""
+				})
+
+				dc.Spec.Driver.Version = testDriverVersion
+
+				m = &kmmv1beta1.Module{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "a-name",
+						Namespace: "a-namespace",
+					},
+				}
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+			})
+
+			It("should use the operator default driver image", func() {
+				Expect(m.Spec.ModuleLoader.Container.KernelMappings).ToNot(BeEmpty())
+				expectedImage := fmt.Sprintf("%s:%s-${KERNEL_FULL_VERSION}", testDriverImageBasename, testDriverVersion)
+				for _, km := range m.Spec.ModuleLoader.Container.KernelMappings {
+					Expect(km.ContainerImage).To(Equal(expectedImage))
+				}
+			})
+		})
+
+		Context("with a DeviceConfig overriding the device plugin image", func() {
+			It("should use the DeviceConfig image", func() {
+				dc.Spec.Driver.Version = testDriverVersion
+				dc.Spec.DevicePlugin.Image = "registry.example.com/habana-ai/device-plugin:test"
+
+				m = &kmmv1beta1.Module{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "a-name",
+						Namespace: "a-namespace",
+					},
+				}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+				Expect(m.Spec.DevicePlugin).ToNot(BeNil())
+				Expect(m.Spec.DevicePlugin.Container.Image).To(Equal(dc.Spec.DevicePlugin.Image))
+			})
+		})
+
+		Context("with kernel mappings", func() {
+			It("should map them to KMM kernel mappings", func() {
+				dc.Spec.Driver.Image = testDriverImage
+				dc.Spec.Driver.Version = testDriverVersion
+				dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{
+					{
+						Literal:        "5.15.0-76-generic",
+						ContainerImage: "registry.example.com/custom:${DRIVER_VERSION}-ubuntu",
+						RegistryTLS:    &hlaiv1beta1.RegistryTLS{Insecure: true},
+					},
+					{
+						Preset: hlaiv1beta1.KernelMappingPresetUbuntu,
+						Build: &hlaiv1beta1.DriverBuildSpec{
+							DockerfileConfigMap: &corev1.LocalObjectReference{Name: "dockerfile"},
+							BuildArgs:           []hlaiv1beta1.BuildArg{{Name: "KEY", Value: "value"}},
+						},
+					},
+				}
+				m = &kmmv1beta1.Module{ObjectMeta: metav1.ObjectMeta{Name: "a-name", Namespace: dc.Namespace}}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+				Expect(m.Spec.ModuleLoader.Container.KernelMappings).To(Equal([]kmmv1beta1.KernelMapping{
+					{
+						ContainerImage: fmt.Sprintf("registry.example.com/custom:%s-ubuntu", testDriverVersion),
+						Literal:        "5.15.0-76-generic",
+						RegistryTLS:    &kmmv1beta1.TLSOptions{Insecure: true},
+					},
+					{
+						ContainerImage: fmt.Sprintf("%s:%s-${KERNEL_FULL_VERSION}", testDriverImage, testDriverVersion),
+						Regexp:         hlaiv1beta1.KernelMappingPresetRegexps[hlaiv1beta1.KernelMappingPresetUbuntu],
+						Build: &kmmv1beta1.Build{
+							DockerfileConfigMap: &corev1.LocalObjectReference{Name: "dockerfile"},
+							BuildArgs: []kmmv1beta1.BuildArg{
+								{Name: "DRIVER_VERSION", Value: testDriverVersion},
+								{Name: "KEY", Value: "value"},
+							},
+						},
+					},
+				}))
+			})
+		})
+
+		Context("with a driver build", func() {
+			It("should build with the operator Dockerfile and the driver version", func() {
+				dc.Spec.Driver.Version = testDriverVersion
+				dc.Spec.Driver.ImageRepoSecret = &corev1.LocalObjectReference{Name: "registry-credentials"}
+				dc.Spec.Driver.Build = &hlaiv1beta1.DriverBuildSpec{
+					BuildArgs:            []hlaiv1beta1.BuildArg{{Name: "BUILDER_IMAGE", Value: "registry.example.com/builder"}},
+					Secrets:              []corev1.LocalObjectReference{{Name: "source-credentials"}},
+					BaseImageRegistryTLS: hlaiv1beta1.RegistryTLS{InsecureSkipTLSVerify: true},
+				}
+				dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{
+					{
+						Literal: "5.15.0-76-generic",
+						Build: &hlaiv1beta1.DriverBuildSpec{
+							BuildArgs: []hlaiv1beta1.BuildArg{{Name: "DRIVER_VERSION", Value: "patched"}},
+						},
+					},
+				}
+				m = &kmmv1beta1.Module{ObjectMeta: metav1.ObjectMeta{Name: "a-name", Namespace: dc.Namespace}}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+
+				dockerfile := &corev1.LocalObjectReference{Name: GetDockerfileConfigMapName(dc)}
+				Expect(m.Spec.ImageRepoSecret).To(Equal(dc.Spec.Driver.ImageRepoSecret))
+				Expect(m.Spec.ModuleLoader.Container.Build).To(Equal(&kmmv1beta1.Build{
+					BuildArgs: []kmmv1beta1.BuildArg{
+						{Name: "DRIVER_VERSION", Value: testDriverVersion},
+						{Name: "BUILDER_IMAGE", Value: "registry.example.com/builder"},
+					},
+					DockerfileConfigMap:  dockerfile,
+					Secrets:              []corev1.LocalObjectReference{{Name: "source-credentials"}},
+					BaseImageRegistryTLS: kmmv1beta1.TLSOptions{InsecureSkipTLSVerify: true},
+				}))
+				Expect(m.Spec.ModuleLoader.Container.KernelMappings[0].Build).To(Equal(&kmmv1beta1.Build{
+					BuildArgs:           []kmmv1beta1.BuildArg{{Name: "DRIVER_VERSION", Value: "patched"}},
+					DockerfileConfigMap: dockerfile,
+				}))
+			})
+		})
+
+		Context("with driver signing", func() {
+			It("should sign the driver module of the unsigned image", func() {
+				dc.Spec.Driver.Image = "registry.example.com/habanalabs"
+				dc.Spec.Driver.Version = testDriverVersion
+				dc.Spec.Driver.Sign = &hlaiv1beta1.DriverSignSpec{
+					UnsignedImage:            "${DRIVER_IMAGE}:${DRIVER_VERSION}-${KERNEL_FULL_VERSION}-unsigned",
+					UnsignedImageRegistryTLS: hlaiv1beta1.RegistryTLS{Insecure: true},
+					KeySecret:                corev1.LocalObjectReference{Name: "signing-key"},
+					CertSecret:               corev1.LocalObjectReference{Name: "signing-cert"},
+				}
+				m = &kmmv1beta1.Module{ObjectMeta: metav1.ObjectMeta{Name: "a-name", Namespace: dc.Namespace}}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+				Expect(m.Spec.ModuleLoader.Container.Sign).To(Equal(&kmmv1beta1.Sign{
+					UnsignedImage:            "registry.example.com/habanalabs:" + testDriverVersion + "-${KERNEL_FULL_VERSION}-unsigned",
+					UnsignedImageRegistryTLS: kmmv1beta1.TLSOptions{Insecure: true},
+					KeySecret:                &corev1.LocalObjectReference{Name: "signing-key"},
+					CertSecret:               &corev1.LocalObjectReference{Name: "signing-cert"},
+					FilesToSign:              []string{"/opt/lib/modules/${KERNEL_FULL_VERSION}/extra/habanalabs.ko"},
+				}))
+			})
+
+			It("should sign the given files of the built image", func() {
+				dc.Spec.Driver.Build = &hlaiv1beta1.DriverBuildSpec{}
+				dc.Spec.Driver.Sign = &hlaiv1beta1.DriverSignSpec{
+					KeySecret:   corev1.LocalObjectReference{Name: "signing-key"},
+					CertSecret:  corev1.LocalObjectReference{Name: "signing-cert"},
+					FilesToSign: []string{"/opt/lib/modules/${KERNEL_FULL_VERSION}/extra/habanalabs.ko.xz"},
+				}
+				m = &kmmv1beta1.Module{ObjectMeta: metav1.ObjectMeta{Name: "a-name", Namespace: dc.Namespace}}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+				Expect(m.Spec.ModuleLoader.Container.Sign.UnsignedImage).To(BeEmpty())
+				Expect(m.Spec.ModuleLoader.Container.Sign.FilesToSign).To(Equal(dc.Spec.Driver.Sign.FilesToSign))
+			})
+		})
+
+		Context("with a modprobe configuration", func() {
+			It("should load the driver with it", func() {
+				dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{
+					Parameters:   []string{"timeout_locked=30", "reset_on_lockup=0"},
+					Args:         &hlaiv1beta1.ModprobeArgs{Load: []string{"-v", "--first-time"}},
+					FirmwarePath: "/opt/firmware",
+				}
+				m = &kmmv1beta1.Module{ObjectMeta: metav1.ObjectMeta{Name: "a-name", Namespace: dc.Namespace}}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+				Expect(m.Spec.ModuleLoader.Container.Modprobe).To(Equal(kmmv1beta1.ModprobeSpec{
+					ModuleName:   "habanalabs",
+					Parameters:   []string{"timeout_locked=30", "reset_on_lockup=0"},
+					DirName:      "/opt",
+					Args:         &kmmv1beta1.ModprobeArgs{Load: []string{"-v", "--first-time"}},
+					FirmwarePath: "/opt/firmware",
+				}))
+			})
+		})
+
+		Context("with companion modules", func() {
+			BeforeEach(func() {
+				dc.Spec.Driver.CompanionModules = []hlaiv1beta1.CompanionModule{hlaiv1beta1.CompanionModuleIB}
+				m = &kmmv1beta1.Module{ObjectMeta: metav1.ObjectMeta{Name: "a-name", Namespace: dc.Namespace}}
+			})
+
+			It("should keep the modprobe parameters and arguments of the habanalabs module", func() {
+				dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{
+					Parameters: []string{"timeout_locked=30"},
+					Args:       &hlaiv1beta1.ModprobeArgs{Load: []string{"-v", "--first-time"}, Unload: []string{"-rv"}},
+				}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+				Expect(m.Spec.ModuleLoader.Container.Modprobe).To(Equal(kmmv1beta1.ModprobeSpec{
+					ModuleName:   "habanalabs",
+					Parameters:   []string{"timeout_locked=30"},
+					DirName:      "/opt",
+					Args:         &kmmv1beta1.ModprobeArgs{Load: []string{"-v", "--first-time"}, Unload: []string{"-rv"}},
+					FirmwarePath: "/opt/lib/firmware",
+				}))
+			})
+
+			It("should declare them in load order to the driver build", func() {
+				dc.Spec.Driver.Build = &hlaiv1beta1.DriverBuildSpec{}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+				Expect(m.Spec.ModuleLoader.Container.Build.BuildArgs).To(ContainElement(
+					kmmv1beta1.BuildArg{Name: "COMPANION_MODULES", Value: "habanalabs_cn habanalabs_ib"},
+				))
+			})
+
+			It("should suffix the driver image tag with them", func() {
+				dc.Spec.Driver.Build = &hlaiv1beta1.DriverBuildSpec{}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+				Expect(m.Spec.ModuleLoader.Container.KernelMappings[0].ContainerImage).To(HaveSuffix("-${KERNEL_FULL_VERSION}-cn-ib"))
+			})
+
+			It("should sign them along with the habanalabs module", func() {
+				dc.Spec.Driver.Sign = &hlaiv1beta1.DriverSignSpec{
+					UnsignedImage: "${DRIVER_IMAGE}:${DRIVER_VERSION}-${KERNEL_FULL_VERSION}-unsigned",
+					KeySecret:     corev1.LocalObjectReference{Name: "signing-key"},
+					CertSecret:    corev1.LocalObjectReference{Name: "signing-cert"},
+				}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+				Expect(m.Spec.ModuleLoader.Container.Sign.FilesToSign).To(Equal([]string{
+					"/opt/lib/modules/${KERNEL_FULL_VERSION}/extra/habanalabs.ko",
+					"/opt/lib/modules/${KERNEL_FULL_VERSION}/extra/habanalabs_cn.ko",
+					"/opt/lib/modules/${KERNEL_FULL_VERSION}/extra/habanalabs_ib.ko",
+				}))
+			})
+		})
+
+		Context("with a node affinity", func() {
+			It("should select the target nodes of its driver version", func() {
+				dc.Spec.NodeSelector = map[string]string{testLabelKey: testLabelValue}
+				dc.Spec.NodeAffinity = &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+							MatchExpressions: []corev1.NodeSelectorRequirement{
+								{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}},
+							},
+						}},
+					},
+				}
+				m = &kmmv1beta1.Module{ObjectMeta: metav1.ObjectMeta{Name: "a-name", Namespace: dc.Namespace}}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+				Expect(m.Spec.Selector).To(Equal(map[string]string{
+					nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(dc),
+					NodeVersionLabel:        "",
+				}))
+			})
+		})
+
 		Context("with a non-nil Module as input", func() {
 			BeforeEach(func() {
 				dc.Spec.NodeSelector = map[string]string{testLabelKey: testLabelValue}
-				dc.Spec.DriverImage = testDriverImage
-				dc.Spec.DriverVersion = testDriverVersion
+				dc.Spec.Driver.Image = testDriverImage
+				dc.Spec.Driver.Version = testDriverVersion
 
 				m = &kmmv1beta1.Module{
 					ObjectMeta: metav1.ObjectMeta{
@@ -189,12 +575,15 @@ var _ = Describe("ModuleReconciler", func() {
 			})
 
 			Context("it returns a Module which", func() {
-				It("should contain the correct node selector", func() {
-					Expect(m.Spec.Selector).ToNot(BeNil())
+				It("should have the instance labels", func() {
+					Expect(m.Labels).To(Equal(instance.GetLabels(dc, moduleSuffix)))
+				})
 
-					v, contains := m.Spec.Selector[testLabelKey]
-					Expect(contains).To(BeTrue())
-					Expect(v).To(Equal(testLabelValue))
+				It("should contain the correct node selector", func() {
+					Expect(m.Spec.Selector).To(Equal(map[string]string{
+						nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(dc),
+						NodeVersionLabel:        testDriverVersion,
+					}))
 				})
 
 				It("should have the correct ModuleLoader", func() {
@@ -202,14 +591,17 @@ var _ = Describe("ModuleReconciler", func() {
 
 					Expect(m.Spec.ModuleLoader.Container.ImagePullPolicy).To(Equal(corev1.PullAlways))
 
-					Expect(m.Spec.ModuleLoader.Container.KernelMappings).ToNot(BeNil())
-					Expect(m.Spec.ModuleLoader.Container.KernelMappings).To(HaveLen(1))
 					expectedImage := fmt.Sprintf("%s:%s-${KERNEL_FULL_VERSION}", testDriverImage, testDriverVersion)
-					Expect(m.Spec.ModuleLoader.Container.KernelMappings[0].ContainerImage).To(Equal(expectedImage))
+					Expect(m.Spec.ModuleLoader.Container.KernelMappings).To(Equal([]kmmv1beta1.KernelMapping{
+						{ContainerImage: expectedImage, Regexp: `^.*\.el\d_?\d?\..*$`},
+						{ContainerImage: expectedImage, Regexp: `^.*\.el\d+(_\d+)?\..*$`},
+						{ContainerImage: expectedImage, Regexp: `^\d+\.\d+\.\d+-\d+-[a-z][a-z0-9-]*$`},
+					}))
 
 					Expect(m.Spec.ModuleLoader.Container.Modprobe).ToNot(BeNil())
 					Expect(m.Spec.ModuleLoader.Container.Modprobe.ModuleName).To(Equal("habanalabs"))
 					Expect(m.Spec.ModuleLoader.Container.Modprobe.FirmwarePath).To(Equal("/opt/lib/firmware"))
+					Expect(m.Spec.ModuleLoader.Container.Modprobe.DirName).To(Equal("/opt"))
 
 					Expect(m.Spec.ModuleLoader.ServiceAccountName).To(Equal(driverServiceAccount))
 				})
@@ -219,8 +611,152 @@ var _ = Describe("ModuleReconciler", func() {
 					Expect(m.Spec.DevicePlugin.Container).ToNot(BeNil())
 					Expect(m.Spec.DevicePlugin.Container.Image).ToNot(BeNil())
 					Expect(m.Spec.DevicePlugin.ServiceAccountName).To(Equal(devicePluginServiceAccount))
+					Expect(m.Spec.DevicePlugin.Container.Args).To(Equal([]string{"--dev_type", "gaudi"}))
 				})
 			})
 		})
+
+		Context("with a device type", func() {
+			It("should configure the device plugin for it", func() {
+				dc.Spec.DeviceType = hlaiv1beta1.DeviceTypeGaudi2
+				m = &kmmv1beta1.Module{ObjectMeta: metav1.ObjectMeta{Name: "a-name", Namespace: dc.Namespace}}
+
+				Expect(r.SetDesiredModule(m, dc)).To(Succeed())
+				Expect(m.Spec.DevicePlugin.Container.Args).To(Equal([]string{"--dev_type", "gaudi2"}))
+			})
+		})
+	})
+})
+
+var _ = Describe("SetModprobeConfigStatus", func() {
+	var dc *hlaiv1beta1.DeviceConfig
+
+	BeforeEach(func() {
+		dc = &hlaiv1beta1.DeviceConfig{ObjectMeta: metav1.ObjectMeta{Name: "a-device-config", Namespace: "a-namespace"}}
+	})
+
+	It("should record the first configuration without reload", func() {
+		changed, err := SetModprobeConfigStatus(dc, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(dc.Status.ModprobeConfigHash).ToNot(BeEmpty())
+		Expect(dc.Status.ModprobeConfigUpdateTime).To(BeNil())
+	})
+
+	It("should only report the configuration changes", func() {
+		_, err := SetModprobeConfigStatus(dc, dc)
+		Expect(err).ToNot(HaveOccurred())
+		hash := dc.Status.ModprobeConfigHash
+
+		changed, err := SetModprobeConfigStatus(dc, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+
+		dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{Parameters: []string{"timeout_locked=30"}}
+		changed, err = SetModprobeConfigStatus(dc, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(dc.Status.ModprobeConfigHash).ToNot(Equal(hash))
+		Expect(dc.Status.ModprobeConfigUpdateTime).ToNot(BeNil())
+	})
+
+	It("should report the companion module changes", func() {
+		_, err := SetModprobeConfigStatus(dc, dc)
+		Expect(err).ToNot(HaveOccurred())
+		hash := dc.Status.ModprobeConfigHash
+
+		dc.Spec.Driver.CompanionModules = []hlaiv1beta1.CompanionModule{hlaiv1beta1.CompanionModuleEN}
+		changed, err := SetModprobeConfigStatus(dc, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(dc.Status.ModprobeConfigHash).ToNot(Equal(hash))
+
+		dc.Spec.Driver.CompanionModules = nil
+		changed, err = SetModprobeConfigStatus(dc, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(dc.Status.ModprobeConfigHash).To(Equal(hash))
+	})
+})
+
+var _ = Describe("GetRevision", func() {
+	var dc *hlaiv1beta1.DeviceConfig
+
+	BeforeEach(func() {
+		Expect(hlaiv1beta1.AddToScheme(scheme.Scheme)).To(Succeed())
+		Expect(kmmv1beta1.AddToScheme(scheme.Scheme)).To(Succeed())
+		dc = &hlaiv1beta1.DeviceConfig{ObjectMeta: metav1.ObjectMeta{Name: "a-device-config", Namespace: "a-namespace"}}
+	})
+
+	getModule := func(version string) kmmv1beta1.Module {
+		m := kmmv1beta1.Module{ObjectMeta: metav1.ObjectMeta{Namespace: dc.Namespace}}
+		desired := dc.DeepCopy()
+		desired.Spec.Driver.Version = version
+		Expect(NewReconciler(nil, scheme.Scheme).SetDesiredModule(&m, desired)).To(Succeed())
+		return m
+	}
+
+	It("should be labelled with the version without Module of another configuration", func() {
+		rev, err := GetRevision(dc, nil, "1.0.0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rev).To(Equal(Revision{Version: "1.0.0", Label: "1.0.0"}))
+	})
+
+	It("should reuse the revision of the Module of the configuration", func() {
+		m := getModule("1.0.0")
+		m.Spec.Selector[NodeVersionLabel] = "a-label"
+
+		rev, err := GetRevision(dc, []kmmv1beta1.Module{m}, "1.0.0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rev).To(Equal(Revision{Version: "1.0.0", Label: "a-label"}))
+	})
+
+	It("should compute the configuration of a Module created before it was recorded", func() {
+		m := getModule("1.0.0")
+		delete(m.Annotations, DriverModprobeAnnotation)
+
+		rev, err := GetRevision(dc, []kmmv1beta1.Module{m}, "1.0.0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rev).To(Equal(Revision{Version: "1.0.0", Label: "1.0.0"}))
+	})
+
+	It("should suffix the version with the hash of a new configuration", func() {
+		m := getModule("1.0.0")
+
+		dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{Parameters: []string{"timeout_locked=30"}}
+		hash, err := GetModprobeConfigHash(dc)
+		Expect(err).ToNot(HaveOccurred())
+
+		rev, err := GetRevision(dc, []kmmv1beta1.Module{m}, "1.0.0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rev).To(Equal(Revision{Version: "1.0.0", Label: "1.0.0-" + hash[:8]}))
+	})
+})
+
+var _ = Describe("GetKernelDriverImage", func() {
+	const kernel = "5.14.0-284.el9.x86_64"
+
+	dc := &hlaiv1beta1.DeviceConfig{
+		Spec: hlaiv1beta1.DeviceConfigSpec{
+			Driver: hlaiv1beta1.DriverSpec{Image: testDriverImageBasename, Version: "1.11.0-587"},
+		},
+	}
+
+	DescribeTable("should replace the variables of the driver image template",
+		func(template, expected string) {
+			Expect(GetKernelDriverImage(dc, hlaiv1beta1.KernelMapping{ContainerImage: template}, kernel)).To(Equal(expected))
+		},
+		Entry("by default", "",
+			"registry.example.com/habana-ai-driver:1.11.0-587-5.14.0-284.el9.x86_64"),
+		Entry("with the kernel versions", "${DRIVER_IMAGE}:${DRIVER_VERSION}-${KERNEL_XYZ}-${KERNEL_X}.${KERNEL_Y}.${KERNEL_Z}",
+			"registry.example.com/habana-ai-driver:1.11.0-587-5.14.0-5.14.0"),
+	)
+
+	It("should suffix the tag with the companion modules", func() {
+		withCompanions := dc.DeepCopy()
+		withCompanions.Spec.Driver.CompanionModules = []hlaiv1beta1.CompanionModule{hlaiv1beta1.CompanionModuleIB}
+
+		Expect(GetKernelDriverImage(withCompanions, hlaiv1beta1.KernelMapping{}, kernel)).To(
+			Equal("registry.example.com/habana-ai-driver:1.11.0-587-5.14.0-284.el9.x86_64-cn-ib"))
 	})
 })
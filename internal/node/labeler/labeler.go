@@ -20,6 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -28,14 +29,18 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
-	"github.com/HabanaAI/habana-ai-operator/internal/constants"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/conditions"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
 	s "github.com/HabanaAI/habana-ai-operator/internal/settings"
 )
 
@@ -46,48 +51,61 @@ const (
 	nodeLabelerLimitsMemory   = "200Mi"
 	nodeLabelerRequestsCpu    = "100m"
 	nodeLabelerRequestsMemory = "200Mi"
+
+	// featureCleanupSuffix is the component of the DaemonSet removing the
+	// feature files of the node labeler when its DeviceConfig is deleted.
+	featureCleanupSuffix = "feature-cleanup"
+
+	// featuresDir is the directory of the NFD local feature files, where the
+	// node labeler writes the features of the Habana AI nodes.
+	featuresDir = "/etc/kubernetes/node-feature-discovery/features.d"
+	// featureFilesGlob matches the feature files written by the node labeler.
+	featureFilesGlob = "habana*"
 )
 
 //go:generate mockgen -source=labeler.go -package=labeler -destination=mock_labeler.go
 
 type Reconciler interface {
-	ReconcileNodeLabeler(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
-	DeleteNodeLabeler(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
-	ReconcileNodeLabelerDaemonSet(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
-	SetDesiredNodeLabelerDaemonSet(ds *appsv1.DaemonSet, cr *hlaiv1alpha1.DeviceConfig) error
-	DeleteNodeLabelerDaemonSet(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
+	ReconcileNodeLabeler(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
+	DeleteNodeLabeler(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
+	ReconcileNodeLabelerDaemonSet(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
+	SetDesiredNodeLabelerDaemonSet(ds *appsv1.DaemonSet, cr *hlaiv1beta1.DeviceConfig) error
+	DeleteNodeLabelerDaemonSet(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
+	CleanupFeatureFiles(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) (bool, error)
 }
 
 type NodeLabelerReconciler struct {
-	client client.Client
-	scheme *runtime.Scheme
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
 }
 
-func NewReconciler(c client.Client, s *runtime.Scheme) *NodeLabelerReconciler {
+func NewReconciler(c client.Client, s *runtime.Scheme, recorder record.EventRecorder) *NodeLabelerReconciler {
 	return &NodeLabelerReconciler{
-		client: c,
-		scheme: s,
+		client:   c,
+		scheme:   s,
+		recorder: recorder,
 	}
 }
 
-func getNodeLabelerName(cr *hlaiv1alpha1.DeviceConfig) string {
+func GetNodeLabelerName(cr *hlaiv1beta1.DeviceConfig) string {
 	return fmt.Sprintf("%s-%s", cr.Name, nodeLabelerSuffix)
 }
 
-func (r *NodeLabelerReconciler) ReconcileNodeLabeler(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
+func (r *NodeLabelerReconciler) ReconcileNodeLabeler(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
 	err := r.ReconcileNodeLabelerDaemonSet(ctx, cr)
 	if err != nil {
 		return err
 	}
 
-	return setNodeLabelerConditions(r)
+	return setNodeLabelerConditions(ctx, r, cr)
 }
 
-func (r *NodeLabelerReconciler) ReconcileNodeLabelerDaemonSet(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
+func (r *NodeLabelerReconciler) ReconcileNodeLabelerDaemonSet(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
 	logger := log.FromContext(ctx)
 
 	existingDS := &appsv1.DaemonSet{}
-	err := r.client.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: getNodeLabelerName(cr)}, existingDS)
+	err := r.client.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: GetNodeLabelerName(cr)}, existingDS)
 	exists := !apierrors.IsNotFound(err)
 	if err != nil && !apierrors.IsNotFound(err) {
 		return err
@@ -95,12 +113,15 @@ func (r *NodeLabelerReconciler) ReconcileNodeLabelerDaemonSet(ctx context.Contex
 
 	ds := &appsv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      getNodeLabelerName(cr),
+			Name:      GetNodeLabelerName(cr),
 			Namespace: cr.Namespace,
 		},
 	}
 
 	if exists {
+		if !reflect.DeepEqual(existingDS.Spec.Selector, instance.GetSelector(cr, nodeLabelerSuffix)) {
+			return r.deleteOutdatedNodeLabelerDaemonSet(ctx, existingDS)
+		}
 		ds = existingDS
 	}
 
@@ -117,14 +138,34 @@ func (r *NodeLabelerReconciler) ReconcileNodeLabelerDaemonSet(ctx context.Contex
 	return nil
 }
 
-func (r *NodeLabelerReconciler) DeleteNodeLabeler(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
+func (r *NodeLabelerReconciler) DeleteNodeLabeler(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
 	return r.DeleteNodeLabelerDaemonSet(ctx, cr)
 }
 
-func (r *NodeLabelerReconciler) DeleteNodeLabelerDaemonSet(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
+// deleteOutdatedNodeLabelerDaemonSet deletes a DaemonSet created with a selector
+// that does not match the current one, e.g. before the instance labels were
+// introduced, since the selector of a DaemonSet is immutable. Its deletion
+// event triggers another reconciliation, which creates it again.
+func (r *NodeLabelerReconciler) deleteOutdatedNodeLabelerDaemonSet(ctx context.Context, ds *appsv1.DaemonSet) error {
+	logger := log.FromContext(ctx)
+
+	opts := []client.DeleteOption{
+		client.Preconditions{UID: &ds.UID},
+		client.PropagationPolicy(metav1.DeletePropagationBackground),
+	}
+	if err := r.client.Delete(ctx, ds, opts...); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete DaemonSet %s with an outdated selector: %w", ds.Name, err)
+	}
+
+	logger.Info("Deleted DaemonSet with an outdated selector", "resource", ds.Name)
+
+	return nil
+}
+
+func (r *NodeLabelerReconciler) DeleteNodeLabelerDaemonSet(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
 	ds := &appsv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      getNodeLabelerName(cr),
+			Name:      GetNodeLabelerName(cr),
 			Namespace: cr.Namespace,
 		},
 	}
@@ -137,20 +178,105 @@ func (r *NodeLabelerReconciler) DeleteNodeLabelerDaemonSet(ctx context.Context,
 	return nil
 }
 
-func (r *NodeLabelerReconciler) SetDesiredNodeLabelerDaemonSet(ds *appsv1.DaemonSet, cr *hlaiv1alpha1.DeviceConfig) error {
+func getFeatureCleanupName(cr *hlaiv1beta1.DeviceConfig) string {
+	return fmt.Sprintf("%s-%s", cr.Name, featureCleanupSuffix)
+}
+
+// CleanupFeatureFiles removes the feature files written by the node labeler
+// from the nodes of cr, so that NFD stops labelling them once the labeler is
+// gone. A DaemonSet removes them in an init container, and is deleted once
+// its pods are ready on all the nodes, which returns true.
+func (r *NodeLabelerReconciler) CleanupFeatureFiles(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (bool, error) {
+	logger := log.FromContext(ctx)
+
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getFeatureCleanupName(cr),
+			Namespace: cr.Namespace,
+		},
+	}
+
+	res, err := controllerutil.CreateOrPatch(ctx, r.client, ds, func() error {
+		return r.setDesiredFeatureCleanupDaemonSet(ds, cr)
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not create or patch DaemonSet: %v", err)
+	}
+	logger.Info("Reconciled DaemonSet", "resource", ds.Name, "result", res)
+
+	h, err := pods.GetDaemonSetHealth(ctx, r.client, ds)
+	if err != nil {
+		return false, err
+	}
+
+	if !h.IsReady() {
+		return false, nil
+	}
+
+	if err := r.client.Delete(ctx, ds); err != nil && !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to delete DaemonSet %s: %w", ds.Name, err)
+	}
+	logger.Info("Removed the node labeler feature files", "nodes", h.Nodes)
+
+	return true, nil
+}
+
+func (r *NodeLabelerReconciler) setDesiredFeatureCleanupDaemonSet(ds *appsv1.DaemonSet, cr *hlaiv1beta1.DeviceConfig) error {
+	instance.SetLabels(ds, cr, featureCleanupSuffix)
+
+	ds.Spec.Selector = instance.GetSelector(cr, featureCleanupSuffix)
+
+	hostPathTypeDirectory := corev1.HostPathDirectory
+	image := getNodeLabelerImage(cr)
+	mounts := []corev1.VolumeMount{{Name: "features", MountPath: featuresDir}}
+
+	ds.Spec.Template = corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: instance.GetLabels(cr, featureCleanupSuffix),
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{
+				Name:            featureCleanupSuffix,
+				Image:           image,
+				Command:         []string{"sh", "-c", fmt.Sprintf("rm -f %s/%s", featuresDir, featureFilesGlob)},
+				SecurityContext: &corev1.SecurityContext{Privileged: pointer.Bool(true), RunAsUser: pointer.Int64(0)},
+				VolumeMounts:    mounts,
+			}},
+			// The pod stays ready once the files are removed, which tells
+			// that the cleanup is done on its node.
+			Containers: []corev1.Container{{
+				Name:    "done",
+				Image:   image,
+				Command: []string{"sleep", "infinity"},
+			}},
+			NodeSelector:       nodetargets.GetNodeSelector(cr),
+			PriorityClassName:  "system-node-critical",
+			ServiceAccountName: nodeLabelerServiceAccount,
+			Tolerations:        append([]corev1.Toleration(nil), cr.Spec.Tolerations...),
+			Volumes: []corev1.Volume{{
+				Name: "features",
+				VolumeSource: corev1.VolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{Path: featuresDir, Type: &hostPathTypeDirectory},
+				},
+			}},
+		},
+	}
+
+	return ctrl.SetControllerReference(cr, ds, r.scheme)
+}
+
+func (r *NodeLabelerReconciler) SetDesiredNodeLabelerDaemonSet(ds *appsv1.DaemonSet, cr *hlaiv1beta1.DeviceConfig) error {
 	if ds == nil {
 		return errors.New("daemonset cannot be nil")
 	}
 
-	labels := labelsForNodeLabelerDaemonSet(cr)
+	instance.SetLabels(ds, cr, nodeLabelerSuffix)
 
-	ds.Spec.Selector = &metav1.LabelSelector{
-		MatchLabels: labels,
-	}
+	ds.Spec.Selector = instance.GetSelector(cr, nodeLabelerSuffix)
 
 	ds.Spec.Template = corev1.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
-			Labels: labels,
+			Labels: instance.GetLabels(cr, nodeLabelerSuffix),
 		},
 	}
 
@@ -160,7 +286,7 @@ func (r *NodeLabelerReconciler) SetDesiredNodeLabelerDaemonSet(ds *appsv1.Daemon
 			Name: "pod-resources",
 			VolumeSource: corev1.VolumeSource{
 				HostPath: &corev1.HostPathVolumeSource{
-					Path: "/etc/kubernetes/node-feature-discovery/features.d",
+					Path: featuresDir,
 					Type: &hostPathTypeDirectory,
 				},
 			},
@@ -172,7 +298,7 @@ func (r *NodeLabelerReconciler) SetDesiredNodeLabelerDaemonSet(ds *appsv1.Daemon
 	}
 
 	nodeSelector := make(map[string]string)
-	for k, v := range cr.GetNodeSelector() {
+	for k, v := range nodetargets.GetNodeSelector(cr) {
 		nodeSelector[k] = v
 	}
 
@@ -182,18 +308,19 @@ func (r *NodeLabelerReconciler) SetDesiredNodeLabelerDaemonSet(ds *appsv1.Daemon
 		NodeSelector:       nodeSelector,
 		PriorityClassName:  "system-node-critical",
 		ServiceAccountName: nodeLabelerServiceAccount,
+		Tolerations:        append([]corev1.Toleration(nil), cr.Spec.Tolerations...),
 		Volumes:            volumes,
 	}
 
 	return ctrl.SetControllerReference(cr, ds, r.scheme)
 }
 
-func (r *NodeLabelerReconciler) makeNodeLabelerContainer(cr *hlaiv1alpha1.DeviceConfig) corev1.Container {
+func (r *NodeLabelerReconciler) makeNodeLabelerContainer(cr *hlaiv1beta1.DeviceConfig) corev1.Container {
 	nodeLabeler := corev1.Container{
 		Name: nodeLabelerSuffix,
 	}
 
-	nodeLabeler.Image = s.Settings.NodeLabelerImage
+	nodeLabeler.Image = getNodeLabelerImage(cr)
 	nodeLabeler.ImagePullPolicy = corev1.PullAlways
 
 	nodeLabeler.SecurityContext = &corev1.SecurityContext{
@@ -215,7 +342,7 @@ func (r *NodeLabelerReconciler) makeNodeLabelerContainer(cr *hlaiv1alpha1.Device
 	nodeLabeler.VolumeMounts = []corev1.VolumeMount{
 		{
 			Name:      "pod-resources",
-			MountPath: "/etc/kubernetes/node-feature-discovery/features.d",
+			MountPath: featuresDir,
 			ReadOnly:  false,
 		},
 	}
@@ -223,24 +350,51 @@ func (r *NodeLabelerReconciler) makeNodeLabelerContainer(cr *hlaiv1alpha1.Device
 	return nodeLabeler
 }
 
-// labelsForNodeLabelerDaemonSet returns the labels for selecting the
-// resources belonging to the given DeviceConfig CR name.
-func labelsForNodeLabelerDaemonSet(cr *hlaiv1alpha1.DeviceConfig) map[string]string {
-	return map[string]string{
-		"app.kubernetes.io/name":      constants.HabanaAIOperatorName,
-		"app.kubernetes.io/component": nodeLabelerSuffix,
+// getNodeLabelerImage returns the DeviceConfig node labeler image, falling
+// back to the operator default.
+func getNodeLabelerImage(cr *hlaiv1beta1.DeviceConfig) string {
+	if cr.Spec.NodeLabeler.Image != "" {
+		return cr.Spec.NodeLabeler.Image
 	}
+
+	return s.Settings.NodeLabelerImage
 }
 
-// TODO: NodeLabelerConditions shoud reflect the conditions of the pods
-//
-//	on the nodes. For that, we should list all the nodes that match the
-//	DaemonSet.Spec.Template.Spec.NodeSelector and verify the conditions
-//	of the pods running on each of these nodes. We can then set the
-//	NodeLabeler* conditions accordingly.
-//
-// TODO: Define the NodeLabeler* conditions and what they mean.
-// setNodeLabelerConditions wraps the condition create/update
-func setNodeLabelerConditions(r *NodeLabelerReconciler) (err error) {
-	return
+// setNodeLabelerConditions sets the NodeLabelerReady condition from the pods of the
+// node labeler DaemonSet on every node it targets, and records an Event
+// naming the nodes where its pods are failing or missing when it changes.
+func setNodeLabelerConditions(ctx context.Context, r *NodeLabelerReconciler, cr *hlaiv1beta1.DeviceConfig) error {
+	ds := &appsv1.DaemonSet{}
+	err := r.client.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: GetNodeLabelerName(cr)}, ds)
+	if apierrors.IsNotFound(err) {
+		// The cache has not caught up with the DaemonSet creation yet, its
+		// creation event will trigger another reconciliation.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get DaemonSet %s: %w", GetNodeLabelerName(cr), err)
+	}
+
+	h, err := pods.GetDaemonSetHealth(ctx, r.client, ds)
+	if err != nil {
+		return err
+	}
+
+	// An ongoing problem is only recorded once, when the condition changes,
+	// as the DeviceConfig is requeued while nodes are upgrading or leaving.
+	if !conditions.SetPodsHealthCondition(cr, conditions.NodeLabelerReady, h) {
+		return nil
+	}
+
+	// Pods are missing until the DaemonSet controller has handled the latest
+	// DaemonSet generation, which is not worth an event.
+	if ds.Status.ObservedGeneration < ds.Generation {
+		h.Missing = nil
+	}
+
+	for _, problem := range h.Problems() {
+		r.recorder.Eventf(cr, corev1.EventTypeWarning, "NodeLabelerUnhealthy", "Node labeler pods: %s", problem)
+	}
+
+	return nil
 }
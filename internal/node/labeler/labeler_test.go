@@ -24,15 +24,22 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	"github.com/HabanaAI/habana-ai-operator/internal/client"
+	"github.com/HabanaAI/habana-ai-operator/internal/conditions"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
 	s "github.com/HabanaAI/habana-ai-operator/internal/settings"
 )
 
@@ -43,14 +50,14 @@ const (
 
 var _ = Describe("NodeLabelerReconciler", func() {
 	var (
-		dc  *hlaiv1alpha1.DeviceConfig
+		dc  *hlaiv1beta1.DeviceConfig
 		r   *NodeLabelerReconciler
 		c   *client.MockClient
 		ctx context.Context
 	)
 
 	BeforeEach(func() {
-		dc = &hlaiv1alpha1.DeviceConfig{
+		dc = &hlaiv1beta1.DeviceConfig{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "a-device-config",
 				Namespace: "a-namespace",
@@ -59,9 +66,9 @@ var _ = Describe("NodeLabelerReconciler", func() {
 		c = client.NewMockClient(gomock.NewController(GinkgoT()))
 
 		s := scheme.Scheme
-		Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+		Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
-		r = NewReconciler(c, s)
+		r = NewReconciler(c, s, record.NewFakeRecorder(10))
 
 		ctx = context.TODO()
 	})
@@ -69,13 +76,110 @@ var _ = Describe("NodeLabelerReconciler", func() {
 	Describe("ReconcileNodeLabeler", func() {
 	})
 
+	Describe("setNodeLabelerConditions", func() {
+		var (
+			fakeRecorder *record.FakeRecorder
+			ds           *appsv1.DaemonSet
+			node         *corev1.Node
+		)
+
+		BeforeEach(func() {
+			dc.Spec.NodeSelector = map[string]string{testLabelKey: testLabelValue}
+			fakeRecorder = record.NewFakeRecorder(10)
+
+			ds = &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      GetNodeLabelerName(dc),
+					Namespace: dc.Namespace,
+				},
+			}
+			Expect(r.SetDesiredNodeLabelerDaemonSet(ds, dc)).To(Succeed())
+
+			node = &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "a-node",
+					Labels: dc.Spec.NodeSelector,
+				},
+			}
+		})
+
+		newReconciler := func(objs ...ctrlclient.Object) *NodeLabelerReconciler {
+			c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).Build()
+			return NewReconciler(c, scheme.Scheme, fakeRecorder)
+		}
+
+		Context("with a crashing pod", func() {
+			It("should set the NodeLabelerReady condition as false and record an event naming the node", func() {
+				p := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "a-pod",
+						Namespace: dc.Namespace,
+						Labels:    ds.Spec.Template.Labels,
+					},
+					Spec: corev1.PodSpec{NodeName: node.Name},
+					Status: corev1.PodStatus{
+						Phase: corev1.PodRunning,
+						ContainerStatuses: []corev1.ContainerStatus{
+							{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+						},
+					},
+				}
+
+				Expect(setNodeLabelerConditions(ctx, newReconciler(ds, node, p), dc)).To(Succeed())
+
+				cond := meta.FindStatusCondition(dc.Status.Conditions, conditions.NodeLabelerReady)
+				Expect(cond).ToNot(BeNil())
+				Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+				Expect(cond.Reason).To(Equal(conditions.ReasonPodsFailing))
+				Expect(cond.Message).To(ContainSubstring("CrashLoopBackOff on a-node"))
+
+				Expect(fakeRecorder.Events).To(Receive(Equal(
+					"Warning NodeLabelerUnhealthy Node labeler pods: CrashLoopBackOff on a-node")))
+
+				Expect(setNodeLabelerConditions(ctx, newReconciler(ds, node, p), dc)).To(Succeed())
+				Expect(fakeRecorder.Events).To(BeEmpty())
+			})
+		})
+
+		Context("with a missing pod", func() {
+			It("should record an event once the DaemonSet status is up to date", func() {
+				ds.Generation = 2
+				ds.Status.ObservedGeneration = 2
+
+				Expect(setNodeLabelerConditions(ctx, newReconciler(ds, node), dc)).To(Succeed())
+
+				Expect(meta.FindStatusCondition(dc.Status.Conditions, conditions.NodeLabelerReady).Reason).
+					To(Equal(conditions.ReasonPodsMissing))
+				Expect(fakeRecorder.Events).To(Receive(ContainSubstring("no pod on a-node")))
+			})
+
+			It("should not record an event while the DaemonSet is being rolled out", func() {
+				ds.Generation = 2
+				ds.Status.ObservedGeneration = 1
+
+				Expect(setNodeLabelerConditions(ctx, newReconciler(ds, node), dc)).To(Succeed())
+
+				Expect(meta.IsStatusConditionFalse(dc.Status.Conditions, conditions.NodeLabelerReady)).To(BeTrue())
+				Expect(fakeRecorder.Events).To(BeEmpty())
+			})
+		})
+
+		Context("with a DaemonSet not in the cache yet", func() {
+			It("should not return an error nor set the condition", func() {
+				Expect(setNodeLabelerConditions(ctx, newReconciler(), dc)).To(Succeed())
+
+				Expect(dc.Status.Conditions).To(BeEmpty())
+			})
+		})
+	})
+
 	Describe("ReconcileNodeLabelerDaemonSet", func() {
 		Context("with no client Get error", func() {
 			BeforeEach(func() {
 				gomock.InOrder(
 					c.EXPECT().
 						Get(ctx, gomock.Any(), gomock.Any()).
-						Return(apierrors.NewNotFound(schema.GroupResource{Resource: "daemonsets"}, getNodeLabelerName(dc))).
+						Return(apierrors.NewNotFound(schema.GroupResource{Resource: "daemonsets"}, GetNodeLabelerName(dc))).
 						AnyTimes(),
 				)
 			})
@@ -118,6 +222,52 @@ var _ = Describe("NodeLabelerReconciler", func() {
 		})
 	})
 
+	Describe("ReconcileNodeLabelerDaemonSet with an existing DaemonSet", func() {
+		var (
+			existing *appsv1.DaemonSet
+			fc       ctrlclient.Client
+		)
+
+		BeforeEach(func() {
+			existing = &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      GetNodeLabelerName(dc),
+					Namespace: dc.Namespace,
+				},
+			}
+			Expect(r.SetDesiredNodeLabelerDaemonSet(existing, dc)).To(Succeed())
+		})
+
+		It("should delete a DaemonSet with an outdated selector", func() {
+			outdated := map[string]string{
+				"app.kubernetes.io/name":      "habana-ai-operator",
+				"app.kubernetes.io/component": "node-labeler",
+			}
+			existing.Spec.Selector.MatchLabels = outdated
+			existing.Spec.Template.Labels = outdated
+
+			fc = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(existing).Build()
+			r = NewReconciler(fc, scheme.Scheme, record.NewFakeRecorder(10))
+
+			Expect(r.ReconcileNodeLabelerDaemonSet(ctx, dc)).To(Succeed())
+
+			err := fc.Get(ctx, ctrlclient.ObjectKeyFromObject(existing), &appsv1.DaemonSet{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should patch a DaemonSet with the current selector", func() {
+			delete(existing.Labels, instance.ManagedByLabel)
+			fc = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(existing).Build()
+			r = NewReconciler(fc, scheme.Scheme, record.NewFakeRecorder(10))
+
+			Expect(r.ReconcileNodeLabelerDaemonSet(ctx, dc)).To(Succeed())
+
+			ds := &appsv1.DaemonSet{}
+			Expect(fc.Get(ctx, ctrlclient.ObjectKeyFromObject(existing), ds)).To(Succeed())
+			Expect(ds.Labels).To(HaveKeyWithValue(instance.ManagedByLabel, "habana-ai-operator"))
+		})
+	})
+
 	Describe("DeleteNodeLabelerDaemonSet", func() {
 		Context("without a client Delete error", func() {
 			BeforeEach(func() {
@@ -136,7 +286,7 @@ var _ = Describe("NodeLabelerReconciler", func() {
 				gomock.InOrder(
 					c.EXPECT().
 						Delete(ctx, gomock.Any()).
-						Return(apierrors.NewNotFound(schema.GroupResource{Resource: "daemonsets"}, getNodeLabelerName(dc))),
+						Return(apierrors.NewNotFound(schema.GroupResource{Resource: "daemonsets"}, GetNodeLabelerName(dc))),
 				)
 			})
 
@@ -158,6 +308,52 @@ var _ = Describe("NodeLabelerReconciler", func() {
 		})
 	})
 
+	Describe("CleanupFeatureFiles", func() {
+		var node *corev1.Node
+
+		BeforeEach(func() {
+			dc.UID = "a-uid"
+			dc.Spec.NodeSelector = map[string]string{testLabelKey: testLabelValue}
+			node = &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-node", Labels: dc.Spec.NodeSelector},
+			}
+		})
+
+		It("should remove the feature files from the nodes before deleting its DaemonSet", func() {
+			fc := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(node).Build()
+			r = NewReconciler(fc, scheme.Scheme, record.NewFakeRecorder(10))
+
+			done, err := r.CleanupFeatureFiles(ctx, dc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(done).To(BeFalse())
+
+			ds := &appsv1.DaemonSet{}
+			Expect(fc.Get(ctx, ctrlclient.ObjectKey{Namespace: dc.Namespace, Name: "a-device-config-feature-cleanup"}, ds)).To(Succeed())
+			Expect(ds.Spec.Template.Spec.NodeSelector).To(Equal(dc.Spec.NodeSelector))
+			Expect(ds.Spec.Template.Spec.InitContainers).To(HaveLen(1))
+			Expect(ds.Spec.Template.Spec.InitContainers[0].Command).To(Equal([]string{
+				"sh", "-c", "rm -f /etc/kubernetes/node-feature-discovery/features.d/habana*",
+			}))
+
+			p := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-pod", Namespace: dc.Namespace, Labels: ds.Spec.Template.Labels},
+				Spec:       corev1.PodSpec{NodeName: node.Name},
+				Status: corev1.PodStatus{
+					Phase:      corev1.PodRunning,
+					Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+				},
+			}
+			Expect(fc.Create(ctx, p)).To(Succeed())
+
+			done, err = r.CleanupFeatureFiles(ctx, dc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(done).To(BeTrue())
+
+			err = fc.Get(ctx, ctrlclient.ObjectKey{Namespace: dc.Namespace, Name: "a-device-config-feature-cleanup"}, ds)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
 	Describe("SetDesiredNodeLabelerDaemonSet", func() {
 		var (
 			ds *appsv1.DaemonSet
@@ -176,6 +372,42 @@ var _ = Describe("NodeLabelerReconciler", func() {
 			})
 		})
 
+		Context("with a DeviceConfig overriding the node labeler image", func() {
+			It("should use the DeviceConfig image", func() {
+				dc.Spec.NodeLabeler.Image = "registry.example.com/habana-ai/node-labeler:test"
+
+				ds = &appsv1.DaemonSet{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "a-name",
+						Namespace: "a-namespace",
+					},
+				}
+
+				Expect(r.SetDesiredNodeLabelerDaemonSet(ds, dc)).To(Succeed())
+				Expect(ds.Spec.Template.Spec.Containers).To(HaveLen(1))
+				Expect(ds.Spec.Template.Spec.Containers[0].Image).To(Equal(dc.Spec.NodeLabeler.Image))
+			})
+		})
+
+		Context("with node selector expressions and tolerations", func() {
+			It("should select the target nodes and tolerate their taints", func() {
+				dc.Spec.NodeSelector = map[string]string{testLabelKey: testLabelValue}
+				dc.Spec.NodeSelectorExpressions = []metav1.LabelSelectorRequirement{
+					{Key: "zone", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"b"}},
+				}
+				dc.Spec.Tolerations = []corev1.Toleration{
+					{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "hpu", Effect: corev1.TaintEffectNoSchedule},
+				}
+				ds = &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "a-name", Namespace: dc.Namespace}}
+
+				Expect(r.SetDesiredNodeLabelerDaemonSet(ds, dc)).To(Succeed())
+				Expect(ds.Spec.Template.Spec.NodeSelector).To(Equal(map[string]string{
+					nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(dc),
+				}))
+				Expect(ds.Spec.Template.Spec.Tolerations).To(Equal(dc.Spec.Tolerations))
+			})
+		})
+
 		Context("with a non-nil DaemonSet as input", func() {
 			BeforeEach(func() {
 				dc.Spec.NodeSelector = map[string]string{testLabelKey: testLabelValue}
@@ -192,6 +424,20 @@ var _ = Describe("NodeLabelerReconciler", func() {
 			})
 
 			Context("it returns a DaemonSet which", func() {
+				It("should only select the pods of its DeviceConfig", func() {
+					Expect(ds.Spec.Selector.MatchLabels).To(HaveKeyWithValue(instance.InstanceLabel, dc.Name))
+					Expect(ds.Spec.Selector.MatchLabels).To(HaveKeyWithValue(instance.ComponentLabel, "node-labeler"))
+
+					for k, v := range ds.Spec.Selector.MatchLabels {
+						Expect(ds.Spec.Template.Labels).To(HaveKeyWithValue(k, v))
+					}
+				})
+
+				It("should have the instance labels", func() {
+					Expect(ds.Labels).To(HaveKeyWithValue(instance.ManagedByLabel, "habana-ai-operator"))
+					Expect(ds.Labels).To(HaveKeyWithValue(instance.InstanceLabel, dc.Name))
+				})
+
 				It("should contain the correct node selector", func() {
 					Expect(ds.Spec.Template.Spec.NodeSelector).ToNot(BeNil())
 
@@ -200,6 +446,10 @@ var _ = Describe("NodeLabelerReconciler", func() {
 					Expect(v).To(Equal(testLabelValue))
 				})
 
+				It("should not have tolerations", func() {
+					Expect(ds.Spec.Template.Spec.Tolerations).To(BeEmpty())
+				})
+
 				It("should have the HostPID enabled", func() {
 					Expect(ds.Spec.Template.Spec.HostPID).To(BeTrue())
 				})
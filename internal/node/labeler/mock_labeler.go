@@ -8,7 +8,7 @@ import (
 	context "context"
 	reflect "reflect"
 
-	v1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	v1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	gomock "github.com/golang/mock/gomock"
 	v1 "k8s.io/api/apps/v1"
 )
@@ -36,8 +36,23 @@ func (m *MockReconciler) EXPECT() *MockReconcilerMockRecorder {
 	return m.recorder
 }
 
+// CleanupFeatureFiles mocks base method.
+func (m *MockReconciler) CleanupFeatureFiles(ctx context.Context, dc *v1beta1.DeviceConfig) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CleanupFeatureFiles", ctx, dc)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CleanupFeatureFiles indicates an expected call of CleanupFeatureFiles.
+func (mr *MockReconcilerMockRecorder) CleanupFeatureFiles(ctx, dc interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CleanupFeatureFiles", reflect.TypeOf((*MockReconciler)(nil).CleanupFeatureFiles), ctx, dc)
+}
+
 // DeleteNodeLabeler mocks base method.
-func (m *MockReconciler) DeleteNodeLabeler(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) DeleteNodeLabeler(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DeleteNodeLabeler", ctx, dc)
 	ret0, _ := ret[0].(error)
@@ -51,7 +66,7 @@ func (mr *MockReconcilerMockRecorder) DeleteNodeLabeler(ctx, dc interface{}) *go
 }
 
 // DeleteNodeLabelerDaemonSet mocks base method.
-func (m *MockReconciler) DeleteNodeLabelerDaemonSet(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) DeleteNodeLabelerDaemonSet(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DeleteNodeLabelerDaemonSet", ctx, dc)
 	ret0, _ := ret[0].(error)
@@ -65,7 +80,7 @@ func (mr *MockReconcilerMockRecorder) DeleteNodeLabelerDaemonSet(ctx, dc interfa
 }
 
 // ReconcileNodeLabeler mocks base method.
-func (m *MockReconciler) ReconcileNodeLabeler(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) ReconcileNodeLabeler(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "ReconcileNodeLabeler", ctx, dc)
 	ret0, _ := ret[0].(error)
@@ -79,7 +94,7 @@ func (mr *MockReconcilerMockRecorder) ReconcileNodeLabeler(ctx, dc interface{})
 }
 
 // ReconcileNodeLabelerDaemonSet mocks base method.
-func (m *MockReconciler) ReconcileNodeLabelerDaemonSet(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) ReconcileNodeLabelerDaemonSet(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "ReconcileNodeLabelerDaemonSet", ctx, dc)
 	ret0, _ := ret[0].(error)
@@ -93,7 +108,7 @@ func (mr *MockReconcilerMockRecorder) ReconcileNodeLabelerDaemonSet(ctx, dc inte
 }
 
 // SetDesiredNodeLabelerDaemonSet mocks base method.
-func (m *MockReconciler) SetDesiredNodeLabelerDaemonSet(ds *v1.DaemonSet, cr *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) SetDesiredNodeLabelerDaemonSet(ds *v1.DaemonSet, cr *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "SetDesiredNodeLabelerDaemonSet", ds, cr)
 	ret0, _ := ret[0].(error)
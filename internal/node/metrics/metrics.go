@@ -20,6 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -29,14 +30,18 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
-	"github.com/HabanaAI/habana-ai-operator/internal/constants"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/conditions"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
 	s "github.com/HabanaAI/habana-ai-operator/internal/settings"
 )
 
@@ -53,33 +58,35 @@ const (
 //go:generate mockgen -source=metrics.go -package=metrics -destination=mock_metrics.go
 
 type Reconciler interface {
-	ReconcileNodeMetrics(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
-	DeleteNodeMetrics(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
-	ReconcileNodeMetricsDaemonSet(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
-	SetDesiredNodeMetricsDaemonSet(ds *appsv1.DaemonSet, cr *hlaiv1alpha1.DeviceConfig) error
-	DeleteNodeMetricsDaemonSet(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
-	ReconcileNodeMetricsService(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
-	SetDesiredNodeMetricsService(s *corev1.Service, cr *hlaiv1alpha1.DeviceConfig) error
-	DeleteNodeMetricsService(ctx context.Context, dc *hlaiv1alpha1.DeviceConfig) error
+	ReconcileNodeMetrics(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
+	DeleteNodeMetrics(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
+	ReconcileNodeMetricsDaemonSet(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
+	SetDesiredNodeMetricsDaemonSet(ds *appsv1.DaemonSet, cr *hlaiv1beta1.DeviceConfig) error
+	DeleteNodeMetricsDaemonSet(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
+	ReconcileNodeMetricsService(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
+	SetDesiredNodeMetricsService(s *corev1.Service, cr *hlaiv1beta1.DeviceConfig) error
+	DeleteNodeMetricsService(ctx context.Context, dc *hlaiv1beta1.DeviceConfig) error
 }
 
 type NodeMetricsReconciler struct {
-	client client.Client
-	scheme *runtime.Scheme
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
 }
 
-func NewReconciler(c client.Client, s *runtime.Scheme) *NodeMetricsReconciler {
+func NewReconciler(c client.Client, s *runtime.Scheme, recorder record.EventRecorder) *NodeMetricsReconciler {
 	return &NodeMetricsReconciler{
-		client: c,
-		scheme: s,
+		client:   c,
+		scheme:   s,
+		recorder: recorder,
 	}
 }
 
-func GetNodeMetricsName(cr *hlaiv1alpha1.DeviceConfig) string {
+func GetNodeMetricsName(cr *hlaiv1beta1.DeviceConfig) string {
 	return fmt.Sprintf("%s-%s", cr.Name, nodeMetricsSuffix)
 }
 
-func (r *NodeMetricsReconciler) ReconcileNodeMetrics(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
+func (r *NodeMetricsReconciler) ReconcileNodeMetrics(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
 	err := r.ReconcileNodeMetricsDaemonSet(ctx, cr)
 	if err != nil {
 		return err
@@ -90,10 +97,10 @@ func (r *NodeMetricsReconciler) ReconcileNodeMetrics(ctx context.Context, cr *hl
 		return err
 	}
 
-	return setNodeMetricsConditions(r)
+	return setNodeMetricsConditions(ctx, r, cr)
 }
 
-func (r *NodeMetricsReconciler) ReconcileNodeMetricsDaemonSet(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
+func (r *NodeMetricsReconciler) ReconcileNodeMetricsDaemonSet(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
 	logger := log.FromContext(ctx)
 
 	existingDS := &appsv1.DaemonSet{}
@@ -111,6 +118,9 @@ func (r *NodeMetricsReconciler) ReconcileNodeMetricsDaemonSet(ctx context.Contex
 	}
 
 	if exists {
+		if !reflect.DeepEqual(existingDS.Spec.Selector, instance.GetSelector(cr, nodeMetricsSuffix)) {
+			return r.deleteOutdatedNodeMetricsDaemonSet(ctx, existingDS)
+		}
 		ds = existingDS
 	}
 
@@ -127,7 +137,7 @@ func (r *NodeMetricsReconciler) ReconcileNodeMetricsDaemonSet(ctx context.Contex
 	return nil
 }
 
-func (r *NodeMetricsReconciler) ReconcileNodeMetricsService(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
+func (r *NodeMetricsReconciler) ReconcileNodeMetricsService(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
 	logger := log.FromContext(ctx)
 
 	existingService := &corev1.Service{}
@@ -164,7 +174,7 @@ func (r *NodeMetricsReconciler) ReconcileNodeMetricsService(ctx context.Context,
 	return nil
 }
 
-func (r *NodeMetricsReconciler) DeleteNodeMetrics(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
+func (r *NodeMetricsReconciler) DeleteNodeMetrics(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
 	err := r.DeleteNodeMetricsDaemonSet(ctx, cr)
 	if err != nil {
 		return err
@@ -178,7 +188,27 @@ func (r *NodeMetricsReconciler) DeleteNodeMetrics(ctx context.Context, cr *hlaiv
 	return nil
 }
 
-func (r *NodeMetricsReconciler) DeleteNodeMetricsDaemonSet(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
+// deleteOutdatedNodeMetricsDaemonSet deletes a DaemonSet created with a selector
+// that does not match the current one, e.g. before the instance labels were
+// introduced, since the selector of a DaemonSet is immutable. Its deletion
+// event triggers another reconciliation, which creates it again.
+func (r *NodeMetricsReconciler) deleteOutdatedNodeMetricsDaemonSet(ctx context.Context, ds *appsv1.DaemonSet) error {
+	logger := log.FromContext(ctx)
+
+	opts := []client.DeleteOption{
+		client.Preconditions{UID: &ds.UID},
+		client.PropagationPolicy(metav1.DeletePropagationBackground),
+	}
+	if err := r.client.Delete(ctx, ds, opts...); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete DaemonSet %s with an outdated selector: %w", ds.Name, err)
+	}
+
+	logger.Info("Deleted DaemonSet with an outdated selector", "resource", ds.Name)
+
+	return nil
+}
+
+func (r *NodeMetricsReconciler) DeleteNodeMetricsDaemonSet(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
 	ds := &appsv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      GetNodeMetricsName(cr),
@@ -194,7 +224,7 @@ func (r *NodeMetricsReconciler) DeleteNodeMetricsDaemonSet(ctx context.Context,
 	return nil
 }
 
-func (r *NodeMetricsReconciler) DeleteNodeMetricsService(ctx context.Context, cr *hlaiv1alpha1.DeviceConfig) error {
+func (r *NodeMetricsReconciler) DeleteNodeMetricsService(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
 	s := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      GetNodeMetricsName(cr),
@@ -210,20 +240,18 @@ func (r *NodeMetricsReconciler) DeleteNodeMetricsService(ctx context.Context, cr
 	return nil
 }
 
-func (r *NodeMetricsReconciler) SetDesiredNodeMetricsDaemonSet(ds *appsv1.DaemonSet, cr *hlaiv1alpha1.DeviceConfig) error {
+func (r *NodeMetricsReconciler) SetDesiredNodeMetricsDaemonSet(ds *appsv1.DaemonSet, cr *hlaiv1beta1.DeviceConfig) error {
 	if ds == nil {
 		return errors.New("daemonset cannot be nil")
 	}
 
-	labels := labelsForNodeMetricsDaemonSet(cr)
+	instance.SetLabels(ds, cr, nodeMetricsSuffix)
 
-	ds.Spec.Selector = &metav1.LabelSelector{
-		MatchLabels: labels,
-	}
+	ds.Spec.Selector = instance.GetSelector(cr, nodeMetricsSuffix)
 
 	ds.Spec.Template = corev1.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
-			Labels: labels,
+			Labels: instance.GetLabels(cr, nodeMetricsSuffix),
 		},
 	}
 
@@ -245,7 +273,7 @@ func (r *NodeMetricsReconciler) SetDesiredNodeMetricsDaemonSet(ds *appsv1.Daemon
 	}
 
 	nodeSelector := make(map[string]string)
-	for k, v := range cr.GetNodeSelector("gaudi") {
+	for k, v := range nodetargets.GetNodeSelector(cr) {
 		nodeSelector[k] = v
 	}
 
@@ -255,6 +283,7 @@ func (r *NodeMetricsReconciler) SetDesiredNodeMetricsDaemonSet(ds *appsv1.Daemon
 		NodeSelector:       nodeSelector,
 		PriorityClassName:  "system-node-critical",
 		ServiceAccountName: nodeMetricsServiceAccount,
+		Tolerations:        append([]corev1.Toleration(nil), cr.Spec.Tolerations...),
 		Volumes:            volumes,
 	}
 
@@ -265,18 +294,18 @@ func (r *NodeMetricsReconciler) SetDesiredNodeMetricsDaemonSet(ds *appsv1.Daemon
 	return nil
 }
 
-func (r *NodeMetricsReconciler) SetDesiredNodeMetricsService(s *corev1.Service, cr *hlaiv1alpha1.DeviceConfig) error {
+func (r *NodeMetricsReconciler) SetDesiredNodeMetricsService(s *corev1.Service, cr *hlaiv1beta1.DeviceConfig) error {
 	if s == nil {
 		return errors.New("service cannot be nil")
 	}
 
-	s.ObjectMeta.Labels = labelsForNodeMetricsDaemonSet(cr)
+	instance.SetLabels(s, cr, nodeMetricsSuffix)
 	s.ObjectMeta.Annotations = map[string]string{
 		"prometheus.io/scrape": "true",
 	}
 
 	s.Spec = corev1.ServiceSpec{
-		Selector: labelsForNodeMetricsDaemonSet(cr),
+		Selector: instance.GetSelectorLabels(cr, nodeMetricsSuffix),
 		Ports: []corev1.ServicePort{
 			{
 				Name:       nodeMetricsSuffix,
@@ -294,12 +323,12 @@ func (r *NodeMetricsReconciler) SetDesiredNodeMetricsService(s *corev1.Service,
 	return nil
 }
 
-func (r *NodeMetricsReconciler) makeNodeMetricsContainer(cr *hlaiv1alpha1.DeviceConfig) corev1.Container {
+func (r *NodeMetricsReconciler) makeNodeMetricsContainer(cr *hlaiv1beta1.DeviceConfig) corev1.Container {
 	nodeMetrics := corev1.Container{
 		Name: nodeMetricsSuffix,
 	}
 
-	nodeMetrics.Image = s.Settings.NodeMetricsImage
+	nodeMetrics.Image = getNodeMetricsImage(cr)
 	nodeMetrics.ImagePullPolicy = corev1.PullAlways
 
 	nodeMetrics.SecurityContext = &corev1.SecurityContext{
@@ -338,24 +367,51 @@ func (r *NodeMetricsReconciler) makeNodeMetricsContainer(cr *hlaiv1alpha1.Device
 	return nodeMetrics
 }
 
-// labelsForNodeMetricsDaemonSet returns the labels for selecting the
-// resources belonging to the given DeviceConfig CR name.
-func labelsForNodeMetricsDaemonSet(cr *hlaiv1alpha1.DeviceConfig) map[string]string {
-	return map[string]string{
-		"app.kubernetes.io/name":      constants.HabanaAIOperatorName,
-		"app.kubernetes.io/component": nodeMetricsSuffix,
+// getNodeMetricsImage returns the DeviceConfig metrics exporter image,
+// falling back to the operator default.
+func getNodeMetricsImage(cr *hlaiv1beta1.DeviceConfig) string {
+	if cr.Spec.NodeMetrics.Image != "" {
+		return cr.Spec.NodeMetrics.Image
 	}
+
+	return s.Settings.NodeMetricsImage
 }
 
-// TODO: NodeMetricsConditions shoud reflect the conditions of the pods
-//
-//	on the nodes. For that, we should list all the nodes that match the
-//	DaemonSet.Spec.Template.Spec.NodeSelector and verify the conditions
-//	of the pods running on each of these nodes. We can then set the
-//	NodeMetrics* conditions accordingly.
-//
-// TODO: Define the NodeMetrics* conditions and what they mean.
-// setNodeMetricsConditions wraps the condition create/update
-func setNodeMetricsConditions(r *NodeMetricsReconciler) (err error) {
-	return
+// setNodeMetricsConditions sets the NodeMetricsReady condition from the pods of the
+// node metrics DaemonSet on every node it targets, and records an Event
+// naming the nodes where its pods are failing or missing when it changes.
+func setNodeMetricsConditions(ctx context.Context, r *NodeMetricsReconciler, cr *hlaiv1beta1.DeviceConfig) error {
+	ds := &appsv1.DaemonSet{}
+	err := r.client.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: GetNodeMetricsName(cr)}, ds)
+	if apierrors.IsNotFound(err) {
+		// The cache has not caught up with the DaemonSet creation yet, its
+		// creation event will trigger another reconciliation.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get DaemonSet %s: %w", GetNodeMetricsName(cr), err)
+	}
+
+	h, err := pods.GetDaemonSetHealth(ctx, r.client, ds)
+	if err != nil {
+		return err
+	}
+
+	// An ongoing problem is only recorded once, when the condition changes,
+	// as the DeviceConfig is requeued while nodes are upgrading or leaving.
+	if !conditions.SetPodsHealthCondition(cr, conditions.NodeMetricsReady, h) {
+		return nil
+	}
+
+	// Pods are missing until the DaemonSet controller has handled the latest
+	// DaemonSet generation, which is not worth an event.
+	if ds.Status.ObservedGeneration < ds.Generation {
+		h.Missing = nil
+	}
+
+	for _, problem := range h.Problems() {
+		r.recorder.Eventf(cr, corev1.EventTypeWarning, "NodeMetricsUnhealthy", "Node metrics pods: %s", problem)
+	}
+
+	return nil
 }
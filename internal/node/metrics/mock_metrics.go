@@ -8,7 +8,7 @@ import (
 	context "context"
 	reflect "reflect"
 
-	v1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	v1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	gomock "github.com/golang/mock/gomock"
 	v1 "k8s.io/api/apps/v1"
 	v10 "k8s.io/api/core/v1"
@@ -38,7 +38,7 @@ func (m *MockReconciler) EXPECT() *MockReconcilerMockRecorder {
 }
 
 // DeleteNodeMetrics mocks base method.
-func (m *MockReconciler) DeleteNodeMetrics(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) DeleteNodeMetrics(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DeleteNodeMetrics", ctx, dc)
 	ret0, _ := ret[0].(error)
@@ -52,7 +52,7 @@ func (mr *MockReconcilerMockRecorder) DeleteNodeMetrics(ctx, dc interface{}) *go
 }
 
 // DeleteNodeMetricsDaemonSet mocks base method.
-func (m *MockReconciler) DeleteNodeMetricsDaemonSet(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) DeleteNodeMetricsDaemonSet(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DeleteNodeMetricsDaemonSet", ctx, dc)
 	ret0, _ := ret[0].(error)
@@ -66,7 +66,7 @@ func (mr *MockReconcilerMockRecorder) DeleteNodeMetricsDaemonSet(ctx, dc interfa
 }
 
 // DeleteNodeMetricsService mocks base method.
-func (m *MockReconciler) DeleteNodeMetricsService(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) DeleteNodeMetricsService(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DeleteNodeMetricsService", ctx, dc)
 	ret0, _ := ret[0].(error)
@@ -80,7 +80,7 @@ func (mr *MockReconcilerMockRecorder) DeleteNodeMetricsService(ctx, dc interface
 }
 
 // ReconcileNodeMetrics mocks base method.
-func (m *MockReconciler) ReconcileNodeMetrics(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) ReconcileNodeMetrics(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "ReconcileNodeMetrics", ctx, dc)
 	ret0, _ := ret[0].(error)
@@ -94,7 +94,7 @@ func (mr *MockReconcilerMockRecorder) ReconcileNodeMetrics(ctx, dc interface{})
 }
 
 // ReconcileNodeMetricsDaemonSet mocks base method.
-func (m *MockReconciler) ReconcileNodeMetricsDaemonSet(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) ReconcileNodeMetricsDaemonSet(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "ReconcileNodeMetricsDaemonSet", ctx, dc)
 	ret0, _ := ret[0].(error)
@@ -108,7 +108,7 @@ func (mr *MockReconcilerMockRecorder) ReconcileNodeMetricsDaemonSet(ctx, dc inte
 }
 
 // ReconcileNodeMetricsService mocks base method.
-func (m *MockReconciler) ReconcileNodeMetricsService(ctx context.Context, dc *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) ReconcileNodeMetricsService(ctx context.Context, dc *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "ReconcileNodeMetricsService", ctx, dc)
 	ret0, _ := ret[0].(error)
@@ -122,7 +122,7 @@ func (mr *MockReconcilerMockRecorder) ReconcileNodeMetricsService(ctx, dc interf
 }
 
 // SetDesiredNodeMetricsDaemonSet mocks base method.
-func (m *MockReconciler) SetDesiredNodeMetricsDaemonSet(ds *v1.DaemonSet, cr *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) SetDesiredNodeMetricsDaemonSet(ds *v1.DaemonSet, cr *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "SetDesiredNodeMetricsDaemonSet", ds, cr)
 	ret0, _ := ret[0].(error)
@@ -136,7 +136,7 @@ func (mr *MockReconcilerMockRecorder) SetDesiredNodeMetricsDaemonSet(ds, cr inte
 }
 
 // SetDesiredNodeMetricsService mocks base method.
-func (m *MockReconciler) SetDesiredNodeMetricsService(s *v10.Service, cr *v1alpha1.DeviceConfig) error {
+func (m *MockReconciler) SetDesiredNodeMetricsService(s *v10.Service, cr *v1beta1.DeviceConfig) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "SetDesiredNodeMetricsService", s, cr)
 	ret0, _ := ret[0].(error)
@@ -0,0 +1,100 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeselector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+)
+
+// ErrConflictingNodeSelector is returned when a DeviceConfig selects nodes that
+// are already selected by another DeviceConfig.
+var ErrConflictingNodeSelector = errors.New("conflicting DeviceConfig NodeSelectors found")
+
+//go:generate mockgen -source=nodeselector.go -package=nodeselector -destination=mock_nodeselector.go
+
+type Validator interface {
+	CheckDeviceConfigForConflictingNodeSelector(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error
+}
+
+type validator struct {
+	client client.Client
+}
+
+func NewValidator(c client.Client) *validator {
+	return &validator{client: c}
+}
+
+// CheckDeviceConfigForConflictingNodeSelector returns an error if any of the
+// nodes selected by cr is already selected by another DeviceConfig. cr does not
+// need to exist in the cluster, so that it can be checked before admission.
+func (v *validator) CheckDeviceConfigForConflictingNodeSelector(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	dcs := &hlaiv1beta1.DeviceConfigList{}
+	err := v.client.List(ctx, dcs)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := ListSelectedNodes(ctx, v.client, cr)
+	if err != nil {
+		return err
+	}
+
+	selected := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		selected[n.Name] = true
+	}
+
+	conflicts := []string{}
+	for i := range dcs.Items {
+		dc := &dcs.Items[i]
+		if dc.Namespace == cr.Namespace && dc.Name == cr.Name {
+			continue
+		}
+
+		nodes, err := ListSelectedNodes(ctx, v.client, dc)
+		if err != nil {
+			return err
+		}
+
+		names := []string{}
+		for _, n := range nodes {
+			if selected[n.Name] {
+				names = append(names, n.Name)
+			}
+		}
+
+		if len(names) > 0 {
+			sort.Strings(names)
+			conflicts = append(conflicts, fmt.Sprintf("%s/%s (%s)", dc.Namespace, dc.Name, strings.Join(names, ", ")))
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w for resource %s: nodes already selected by %s",
+			ErrConflictingNodeSelector, cr.Name, strings.Join(conflicts, "; "))
+	}
+
+	return nil
+}
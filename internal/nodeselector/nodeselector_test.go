@@ -11,7 +11,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package controllers
+package nodeselector
 
 import (
 	"context"
@@ -26,12 +26,13 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	"github.com/HabanaAI/habana-ai-operator/internal/client"
 )
 
 const (
-	testNodeName = "test-node"
+	testDeviceConfigName = "test"
+	testNodeName         = "test-node"
 )
 
 var _ = Describe("NodeSelectorValidator", func() {
@@ -44,19 +45,19 @@ var _ = Describe("NodeSelectorValidator", func() {
 			var (
 				gCtrl *gomock.Controller
 				c     *client.MockClient
-				nsv   *nodeSelectorValidator
+				nsv   *validator
 			)
 
 			nonconflictingDC := makeTestDeviceConfig(named("nonconflictingDC"))
 
 			s := scheme.Scheme
-			Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+			Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
 			BeforeEach(func() {
 				gCtrl = gomock.NewController(GinkgoT())
 				c = client.NewMockClient(gCtrl)
 
-				nsv = NewNodeSelectorValidator(c)
+				nsv = NewValidator(c)
 
 				gomock.InOrder(
 					c.EXPECT().
@@ -77,80 +78,102 @@ var _ = Describe("NodeSelectorValidator", func() {
 				conflictingDC := makeTestDeviceConfig(named("conflictingDC"), nodeSelector(node.Labels))
 
 				s := scheme.Scheme
-				Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+				Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
 				c := fake.
 					NewClientBuilder().
 					WithScheme(s).
 					WithObjects(node, dc, conflictingDC).
 					Build()
-				nsv := NewNodeSelectorValidator(c)
+				nsv := NewValidator(c)
 
 				err := nsv.CheckDeviceConfigForConflictingNodeSelector(context.TODO(), conflictingDC)
 				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(And(
+					ContainSubstring(testDeviceConfigName),
+					ContainSubstring(testNodeName),
+				))
 			})
 		})
 
-		Context("with a valid nodeSelector", func() {
-			It("should not return an error", func() {
-				nonconflictingDC := makeTestDeviceConfig(named("nonconflictingDC"))
+		Context("with a conflicting nodeSelector on a DeviceConfig that does not exist yet", func() {
+			It("should return an error", func() {
+				newDC := makeTestDeviceConfig(named("newDC"), nodeSelector(node.Labels))
 
 				s := scheme.Scheme
-				Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+				Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
 				c := fake.
 					NewClientBuilder().
 					WithScheme(s).
-					WithObjects(node, dc, nonconflictingDC).
+					WithObjects(node, dc).
 					Build()
-				nsv := NewNodeSelectorValidator(c)
+				nsv := NewValidator(c)
 
-				err := nsv.CheckDeviceConfigForConflictingNodeSelector(context.TODO(), nonconflictingDC)
-				Expect(err).ToNot(HaveOccurred())
+				err := nsv.CheckDeviceConfigForConflictingNodeSelector(context.TODO(), newDC)
+				Expect(err).To(HaveOccurred())
 			})
 		})
-	})
 
-	Describe("getDeviceConfigSelectedNodes", func() {
-		Context("with a valid nodeSelector", func() {
-			It("should returned the selected nodes", func() {
-				node := makeTestNode(labelled(map[string]string{"matching": "label"}))
-				dc := makeTestDeviceConfig(nodeSelector(node.Labels))
+		Context("with node selector expressions excluding the conflicting node", func() {
+			It("should not return an error", func() {
+				exclusiveDC := makeTestDeviceConfig(
+					named("exclusiveDC"),
+					nodeSelector(node.Labels),
+					nodeSelectorExpressions(metav1.LabelSelectorRequirement{
+						Key: "matching", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"label"},
+					}),
+				)
 
 				s := scheme.Scheme
-				Expect(hlaiv1alpha1.AddToScheme(s)).ToNot(HaveOccurred())
+				Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
 
 				c := fake.
 					NewClientBuilder().
 					WithScheme(s).
 					WithObjects(node, dc).
 					Build()
-				nsv := NewNodeSelectorValidator(c)
-
-				nodeList, err := nsv.getDeviceConfigSelectedNodes(context.TODO(), dc)
+				nsv := NewValidator(c)
 
+				err := nsv.CheckDeviceConfigForConflictingNodeSelector(context.TODO(), exclusiveDC)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(nodeList.Items).To(HaveLen(1))
-				Expect(testNodeName).To(Equal(nodeList.Items[0].Name))
 			})
 		})
-	})
-})
 
-var _ = Describe("ContainsDuplicates", func() {
-	Context("with a list without duplicates", func() {
-		It("should return false", func() {
-			arr := []string{"apple", "orange"}
+		Context("with a valid nodeSelector", func() {
+			It("should not return an error", func() {
+				nonconflictingDC := makeTestDeviceConfig(named("nonconflictingDC"))
+
+				s := scheme.Scheme
+				Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+				c := fake.
+					NewClientBuilder().
+					WithScheme(s).
+					WithObjects(node, dc, nonconflictingDC).
+					Build()
+				nsv := NewValidator(c)
 
-			Expect(containsDuplicates(arr)).To(BeFalse())
+				err := nsv.CheckDeviceConfigForConflictingNodeSelector(context.TODO(), nonconflictingDC)
+				Expect(err).ToNot(HaveOccurred())
+			})
 		})
-	})
 
-	Context("with a list with duplicates", func() {
-		It("should return true", func() {
-			arr := []string{"apple", "apple"}
+		Context("with the only DeviceConfig selecting the node", func() {
+			It("should not return an error", func() {
+				s := scheme.Scheme
+				Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+				c := fake.
+					NewClientBuilder().
+					WithScheme(s).
+					WithObjects(node, dc).
+					Build()
+				nsv := NewValidator(c)
 
-			Expect(containsDuplicates(arr)).To(BeTrue())
+				err := nsv.CheckDeviceConfigForConflictingNodeSelector(context.TODO(), dc)
+				Expect(err).ToNot(HaveOccurred())
+			})
 		})
 	})
 })
@@ -174,3 +197,31 @@ func makeTestNode(opts ...nodeOptions) *corev1.Node {
 	}
 	return n
 }
+
+func named(name string) deviceConfigOptions {
+	return func(c *hlaiv1beta1.DeviceConfig) {
+		c.ObjectMeta.Name = name
+	}
+}
+
+func nodeSelector(labels map[string]string) deviceConfigOptions {
+	return func(c *hlaiv1beta1.DeviceConfig) {
+		c.Spec.NodeSelector = labels
+	}
+}
+
+type deviceConfigOptions func(*hlaiv1beta1.DeviceConfig)
+
+func makeTestDeviceConfig(opts ...deviceConfigOptions) *hlaiv1beta1.DeviceConfig {
+	c := &hlaiv1beta1.DeviceConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: testDeviceConfigName,
+		},
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
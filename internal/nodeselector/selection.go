@@ -0,0 +1,217 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeselector
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+)
+
+// nodeNameField is the only field supported by node selector terms.
+const nodeNameField = "metadata.name"
+
+var nodeSelectorOperators = map[v1.NodeSelectorOperator]selection.Operator{
+	v1.NodeSelectorOpIn:           selection.In,
+	v1.NodeSelectorOpNotIn:        selection.NotIn,
+	v1.NodeSelectorOpExists:       selection.Exists,
+	v1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	v1.NodeSelectorOpGt:           selection.GreaterThan,
+	v1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// GetLabelSelector returns the selector of the labels of the nodes selected by
+// cr, from its NodeSelector and NodeSelectorExpressions.
+func GetLabelSelector(cr *hlaiv1beta1.DeviceConfig) (labels.Selector, error) {
+	return metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
+		MatchLabels:      cr.GetNodeSelector(),
+		MatchExpressions: cr.Spec.NodeSelectorExpressions,
+	})
+}
+
+// HasRequiredNodeAffinity returns true if cr restricts the selected nodes with
+// required node affinity terms.
+func HasRequiredNodeAffinity(cr *hlaiv1beta1.DeviceConfig) bool {
+	return cr.Spec.NodeAffinity != nil && cr.Spec.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil
+}
+
+// SelectsNode returns true if node matches the label selector, the device
+// type and the required node affinity of cr.
+func SelectsNode(cr *hlaiv1beta1.DeviceConfig, node *v1.Node) (bool, error) {
+	selector, err := GetLabelSelector(cr)
+	if err != nil {
+		return false, fmt.Errorf("invalid node selector: %w", err)
+	}
+
+	if !selector.Matches(labels.Set(node.Labels)) || !matchDeviceType(cr, node) {
+		return false, nil
+	}
+
+	if !HasRequiredNodeAffinity(cr) {
+		return true, nil
+	}
+
+	return MatchNodeSelectorTerms(cr.Spec.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms, node)
+}
+
+// ListSelectedNodes returns the nodes selected by cr. The label selector is
+// applied by the client, the device type and node affinity are matched
+// afterwards.
+func ListSelectedNodes(ctx context.Context, c client.Client, cr *hlaiv1beta1.DeviceConfig) ([]v1.Node, error) {
+	selector, err := GetLabelSelector(cr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node selector: %w", err)
+	}
+
+	nodeList := &v1.NodeList{}
+	if err := c.List(ctx, nodeList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	if cr.Spec.DeviceType == "" && !HasRequiredNodeAffinity(cr) {
+		return nodeList.Items, nil
+	}
+
+	nodes := make([]v1.Node, 0, len(nodeList.Items))
+	for i := range nodeList.Items {
+		selected, err := SelectsNode(cr, &nodeList.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		if selected {
+			nodes = append(nodes, nodeList.Items[i])
+		}
+	}
+
+	return nodes, nil
+}
+
+// matchDeviceType returns true if cr has no device type, or if node has a
+// device of its type.
+func matchDeviceType(cr *hlaiv1beta1.DeviceConfig, node *v1.Node) bool {
+	if cr.Spec.DeviceType == "" {
+		return true
+	}
+
+	for _, l := range cr.Spec.DeviceType.GetNodeFeatureLabels() {
+		if node.Labels[l] == "true" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchNodeSelectorTerms returns true if node matches any of terms, with the
+// scheduler semantics: the requirements of a term are ANDed, and an empty term
+// matches no node.
+func MatchNodeSelectorTerms(terms []v1.NodeSelectorTerm, node *v1.Node) (bool, error) {
+	for _, term := range terms {
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+
+		labelSelector, err := nodeSelectorTermAsSelector(term)
+		if err != nil {
+			return false, err
+		}
+
+		if labelSelector.Matches(labels.Set(node.Labels)) && matchNodeName(term.MatchFields, node.Name) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ValidateNodeSelectorTerm returns an error if term has invalid requirements,
+// or requirements on other fields than the node name.
+func ValidateNodeSelectorTerm(term v1.NodeSelectorTerm) error {
+	_, err := nodeSelectorTermAsSelector(term)
+	return err
+}
+
+// nodeSelectorTermAsSelector returns the selector of the node labels of term,
+// after validating its requirements on the node name. Node names are not
+// label values, so that they are matched by matchNodeName.
+func nodeSelectorTermAsSelector(term v1.NodeSelectorTerm) (labels.Selector, error) {
+	labelSelector, err := nodeSelectorRequirementsAsSelector(term.MatchExpressions)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range term.MatchFields {
+		if r.Key != nodeNameField {
+			return nil, fmt.Errorf("unsupported node field %q, only %q is supported", r.Key, nodeNameField)
+		}
+
+		if r.Operator != v1.NodeSelectorOpIn && r.Operator != v1.NodeSelectorOpNotIn {
+			return nil, fmt.Errorf("unsupported operator %q for node field %q, only %q and %q are supported",
+				r.Operator, nodeNameField, v1.NodeSelectorOpIn, v1.NodeSelectorOpNotIn)
+		}
+
+		if len(r.Values) == 0 {
+			return nil, fmt.Errorf("values must be non-empty for node field %q", nodeNameField)
+		}
+	}
+
+	return labelSelector, nil
+}
+
+// matchNodeName returns true if name matches all the requirements on the node
+// name reqs.
+func matchNodeName(reqs []v1.NodeSelectorRequirement, name string) bool {
+	for _, r := range reqs {
+		found := false
+		for _, v := range r.Values {
+			found = found || v == name
+		}
+
+		if found != (r.Operator == v1.NodeSelectorOpIn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func nodeSelectorRequirementsAsSelector(reqs []v1.NodeSelectorRequirement) (labels.Selector, error) {
+	selector := labels.NewSelector()
+
+	for _, r := range reqs {
+		op, ok := nodeSelectorOperators[r.Operator]
+		if !ok {
+			return nil, fmt.Errorf("invalid node selector operator %q", r.Operator)
+		}
+
+		req, err := labels.NewRequirement(r.Key, op, r.Values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node selector requirement: %w", err)
+		}
+
+		selector = selector.Add(*req)
+	}
+
+	return selector, nil
+}
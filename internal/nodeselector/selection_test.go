@@ -0,0 +1,180 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeselector
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+)
+
+var _ = Describe("SelectsNode", func() {
+	node := makeTestNode(labelled(map[string]string{
+		"matching": "label",
+		"zone":     "a",
+		"feature.node.kubernetes.io/pci-1da3_1010.present": "true",
+	}))
+
+	DescribeTable("should match the labels and the required node affinity",
+		func(dc *hlaiv1beta1.DeviceConfig, expected bool) {
+			selected, err := SelectsNode(dc, node)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(selected).To(Equal(expected))
+		},
+		Entry("matching nodeSelector", makeTestDeviceConfig(nodeSelector(map[string]string{"matching": "label"})), true),
+		Entry("other nodeSelector", makeTestDeviceConfig(nodeSelector(map[string]string{"matching": "other"})), false),
+		Entry("matching expression",
+			makeTestDeviceConfig(
+				nodeSelector(map[string]string{"matching": "label"}),
+				nodeSelectorExpressions(metav1.LabelSelectorRequirement{
+					Key: "zone", Operator: metav1.LabelSelectorOpIn, Values: []string{"a", "b"},
+				}),
+			), true),
+		Entry("excluding expression",
+			makeTestDeviceConfig(
+				nodeSelector(map[string]string{"matching": "label"}),
+				nodeSelectorExpressions(metav1.LabelSelectorRequirement{
+					Key: "zone", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"a"},
+				}),
+			), false),
+		Entry("matching node affinity",
+			makeTestDeviceConfig(
+				nodeSelector(map[string]string{"matching": "label"}),
+				requiredNodeAffinity(
+					corev1.NodeSelectorTerm{MatchFields: []corev1.NodeSelectorRequirement{
+						{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"other-node"}},
+					}},
+					corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+						{Key: "zone", Operator: corev1.NodeSelectorOpExists},
+					}},
+				),
+			), true),
+		Entry("excluding node affinity",
+			makeTestDeviceConfig(
+				nodeSelector(map[string]string{"matching": "label"}),
+				requiredNodeAffinity(
+					corev1.NodeSelectorTerm{MatchFields: []corev1.NodeSelectorRequirement{
+						{Key: "metadata.name", Operator: corev1.NodeSelectorOpNotIn, Values: []string{testNodeName}},
+					}},
+				),
+			), false),
+		Entry("matching device type",
+			makeTestDeviceConfig(deviceType(hlaiv1beta1.DeviceTypeGaudi)), true),
+		Entry("other device type",
+			makeTestDeviceConfig(deviceType(hlaiv1beta1.DeviceTypeGaudi2)), false),
+		Entry("matching device type and nodeSelector",
+			makeTestDeviceConfig(deviceType(hlaiv1beta1.DeviceTypeGaudi), nodeSelector(map[string]string{"zone": "a"})), true),
+		Entry("matching device type and other nodeSelector",
+			makeTestDeviceConfig(deviceType(hlaiv1beta1.DeviceTypeGaudi), nodeSelector(map[string]string{"zone": "b"})), false),
+		Entry("node affinity on a node name that is not a label value",
+			makeTestDeviceConfig(
+				nodeSelector(map[string]string{"matching": "label"}),
+				requiredNodeAffinity(
+					corev1.NodeSelectorTerm{MatchFields: []corev1.NodeSelectorRequirement{
+						{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{
+							"ip-10-0-1-23.eu-west-1.compute.internal.example-cluster.example.com", testNodeName,
+						}},
+					}},
+				),
+			), true),
+		Entry("empty node selector term",
+			makeTestDeviceConfig(
+				nodeSelector(map[string]string{"matching": "label"}),
+				requiredNodeAffinity(corev1.NodeSelectorTerm{}),
+			), false),
+	)
+
+	It("should return an error for an unsupported node field", func() {
+		dc := makeTestDeviceConfig(
+			nodeSelector(map[string]string{"matching": "label"}),
+			requiredNodeAffinity(corev1.NodeSelectorTerm{MatchFields: []corev1.NodeSelectorRequirement{
+				{Key: "spec.unschedulable", Operator: corev1.NodeSelectorOpIn, Values: []string{"true"}},
+			}}),
+		)
+
+		_, err := SelectsNode(dc, node)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should return an error for an unsupported node name operator", func() {
+		dc := makeTestDeviceConfig(
+			nodeSelector(map[string]string{"matching": "label"}),
+			requiredNodeAffinity(corev1.NodeSelectorTerm{MatchFields: []corev1.NodeSelectorRequirement{
+				{Key: "metadata.name", Operator: corev1.NodeSelectorOpExists},
+			}}),
+		)
+
+		_, err := SelectsNode(dc, node)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ListSelectedNodes", func() {
+	It("should return the nodes matching the labels and the required node affinity", func() {
+		node := makeTestNode(labelled(map[string]string{"matching": "label"}))
+		other := makeTestNode(labelled(map[string]string{"matching": "label"}))
+		other.Name = "other-node"
+		unlabelled := makeTestNode()
+		unlabelled.Name = "unlabelled-node"
+
+		dc := makeTestDeviceConfig(
+			nodeSelector(map[string]string{"matching": "label"}),
+			requiredNodeAffinity(corev1.NodeSelectorTerm{MatchFields: []corev1.NodeSelectorRequirement{
+				{Key: "metadata.name", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"other-node"}},
+			}}),
+		)
+
+		s := scheme.Scheme
+		Expect(hlaiv1beta1.AddToScheme(s)).ToNot(HaveOccurred())
+
+		c := fake.
+			NewClientBuilder().
+			WithScheme(s).
+			WithObjects(node, other, unlabelled, dc).
+			Build()
+
+		nodes, err := ListSelectedNodes(context.TODO(), c, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Name).To(Equal(testNodeName))
+	})
+})
+
+func deviceType(t hlaiv1beta1.DeviceType) deviceConfigOptions {
+	return func(c *hlaiv1beta1.DeviceConfig) {
+		c.Spec.DeviceType = t
+	}
+}
+
+func nodeSelectorExpressions(reqs ...metav1.LabelSelectorRequirement) deviceConfigOptions {
+	return func(c *hlaiv1beta1.DeviceConfig) {
+		c.Spec.NodeSelectorExpressions = reqs
+	}
+}
+
+func requiredNodeAffinity(terms ...corev1.NodeSelectorTerm) deviceConfigOptions {
+	return func(c *hlaiv1beta1.DeviceConfig) {
+		c.Spec.NodeAffinity = &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: nodestatus.go
+
+// Package nodestatus is a generated GoMock package.
+package nodestatus
+
+import (
+	context "context"
+	reflect "reflect"
+
+	v1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockUpdater is a mock of Updater interface.
+type MockUpdater struct {
+	ctrl     *gomock.Controller
+	recorder *MockUpdaterMockRecorder
+}
+
+// MockUpdaterMockRecorder is the mock recorder for MockUpdater.
+type MockUpdaterMockRecorder struct {
+	mock *MockUpdater
+}
+
+// NewMockUpdater creates a new mock instance.
+func NewMockUpdater(ctrl *gomock.Controller) *MockUpdater {
+	mock := &MockUpdater{ctrl: ctrl}
+	mock.recorder = &MockUpdaterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockUpdater) EXPECT() *MockUpdaterMockRecorder {
+	return m.recorder
+}
+
+// SetNodesStatus mocks base method.
+func (m *MockUpdater) SetNodesStatus(ctx context.Context, cr *v1beta1.DeviceConfig) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetNodesStatus", ctx, cr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetNodesStatus indicates an expected call of SetNodesStatus.
+func (mr *MockUpdaterMockRecorder) SetNodesStatus(ctx, cr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetNodesStatus", reflect.TypeOf((*MockUpdater)(nil).SetNodesStatus), ctx, cr)
+}
@@ -0,0 +1,313 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodestatus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+	nodeLabeler "github.com/HabanaAI/habana-ai-operator/internal/node/labeler"
+	nodeMetrics "github.com/HabanaAI/habana-ai-operator/internal/node/metrics"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodeselector"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
+	"github.com/HabanaAI/habana-ai-operator/internal/upgrade"
+)
+
+const (
+	// The labels set by KMM on the build and signing Jobs of a Module, along
+	// with module.KMMModuleNameLabel.
+	kmmJobTypeLabel      = "kmm.node.kubernetes.io/job-type"
+	kmmTargetKernelLabel = "kmm.node.kubernetes.io/target-kernel"
+
+	kmmSignJobType = "sign"
+)
+
+//go:generate mockgen -source=nodestatus.go -package=nodestatus -destination=mock_nodestatus.go
+
+type Updater interface {
+	SetNodesStatus(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error
+}
+
+type updater struct {
+	client client.Client
+	// reader lists the KMM pods, which the manager cache, restricted to the
+	// operand pods, does not hold.
+	reader client.Reader
+}
+
+func NewUpdater(c client.Client, reader client.Reader) Updater {
+	return &updater{client: c, reader: reader}
+}
+
+// SetNodesStatus fills the node counts, components and nodes of cr.Status,
+// from the selected nodes and the pods of each component. The status is
+// written along with the DeviceConfig conditions.
+func (u *updater) SetNodesStatus(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	nodes, err := nodeselector.ListSelectedNodes(ctx, u.client, cr)
+	if err != nil {
+		return fmt.Errorf("failed to list selected nodes: %w", err)
+	}
+
+	// A Module is deployed per driver version during an upgrade.
+	modules, err := module.ListModules(ctx, u.client, cr)
+	if err != nil {
+		return err
+	}
+
+	versions := make(map[string]string, len(modules))
+	driver := hlaiv1beta1.ComponentStatus{}
+	devicePlugin := hlaiv1beta1.ComponentStatus{}
+	for i := range modules {
+		m := &modules[i]
+		versions[m.Name] = module.GetModuleVersion(m, cr)
+		addKMMStatus(&driver, m.Status.ModuleLoader)
+		addKMMStatus(&devicePlugin, m.Status.DevicePlugin)
+	}
+
+	driverPods, err := u.getKMMPods(ctx, cr, modules, module.KMMModuleLoaderRole)
+	if err != nil {
+		return err
+	}
+
+	devicePluginPods, err := u.getKMMPods(ctx, cr, modules, module.KMMDevicePluginRole)
+	if err != nil {
+		return err
+	}
+
+	nodeLabelerStatus, nodeLabelerPods, err := u.getDaemonSet(ctx, cr.Namespace, nodeLabeler.GetNodeLabelerName(cr))
+	if err != nil {
+		return err
+	}
+
+	nodeMetricsStatus, nodeMetricsPods, err := u.getDaemonSet(ctx, cr.Namespace, nodeMetrics.GetNodeMetricsName(cr))
+	if err != nil {
+		return err
+	}
+
+	cr.Status.SignFailures, err = u.getSignFailures(ctx, cr, modules)
+	if err != nil {
+		return err
+	}
+
+	signFailures := make(map[string]*hlaiv1beta1.SignFailure, len(cr.Status.SignFailures))
+	for i := range cr.Status.SignFailures {
+		signFailures[cr.Status.SignFailures[i].Kernel] = &cr.Status.SignFailures[i]
+	}
+
+	cr.Status.Components = hlaiv1beta1.ComponentsStatus{
+		Driver:       driver,
+		DevicePlugin: devicePlugin,
+		NodeLabeler:  nodeLabelerStatus,
+		NodeMetrics:  nodeMetricsStatus,
+	}
+
+	cr.Status.Nodes = make([]hlaiv1beta1.NodeStatus, 0, len(nodes))
+	cr.Status.MatchedNodes = int32(len(nodes))
+	cr.Status.ReadyNodes = 0
+	cr.Status.FailedNodes = 0
+
+	for i := range nodes {
+		n := &nodes[i]
+		ns := hlaiv1beta1.NodeStatus{Name: n.Name, KernelVersion: n.Status.NodeInfo.KernelVersion}
+		failures := []string{}
+
+		// KMM does not schedule the driver on a node whose kernel matches no
+		// kernel mapping, so it would never get ready.
+		km, err := cr.Spec.Driver.FindKernelMapping(ns.KernelVersion)
+		switch {
+		case err != nil:
+			failures = append(failures, fmt.Sprintf("driver: %v", err))
+		case km == nil:
+			failures = append(failures, fmt.Sprintf("driver: kernel %s matches no kernel mapping", ns.KernelVersion))
+		default:
+			ns.KernelSupported = true
+		}
+
+		// KMM does not load an unsigned driver image, and does not retry a
+		// failed signing Job until the Module changes.
+		if f, ok := signFailures[ns.KernelVersion]; ok {
+			failures = append(failures, fmt.Sprintf("driver: signing failed: %s", f.Message))
+		}
+
+		inspect := func(name string, byNode map[string]*corev1.Pod) bool {
+			p, ok := byNode[n.Name]
+			if !ok {
+				return false
+			}
+			if reason := pods.FailureReason(p); reason != "" {
+				failures = append(failures, fmt.Sprintf("%s: %s", name, reason))
+			}
+			return pods.IsReady(p)
+		}
+
+		ns.DriverLoaded = inspect("driver", driverPods)
+		if p, ok := driverPods[n.Name]; ok {
+			ns.DriverVersion = versions[p.Labels[module.KMMModuleNameLabel]]
+		}
+		// KMM replaces the driver pods loaded with a previous modprobe
+		// configuration one node at a time, reloading the driver.
+		if p, ok := driverPods[n.Name]; ok && cr.Status.ModprobeConfigUpdateTime != nil {
+			ns.DriverReloadPending = p.CreationTimestamp.Before(cr.Status.ModprobeConfigUpdateTime)
+		}
+		ns.DevicePluginReady = inspect("device plugin", devicePluginPods)
+		ns.NodeLabelerReady = inspect("node labeler", nodeLabelerPods)
+		ns.NodeMetricsReady = inspect("node metrics", nodeMetricsPods)
+
+		if q, ok := n.Status.Allocatable[cr.GetDeviceType().GetResourceName()]; ok {
+			ns.HPUs = q.Value()
+		}
+
+		// A failed upgrade is left as is until an administrator retries it,
+		// and the nodes being upgraded are not ready until they are done.
+		ns.UpgradeState = hlaiv1beta1.NodeUpgradeState(n.Labels[upgrade.StateLabel])
+		upgrading := false
+		switch ns.UpgradeState {
+		case "", hlaiv1beta1.NodeUpgradeStateDone:
+		case hlaiv1beta1.NodeUpgradeStateFailed:
+			failures = append(failures, fmt.Sprintf("upgrade: %s", n.Annotations[upgrade.MessageAnnotation]))
+		default:
+			upgrading = true
+		}
+
+		switch {
+		case len(failures) > 0:
+			ns.State = hlaiv1beta1.NodeStateFailed
+			ns.Message = strings.Join(failures, ", ")
+			cr.Status.FailedNodes++
+		case !upgrading && ns.DriverLoaded && !ns.DriverReloadPending && ns.DevicePluginReady && ns.NodeLabelerReady && ns.NodeMetricsReady:
+			ns.State = hlaiv1beta1.NodeStateReady
+			cr.Status.ReadyNodes++
+		default:
+			ns.State = hlaiv1beta1.NodeStateProgressing
+		}
+
+		cr.Status.Nodes = append(cr.Status.Nodes, ns)
+	}
+
+	sort.Slice(cr.Status.Nodes, func(i, j int) bool {
+		return cr.Status.Nodes[i].Name < cr.Status.Nodes[j].Name
+	})
+
+	return nil
+}
+
+// addKMMStatus adds the rollout state of a Module DaemonSet to cs.
+func addKMMStatus(cs *hlaiv1beta1.ComponentStatus, s kmmv1beta1.DaemonSetStatus) {
+	cs.NodesMatchingSelectorNumber += s.NodesMatchingSelectorNumber
+	cs.DesiredNumber += s.DesiredNumber
+	cs.AvailableNumber += s.AvailableNumber
+}
+
+// getKMMPods returns the pods of the Module DaemonSets with role indexed by
+// node.
+func (u *updater) getKMMPods(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, modules []kmmv1beta1.Module, role string) (map[string]*corev1.Pod, error) {
+	kmmPods, err := module.ListPods(ctx, u.reader, cr, modules, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s pods: %w", role, err)
+	}
+
+	return pods.ByNode(kmmPods), nil
+}
+
+// getSignFailures returns the failed KMM signing Jobs of the driver images,
+// sorted by kernel. KMM does not report them in the Module status.
+func (u *updater) getSignFailures(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, modules []kmmv1beta1.Module) ([]hlaiv1beta1.SignFailure, error) {
+	if cr.Spec.Driver.Sign == nil || len(modules) == 0 {
+		return nil, nil
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{kmmJobTypeLabel: kmmSignJobType})
+	req, err := labels.NewRequirement(module.KMMModuleNameLabel, selection.In, module.GetModuleNames(modules))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Module names: %w", err)
+	}
+
+	jobList := &batchv1.JobList{}
+	opts := []client.ListOption{
+		client.InNamespace(cr.Namespace),
+		client.MatchingLabelsSelector{Selector: selector.Add(*req)},
+	}
+	if err := u.client.List(ctx, jobList, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list signing jobs: %w", err)
+	}
+
+	failures := []hlaiv1beta1.SignFailure{}
+	for _, j := range jobList.Items {
+		if j.Status.Failed == 0 {
+			continue
+		}
+
+		f := hlaiv1beta1.SignFailure{
+			Kernel:  j.Labels[kmmTargetKernelLabel],
+			Job:     j.Name,
+			Message: fmt.Sprintf("job %s failed", j.Name),
+		}
+		for _, c := range j.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue && c.Message != "" {
+				f.Message = fmt.Sprintf("job %s failed: %s", j.Name, c.Message)
+			}
+		}
+
+		failures = append(failures, f)
+	}
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Kernel < failures[j].Kernel
+	})
+
+	return failures, nil
+}
+
+// getDaemonSet returns the rollout state of an operand DaemonSet and its pods
+// indexed by node. A missing DaemonSet has no pods.
+func (u *updater) getDaemonSet(ctx context.Context, namespace, name string) (hlaiv1beta1.ComponentStatus, map[string]*corev1.Pod, error) {
+	ds := &appsv1.DaemonSet{}
+	err := u.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, ds)
+	if apierrors.IsNotFound(err) {
+		return hlaiv1beta1.ComponentStatus{}, map[string]*corev1.Pod{}, nil
+	}
+	if err != nil {
+		return hlaiv1beta1.ComponentStatus{}, nil, fmt.Errorf("failed to get DaemonSet %s: %w", name, err)
+	}
+
+	status := hlaiv1beta1.ComponentStatus{
+		DesiredNumber:   ds.Status.DesiredNumberScheduled,
+		AvailableNumber: ds.Status.NumberAvailable,
+	}
+
+	byNode, err := pods.ListDaemonSetPods(ctx, u.client, ds)
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, byNode, nil
+}
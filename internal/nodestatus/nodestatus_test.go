@@ -0,0 +1,510 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodestatus
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	gomock "github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/client"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+	nodeLabeler "github.com/HabanaAI/habana-ai-operator/internal/node/labeler"
+	nodeMetrics "github.com/HabanaAI/habana-ai-operator/internal/node/metrics"
+	"github.com/HabanaAI/habana-ai-operator/internal/upgrade"
+	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+)
+
+const (
+	testNamespace = "a-namespace"
+
+	testLabelKey   = "habana.ai/hpu.gaudi.present"
+	testLabelValue = "true"
+
+	testKernelVersion = "4.18.0-372.26.1.el8_6.x86_64"
+)
+
+var _ = Describe("NodeStatusUpdater", func() {
+	var (
+		ctx context.Context
+		s   *runtime.Scheme
+		dc  *hlaiv1beta1.DeviceConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+
+		s = scheme.Scheme
+		Expect(hlaiv1beta1.AddToScheme(s)).To(Succeed())
+		Expect(kmmv1beta1.AddToScheme(s)).To(Succeed())
+
+		dc = &hlaiv1beta1.DeviceConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-device-config", Namespace: testNamespace, UID: "a-uid"},
+			Spec: hlaiv1beta1.DeviceConfigSpec{
+				NodeSelector: map[string]string{testLabelKey: testLabelValue},
+			},
+		}
+	})
+
+	Describe("SetNodesStatus", func() {
+		Context("with no component deployed yet", func() {
+			It("should report the selected nodes as progressing", func() {
+				unselected := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unselected-node"}}
+				c := fake.NewClientBuilder().
+					WithScheme(s).
+					WithObjects(makeNode("node-1", 0), unselected).
+					Build()
+
+				Expect(NewUpdater(c, c).SetNodesStatus(ctx, dc)).To(Succeed())
+
+				Expect(dc.Status.MatchedNodes).To(Equal(int32(1)))
+				Expect(dc.Status.ReadyNodes).To(BeZero())
+				Expect(dc.Status.FailedNodes).To(BeZero())
+				Expect(dc.Status.Nodes).To(Equal([]hlaiv1beta1.NodeStatus{
+					{Name: "node-1", State: hlaiv1beta1.NodeStateProgressing, KernelVersion: testKernelVersion, KernelSupported: true},
+				}))
+			})
+		})
+
+		Context("with a node kernel matching no kernel mapping", func() {
+			It("should report the node as failed", func() {
+				dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{{Preset: hlaiv1beta1.KernelMappingPresetUbuntu}}
+
+				ubuntu := makeNode("ubuntu-node", 0)
+				ubuntu.Status.NodeInfo.KernelVersion = "5.15.0-76-generic"
+				c := fake.NewClientBuilder().
+					WithScheme(s).
+					WithObjects(makeNode("rhcos-node", 0), ubuntu).
+					Build()
+
+				Expect(NewUpdater(c, c).SetNodesStatus(ctx, dc)).To(Succeed())
+
+				Expect(dc.Status.FailedNodes).To(Equal(int32(1)))
+				Expect(dc.Status.Nodes).To(Equal([]hlaiv1beta1.NodeStatus{
+					{
+						Name:          "rhcos-node",
+						State:         hlaiv1beta1.NodeStateFailed,
+						KernelVersion: testKernelVersion,
+						Message:       "driver: kernel " + testKernelVersion + " matches no kernel mapping",
+					},
+					{
+						Name:            "ubuntu-node",
+						State:           hlaiv1beta1.NodeStateProgressing,
+						KernelVersion:   "5.15.0-76-generic",
+						KernelSupported: true,
+					},
+				}))
+			})
+		})
+
+		Context("with a failed signing job", func() {
+			It("should report the signing failure and the nodes of its kernel as failed", func() {
+				dc.Spec.Driver.Sign = &hlaiv1beta1.DriverSignSpec{
+					KeySecret:  corev1.LocalObjectReference{Name: "signing-key"},
+					CertSecret: corev1.LocalObjectReference{Name: "signing-cert"},
+				}
+
+				ubuntu := makeNode("ubuntu-node", 0)
+				ubuntu.Status.NodeInfo.KernelVersion = "5.15.0-76-generic"
+				c := fake.NewClientBuilder().
+					WithScheme(s).
+					WithObjects(
+						makeNode("rhcos-node", 0),
+						ubuntu,
+						makeModule(dc, module.GetModuleName(dc), ""),
+						makeSignJob("failed-job", dc, testKernelVersion, &batchv1.JobCondition{
+							Type:    batchv1.JobFailed,
+							Status:  corev1.ConditionTrue,
+							Message: "Job has reached the specified backoff limit",
+						}),
+						makeSignJob("active-job", dc, "5.15.0-76-generic", nil),
+					).
+					Build()
+
+				Expect(NewUpdater(c, c).SetNodesStatus(ctx, dc)).To(Succeed())
+
+				Expect(dc.Status.SignFailures).To(Equal([]hlaiv1beta1.SignFailure{
+					{
+						Kernel:  testKernelVersion,
+						Job:     "failed-job",
+						Message: "job failed-job failed: Job has reached the specified backoff limit",
+					},
+				}))
+				Expect(dc.Status.FailedNodes).To(Equal(int32(1)))
+				Expect(dc.Status.Nodes[0].Name).To(Equal("rhcos-node"))
+				Expect(dc.Status.Nodes[0].State).To(Equal(hlaiv1beta1.NodeStateFailed))
+				Expect(dc.Status.Nodes[0].Message).To(Equal(
+					"driver: signing failed: job failed-job failed: Job has reached the specified backoff limit"))
+				Expect(dc.Status.Nodes[1].State).To(Equal(hlaiv1beta1.NodeStateProgressing))
+			})
+
+			It("should ignore the signing jobs without driver signing", func() {
+				c := fake.NewClientBuilder().
+					WithScheme(s).
+					WithObjects(
+						makeNode("rhcos-node", 0),
+						makeSignJob("failed-job", dc, testKernelVersion, &batchv1.JobCondition{
+							Type:   batchv1.JobFailed,
+							Status: corev1.ConditionTrue,
+						}),
+					).
+					Build()
+
+				Expect(NewUpdater(c, c).SetNodesStatus(ctx, dc)).To(Succeed())
+
+				Expect(dc.Status.SignFailures).To(BeNil())
+				Expect(dc.Status.FailedNodes).To(BeZero())
+			})
+		})
+
+		Context("with a device type", func() {
+			It("should only report the nodes with devices of its type and their HPUs", func() {
+				dc.Spec.DeviceType = hlaiv1beta1.DeviceTypeGaudi2
+
+				gaudi := makeNode("gaudi-node", 8)
+				gaudi.Labels["feature.node.kubernetes.io/pci-1da3_1000.present"] = "true"
+				gaudi2 := makeNode("gaudi2-node", 0)
+				gaudi2.Labels["feature.node.kubernetes.io/pci-1da3_1020.present"] = "true"
+				gaudi2.Status.Allocatable = corev1.ResourceList{
+					hlaiv1beta1.DeviceTypeGaudi2.GetResourceName(): *resource.NewQuantity(8, resource.DecimalSI),
+				}
+
+				c := fake.NewClientBuilder().
+					WithScheme(s).
+					WithObjects(gaudi, gaudi2).
+					Build()
+
+				Expect(NewUpdater(c, c).SetNodesStatus(ctx, dc)).To(Succeed())
+
+				Expect(dc.Status.MatchedNodes).To(Equal(int32(1)))
+				Expect(dc.Status.Nodes).To(Equal([]hlaiv1beta1.NodeStatus{
+					{
+						Name:            "gaudi2-node",
+						State:           hlaiv1beta1.NodeStateProgressing,
+						HPUs:            8,
+						KernelVersion:   testKernelVersion,
+						KernelSupported: true,
+					},
+				}))
+			})
+		})
+
+		Context("with deployed components", func() {
+			var (
+				c ctrlclient.Client
+			)
+
+			BeforeEach(func() {
+				m := makeModule(dc, module.GetModuleName(dc), "")
+				m.Status = kmmv1beta1.ModuleStatus{
+					ModuleLoader: kmmv1beta1.DaemonSetStatus{NodesMatchingSelectorNumber: 3, DesiredNumber: 3, AvailableNumber: 2},
+					DevicePlugin: kmmv1beta1.DaemonSetStatus{NodesMatchingSelectorNumber: 3, DesiredNumber: 3, AvailableNumber: 1},
+				}
+
+				labelerDS := makeDaemonSet(nodeLabeler.GetNodeLabelerName(dc), "node-labeler", 3, 3)
+				metricsDS := makeDaemonSet(nodeMetrics.GetNodeMetricsName(dc), "node-metrics", 3, 2)
+
+				objs := []ctrlclient.Object{
+					makeNode("node-1", 8),
+					makeNode("node-2", 0),
+					makeNode("node-3", 0),
+					m, labelerDS, metricsDS,
+				}
+
+				for _, n := range []string{"node-1", "node-2", "node-3"} {
+					objs = append(objs, makeKMMPod("driver-"+n, n, dc, module.KMMModuleLoaderRole, ""))
+					objs = append(objs, makeLabelledPod("labeler-"+n, n, "node-labeler", ""))
+				}
+				objs = append(objs,
+					makeKMMPod("device-plugin-node-1", "node-1", dc, module.KMMDevicePluginRole, ""),
+					makeKMMPod("device-plugin-node-3", "node-3", dc, module.KMMDevicePluginRole, "CrashLoopBackOff"),
+					makeLabelledPod("metrics-node-1", "node-1", "node-metrics", ""),
+					makeLabelledPod("metrics-node-3", "node-3", "node-metrics", "ImagePullBackOff"),
+				)
+
+				c = fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build()
+
+				Expect(NewUpdater(c, c).SetNodesStatus(ctx, dc)).To(Succeed())
+			})
+
+			It("should set the node counts", func() {
+				Expect(dc.Status.MatchedNodes).To(Equal(int32(3)))
+				Expect(dc.Status.ReadyNodes).To(Equal(int32(1)))
+				Expect(dc.Status.FailedNodes).To(Equal(int32(1)))
+			})
+
+			It("should set the components rollout state", func() {
+				Expect(dc.Status.Components).To(Equal(hlaiv1beta1.ComponentsStatus{
+					Driver:       hlaiv1beta1.ComponentStatus{NodesMatchingSelectorNumber: 3, DesiredNumber: 3, AvailableNumber: 2},
+					DevicePlugin: hlaiv1beta1.ComponentStatus{NodesMatchingSelectorNumber: 3, DesiredNumber: 3, AvailableNumber: 1},
+					NodeLabeler:  hlaiv1beta1.ComponentStatus{DesiredNumber: 3, AvailableNumber: 3},
+					NodeMetrics:  hlaiv1beta1.ComponentStatus{DesiredNumber: 3, AvailableNumber: 2},
+				}))
+			})
+
+			It("should set the state of each node", func() {
+				Expect(dc.Status.Nodes).To(Equal([]hlaiv1beta1.NodeStatus{
+					{
+						Name:              "node-1",
+						State:             hlaiv1beta1.NodeStateReady,
+						KernelVersion:     testKernelVersion,
+						KernelSupported:   true,
+						DriverLoaded:      true,
+						DevicePluginReady: true,
+						NodeLabelerReady:  true,
+						NodeMetricsReady:  true,
+						HPUs:              8,
+					},
+					{
+						Name:             "node-2",
+						State:            hlaiv1beta1.NodeStateProgressing,
+						KernelVersion:    testKernelVersion,
+						KernelSupported:  true,
+						DriverLoaded:     true,
+						NodeLabelerReady: true,
+					},
+					{
+						Name:             "node-3",
+						State:            hlaiv1beta1.NodeStateFailed,
+						KernelVersion:    testKernelVersion,
+						KernelSupported:  true,
+						DriverLoaded:     true,
+						NodeLabelerReady: true,
+						Message:          "device plugin: CrashLoopBackOff, node metrics: ImagePullBackOff",
+					},
+				}))
+			})
+		})
+
+		Context("with a modprobe configuration change", func() {
+			It("should report the nodes whose driver was loaded before it as pending a reload", func() {
+				changed := metav1.NewTime(time.Now().Add(-time.Minute))
+				dc.Status.ModprobeConfigUpdateTime = &changed
+
+				before := makeKMMPod("driver-node-1", "node-1", dc, module.KMMModuleLoaderRole, "")
+				before.CreationTimestamp = metav1.NewTime(changed.Add(-time.Hour))
+				after := makeKMMPod("driver-node-2", "node-2", dc, module.KMMModuleLoaderRole, "")
+				after.CreationTimestamp = metav1.NewTime(changed.Add(time.Second))
+
+				c := fake.NewClientBuilder().
+					WithScheme(s).
+					WithObjects(makeNode("node-1", 0), makeNode("node-2", 0), makeModule(dc, module.GetModuleName(dc), ""), before, after).
+					Build()
+
+				Expect(NewUpdater(c, c).SetNodesStatus(ctx, dc)).To(Succeed())
+
+				Expect(dc.Status.Nodes[0].DriverLoaded).To(BeTrue())
+				Expect(dc.Status.Nodes[0].DriverReloadPending).To(BeTrue())
+				Expect(dc.Status.Nodes[1].DriverLoaded).To(BeTrue())
+				Expect(dc.Status.Nodes[1].DriverReloadPending).To(BeFalse())
+			})
+		})
+
+		Context("with a driver upgrade", func() {
+			It("should report the version and upgrade state of each node", func() {
+				dc.Spec.Driver.Version = "1.1.0"
+
+				previous := makeModule(dc, module.GetModuleName(dc), "1.0.0")
+				previous.Status.ModuleLoader = kmmv1beta1.DaemonSetStatus{NodesMatchingSelectorNumber: 2, DesiredNumber: 2, AvailableNumber: 2}
+				current := makeModule(dc, module.GetModuleName(dc)+"-1", "1.1.0")
+				current.Status.ModuleLoader = kmmv1beta1.DaemonSetStatus{NodesMatchingSelectorNumber: 1, DesiredNumber: 1, AvailableNumber: 1}
+
+				upgraded := makeNode("node-1", 8)
+				upgraded.Labels[upgrade.StateLabel] = string(hlaiv1beta1.NodeUpgradeStateDone)
+				draining := makeNode("node-2", 8)
+				draining.Labels[upgrade.StateLabel] = string(hlaiv1beta1.NodeUpgradeStateDrainRequired)
+				failed := makeNode("node-3", 8)
+				failed.Labels[upgrade.StateLabel] = string(hlaiv1beta1.NodeUpgradeStateFailed)
+				failed.Annotations = map[string]string{upgrade.MessageAnnotation: "drain timed out"}
+
+				objs := []ctrlclient.Object{upgraded, draining, failed, previous, current}
+				for _, n := range []string{"node-1", "node-2", "node-3"} {
+					m := previous
+					if n == "node-1" {
+						m = current
+					}
+					for _, role := range []string{module.KMMModuleLoaderRole, module.KMMDevicePluginRole} {
+						p := makeKMMPod(role+"-"+n, n, dc, role, "")
+						p.Labels[module.KMMModuleNameLabel] = m.Name
+						objs = append(objs, p)
+					}
+					objs = append(objs,
+						makeLabelledPod("labeler-"+n, n, "node-labeler", ""),
+						makeLabelledPod("metrics-"+n, n, "node-metrics", ""),
+					)
+				}
+
+				c := fake.NewClientBuilder().
+					WithScheme(s).
+					WithObjects(append(objs,
+						makeDaemonSet(nodeLabeler.GetNodeLabelerName(dc), "node-labeler", 3, 3),
+						makeDaemonSet(nodeMetrics.GetNodeMetricsName(dc), "node-metrics", 3, 3),
+					)...).
+					Build()
+
+				Expect(NewUpdater(c, c).SetNodesStatus(ctx, dc)).To(Succeed())
+
+				Expect(dc.Status.Components.Driver).To(Equal(
+					hlaiv1beta1.ComponentStatus{NodesMatchingSelectorNumber: 3, DesiredNumber: 3, AvailableNumber: 3}))
+
+				Expect(dc.Status.Nodes[0].State).To(Equal(hlaiv1beta1.NodeStateReady))
+				Expect(dc.Status.Nodes[0].DriverVersion).To(Equal("1.1.0"))
+				Expect(dc.Status.Nodes[0].UpgradeState).To(Equal(hlaiv1beta1.NodeUpgradeStateDone))
+
+				Expect(dc.Status.Nodes[1].State).To(Equal(hlaiv1beta1.NodeStateProgressing))
+				Expect(dc.Status.Nodes[1].DriverVersion).To(Equal("1.0.0"))
+				Expect(dc.Status.Nodes[1].UpgradeState).To(Equal(hlaiv1beta1.NodeUpgradeStateDrainRequired))
+
+				Expect(dc.Status.Nodes[2].State).To(Equal(hlaiv1beta1.NodeStateFailed))
+				Expect(dc.Status.Nodes[2].Message).To(Equal("upgrade: drain timed out"))
+			})
+		})
+
+		Context("with a client listing error", func() {
+			It("should return an error", func() {
+				c := client.NewMockClient(gomock.NewController(GinkgoT()))
+				c.EXPECT().List(ctx, gomock.Any(), gomock.Any()).Return(errors.New("some-error"))
+
+				err := NewUpdater(c, c).SetNodesStatus(ctx, dc)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("some-error"))
+			})
+		})
+	})
+})
+
+func makeNode(name string, hpus int64) *corev1.Node {
+	n := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{testLabelKey: testLabelValue},
+		},
+		Status: corev1.NodeStatus{
+			NodeInfo: corev1.NodeSystemInfo{KernelVersion: testKernelVersion},
+		},
+	}
+
+	if hpus > 0 {
+		n.Status.Allocatable = corev1.ResourceList{
+			hlaiv1beta1.DeviceTypeGaudi.GetResourceName(): *resource.NewQuantity(hpus, resource.DecimalSI),
+		}
+	}
+
+	return n
+}
+
+// makeModule returns a Module of dc, of version if not empty.
+func makeModule(dc *hlaiv1beta1.DeviceConfig, name, version string) *kmmv1beta1.Module {
+	m := &kmmv1beta1.Module{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: dc.Namespace},
+	}
+	Expect(controllerutil.SetControllerReference(dc, m, scheme.Scheme)).To(Succeed())
+
+	if version != "" {
+		m.Annotations = map[string]string{module.DriverVersionAnnotation: version}
+	}
+
+	return m
+}
+
+func makeDaemonSet(name, component string, desired, available int32) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app.kubernetes.io/component": component},
+			},
+		},
+		Status: appsv1.DaemonSetStatus{
+			DesiredNumberScheduled: desired,
+			NumberAvailable:        available,
+		},
+	}
+}
+
+func makeKMMPod(name, nodeName string, dc *hlaiv1beta1.DeviceConfig, role, waitingReason string) *corev1.Pod {
+	p := makePod(name, nodeName, waitingReason)
+	p.Labels = map[string]string{
+		module.KMMModuleNameLabel: module.GetModuleName(dc),
+		module.KMMRoleLabel:       role,
+	}
+	return p
+}
+
+// makeSignJob returns a KMM signing Job, failed if it has a condition.
+func makeSignJob(name string, dc *hlaiv1beta1.DeviceConfig, kernel string, c *batchv1.JobCondition) *batchv1.Job {
+	j := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: dc.Namespace,
+			Labels: map[string]string{
+				module.KMMModuleNameLabel: module.GetModuleName(dc),
+				kmmJobTypeLabel:           kmmSignJobType,
+				kmmTargetKernelLabel:      kernel,
+			},
+		},
+		Status: batchv1.JobStatus{Active: 1},
+	}
+
+	if c != nil {
+		j.Status = batchv1.JobStatus{Failed: 1, Conditions: []batchv1.JobCondition{*c}}
+	}
+
+	return j
+}
+
+func makeLabelledPod(name, nodeName, component, waitingReason string) *corev1.Pod {
+	p := makePod(name, nodeName, waitingReason)
+	p.Labels = map[string]string{"app.kubernetes.io/component": component}
+	return p
+}
+
+func makePod(name, nodeName, waitingReason string) *corev1.Pod {
+	p := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+	}
+
+	if waitingReason != "" {
+		p.Status = corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: waitingReason}}},
+			},
+		}
+		return p
+	}
+
+	p.Status = corev1.PodStatus{
+		Phase:      corev1.PodRunning,
+		Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+	}
+	return p
+}
@@ -0,0 +1,64 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: nodetargets.go
+
+// Package nodetargets is a generated GoMock package.
+package nodetargets
+
+import (
+	context "context"
+	reflect "reflect"
+
+	v1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockUpdater is a mock of Updater interface.
+type MockUpdater struct {
+	ctrl     *gomock.Controller
+	recorder *MockUpdaterMockRecorder
+}
+
+// MockUpdaterMockRecorder is the mock recorder for MockUpdater.
+type MockUpdaterMockRecorder struct {
+	mock *MockUpdater
+}
+
+// NewMockUpdater creates a new mock instance.
+func NewMockUpdater(ctrl *gomock.Controller) *MockUpdater {
+	mock := &MockUpdater{ctrl: ctrl}
+	mock.recorder = &MockUpdaterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockUpdater) EXPECT() *MockUpdaterMockRecorder {
+	return m.recorder
+}
+
+// ClearTargetNodes mocks base method.
+func (m *MockUpdater) ClearTargetNodes(ctx context.Context, cr *v1beta1.DeviceConfig) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClearTargetNodes", ctx, cr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ClearTargetNodes indicates an expected call of ClearTargetNodes.
+func (mr *MockUpdaterMockRecorder) ClearTargetNodes(ctx, cr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearTargetNodes", reflect.TypeOf((*MockUpdater)(nil).ClearTargetNodes), ctx, cr)
+}
+
+// SetTargetNodes mocks base method.
+func (m *MockUpdater) SetTargetNodes(ctx context.Context, cr *v1beta1.DeviceConfig) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetTargetNodes", ctx, cr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetTargetNodes indicates an expected call of SetTargetNodes.
+func (mr *MockUpdaterMockRecorder) SetTargetNodes(ctx, cr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTargetNodes", reflect.TypeOf((*MockUpdater)(nil).SetTargetNodes), ctx, cr)
+}
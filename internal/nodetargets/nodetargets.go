@@ -0,0 +1,317 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodetargets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodeselector"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
+)
+
+const (
+	// TargetLabel is set by the operator on the nodes selected by a
+	// DeviceConfig. The KMM Modules select them by it, see module, and so do
+	// the DaemonSets of the DeviceConfigs using node selector expressions, a
+	// node affinity or a device type.
+	TargetLabel = "habana.ai/deviceconfig"
+
+	// LeavingAnnotation records when a node stopped being selected by its
+	// DeviceConfig. It keeps TargetLabel, and so its driver, until the pods
+	// using its HPUs are gone.
+	LeavingAnnotation = "habana.ai/deviceconfig-leaving"
+
+	// cordonedAnnotation marks the nodes cordoned while leaving, which are
+	// uncordoned once they left. The nodes cordoned by an administrator are
+	// left cordoned.
+	cordonedAnnotation = "habana.ai/deviceconfig-leaving-cordoned"
+
+	// requeueAfter is how often the leaving nodes are checked, since the pods
+	// they wait for are not watched.
+	requeueAfter = 10 * time.Second
+)
+
+//go:generate mockgen -source=nodetargets.go -package=nodetargets -destination=mock_nodetargets.go
+
+type Updater interface {
+	SetTargetNodes(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error
+	ClearTargetNodes(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error
+}
+
+type updater struct {
+	client client.Client
+	// reader lists the pods of all the namespaces, which the manager cache,
+	// restricted to the operator namespace, does not hold.
+	reader   client.Reader
+	recorder record.EventRecorder
+}
+
+func NewUpdater(client client.Client, reader client.Reader, recorder record.EventRecorder) Updater {
+	return &updater{client: client, reader: reader, recorder: recorder}
+}
+
+// RequeueAfter returns when to check the nodes leaving cr again, or 0 if none
+// is.
+func RequeueAfter(cr *hlaiv1beta1.DeviceConfig) time.Duration {
+	if len(cr.Status.LeavingNodes) > 0 {
+		return requeueAfter
+	}
+
+	return 0
+}
+
+// UsesTargetLabel returns true if the nodes selected by cr cannot be expressed
+// by a set of labels, and are selected by TargetLabel instead.
+func UsesTargetLabel(cr *hlaiv1beta1.DeviceConfig) bool {
+	return len(cr.Spec.NodeSelectorExpressions) > 0 || nodeselector.HasRequiredNodeAffinity(cr) || cr.Spec.DeviceType != ""
+}
+
+// GetTargetLabelValue returns the value of TargetLabel on the nodes selected by cr.
+func GetTargetLabelValue(cr *hlaiv1beta1.DeviceConfig) string {
+	return instance.LabelValue(fmt.Sprintf("%s.%s", cr.Namespace, cr.Name))
+}
+
+// GetNodeSelector returns the node selector of the DaemonSets of cr. Node
+// selectors only support a set of labels, so the nodes selected with
+// expressions, a node affinity or a device type, whose PCI device IDs are
+// ORed, are selected with TargetLabel. The other DeviceConfigs keep their own
+// node selector, so that their DaemonSets are not restarted when the operator
+// upgrades.
+func GetNodeSelector(cr *hlaiv1beta1.DeviceConfig) map[string]string {
+	if !UsesTargetLabel(cr) {
+		return cr.GetNodeSelector()
+	}
+
+	return map[string]string{TargetLabel: GetTargetLabelValue(cr)}
+}
+
+// SetTargetNodes labels the nodes selected by cr with TargetLabel, and removes
+// it from the nodes cr no longer selects once they are drained, see leave. The
+// nodes still leaving are recorded in cr.Status.LeavingNodes.
+func (u *updater) SetTargetNodes(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	nodeList := &v1.NodeList{}
+	if err := u.client.List(ctx, nodeList); err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	value := GetTargetLabelValue(cr)
+	leaving := []hlaiv1beta1.LeavingNode{}
+	for i := range nodeList.Items {
+		n := &nodeList.Items[i]
+
+		selected, err := nodeselector.SelectsNode(cr, n)
+		if err != nil {
+			return err
+		}
+
+		labelled := n.Labels[TargetLabel] == value
+		if selected && labelled {
+			if err := u.stay(ctx, cr, n); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !selected && !labelled {
+			continue
+		}
+
+		// Leave the nodes targeted by another DeviceConfig alone, the
+		// conflict is reported on the DeviceConfigs themselves.
+		if _, ok := n.Labels[TargetLabel]; ok && !labelled {
+			continue
+		}
+
+		if selected {
+			if err := u.setTargetLabel(ctx, n, value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		l, err := u.leave(ctx, cr, n)
+		if err != nil {
+			return err
+		}
+		if l != nil {
+			leaving = append(leaving, *l)
+		}
+	}
+
+	cr.Status.LeavingNodes = nil
+	if len(leaving) > 0 {
+		cr.Status.LeavingNodes = leaving
+	}
+
+	return nil
+}
+
+// leave drains n, no longer selected by cr, of the pods using its HPUs, and
+// then removes TargetLabel from it, so that KMM unloads its driver. n is
+// cordoned meanwhile, so that no new pod gets its HPUs, and the pods are
+// waited for or evicted following the workload deletion policy of cr. It
+// returns the status of n while it is leaving, or nil once it left.
+func (u *updater) leave(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, n *v1.Node) (*hlaiv1beta1.LeavingNode, error) {
+	evict := cr.GetWorkloadDeletionPolicy() == hlaiv1beta1.WorkloadDeletionPolicyEvict
+
+	since, ok := n.Annotations[LeavingAnnotation]
+	if !ok {
+		since = time.Now().UTC().Format(time.RFC3339)
+		if err := u.setLeaving(ctx, n, since); err != nil {
+			return nil, err
+		}
+
+		action := "Waiting for"
+		if evict {
+			action = "Evicting"
+		}
+		u.recorder.Eventf(cr, v1.EventTypeNormal, "NodeLeaving",
+			"Node %s is no longer selected. %s the pods using its HPUs before removing the driver", n.Name, action)
+	}
+
+	resourceName := cr.GetDeviceType().GetResourceName()
+	remaining, err := pods.Drain(ctx, u.client, u.reader, n.Name, resourceName, evict)
+	if err != nil {
+		return nil, fmt.Errorf("failed to drain node %s: %w", n.Name, err)
+	}
+
+	if len(remaining) > 0 {
+		l := &hlaiv1beta1.LeavingNode{
+			Name:    n.Name,
+			Since:   metav1.Now(),
+			Message: fmt.Sprintf("waiting for %d pods using %s: %s", len(remaining), resourceName, pods.JoinNames(remaining)),
+		}
+		if t, err := time.Parse(time.RFC3339, since); err == nil {
+			l.Since = metav1.NewTime(t)
+		}
+
+		return l, nil
+	}
+
+	if err := u.clearLeaving(ctx, n, true); err != nil {
+		return nil, err
+	}
+
+	u.recorder.Eventf(cr, v1.EventTypeNormal, "NodeLeft",
+		"Node %s left after its workloads were drained, removing the driver", n.Name)
+
+	return nil, nil
+}
+
+// stay stops n from leaving cr, when it is selected again while leaving.
+func (u *updater) stay(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, n *v1.Node) error {
+	if _, ok := n.Annotations[LeavingAnnotation]; !ok {
+		return nil
+	}
+
+	if err := u.clearLeaving(ctx, n, false); err != nil {
+		return err
+	}
+
+	u.recorder.Eventf(cr, v1.EventTypeNormal, "NodeLeavingCanceled",
+		"Node %s is selected again, keeping its driver", n.Name)
+
+	return nil
+}
+
+// setLeaving records that n started leaving at since, and cordons it.
+func (u *updater) setLeaving(ctx context.Context, n *v1.Node, since string) error {
+	patch := client.MergeFrom(n.DeepCopy())
+	metav1.SetMetaDataAnnotation(&n.ObjectMeta, LeavingAnnotation, since)
+	if !n.Spec.Unschedulable {
+		n.Spec.Unschedulable = true
+		metav1.SetMetaDataAnnotation(&n.ObjectMeta, cordonedAnnotation, "true")
+	}
+
+	if err := u.client.Patch(ctx, n, patch); err != nil {
+		return fmt.Errorf("failed to mark node %s as leaving: %w", n.Name, err)
+	}
+
+	log.FromContext(ctx).Info("Node leaving", "node", n.Name)
+
+	return nil
+}
+
+// clearLeaving uncordons n, if it was cordoned while leaving, and removes
+// TargetLabel from it if left is true.
+func (u *updater) clearLeaving(ctx context.Context, n *v1.Node, left bool) error {
+	patch := client.MergeFrom(n.DeepCopy())
+	if _, ok := n.Annotations[cordonedAnnotation]; ok {
+		n.Spec.Unschedulable = false
+		delete(n.Annotations, cordonedAnnotation)
+	}
+	delete(n.Annotations, LeavingAnnotation)
+	if left {
+		delete(n.Labels, TargetLabel)
+	}
+
+	if err := u.client.Patch(ctx, n, patch); err != nil {
+		return fmt.Errorf("failed to update label %s of node %s: %w", TargetLabel, n.Name, err)
+	}
+
+	log.FromContext(ctx).Info("Cleared node leaving state", "node", n.Name, "left", left)
+
+	return nil
+}
+
+// ClearTargetNodes removes TargetLabel from the nodes selected by cr, and
+// uncordons the ones cordoned while leaving.
+func (u *updater) ClearTargetNodes(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	nodeList := &v1.NodeList{}
+	opts := []client.ListOption{
+		client.MatchingLabels{TargetLabel: GetTargetLabelValue(cr)},
+	}
+	if err := u.client.List(ctx, nodeList, opts...); err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	for i := range nodeList.Items {
+		if err := u.clearLeaving(ctx, &nodeList.Items[i], true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (u *updater) setTargetLabel(ctx context.Context, n *v1.Node, value string) error {
+	logger := log.FromContext(ctx)
+
+	patch := client.MergeFrom(n.DeepCopy())
+	if n.Labels == nil {
+		n.Labels = map[string]string{}
+	}
+	n.Labels[TargetLabel] = value
+
+	if err := u.client.Patch(ctx, n, patch); err != nil {
+		return fmt.Errorf("failed to update label %s of node %s: %w", TargetLabel, n.Name, err)
+	}
+
+	logger.Info("Updated node target label", "node", n.Name, "label", TargetLabel)
+
+	return nil
+}
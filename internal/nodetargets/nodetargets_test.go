@@ -0,0 +1,204 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodetargets
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
+)
+
+var _ = Describe("NodeTargetsUpdater", func() {
+	var (
+		dc       *hlaiv1beta1.DeviceConfig
+		c        client.Client
+		u        Updater
+		recorder *record.FakeRecorder
+	)
+
+	getNode := func(name string) *corev1.Node {
+		n := &corev1.Node{}
+		Expect(c.Get(context.TODO(), types.NamespacedName{Name: name}, n)).To(Succeed())
+		return n
+	}
+
+	nodeLabels := func(name string) map[string]string {
+		return getNode(name).Labels
+	}
+
+	BeforeEach(func() {
+		dc = &hlaiv1beta1.DeviceConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-device-config", Namespace: "habana-ai-operator"},
+			Spec: hlaiv1beta1.DeviceConfigSpec{
+				NodeSelector: map[string]string{"hpu": "true"},
+				NodeSelectorExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "zone", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"b"}},
+				},
+			},
+		}
+
+		value := GetTargetLabelValue(dc)
+		c = fake.
+			NewClientBuilder().
+			WithScheme(scheme.Scheme).
+			WithObjects(
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "selected", Labels: map[string]string{"hpu": "true", "zone": "a"}}},
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "excluded", Labels: map[string]string{"hpu": "true", "zone": "b", TargetLabel: value}}},
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "other", Labels: map[string]string{"hpu": "true", TargetLabel: "other.device-config"}}},
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cpu"}},
+			).
+			WithIndex(&corev1.Pod{}, pods.NodeNameField, func(o client.Object) []string {
+				return []string{o.(*corev1.Pod).Spec.NodeName}
+			}).
+			Build()
+		recorder = record.NewFakeRecorder(10)
+		u = NewUpdater(c, c, recorder)
+	})
+
+	Describe("GetNodeSelector", func() {
+		It("should return the node selector of a DeviceConfig without expressions nor node affinity", func() {
+			dc.Spec.NodeSelectorExpressions = nil
+			Expect(GetNodeSelector(dc)).To(Equal(map[string]string{"hpu": "true"}))
+		})
+
+		It("should return the target label of a DeviceConfig with expressions", func() {
+			Expect(GetNodeSelector(dc)).To(Equal(map[string]string{TargetLabel: "habana-ai-operator.a-device-config"}))
+		})
+
+		It("should return the target label of a DeviceConfig with a required node affinity", func() {
+			dc.Spec.NodeSelectorExpressions = nil
+			dc.Spec.NodeAffinity = &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{},
+			}
+			Expect(GetNodeSelector(dc)).To(Equal(map[string]string{TargetLabel: "habana-ai-operator.a-device-config"}))
+		})
+	})
+
+	Describe("SetTargetNodes", func() {
+		It("should label the selected nodes only", func() {
+			Expect(u.SetTargetNodes(context.TODO(), dc)).To(Succeed())
+
+			Expect(nodeLabels("selected")).To(HaveKeyWithValue(TargetLabel, GetTargetLabelValue(dc)))
+			Expect(nodeLabels("excluded")).ToNot(HaveKey(TargetLabel))
+			Expect(nodeLabels("other")).To(HaveKeyWithValue(TargetLabel, "other.device-config"))
+			Expect(nodeLabels("cpu")).ToNot(HaveKey(TargetLabel))
+		})
+
+		It("should label the selected nodes of a DeviceConfig without expressions nor node affinity", func() {
+			dc.Spec.NodeSelectorExpressions = nil
+			Expect(u.SetTargetNodes(context.TODO(), dc)).To(Succeed())
+
+			Expect(nodeLabels("selected")).To(HaveKeyWithValue(TargetLabel, GetTargetLabelValue(dc)))
+			Expect(nodeLabels("excluded")).To(HaveKeyWithValue(TargetLabel, GetTargetLabelValue(dc)))
+			Expect(nodeLabels("cpu")).ToNot(HaveKey(TargetLabel))
+		})
+	})
+
+	Describe("leaving nodes", func() {
+		workload := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "training", Namespace: "user"},
+			Spec: corev1.PodSpec{
+				NodeName: "excluded",
+				Containers: []corev1.Container{
+					{
+						Name: "train",
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{"habana.ai/gaudi": resource.MustParse("8")},
+						},
+					},
+				},
+			},
+		}
+
+		BeforeEach(func() {
+			Expect(c.Create(context.TODO(), workload.DeepCopy())).To(Succeed())
+		})
+
+		It("should keep a node no longer selected until its workloads are gone", func() {
+			Expect(u.SetTargetNodes(context.TODO(), dc)).To(Succeed())
+
+			excluded := getNode("excluded")
+			Expect(excluded.Labels).To(HaveKeyWithValue(TargetLabel, GetTargetLabelValue(dc)))
+			Expect(excluded.Annotations).To(HaveKey(LeavingAnnotation))
+			Expect(excluded.Spec.Unschedulable).To(BeTrue())
+			Expect(dc.Status.LeavingNodes).To(HaveLen(1))
+			Expect(dc.Status.LeavingNodes[0].Name).To(Equal("excluded"))
+			Expect(dc.Status.LeavingNodes[0].Message).To(Equal("waiting for 1 pods using habana.ai/gaudi: user/training"))
+			Expect(RequeueAfter(dc)).ToNot(BeZero())
+			Expect(recorder.Events).To(Receive(HavePrefix("Normal NodeLeaving Node excluded is no longer selected. Waiting for")))
+
+			Expect(c.Delete(context.TODO(), workload.DeepCopy())).To(Succeed())
+			Expect(u.SetTargetNodes(context.TODO(), dc)).To(Succeed())
+
+			excluded = getNode("excluded")
+			Expect(excluded.Labels).ToNot(HaveKey(TargetLabel))
+			Expect(excluded.Annotations).ToNot(HaveKey(LeavingAnnotation))
+			Expect(excluded.Spec.Unschedulable).To(BeFalse())
+			Expect(dc.Status.LeavingNodes).To(BeEmpty())
+			Expect(RequeueAfter(dc)).To(BeZero())
+			Expect(recorder.Events).To(Receive(HavePrefix("Normal NodeLeft Node excluded left")))
+		})
+
+		It("should keep a node selected again while leaving", func() {
+			Expect(u.SetTargetNodes(context.TODO(), dc)).To(Succeed())
+			Expect(recorder.Events).To(Receive(HavePrefix("Normal NodeLeaving")))
+
+			dc.Spec.NodeSelectorExpressions = nil
+			Expect(u.SetTargetNodes(context.TODO(), dc)).To(Succeed())
+
+			excluded := getNode("excluded")
+			Expect(excluded.Labels).To(HaveKeyWithValue(TargetLabel, GetTargetLabelValue(dc)))
+			Expect(excluded.Annotations).ToNot(HaveKey(LeavingAnnotation))
+			Expect(excluded.Spec.Unschedulable).To(BeFalse())
+			Expect(dc.Status.LeavingNodes).To(BeEmpty())
+			Expect(recorder.Events).To(Receive(HavePrefix("Normal NodeLeavingCanceled")))
+		})
+
+		It("should leave a node cordoned by an administrator cordoned", func() {
+			excluded := getNode("excluded")
+			excluded.Spec.Unschedulable = true
+			Expect(c.Update(context.TODO(), excluded)).To(Succeed())
+			Expect(c.Delete(context.TODO(), workload.DeepCopy())).To(Succeed())
+
+			Expect(u.SetTargetNodes(context.TODO(), dc)).To(Succeed())
+
+			excluded = getNode("excluded")
+			Expect(excluded.Labels).ToNot(HaveKey(TargetLabel))
+			Expect(excluded.Spec.Unschedulable).To(BeTrue())
+		})
+	})
+
+	Describe("ClearTargetNodes", func() {
+		It("should unlabel the nodes of the DeviceConfig only", func() {
+			Expect(u.SetTargetNodes(context.TODO(), dc)).To(Succeed())
+			Expect(u.ClearTargetNodes(context.TODO(), dc)).To(Succeed())
+
+			Expect(nodeLabels("selected")).ToNot(HaveKey(TargetLabel))
+			Expect(nodeLabels("other")).To(HaveKeyWithValue(TargetLabel, "other.device-config"))
+		})
+	})
+})
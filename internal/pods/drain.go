@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pods
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// NodeNameField selects the pods of a node.
+	NodeNameField = "spec.nodeName"
+
+	// mirrorPodAnnotation is set by the kubelet on the mirror pods of its
+	// static pods, which cannot be evicted.
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+	// maxListedPods is the number of pods detailed by JoinNames.
+	maxListedPods = 5
+)
+
+// Drain returns the pods using the resourceName HPUs of node, evicting them if
+// evict is true. The evictions honor the PodDisruptionBudgets, and the ones
+// they deny are retried on the next call. The DaemonSet pods are left alone,
+// since they would be recreated right away. reader lists the pods of all the
+// namespaces.
+func Drain(ctx context.Context, c client.Client, reader client.Reader, node string, resourceName corev1.ResourceName, evict bool) ([]string, error) {
+	logger := log.FromContext(ctx)
+
+	podList := &corev1.PodList{}
+	if err := reader.List(ctx, podList, client.MatchingFields{NodeNameField: node}); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	remaining := []string{}
+	for i := range podList.Items {
+		p := &podList.Items[i]
+		if !usesHPUs(p, resourceName) || !isEvictable(p) {
+			continue
+		}
+
+		name := fmt.Sprintf("%s/%s", p.Namespace, p.Name)
+		remaining = append(remaining, name)
+
+		if !evict || !p.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		err := c.SubResource("eviction").Create(ctx, p, &policyv1.Eviction{})
+		switch {
+		case apierrors.IsNotFound(err):
+			remaining = remaining[:len(remaining)-1]
+		case apierrors.IsTooManyRequests(err):
+			logger.Info("Eviction denied by a PodDisruptionBudget", "node", node, "pod", name)
+		case err != nil:
+			return nil, fmt.Errorf("failed to evict pod %s: %w", name, err)
+		default:
+			logger.Info("Evicted pod", "node", node, "pod", name)
+		}
+	}
+
+	return remaining, nil
+}
+
+// usesHPUs returns true if a running or pending container of p requests HPUs.
+func usesHPUs(p *corev1.Pod, resourceName corev1.ResourceName) bool {
+	if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+		return false
+	}
+
+	containers := append(append([]corev1.Container{}, p.Spec.InitContainers...), p.Spec.Containers...)
+	for _, c := range containers {
+		if q, ok := c.Resources.Limits[resourceName]; ok && !q.IsZero() {
+			return true
+		}
+		if q, ok := c.Resources.Requests[resourceName]; ok && !q.IsZero() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isEvictable(p *corev1.Pod) bool {
+	if _, ok := p.Annotations[mirrorPodAnnotation]; ok {
+		return false
+	}
+
+	owner := metav1.GetControllerOf(p)
+	return owner == nil || owner.Kind != "DaemonSet"
+}
+
+// JoinNames joins the first pod names returned by Drain, so that the messages
+// reporting them stay readable.
+func JoinNames(names []string) string {
+	if len(names) <= maxListedPods {
+		return strings.Join(names, ", ")
+	}
+
+	return fmt.Sprintf("%s and %d more", strings.Join(names[:maxListedPods], ", "), len(names)-maxListedPods)
+}
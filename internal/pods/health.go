@@ -0,0 +1,126 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pods
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Health is the state of the pods of a DaemonSet on the nodes it targets.
+// Each field holds sorted node names.
+type Health struct {
+	Nodes    int
+	Ready    []string
+	Starting []string
+	Missing  []string
+	// Failing indexes the nodes by the reason their pod is failing.
+	Failing map[string][]string
+}
+
+// IsReady returns true if the pods are ready on all the targeted nodes.
+func (h *Health) IsReady() bool {
+	return len(h.Ready) == h.Nodes
+}
+
+// Problems describes the failing and missing pods, one entry per failure
+// reason, e.g. "CrashLoopBackOff on node-a, node-b".
+func (h *Health) Problems() []string {
+	reasons := make([]string, 0, len(h.Failing))
+	for reason := range h.Failing {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	problems := []string{}
+	for _, reason := range reasons {
+		problems = append(problems, fmt.Sprintf("%s on %s", reason, strings.Join(h.Failing[reason], ", ")))
+	}
+
+	if len(h.Missing) > 0 {
+		problems = append(problems, fmt.Sprintf("no pod on %s", strings.Join(h.Missing, ", ")))
+	}
+
+	return problems
+}
+
+// ListDaemonSetPods returns the pods of ds indexed by node.
+func ListDaemonSetPods(ctx context.Context, c client.Client, ds *appsv1.DaemonSet) (map[string]*corev1.Pod, error) {
+	selector, err := metav1.LabelSelectorAsSelector(ds.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector for DaemonSet %s: %w", ds.Name, err)
+	}
+
+	podList := &corev1.PodList{}
+	opts := []client.ListOption{
+		client.InNamespace(ds.Namespace),
+		client.MatchingLabelsSelector{Selector: selector},
+	}
+	if err := c.List(ctx, podList, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list pods of DaemonSet %s: %w", ds.Name, err)
+	}
+
+	return ByNode(podList.Items), nil
+}
+
+// GetDaemonSetHealth inspects the pods of ds on every node matching its pod
+// template node selector.
+func GetDaemonSetHealth(ctx context.Context, c client.Client, ds *appsv1.DaemonSet) (*Health, error) {
+	nodeList := &corev1.NodeList{}
+	selector := labels.SelectorFromSet(ds.Spec.Template.Spec.NodeSelector)
+	if err := c.List(ctx, nodeList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, fmt.Errorf("failed to list nodes of DaemonSet %s: %w", ds.Name, err)
+	}
+
+	byNode, err := ListDaemonSetPods(ctx, c, ds)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(nodeList.Items, func(i, j int) bool {
+		return nodeList.Items[i].Name < nodeList.Items[j].Name
+	})
+
+	h := &Health{
+		Nodes:   len(nodeList.Items),
+		Failing: map[string][]string{},
+	}
+	for _, n := range nodeList.Items {
+		p, ok := byNode[n.Name]
+		switch {
+		case !ok:
+			h.Missing = append(h.Missing, n.Name)
+		case FailureReason(p) != "":
+			reason := FailureReason(p)
+			h.Failing[reason] = append(h.Failing[reason], n.Name)
+		case IsReady(p):
+			h.Ready = append(h.Ready, n.Name)
+		default:
+			h.Starting = append(h.Starting, n.Name)
+		}
+	}
+
+	return h, nil
+}
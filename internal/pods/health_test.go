@@ -0,0 +1,107 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pods
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	Describe("GetDaemonSetHealth", func() {
+		var ds *appsv1.DaemonSet
+
+		BeforeEach(func() {
+			labels := map[string]string{"app": "an-app"}
+			ds = &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-daemonset", Namespace: "a-namespace"},
+				Spec: appsv1.DaemonSetSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{NodeSelector: map[string]string{"selected": "true"}},
+					},
+				},
+			}
+		})
+
+		It("should classify the pods of every targeted node", func() {
+			objs := []client.Object{ds}
+			for _, name := range []string{"node-ready", "node-starting", "node-missing", "node-crashing"} {
+				objs = append(objs, &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+					Name:   name,
+					Labels: map[string]string{"selected": "true"},
+				}})
+			}
+			objs = append(objs, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-unselected"}})
+
+			for _, p := range []*corev1.Pod{
+				makeReadyPod("pod-ready", "node-ready"),
+				makeWaitingPod("pod-starting", "node-starting", "ContainerCreating"),
+				makeWaitingPod("pod-crashing", "node-crashing", "CrashLoopBackOff"),
+				makeReadyPod("pod-unselected", "node-unselected"),
+			} {
+				p.Namespace = ds.Namespace
+				p.Labels = ds.Spec.Selector.MatchLabels
+				objs = append(objs, p)
+			}
+
+			c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).Build()
+
+			h, err := GetDaemonSetHealth(context.TODO(), c, ds)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(h.Nodes).To(Equal(4))
+			Expect(h.IsReady()).To(BeFalse())
+			Expect(h.Ready).To(Equal([]string{"node-ready"}))
+			Expect(h.Starting).To(Equal([]string{"node-starting"}))
+			Expect(h.Missing).To(Equal([]string{"node-missing"}))
+			Expect(h.Failing).To(Equal(map[string][]string{"CrashLoopBackOff": {"node-crashing"}}))
+		})
+
+		It("should be ready when no node is targeted", func() {
+			c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(ds).Build()
+
+			h, err := GetDaemonSetHealth(context.TODO(), c, ds)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(h.IsReady()).To(BeTrue())
+		})
+	})
+
+	Describe("Problems", func() {
+		It("should describe the failing and missing pods by reason", func() {
+			h := &Health{
+				Nodes:   4,
+				Missing: []string{"node-d"},
+				Failing: map[string][]string{
+					"ImagePullBackOff": {"node-c"},
+					"CrashLoopBackOff": {"node-a", "node-b"},
+				},
+			}
+
+			Expect(h.Problems()).To(Equal([]string{
+				"CrashLoopBackOff on node-a, node-b",
+				"ImagePullBackOff on node-c",
+				"no pod on node-d",
+			}))
+		})
+	})
+})
@@ -0,0 +1,91 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pods
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// failingWaitingReasons are the reasons of a waiting container that will
+// not recover without a change of the pod or of the node.
+var failingWaitingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// IsReady returns true if the pod is running and its Ready condition is true.
+func IsReady(p *corev1.Pod) bool {
+	if p.Status.Phase != corev1.PodRunning || !p.DeletionTimestamp.IsZero() {
+		return false
+	}
+
+	for _, c := range p.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// FailureReason returns the reason why the pod is failing, or an empty string
+// if it is healthy or still starting.
+func FailureReason(p *corev1.Pod) string {
+	if p.Status.Phase == corev1.PodFailed {
+		if p.Status.Reason != "" {
+			return p.Status.Reason
+		}
+		return string(corev1.PodFailed)
+	}
+
+	statuses := append([]corev1.ContainerStatus{}, p.Status.InitContainerStatuses...)
+	statuses = append(statuses, p.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		if cs.State.Waiting != nil && failingWaitingReasons[cs.State.Waiting.Reason] {
+			return cs.State.Waiting.Reason
+		}
+	}
+
+	return ""
+}
+
+// ByNode indexes the pods by the name of the node they are scheduled on.
+// Unscheduled pods are skipped and, if several pods run on the same node, the
+// ready one is preferred, as during a rolling update.
+func ByNode(pods []corev1.Pod) map[string]*corev1.Pod {
+	byNode := make(map[string]*corev1.Pod, len(pods))
+
+	for i := range pods {
+		p := &pods[i]
+		if p.Spec.NodeName == "" {
+			continue
+		}
+
+		if existing, ok := byNode[p.Spec.NodeName]; ok && IsReady(existing) {
+			continue
+		}
+
+		byNode[p.Spec.NodeName] = p
+	}
+
+	return byNode
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pods
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pods", func() {
+	Describe("IsReady", func() {
+		It("should return true for a running and ready pod", func() {
+			Expect(IsReady(makeReadyPod("a-pod", "a-node"))).To(BeTrue())
+		})
+
+		It("should return false for a running pod that is not ready", func() {
+			p := makeReadyPod("a-pod", "a-node")
+			p.Status.Conditions[0].Status = corev1.ConditionFalse
+
+			Expect(IsReady(p)).To(BeFalse())
+		})
+
+		It("should return false for a pending pod", func() {
+			p := makeReadyPod("a-pod", "a-node")
+			p.Status.Phase = corev1.PodPending
+
+			Expect(IsReady(p)).To(BeFalse())
+		})
+
+		It("should return false for a pod being deleted", func() {
+			p := makeReadyPod("a-pod", "a-node")
+			now := metav1.Now()
+			p.DeletionTimestamp = &now
+
+			Expect(IsReady(p)).To(BeFalse())
+		})
+	})
+
+	Describe("FailureReason", func() {
+		It("should return an empty string for a ready pod", func() {
+			Expect(FailureReason(makeReadyPod("a-pod", "a-node"))).To(BeEmpty())
+		})
+
+		It("should return an empty string for a starting pod", func() {
+			p := makeWaitingPod("a-pod", "a-node", "ContainerCreating")
+
+			Expect(FailureReason(p)).To(BeEmpty())
+		})
+
+		DescribeTable("with a failing container",
+			func(reason string) {
+				Expect(FailureReason(makeWaitingPod("a-pod", "a-node", reason))).To(Equal(reason))
+			},
+			Entry(nil, "CrashLoopBackOff"),
+			Entry(nil, "ImagePullBackOff"),
+			Entry(nil, "ErrImagePull"),
+		)
+
+		It("should return the reason of a failing init container", func() {
+			p := makeWaitingPod("a-pod", "a-node", "ContainerCreating")
+			p.Status.InitContainerStatuses = []corev1.ContainerStatus{
+				{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+			}
+
+			Expect(FailureReason(p)).To(Equal("CrashLoopBackOff"))
+		})
+
+		It("should return the reason of a failed pod", func() {
+			p := makeReadyPod("a-pod", "a-node")
+			p.Status.Phase = corev1.PodFailed
+			p.Status.Reason = "Evicted"
+
+			Expect(FailureReason(p)).To(Equal("Evicted"))
+		})
+	})
+
+	Describe("ByNode", func() {
+		It("should index the scheduled pods by node", func() {
+			pods := []corev1.Pod{
+				*makeReadyPod("pod-1", "node-1"),
+				*makeReadyPod("pod-2", "node-2"),
+				*makeReadyPod("pod-3", ""),
+			}
+
+			byNode := ByNode(pods)
+
+			Expect(byNode).To(HaveLen(2))
+			Expect(byNode["node-1"].Name).To(Equal("pod-1"))
+			Expect(byNode["node-2"].Name).To(Equal("pod-2"))
+		})
+
+		It("should prefer the ready pod when several run on a node", func() {
+			pods := []corev1.Pod{
+				*makeWaitingPod("new-pod", "node-1", "CrashLoopBackOff"),
+				*makeReadyPod("old-pod", "node-1"),
+				*makeWaitingPod("newer-pod", "node-1", "ContainerCreating"),
+			}
+
+			Expect(ByNode(pods)["node-1"].Name).To(Equal("old-pod"))
+		})
+	})
+})
+
+func makeReadyPod(name, nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func makeWaitingPod(name, nodeName, reason string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}},
+			},
+		},
+	}
+}
@@ -0,0 +1,92 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: preflight.go
+
+// Package preflight is a generated GoMock package.
+package preflight
+
+import (
+	context "context"
+	reflect "reflect"
+
+	v1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	gomock "github.com/golang/mock/gomock"
+	v1beta10 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+)
+
+// MockReconciler is a mock of Reconciler interface.
+type MockReconciler struct {
+	ctrl     *gomock.Controller
+	recorder *MockReconcilerMockRecorder
+}
+
+// MockReconcilerMockRecorder is the mock recorder for MockReconciler.
+type MockReconcilerMockRecorder struct {
+	mock *MockReconciler
+}
+
+// NewMockReconciler creates a new mock instance.
+func NewMockReconciler(ctrl *gomock.Controller) *MockReconciler {
+	mock := &MockReconciler{ctrl: ctrl}
+	mock.recorder = &MockReconcilerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReconciler) EXPECT() *MockReconcilerMockRecorder {
+	return m.recorder
+}
+
+// DeletePreflight mocks base method.
+func (m *MockReconciler) DeletePreflight(ctx context.Context, cr *v1beta1.DeviceConfig) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeletePreflight", ctx, cr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePreflight indicates an expected call of DeletePreflight.
+func (mr *MockReconcilerMockRecorder) DeletePreflight(ctx, cr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePreflight", reflect.TypeOf((*MockReconciler)(nil).DeletePreflight), ctx, cr)
+}
+
+// ReconcilePreflight mocks base method.
+func (m *MockReconciler) ReconcilePreflight(ctx context.Context, cr *v1beta1.DeviceConfig) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReconcilePreflight", ctx, cr)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReconcilePreflight indicates an expected call of ReconcilePreflight.
+func (mr *MockReconcilerMockRecorder) ReconcilePreflight(ctx, cr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReconcilePreflight", reflect.TypeOf((*MockReconciler)(nil).ReconcilePreflight), ctx, cr)
+}
+
+// SetDesiredCandidateModule mocks base method.
+func (m_2 *MockReconciler) SetDesiredCandidateModule(m *v1beta10.Module, cr *v1beta1.DeviceConfig) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "SetDesiredCandidateModule", m, cr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetDesiredCandidateModule indicates an expected call of SetDesiredCandidateModule.
+func (mr *MockReconcilerMockRecorder) SetDesiredCandidateModule(m, cr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDesiredCandidateModule", reflect.TypeOf((*MockReconciler)(nil).SetDesiredCandidateModule), m, cr)
+}
+
+// SetDesiredPreflightValidation mocks base method.
+func (m *MockReconciler) SetDesiredPreflightValidation(pv *v1beta10.PreflightValidation, cr *v1beta1.DeviceConfig, kernel string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetDesiredPreflightValidation", pv, cr, kernel)
+}
+
+// SetDesiredPreflightValidation indicates an expected call of SetDesiredPreflightValidation.
+func (mr *MockReconcilerMockRecorder) SetDesiredPreflightValidation(pv, cr, kernel interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDesiredPreflightValidation", reflect.TypeOf((*MockReconciler)(nil).SetDesiredPreflightValidation), pv, cr, kernel)
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preflight
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodeselector"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+)
+
+const (
+	candidateSuffix    = "preflight"
+	candidateComponent = "module-preflight"
+	preflightComponent = "preflight"
+
+	// CandidateSelectorLabel selects the nodes of a candidate Module, which no
+	// node has, so that KMM validates it without deploying it.
+	CandidateSelectorLabel = "habana.ai/preflight-candidate"
+)
+
+//go:generate mockgen -source=preflight.go -package=preflight -destination=mock_preflight.go
+
+type Reconciler interface {
+	ReconcilePreflight(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (string, error)
+	DeletePreflight(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error
+	SetDesiredCandidateModule(m *kmmv1beta1.Module, cr *hlaiv1beta1.DeviceConfig) error
+	SetDesiredPreflightValidation(pv *kmmv1beta1.PreflightValidation, cr *hlaiv1beta1.DeviceConfig, kernel string)
+}
+
+type preflightReconciler struct {
+	client client.Client
+	scheme *runtime.Scheme
+	mr     module.Reconciler
+}
+
+func NewReconciler(c client.Client, s *runtime.Scheme, mr module.Reconciler) Reconciler {
+	return &preflightReconciler{client: c, scheme: s, mr: mr}
+}
+
+// GetCandidateModuleName returns the name of the Module validating a new
+// driver version of cr.
+func GetCandidateModuleName(cr *hlaiv1beta1.DeviceConfig) string {
+	return fmt.Sprintf("%s-%s", module.GetModuleName(cr), candidateSuffix)
+}
+
+// GetPreflightValidationName returns the name of the cluster scoped
+// PreflightValidation of the new driver version of cr against kernel. Kernel
+// versions are not valid object names, so that they are hashed.
+func GetPreflightValidationName(cr *hlaiv1beta1.DeviceConfig, kernel string) string {
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(kernel)))[:8]
+
+	return fmt.Sprintf("%s-%s-%s", cr.Namespace, GetCandidateModuleName(cr), hash)
+}
+
+// ReconcilePreflight returns the driver version to deploy for cr. Without
+// preflight, or when no version is deployed yet, it is the DeviceConfig one.
+// Otherwise, a new version is validated by KMM against the kernel of each
+// selected node, with a candidate Module selecting no node and a
+// PreflightValidation per kernel, and the deployed version is returned until
+// the new one is validated for all of them. The validation is reported in
+// cr.Status.Preflight. A rolled back version is not validated again.
+func (r *preflightReconciler) ReconcilePreflight(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (string, error) {
+	// A rolled back version is not deployed, so that it is not validated.
+	if cr.Spec.Driver.Preflight == nil || cr.IsDriverRolledBack() {
+		cr.Status.Preflight = nil
+		return cr.Spec.Driver.Version, r.DeletePreflight(ctx, cr)
+	}
+
+	deployed, err := r.getDeployedVersion(ctx, cr)
+	if err != nil {
+		return "", err
+	}
+
+	if deployed == "" || deployed == cr.Spec.Driver.Version {
+		return cr.Spec.Driver.Version, r.DeletePreflight(ctx, cr)
+	}
+
+	kernels, err := r.getSelectedKernels(ctx, cr)
+	if err != nil {
+		return "", err
+	}
+
+	status, err := r.validate(ctx, cr, kernels)
+	if err != nil {
+		return "", err
+	}
+	cr.Status.Preflight = status
+
+	if !status.IsVerified() {
+		return deployed, nil
+	}
+
+	log.FromContext(ctx).Info("Validated driver version", "version", cr.Spec.Driver.Version, "kernels", kernels)
+
+	return cr.Spec.Driver.Version, r.DeletePreflight(ctx, cr)
+}
+
+// getDeployedVersion returns the driver version the nodes of cr are upgraded
+// to, or the one of its Module before the upgrades were tracked. A new Module,
+// or one created before its version was recorded, has no version to keep.
+func (r *preflightReconciler) getDeployedVersion(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (string, error) {
+	if cr.Status.Upgrade != nil {
+		return cr.Status.Upgrade.TargetVersion, nil
+	}
+
+	m := &kmmv1beta1.Module{}
+	err := r.client.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: module.GetModuleName(cr)}, m)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get Module: %w", err)
+	}
+
+	return m.Annotations[module.DriverVersionAnnotation], nil
+}
+
+// validate creates the candidate Module and the PreflightValidations of
+// kernels, deletes the ones of other kernels, and returns their results.
+func (r *preflightReconciler) validate(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, kernels []string) (*hlaiv1beta1.PreflightStatus, error) {
+	logger := log.FromContext(ctx)
+
+	m := &kmmv1beta1.Module{
+		ObjectMeta: metav1.ObjectMeta{Name: GetCandidateModuleName(cr), Namespace: cr.Namespace},
+	}
+	res, err := controllerutil.CreateOrPatch(ctx, r.client, m, func() error {
+		return r.SetDesiredCandidateModule(m, cr)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create or patch candidate Module: %w", err)
+	}
+	logger.Info("Reconciled candidate Module", "resource", m.Name, "result", res)
+
+	pvList, err := r.listPreflightValidations(ctx, cr)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &hlaiv1beta1.PreflightStatus{Version: cr.Spec.Driver.Version}
+	wanted := map[string]bool{}
+	for _, k := range kernels {
+		pv := &kmmv1beta1.PreflightValidation{
+			ObjectMeta: metav1.ObjectMeta{Name: GetPreflightValidationName(cr, k)},
+		}
+		wanted[pv.Name] = true
+
+		res, err := controllerutil.CreateOrPatch(ctx, r.client, pv, func() error {
+			r.SetDesiredPreflightValidation(pv, cr, k)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create or patch PreflightValidation %s: %w", pv.Name, err)
+		}
+		logger.Info("Reconciled PreflightValidation", "resource", pv.Name, "kernel", k, "result", res)
+
+		ks := hlaiv1beta1.PreflightKernelStatus{Kernel: k, Message: "Waiting for KMM to validate the driver version"}
+		// KMM validates all the Modules of the cluster, by name.
+		if s, ok := pv.Status.CRStatuses[m.Name]; ok && s != nil {
+			ks.Verified = s.VerificationStatus == kmmv1beta1.VerificationTrue
+			ks.Stage = s.VerificationStage
+			if s.StatusReason != "" {
+				ks.Message = s.StatusReason
+			}
+		}
+		status.Kernels = append(status.Kernels, ks)
+	}
+
+	for i := range pvList.Items {
+		if pv := &pvList.Items[i]; !wanted[pv.Name] {
+			if err := r.client.Delete(ctx, pv); client.IgnoreNotFound(err) != nil {
+				return nil, fmt.Errorf("failed to delete PreflightValidation %s: %w", pv.Name, err)
+			}
+		}
+	}
+
+	return status, nil
+}
+
+// SetDesiredCandidateModule sets m to the Module of the DeviceConfig driver
+// version of cr, selecting no node and without device plugin.
+func (r *preflightReconciler) SetDesiredCandidateModule(m *kmmv1beta1.Module, cr *hlaiv1beta1.DeviceConfig) error {
+	if err := r.mr.SetDesiredModule(m, cr); err != nil {
+		return err
+	}
+
+	instance.SetLabels(m, cr, candidateComponent)
+	m.Spec.DevicePlugin = nil
+	m.Spec.Selector = map[string]string{CandidateSelectorLabel: "true"}
+
+	return nil
+}
+
+// SetDesiredPreflightValidation sets pv to the validation of the candidate
+// Module of cr against kernel. PreflightValidations are cluster scoped, so
+// that they are labelled with the DeviceConfig instead of being owned by it.
+func (r *preflightReconciler) SetDesiredPreflightValidation(pv *kmmv1beta1.PreflightValidation, cr *hlaiv1beta1.DeviceConfig, kernel string) {
+	instance.SetLabels(pv, cr, preflightComponent)
+	l := pv.GetLabels()
+	l[nodetargets.TargetLabel] = nodetargets.GetTargetLabelValue(cr)
+	pv.SetLabels(l)
+
+	pv.Spec = kmmv1beta1.PreflightValidationSpec{
+		KernelVersion:  kernel,
+		PushBuiltImage: cr.Spec.Driver.Preflight != nil && cr.Spec.Driver.Preflight.PushBuiltImage,
+	}
+}
+
+// DeletePreflight deletes the candidate Module and the PreflightValidations
+// of cr.
+func (r *preflightReconciler) DeletePreflight(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	m := &kmmv1beta1.Module{
+		ObjectMeta: metav1.ObjectMeta{Name: GetCandidateModuleName(cr), Namespace: cr.Namespace},
+	}
+	if err := r.client.Delete(ctx, m); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete candidate Module: %w", err)
+	}
+
+	pvList, err := r.listPreflightValidations(ctx, cr)
+	if err != nil {
+		return err
+	}
+
+	for i := range pvList.Items {
+		if err := r.client.Delete(ctx, &pvList.Items[i]); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete PreflightValidation %s: %w", pvList.Items[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *preflightReconciler) listPreflightValidations(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (*kmmv1beta1.PreflightValidationList, error) {
+	pvList := &kmmv1beta1.PreflightValidationList{}
+	opts := []client.ListOption{
+		client.MatchingLabels{nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(cr)},
+	}
+	if err := r.client.List(ctx, pvList, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list PreflightValidations: %w", err)
+	}
+
+	return pvList, nil
+}
+
+// getSelectedKernels returns the sorted kernel versions of the selected nodes.
+func (r *preflightReconciler) getSelectedKernels(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) ([]string, error) {
+	nodes, err := nodeselector.ListSelectedNodes(ctx, r.client, cr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list selected nodes: %w", err)
+	}
+
+	seen := map[string]bool{}
+	kernels := []string{}
+	for _, n := range nodes {
+		if k := n.Status.NodeInfo.KernelVersion; k != "" && !seen[k] {
+			seen[k] = true
+			kernels = append(kernels, k)
+		}
+	}
+	sort.Strings(kernels)
+
+	return kernels, nil
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package preflight
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+)
+
+const (
+	testDeployedVersion = "1.10.0-1"
+	testNewVersion      = "1.11.0-1"
+	testKernel          = "5.14.0-284.el9.x86_64"
+	testOtherKernel     = "5.15.0-76-generic"
+)
+
+var _ = Describe("ReconcilePreflight", func() {
+	var (
+		ctx context.Context
+		dc  *hlaiv1beta1.DeviceConfig
+		c   client.Client
+		r   Reconciler
+	)
+
+	makeNode := func(name, kernel string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"some": "label"}},
+			Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KernelVersion: kernel}},
+		}
+	}
+
+	makeModule := func(version string) *kmmv1beta1.Module {
+		return &kmmv1beta1.Module{ObjectMeta: metav1.ObjectMeta{
+			Name:        module.GetModuleName(dc),
+			Namespace:   dc.Namespace,
+			Annotations: map[string]string{module.DriverVersionAnnotation: version},
+		}}
+	}
+
+	listPreflightValidations := func() []kmmv1beta1.PreflightValidation {
+		pvList := &kmmv1beta1.PreflightValidationList{}
+		Expect(c.List(ctx, pvList)).To(Succeed())
+		return pvList.Items
+	}
+
+	getCandidateModule := func() error {
+		key := types.NamespacedName{Namespace: dc.Namespace, Name: GetCandidateModuleName(dc)}
+		return c.Get(ctx, key, &kmmv1beta1.Module{})
+	}
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		dc = &hlaiv1beta1.DeviceConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-device-config", Namespace: "a-namespace"},
+			Spec: hlaiv1beta1.DeviceConfigSpec{
+				Driver: hlaiv1beta1.DriverSpec{
+					Image:     "driver",
+					Version:   testNewVersion,
+					Preflight: &hlaiv1beta1.DriverPreflightSpec{PushBuiltImage: true},
+				},
+				NodeSelector: map[string]string{"some": "label"},
+			},
+		}
+
+		Expect(hlaiv1beta1.AddToScheme(scheme.Scheme)).To(Succeed())
+		Expect(kmmv1beta1.AddToScheme(scheme.Scheme)).To(Succeed())
+	})
+
+	build := func(objs ...client.Object) {
+		c = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).Build()
+		r = NewReconciler(c, scheme.Scheme, module.NewReconciler(c, scheme.Scheme))
+	}
+
+	It("should deploy the DeviceConfig version of a new Module", func() {
+		build(dc, makeNode("a-node", testKernel))
+
+		version, err := r.ReconcilePreflight(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testNewVersion))
+		Expect(dc.Status.Preflight).To(BeNil())
+		Expect(apierrors.IsNotFound(getCandidateModule())).To(BeTrue())
+		Expect(listPreflightValidations()).To(BeEmpty())
+	})
+
+	It("should deploy the DeviceConfig version without preflight", func() {
+		dc.Spec.Driver.Preflight = nil
+		dc.Status.Preflight = &hlaiv1beta1.PreflightStatus{Version: testNewVersion}
+		build(dc, makeModule(testDeployedVersion), makeNode("a-node", testKernel))
+
+		version, err := r.ReconcilePreflight(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testNewVersion))
+		Expect(dc.Status.Preflight).To(BeNil())
+	})
+
+	It("should not validate a rolled back version", func() {
+		dc.Spec.Driver.Rollback = &hlaiv1beta1.DriverRollbackSpec{}
+		dc.Status.Upgrade = &hlaiv1beta1.DriverUpgradeStatus{TargetVersion: testDeployedVersion}
+		dc.Status.Rollback = &hlaiv1beta1.DriverRollbackStatus{FailedImage: "driver", FailedVersion: testNewVersion, Version: testDeployedVersion}
+		build(dc, makeModule(testDeployedVersion), makeNode("a-node", testKernel))
+
+		version, err := r.ReconcilePreflight(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testNewVersion))
+		Expect(dc.Status.Preflight).To(BeNil())
+		Expect(apierrors.IsNotFound(getCandidateModule())).To(BeTrue())
+	})
+
+	It("should keep the version the nodes are upgraded to", func() {
+		dc.Status.Upgrade = &hlaiv1beta1.DriverUpgradeStatus{TargetVersion: testDeployedVersion}
+		build(dc, makeModule(testNewVersion), makeNode("a-node", testKernel))
+
+		version, err := r.ReconcilePreflight(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testDeployedVersion))
+		Expect(getCandidateModule()).To(Succeed())
+	})
+
+	It("should keep the deployed version until the new one is validated for every kernel", func() {
+		build(dc, makeModule(testDeployedVersion),
+			makeNode("a-node", testKernel), makeNode("b-node", testKernel), makeNode("c-node", testOtherKernel))
+
+		version, err := r.ReconcilePreflight(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testDeployedVersion))
+
+		candidate := &kmmv1beta1.Module{}
+		key := types.NamespacedName{Namespace: dc.Namespace, Name: GetCandidateModuleName(dc)}
+		Expect(c.Get(ctx, key, candidate)).To(Succeed())
+		Expect(candidate.Spec.Selector).To(Equal(map[string]string{CandidateSelectorLabel: "true"}))
+		Expect(candidate.Spec.DevicePlugin).To(BeNil())
+		Expect(candidate.Annotations).To(HaveKeyWithValue(module.DriverVersionAnnotation, testNewVersion))
+
+		pvs := listPreflightValidations()
+		Expect(pvs).To(HaveLen(2))
+		for _, pv := range pvs {
+			Expect(pv.Labels).To(HaveKeyWithValue(nodetargets.TargetLabel, nodetargets.GetTargetLabelValue(dc)))
+			Expect(pv.Spec.PushBuiltImage).To(BeTrue())
+		}
+
+		Expect(dc.Status.Preflight).ToNot(BeNil())
+		Expect(dc.Status.Preflight.Version).To(Equal(testNewVersion))
+		Expect(dc.Status.Preflight.Kernels).To(HaveLen(2))
+		Expect(dc.Status.Preflight.Kernels[0].Kernel).To(Equal(testKernel))
+		Expect(dc.Status.Preflight.Kernels[1].Kernel).To(Equal(testOtherKernel))
+		Expect(dc.Status.Preflight.IsVerified()).To(BeFalse())
+
+		By("validating the new version for one kernel")
+		pv := &kmmv1beta1.PreflightValidation{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: GetPreflightValidationName(dc, testKernel)}, pv)).To(Succeed())
+		pv.Status.CRStatuses = map[string]*kmmv1beta1.CRStatus{
+			GetCandidateModuleName(dc): {
+				VerificationStatus: kmmv1beta1.VerificationTrue,
+				VerificationStage:  kmmv1beta1.VerificationStageDone,
+				StatusReason:       "verified image exists",
+			},
+		}
+		Expect(c.Update(ctx, pv)).To(Succeed())
+
+		version, err = r.ReconcilePreflight(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testDeployedVersion))
+		Expect(dc.Status.Preflight.Kernels[0]).To(Equal(hlaiv1beta1.PreflightKernelStatus{
+			Kernel:   testKernel,
+			Verified: true,
+			Stage:    kmmv1beta1.VerificationStageDone,
+			Message:  "verified image exists",
+		}))
+		Expect(dc.Status.Preflight.Kernels[1].Verified).To(BeFalse())
+
+		By("validating the new version for the other kernel")
+		Expect(c.Get(ctx, types.NamespacedName{Name: GetPreflightValidationName(dc, testOtherKernel)}, pv)).To(Succeed())
+		pv.Status.CRStatuses = map[string]*kmmv1beta1.CRStatus{
+			GetCandidateModuleName(dc): {
+				VerificationStatus: kmmv1beta1.VerificationTrue,
+				VerificationStage:  kmmv1beta1.VerificationStageDone,
+			},
+		}
+		Expect(c.Update(ctx, pv)).To(Succeed())
+
+		version, err = r.ReconcilePreflight(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testNewVersion))
+		Expect(dc.Status.Preflight.IsVerified()).To(BeTrue())
+		Expect(apierrors.IsNotFound(getCandidateModule())).To(BeTrue())
+		Expect(listPreflightValidations()).To(BeEmpty())
+	})
+
+	It("should delete the PreflightValidations of kernels no longer selected", func() {
+		stale := &kmmv1beta1.PreflightValidation{ObjectMeta: metav1.ObjectMeta{
+			Name:   GetPreflightValidationName(dc, testOtherKernel),
+			Labels: map[string]string{nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(dc)},
+		}}
+		build(dc, makeModule(testDeployedVersion), makeNode("a-node", testKernel), stale)
+
+		_, err := r.ReconcilePreflight(ctx, dc)
+		Expect(err).ToNot(HaveOccurred())
+
+		pvs := listPreflightValidations()
+		Expect(pvs).To(HaveLen(1))
+		Expect(pvs[0].Name).To(Equal(GetPreflightValidationName(dc, testKernel)))
+		Expect(pvs[0].Spec.KernelVersion).To(Equal(testKernel))
+	})
+})
+
+var _ = Describe("DeletePreflight", func() {
+	It("should only delete the PreflightValidations of the DeviceConfig", func() {
+		Expect(kmmv1beta1.AddToScheme(scheme.Scheme)).To(Succeed())
+
+		dc := &hlaiv1beta1.DeviceConfig{ObjectMeta: metav1.ObjectMeta{Name: "a-device-config", Namespace: "a-namespace"}}
+		other := &hlaiv1beta1.DeviceConfig{ObjectMeta: metav1.ObjectMeta{Name: "a-device-config", Namespace: "other-namespace"}}
+
+		makePreflightValidation := func(cr *hlaiv1beta1.DeviceConfig) *kmmv1beta1.PreflightValidation {
+			return &kmmv1beta1.PreflightValidation{ObjectMeta: metav1.ObjectMeta{
+				Name:   GetPreflightValidationName(cr, testKernel),
+				Labels: map[string]string{nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(cr)},
+			}}
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+			WithObjects(makePreflightValidation(dc), makePreflightValidation(other)).
+			Build()
+		r := NewReconciler(c, scheme.Scheme, module.NewReconciler(c, scheme.Scheme))
+
+		Expect(r.DeletePreflight(context.TODO(), dc)).To(Succeed())
+
+		pvList := &kmmv1beta1.PreflightValidationList{}
+		Expect(c.List(context.TODO(), pvList)).To(Succeed())
+		Expect(pvList.Items).To(HaveLen(1))
+		Expect(pvList.Items[0].Name).To(Equal(GetPreflightValidationName(other, testKernel)))
+	})
+})
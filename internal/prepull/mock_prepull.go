@@ -0,0 +1,65 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: prepull.go
+
+// Package prepull is a generated GoMock package.
+package prepull
+
+import (
+	context "context"
+	reflect "reflect"
+
+	v1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockReconciler is a mock of Reconciler interface.
+type MockReconciler struct {
+	ctrl     *gomock.Controller
+	recorder *MockReconcilerMockRecorder
+}
+
+// MockReconcilerMockRecorder is the mock recorder for MockReconciler.
+type MockReconcilerMockRecorder struct {
+	mock *MockReconciler
+}
+
+// NewMockReconciler creates a new mock instance.
+func NewMockReconciler(ctrl *gomock.Controller) *MockReconciler {
+	mock := &MockReconciler{ctrl: ctrl}
+	mock.recorder = &MockReconcilerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReconciler) EXPECT() *MockReconcilerMockRecorder {
+	return m.recorder
+}
+
+// DeletePrePull mocks base method.
+func (m *MockReconciler) DeletePrePull(ctx context.Context, cr *v1beta1.DeviceConfig) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeletePrePull", ctx, cr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePrePull indicates an expected call of DeletePrePull.
+func (mr *MockReconcilerMockRecorder) DeletePrePull(ctx, cr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePrePull", reflect.TypeOf((*MockReconciler)(nil).DeletePrePull), ctx, cr)
+}
+
+// ReconcilePrePull mocks base method.
+func (m *MockReconciler) ReconcilePrePull(ctx context.Context, cr *v1beta1.DeviceConfig, version string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReconcilePrePull", ctx, cr, version)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReconcilePrePull indicates an expected call of ReconcilePrePull.
+func (mr *MockReconcilerMockRecorder) ReconcilePrePull(ctx, cr, version interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReconcilePrePull", reflect.TypeOf((*MockReconciler)(nil).ReconcilePrePull), ctx, cr, version)
+}
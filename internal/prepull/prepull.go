@@ -0,0 +1,386 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prepull
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodeselector"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
+)
+
+const (
+	prePullComponent = "prepull"
+
+	// ImageLabel selects the pods of the pre-pull DaemonSet of a driver
+	// image, since the selected nodes run different kernels, and so driver
+	// images.
+	ImageLabel = "habana.ai/prepull-image"
+
+	// The pre-pull containers only sleep once their image is pulled.
+	prePullLimitsCpu      = "10m"
+	prePullLimitsMemory   = "16Mi"
+	prePullRequestsCpu    = "10m"
+	prePullRequestsMemory = "16Mi"
+
+	// nodeNameField selects a node by name in a node affinity.
+	nodeNameField = "metadata.name"
+)
+
+//go:generate mockgen -source=prepull.go -package=prepull -destination=mock_prepull.go
+
+type Reconciler interface {
+	ReconcilePrePull(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, version string) (string, error)
+	DeletePrePull(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error
+}
+
+type prePullReconciler struct {
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+}
+
+func NewReconciler(c client.Client, s *runtime.Scheme, recorder record.EventRecorder) Reconciler {
+	return &prePullReconciler{client: c, scheme: s, recorder: recorder}
+}
+
+// GetPrePullName returns the name of the DaemonSet pulling driverImage on the
+// nodes of cr. Images are not valid object names, so that they are hashed.
+func GetPrePullName(cr *hlaiv1beta1.DeviceConfig, driverImage string) string {
+	return fmt.Sprintf("%s-%s-%s", cr.Name, prePullComponent, hashImage(driverImage))
+}
+
+func hashImage(image string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(image)))[:8]
+}
+
+// RequeueAfter returns when the pull of the images of cr times out, or 0 if
+// no pull is in progress. The progress of the pull is watched through the
+// pods of its DaemonSets.
+func RequeueAfter(cr *hlaiv1beta1.DeviceConfig, now time.Time) time.Duration {
+	s := cr.Status.PrePull
+	p := cr.Spec.Driver.PrePull
+	if s == nil || s.IsComplete() || s.StartTime == nil || p == nil || p.GetTimeout() == 0 {
+		return 0
+	}
+
+	if after := s.StartTime.Add(p.GetTimeout()).Sub(now); after > 0 {
+		return after
+	}
+
+	return time.Second
+}
+
+// ReconcilePrePull returns the driver version to move the nodes of cr to.
+// Without pre-pull, or when no version is deployed yet, it is version.
+// Otherwise, the driver and device plugin images of a new version are pulled
+// on every selected node by a DaemonSet per driver image, and the deployed
+// version is returned until they are pulled on all of them, or until the
+// pull times out. The progress is reported in cr.Status.PrePull.
+func (r *prePullReconciler) ReconcilePrePull(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, version string) (string, error) {
+	deployed := ""
+	if u := cr.Status.Upgrade; u != nil {
+		deployed = u.TargetVersion
+	}
+
+	// A rolled back version is the last known-good one, already pulled.
+	if cr.Spec.Driver.PrePull == nil || cr.IsDriverRolledBack() || deployed == "" || deployed == version {
+		cr.Status.PrePull = nil
+		return version, r.DeletePrePull(ctx, cr)
+	}
+
+	nodes, err := nodeselector.ListSelectedNodes(ctx, r.client, cr)
+	if err != nil {
+		return "", fmt.Errorf("failed to list selected nodes: %w", err)
+	}
+
+	byImage := getDriverImages(cr, nodes)
+	images := []string{module.GetDevicePluginImage(cr)}
+	for image := range byImage {
+		if image != "" {
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+
+	status := cr.Status.PrePull
+	if status == nil || status.Version != version || !reflect.DeepEqual(status.Images, images) {
+		now := metav1.Now()
+		status = &hlaiv1beta1.DriverPrePullStatus{Version: version, Images: images, StartTime: &now}
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, "DriverPrePullStarted",
+			"Pulling the images of driver version %s on %d nodes", version, len(nodes))
+	}
+	cr.Status.PrePull = status
+
+	// The DaemonSets are deleted once the pull completes, and are not
+	// created again for the same images.
+	if status.IsComplete() {
+		return version, r.DeletePrePull(ctx, cr)
+	}
+
+	h, err := r.pull(ctx, cr, byImage)
+	if err != nil {
+		return "", err
+	}
+
+	status.DesiredNodes = int32(h.Nodes)
+	status.PulledNodes = int32(len(h.Ready))
+	status.Failures = h.Problems()
+
+	switch timeout := cr.Spec.Driver.PrePull.GetTimeout(); {
+	case h.IsReady():
+		now := metav1.Now()
+		status.CompletionTime = &now
+		status.Message = fmt.Sprintf("Pulled the images on %d nodes", h.Nodes)
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, "DriverImagesPulled",
+			"Pulled the images of driver version %s on %d nodes", version, h.Nodes)
+	case timeout > 0 && time.Since(status.StartTime.Time) > timeout:
+		now := metav1.Now()
+		status.CompletionTime = &now
+		status.Message = fmt.Sprintf("Timed out with the images pulled on %d of %d nodes", len(h.Ready), h.Nodes)
+		if len(status.Failures) > 0 {
+			status.Message = fmt.Sprintf("%s: %s", status.Message, strings.Join(status.Failures, "; "))
+		}
+		r.recorder.Eventf(cr, corev1.EventTypeWarning, "DriverPrePullTimedOut",
+			"Moving the nodes to driver version %s without its images pulled on all of them: %s", version, status.Message)
+	default:
+		return deployed, nil
+	}
+
+	log.FromContext(ctx).Info("Completed driver images pre-pull", "version", version, "message", status.Message)
+
+	return version, r.DeletePrePull(ctx, cr)
+}
+
+// getDriverImages indexes the names of nodes by the driver image of their
+// kernel. The nodes whose kernel matches no kernel mapping, or whose driver
+// image may only be built or signed in the cluster during the rollout, are
+// indexed by an empty image, and only pull the device plugin image.
+func getDriverImages(cr *hlaiv1beta1.DeviceConfig, nodes []corev1.Node) map[string][]string {
+	d := cr.Spec.Driver
+
+	byImage := map[string][]string{}
+	for _, n := range nodes {
+		kernel := n.Status.NodeInfo.KernelVersion
+
+		image := ""
+		if m, err := d.FindKernelMapping(kernel); err == nil && m != nil && m.Build == nil && d.Build == nil && d.Sign == nil {
+			image = module.GetKernelDriverImage(cr, *m, kernel)
+		}
+		byImage[image] = append(byImage[image], n.Name)
+	}
+
+	for image := range byImage {
+		sort.Strings(byImage[image])
+	}
+
+	return byImage
+}
+
+// pull creates or patches the DaemonSets of the driver images of byImage,
+// deletes the other ones, and returns the state of their pods on the nodes.
+// A node has the images pulled once its pod is ready.
+func (r *prePullReconciler) pull(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, byImage map[string][]string) (*pods.Health, error) {
+	logger := log.FromContext(ctx)
+
+	dsList, err := r.listDaemonSets(ctx, cr)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &pods.Health{Failing: map[string][]string{}}
+	wanted := map[string]bool{}
+	for image, nodes := range byImage {
+		ds := &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: GetPrePullName(cr, image), Namespace: cr.Namespace},
+		}
+		wanted[ds.Name] = true
+
+		res, err := controllerutil.CreateOrPatch(ctx, r.client, ds, func() error {
+			return r.setDesiredDaemonSet(ds, cr, image, nodes)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create or patch pre-pull DaemonSet %s: %w", ds.Name, err)
+		}
+		logger.Info("Reconciled pre-pull DaemonSet", "resource", ds.Name, "image", image, "result", res)
+
+		byNode, err := pods.ListDaemonSetPods(ctx, r.client, ds)
+		if err != nil {
+			return nil, err
+		}
+
+		h.Nodes += len(nodes)
+		for _, n := range nodes {
+			p, ok := byNode[n]
+			switch {
+			case !ok:
+				h.Missing = append(h.Missing, n)
+			case pods.FailureReason(p) != "":
+				reason := pods.FailureReason(p)
+				h.Failing[reason] = append(h.Failing[reason], n)
+			case pods.IsReady(p):
+				h.Ready = append(h.Ready, n)
+			default:
+				h.Starting = append(h.Starting, n)
+			}
+		}
+	}
+
+	for i := range dsList.Items {
+		if ds := &dsList.Items[i]; !wanted[ds.Name] {
+			if err := r.client.Delete(ctx, ds); client.IgnoreNotFound(err) != nil {
+				return nil, fmt.Errorf("failed to delete pre-pull DaemonSet %s: %w", ds.Name, err)
+			}
+		}
+	}
+
+	sort.Strings(h.Missing)
+	for reason := range h.Failing {
+		sort.Strings(h.Failing[reason])
+	}
+
+	return h, nil
+}
+
+// setDesiredDaemonSet sets ds to the DaemonSet pulling driverImage and the
+// device plugin image of cr on nodes. Its pods run the images with
+// ImagePullPolicy Always, like KMM does, so that the layers of the tags it
+// pulls during the rollout are cached, and sleep.
+func (r *prePullReconciler) setDesiredDaemonSet(ds *appsv1.DaemonSet, cr *hlaiv1beta1.DeviceConfig, driverImage string, nodes []string) error {
+	instance.SetLabels(ds, cr, prePullComponent)
+
+	selector := instance.GetSelectorLabels(cr, prePullComponent)
+	selector[ImageLabel] = hashImage(driverImage)
+	ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: selector}
+
+	podLabels := instance.GetLabels(cr, prePullComponent)
+	podLabels[ImageLabel] = selector[ImageLabel]
+
+	containers := []corev1.Container{}
+	if driverImage != "" {
+		containers = append(containers, makeContainer("driver", driverImage))
+	}
+	containers = append(containers, makeContainer("device-plugin", module.GetDevicePluginImage(cr)))
+
+	nodeSelector := make(map[string]string)
+	for k, v := range nodetargets.GetNodeSelector(cr) {
+		nodeSelector[k] = v
+	}
+
+	var imagePullSecrets []corev1.LocalObjectReference
+	if s := cr.Spec.Driver.ImageRepoSecret; s != nil {
+		imagePullSecrets = []corev1.LocalObjectReference{*s}
+	}
+
+	ds.Spec.Template = corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+		Spec: corev1.PodSpec{
+			Affinity: &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							{
+								MatchFields: []corev1.NodeSelectorRequirement{
+									{Key: nodeNameField, Operator: corev1.NodeSelectorOpIn, Values: nodes},
+								},
+							},
+						},
+					},
+				},
+			},
+			Containers:                    containers,
+			ImagePullSecrets:              imagePullSecrets,
+			NodeSelector:                  nodeSelector,
+			TerminationGracePeriodSeconds: pointer.Int64(0),
+			Tolerations:                   append([]corev1.Toleration(nil), cr.Spec.Tolerations...),
+		},
+	}
+
+	return ctrl.SetControllerReference(cr, ds, r.scheme)
+}
+
+// makeContainer returns a container pulling image. The driver images have
+// sleep, which KMM runs their containers with too.
+func makeContainer(name, image string) corev1.Container {
+	return corev1.Container{
+		Name:            name,
+		Image:           image,
+		ImagePullPolicy: corev1.PullAlways,
+		Command:         []string{"sleep", "infinity"},
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				"cpu":    resource.MustParse(prePullLimitsCpu),
+				"memory": resource.MustParse(prePullLimitsMemory),
+			},
+			Requests: corev1.ResourceList{
+				"cpu":    resource.MustParse(prePullRequestsCpu),
+				"memory": resource.MustParse(prePullRequestsMemory),
+			},
+		},
+	}
+}
+
+// DeletePrePull deletes the pre-pull DaemonSets of cr.
+func (r *prePullReconciler) DeletePrePull(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	dsList, err := r.listDaemonSets(ctx, cr)
+	if err != nil {
+		return err
+	}
+
+	for i := range dsList.Items {
+		if err := r.client.Delete(ctx, &dsList.Items[i]); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete pre-pull DaemonSet %s: %w", dsList.Items[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *prePullReconciler) listDaemonSets(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) (*appsv1.DaemonSetList, error) {
+	dsList := &appsv1.DaemonSetList{}
+	opts := []client.ListOption{
+		client.InNamespace(cr.Namespace),
+		client.MatchingLabels(instance.GetSelectorLabels(cr, prePullComponent)),
+	}
+	if err := r.client.List(ctx, dsList, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list pre-pull DaemonSets: %w", err)
+	}
+
+	return dsList, nil
+}
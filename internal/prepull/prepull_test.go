@@ -0,0 +1,255 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prepull
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+)
+
+const (
+	testDeployedVersion = "1.10.0-1"
+	testNewVersion      = "1.11.0-1"
+	testKernel          = "5.14.0-284.el9.x86_64"
+	testOtherKernel     = "5.15.0-76-generic"
+
+	testDriverImage       = "driver:1.11.0-1-5.14.0-284.el9.x86_64"
+	testOtherDriverImage  = "driver:1.11.0-1-5.15.0-76-generic"
+	testDevicePluginImage = "device-plugin"
+)
+
+var _ = Describe("ReconcilePrePull", func() {
+	var (
+		ctx      context.Context
+		dc       *hlaiv1beta1.DeviceConfig
+		c        client.Client
+		recorder *record.FakeRecorder
+		r        Reconciler
+	)
+
+	makeNode := func(name, kernel string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"some": "label"}},
+			Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KernelVersion: kernel}},
+		}
+	}
+
+	listDaemonSets := func() []appsv1.DaemonSet {
+		dsList := &appsv1.DaemonSetList{}
+		Expect(c.List(ctx, dsList)).To(Succeed())
+		return dsList.Items
+	}
+
+	// makePod returns the pod of the pre-pull DaemonSet of driverImage on
+	// node, with a container waiting for reason, or ready.
+	makePod := func(driverImage, node, reason string) *corev1.Pod {
+		ds := &appsv1.DaemonSet{}
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: dc.Namespace, Name: GetPrePullName(dc, driverImage)}, ds)).To(Succeed())
+
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: ds.Name + "-" + node, Namespace: dc.Namespace, Labels: ds.Spec.Template.Labels},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}
+		if reason != "" {
+			p.Status.Phase = corev1.PodPending
+			p.Status.Conditions = nil
+			p.Status.ContainerStatuses = []corev1.ContainerStatus{
+				{Name: "driver", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}},
+			}
+		}
+
+		return p
+	}
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		dc = &hlaiv1beta1.DeviceConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-device-config", Namespace: "a-namespace"},
+			Spec: hlaiv1beta1.DeviceConfigSpec{
+				Driver: hlaiv1beta1.DriverSpec{
+					Image:   "driver",
+					Version: testNewVersion,
+					PrePull: &hlaiv1beta1.DriverPrePullSpec{},
+				},
+				DevicePlugin: hlaiv1beta1.DevicePluginSpec{Image: testDevicePluginImage},
+				NodeSelector: map[string]string{"some": "label"},
+			},
+			Status: hlaiv1beta1.DeviceConfigStatus{
+				Upgrade: &hlaiv1beta1.DriverUpgradeStatus{TargetVersion: testDeployedVersion},
+			},
+		}
+
+		Expect(hlaiv1beta1.AddToScheme(scheme.Scheme)).To(Succeed())
+	})
+
+	build := func(objs ...client.Object) {
+		c = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).Build()
+		recorder = record.NewFakeRecorder(10)
+		r = NewReconciler(c, scheme.Scheme, recorder)
+	}
+
+	It("should move the nodes to the version without pre-pull", func() {
+		dc.Spec.Driver.PrePull = nil
+		dc.Status.PrePull = &hlaiv1beta1.DriverPrePullStatus{Version: testNewVersion}
+		build(dc, makeNode("a-node", testKernel))
+
+		version, err := r.ReconcilePrePull(ctx, dc, testNewVersion)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testNewVersion))
+		Expect(dc.Status.PrePull).To(BeNil())
+		Expect(listDaemonSets()).To(BeEmpty())
+	})
+
+	It("should not pull the images of the deployed version", func() {
+		build(dc, makeNode("a-node", testKernel))
+
+		version, err := r.ReconcilePrePull(ctx, dc, testDeployedVersion)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testDeployedVersion))
+		Expect(dc.Status.PrePull).To(BeNil())
+		Expect(listDaemonSets()).To(BeEmpty())
+	})
+
+	It("should keep the deployed version until the images are pulled on all the nodes", func() {
+		build(dc, makeNode("a-node", testKernel), makeNode("b-node", testOtherKernel), makeNode("c-node", "6.1.0-unknown"))
+
+		version, err := r.ReconcilePrePull(ctx, dc, testNewVersion)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testDeployedVersion))
+		Expect(<-recorder.Events).To(ContainSubstring("Pulling the images of driver version 1.11.0-1 on 3 nodes"))
+
+		Expect(dc.Status.PrePull.Version).To(Equal(testNewVersion))
+		Expect(dc.Status.PrePull.Images).To(Equal([]string{testDevicePluginImage, testDriverImage, testOtherDriverImage}))
+		Expect(dc.Status.PrePull.DesiredNodes).To(BeEquivalentTo(3))
+		Expect(dc.Status.PrePull.PulledNodes).To(BeZero())
+		Expect(dc.Status.PrePull.IsComplete()).To(BeFalse())
+
+		daemonSets := listDaemonSets()
+		Expect(daemonSets).To(HaveLen(3))
+		images := map[string][]string{}
+		for _, ds := range daemonSets {
+			terms := ds.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+			for _, cnt := range ds.Spec.Template.Spec.Containers {
+				images[terms[0].MatchFields[0].Values[0]] = append(images[terms[0].MatchFields[0].Values[0]], cnt.Image)
+			}
+		}
+		Expect(images).To(Equal(map[string][]string{
+			"a-node": {testDriverImage, testDevicePluginImage},
+			"b-node": {testOtherDriverImage, testDevicePluginImage},
+			"c-node": {testDevicePluginImage},
+		}))
+
+		Expect(c.Create(ctx, makePod(testDriverImage, "a-node", ""))).To(Succeed())
+		Expect(c.Create(ctx, makePod(testOtherDriverImage, "b-node", ""))).To(Succeed())
+
+		version, err = r.ReconcilePrePull(ctx, dc, testNewVersion)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testDeployedVersion))
+		Expect(dc.Status.PrePull.PulledNodes).To(BeEquivalentTo(2))
+		Expect(dc.Status.PrePull.Failures).To(Equal([]string{"no pod on c-node"}))
+
+		Expect(c.Create(ctx, makePod("", "c-node", ""))).To(Succeed())
+
+		version, err = r.ReconcilePrePull(ctx, dc, testNewVersion)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testNewVersion))
+		Expect(dc.Status.PrePull.PulledNodes).To(BeEquivalentTo(3))
+		Expect(dc.Status.PrePull.IsComplete()).To(BeTrue())
+		Expect(<-recorder.Events).To(ContainSubstring("Pulled the images of driver version 1.11.0-1 on 3 nodes"))
+		Expect(listDaemonSets()).To(BeEmpty())
+
+		// The images are not pulled again until the nodes are moved.
+		version, err = r.ReconcilePrePull(ctx, dc, testNewVersion)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testNewVersion))
+		Expect(listDaemonSets()).To(BeEmpty())
+	})
+
+	It("should pull the images again when they change", func() {
+		completed := metav1.Now()
+		dc.Status.PrePull = &hlaiv1beta1.DriverPrePullStatus{
+			Version:        testNewVersion,
+			Images:         []string{"old-device-plugin", testDriverImage},
+			CompletionTime: &completed,
+		}
+		build(dc, makeNode("a-node", testKernel))
+
+		version, err := r.ReconcilePrePull(ctx, dc, testNewVersion)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testDeployedVersion))
+		Expect(dc.Status.PrePull.Images).To(Equal([]string{testDevicePluginImage, testDriverImage}))
+		Expect(dc.Status.PrePull.IsComplete()).To(BeFalse())
+		Expect(listDaemonSets()).To(HaveLen(1))
+	})
+
+	It("should only pull the device plugin image of the driver images built in the cluster", func() {
+		dc.Spec.Driver.Build = &hlaiv1beta1.DriverBuildSpec{}
+		build(dc, makeNode("a-node", testKernel))
+
+		_, err := r.ReconcilePrePull(ctx, dc, testNewVersion)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dc.Status.PrePull.Images).To(Equal([]string{testDevicePluginImage}))
+	})
+
+	It("should move the nodes to the version once the pull times out", func() {
+		dc.Spec.Driver.PrePull.TimeoutSeconds = pointer.Int32(60)
+		build(dc, makeNode("a-node", testKernel))
+
+		_, err := r.ReconcilePrePull(ctx, dc, testNewVersion)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(RequeueAfter(dc, time.Now())).To(BeNumerically("~", time.Minute, time.Second))
+		Expect(c.Create(ctx, makePod(testDriverImage, "a-node", "ErrImagePull"))).To(Succeed())
+
+		started := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+		dc.Status.PrePull.StartTime = &started
+
+		version, err := r.ReconcilePrePull(ctx, dc, testNewVersion)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testNewVersion))
+		Expect(dc.Status.PrePull.IsComplete()).To(BeTrue())
+		Expect(dc.Status.PrePull.Message).To(Equal("Timed out with the images pulled on 0 of 1 nodes: ErrImagePull on a-node"))
+		Expect(RequeueAfter(dc, time.Now())).To(BeZero())
+		Expect(listDaemonSets()).To(BeEmpty())
+	})
+
+	It("should not pull the images of a rolled back version", func() {
+		dc.Spec.Driver.Rollback = &hlaiv1beta1.DriverRollbackSpec{}
+		dc.Status.Rollback = &hlaiv1beta1.DriverRollbackStatus{FailedImage: "driver", FailedVersion: testNewVersion, Version: testDeployedVersion}
+		build(dc, makeNode("a-node", testKernel))
+
+		version, err := r.ReconcilePrePull(ctx, dc, testNewVersion)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testNewVersion))
+		Expect(dc.Status.PrePull).To(BeNil())
+		Expect(listDaemonSets()).To(BeEmpty())
+	})
+})
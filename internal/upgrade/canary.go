@@ -0,0 +1,240 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+)
+
+// CanaryPromoteAnnotation is set on a DeviceConfig to the version of its
+// canary to promote it to all the selected nodes.
+const CanaryPromoteAnnotation = "habana.ai/driver-canary-promote"
+
+// reconcileCanary updates the canary of target in cr.Status.Canary, and
+// returns the nodes allowed to start their upgrade, or nil if all of them are.
+// The canary starts when a node has to be moved to target, and is promoted
+// once its nodes stayed healthy long enough, or when it is approved. It is
+// aborted while the upgrade of one of its nodes is failed.
+func (r *upgradeReconciler) reconcileCanary(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, nodes []corev1.Node, target module.Revision, modules []kmmv1beta1.Module) (map[string]bool, error) {
+	spec := cr.Spec.Driver.Canary
+	// A rollback is not tried on canary nodes first.
+	if spec == nil || cr.IsDriverRolledBack() {
+		cr.Status.Canary = nil
+		return nil, nil
+	}
+
+	status := cr.Status.Canary
+	if status == nil || status.Version != target.Version {
+		needed := false
+		for i := range nodes {
+			needed = needed || needsUpgrade(&nodes[i], target)
+		}
+		if !needed {
+			cr.Status.Canary = nil
+			return nil, nil
+		}
+
+		status = &hlaiv1beta1.DriverCanaryStatus{Version: target.Version}
+		cr.Status.Canary = status
+		setCanaryPhase(status, hlaiv1beta1.CanaryPhaseInProgress, "")
+		status.Nodes = selectCanaryNodes(spec, nodes, target)
+
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, "DriverCanaryStarted",
+			"Trying the driver version %s on nodes %s", target.Version, strings.Join(status.Nodes, ", "))
+	}
+
+	if status.Phase == hlaiv1beta1.CanaryPhasePromoted {
+		return nil, nil
+	}
+
+	if cr.Annotations[CanaryPromoteAnnotation] == target.Version {
+		r.promoteCanary(ctx, cr, fmt.Sprintf("approved through the %s annotation", CanaryPromoteAnnotation))
+		return nil, nil
+	}
+
+	canaryNodes := []*corev1.Node{}
+	byName := map[string]*corev1.Node{}
+	for i := range nodes {
+		byName[nodes[i].Name] = &nodes[i]
+	}
+	for _, name := range status.Nodes {
+		if n, ok := byName[name]; ok {
+			canaryNodes = append(canaryNodes, n)
+		}
+	}
+	// The canary nodes left the selection, others are tried instead.
+	if len(canaryNodes) == 0 {
+		status.Nodes = selectCanaryNodes(spec, nodes, target)
+		for _, name := range status.Nodes {
+			canaryNodes = append(canaryNodes, byName[name])
+		}
+	}
+
+	allowed := map[string]bool{}
+	for _, n := range canaryNodes {
+		allowed[n.Name] = true
+	}
+
+	if len(canaryNodes) == 0 {
+		status.HealthySince = nil
+		setCanaryPhase(status, hlaiv1beta1.CanaryPhaseInProgress, "no selected node matches the canary node selector")
+		return allowed, nil
+	}
+
+	for _, n := range canaryNodes {
+		if getState(n) == hlaiv1beta1.NodeUpgradeStateFailed {
+			message := fmt.Sprintf("the upgrade of node %s failed: %s", n.Name, n.Annotations[MessageAnnotation])
+			status.HealthySince = nil
+			if setCanaryPhase(status, hlaiv1beta1.CanaryPhaseAborted, message) {
+				r.recorder.Eventf(cr, corev1.EventTypeWarning, "DriverCanaryAborted",
+					"Aborted the canary of driver version %s, the other nodes keep their version: %s", target.Version, message)
+			}
+			return allowed, nil
+		}
+	}
+
+	problems := []string{}
+	for _, n := range canaryNodes {
+		if needsUpgrade(n, target) || !isUpgraded(n) {
+			problems = append(problems, fmt.Sprintf("%s: upgrading", n.Name))
+			continue
+		}
+
+		nodeProblems, err := r.validate(ctx, cr, n, target, modules)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range nodeProblems {
+			problems = append(problems, fmt.Sprintf("%s: %s", n.Name, p))
+		}
+	}
+
+	if len(problems) > 0 {
+		status.HealthySince = nil
+		setCanaryPhase(status, hlaiv1beta1.CanaryPhaseInProgress, strings.Join(problems, ", "))
+		return allowed, nil
+	}
+
+	if status.HealthySince == nil {
+		now := metav1.Now()
+		status.HealthySince = &now
+	}
+
+	healthy := spec.GetHealthyDuration()
+	if time.Since(status.HealthySince.Time) < healthy {
+		setCanaryPhase(status, hlaiv1beta1.CanaryPhaseInProgress,
+			fmt.Sprintf("the canary nodes are healthy, waiting for them to stay healthy for %s", healthy))
+		return allowed, nil
+	}
+
+	if spec.Promotion == hlaiv1beta1.CanaryPromotionManual {
+		message := fmt.Sprintf("the canary nodes are healthy, set the %s annotation to %s to promote it", CanaryPromoteAnnotation, target.Version)
+		if setCanaryPhase(status, hlaiv1beta1.CanaryPhaseAwaitingApproval, message) {
+			r.recorder.Eventf(cr, corev1.EventTypeNormal, "DriverCanaryAwaitingApproval",
+				"The canary of driver version %s is healthy, waiting for its approval", target.Version)
+		}
+		return allowed, nil
+	}
+
+	r.promoteCanary(ctx, cr, fmt.Sprintf("the canary nodes stayed healthy for %s", healthy))
+
+	return nil, nil
+}
+
+func (r *upgradeReconciler) promoteCanary(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, reason string) {
+	status := cr.Status.Canary
+	setCanaryPhase(status, hlaiv1beta1.CanaryPhasePromoted, reason)
+
+	r.recorder.Eventf(cr, corev1.EventTypeNormal, "DriverCanaryPromoted",
+		"Promoted the driver version %s to all the nodes: %s", status.Version, reason)
+	log.FromContext(ctx).Info("Promoted driver canary", "version", status.Version, "reason", reason)
+}
+
+// setCanaryPhase sets the phase and message of s, and returns true if the
+// phase changed.
+func setCanaryPhase(s *hlaiv1beta1.DriverCanaryStatus, phase hlaiv1beta1.CanaryPhase, message string) bool {
+	s.Message = message
+	if s.Phase == phase {
+		return false
+	}
+
+	now := metav1.Now()
+	s.Phase = phase
+	s.LastTransitionTime = &now
+
+	return true
+}
+
+// selectCanaryNodes returns the names of the canary nodes, among the nodes
+// matching the canary node selector. The nodes that do not run target yet are
+// preferred, so that the canary tries it on as many nodes as requested.
+func selectCanaryNodes(spec *hlaiv1beta1.DriverCanarySpec, nodes []corev1.Node, target module.Revision) []string {
+	selector := labels.SelectorFromSet(spec.NodeSelector)
+
+	outdated := []string{}
+	upToDate := []string{}
+	for i := range nodes {
+		n := &nodes[i]
+		if !selector.Matches(labels.Set(n.Labels)) {
+			continue
+		}
+
+		if needsUpgrade(n, target) {
+			outdated = append(outdated, n.Name)
+		} else {
+			upToDate = append(upToDate, n.Name)
+		}
+	}
+
+	candidates := append(outdated, upToDate...)
+	count := spec.GetNodes(len(nodes), len(candidates))
+	if count > len(candidates) {
+		count = len(candidates)
+	}
+
+	return candidates[:count]
+}
+
+// needsUpgrade returns true if n runs, or is being moved to, another driver
+// revision than target. A new node gets target right away.
+func needsUpgrade(n *corev1.Node, target module.Revision) bool {
+	value, ok := n.Labels[module.NodeVersionLabel]
+	if !ok {
+		return getState(n) != ""
+	}
+
+	return value != target.Label
+}
+
+// isUpgraded returns true if n is not being upgraded.
+func isUpgraded(n *corev1.Node) bool {
+	state := getState(n)
+	return state == "" || state == hlaiv1beta1.NodeUpgradeStateDone
+}
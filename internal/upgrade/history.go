@@ -0,0 +1,153 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
+	"github.com/HabanaAI/habana-ai-operator/internal/maintenance"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+)
+
+const (
+	// ChangedByAnnotation is set by the webhook on a DeviceConfig to the user
+	// who last changed its driver image or version.
+	ChangedByAnnotation = "habana.ai/driver-changed-by"
+
+	// triggeredByRollback is who triggers the rollout of the last known-good
+	// driver a failing version is rolled back to.
+	triggeredByRollback = "rollback"
+)
+
+// openHistory records in the driver history of cr the start of the rollout of
+// target, unless it is the latest one. A rollout that was still in progress is
+// superseded by it.
+func (r *upgradeReconciler) openHistory(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, nodes []corev1.Node, known map[string]module.Revision, target string) {
+	image := getAppliedImage(cr)
+
+	history := cr.Status.DriverHistory
+	if len(history) > 0 {
+		last := &history[len(history)-1]
+		if last.Image == image && last.Version == target {
+			return
+		}
+
+		if last.IsInProgress() {
+			completeHistory(last, nodes, known, hlaiv1beta1.DriverOutcomeSuperseded, fmt.Sprintf("superseded by version %s", target))
+			r.recorder.Eventf(cr, corev1.EventTypeNormal, "DriverRolloutSuperseded",
+				"Stopped the rollout of the driver version %s for version %s", last.Version, target)
+		}
+	}
+
+	triggeredBy := cr.Annotations[ChangedByAnnotation]
+	if cr.IsDriverRolledBack() {
+		triggeredBy = triggeredByRollback
+	}
+
+	now := metav1.Now()
+	addHistory(cr, hlaiv1beta1.DriverHistoryEntry{
+		Image:       image,
+		Version:     target,
+		TriggeredBy: triggeredBy,
+		Outcome:     hlaiv1beta1.DriverOutcomeInProgress,
+		StartTime:   &now,
+	})
+
+	by := ""
+	if triggeredBy != "" {
+		by = fmt.Sprintf(", triggered by %s", triggeredBy)
+	}
+	r.recorder.Eventf(cr, corev1.EventTypeNormal, "DriverRolloutStarted",
+		"Rolling out the driver version %s on %d nodes%s", target, len(nodes), by)
+	log.FromContext(ctx).Info("Started driver rollout", "image", image, "version", target, "triggeredBy", triggeredBy)
+}
+
+// getRollout returns the driver history entry of the rollout of version in
+// progress, or nil.
+func getRollout(cr *hlaiv1beta1.DeviceConfig, version string) *hlaiv1beta1.DriverHistoryEntry {
+	history := cr.Status.DriverHistory
+	if len(history) == 0 {
+		return nil
+	}
+
+	if last := &history[len(history)-1]; last.IsInProgress() && last.Version == version {
+		return last
+	}
+
+	return nil
+}
+
+// completeHistory records the outcome of the rollout of e, with the node
+// counts at its completion. known maps the revision labels of the nodes to
+// their driver revisions, any of which runs the version of e.
+func completeHistory(e *hlaiv1beta1.DriverHistoryEntry, nodes []corev1.Node, known map[string]module.Revision, outcome hlaiv1beta1.DriverOutcome, message string) {
+	now := metav1.Now()
+	e.Outcome = outcome
+	e.CompletionTime = &now
+	e.Message = message
+	e.Nodes = int32(len(nodes))
+	e.UpgradedNodes = 0
+	e.FailedNodes = 0
+
+	for i := range nodes {
+		n := &nodes[i]
+		value := n.Labels[module.NodeVersionLabel]
+		rev, ok := known[value]
+		// The Modules of the version of e may already be deleted.
+		if !ok {
+			rev = module.Revision{Version: e.Version, Label: instance.LabelValue(e.Version)}
+		}
+		if value != rev.Label || rev.Version != e.Version {
+			continue
+		}
+
+		switch getState(n) {
+		case "", hlaiv1beta1.NodeUpgradeStateDone:
+			e.UpgradedNodes++
+		case hlaiv1beta1.NodeUpgradeStateFailed:
+			e.FailedNodes++
+		}
+	}
+}
+
+// addHistory appends e to the driver history of cr, dropping the oldest
+// entries beyond MaxDriverHistory.
+func addHistory(cr *hlaiv1beta1.DeviceConfig, e hlaiv1beta1.DriverHistoryEntry) {
+	history := append(cr.Status.DriverHistory, e)
+	if len(history) > hlaiv1beta1.MaxDriverHistory {
+		history = history[len(history)-hlaiv1beta1.MaxDriverHistory:]
+	}
+
+	cr.Status.DriverHistory = history
+}
+
+// getAppliedImage returns the driver image applied to the nodes of cr, as
+// resolved for its Modules.
+func getAppliedImage(cr *hlaiv1beta1.DeviceConfig) string {
+	if cr.IsDriverRolledBack() {
+		return cr.Status.Rollback.Image
+	}
+
+	return module.GetDriverImage(maintenance.GetAppliedDeviceConfig(cr))
+}
@@ -0,0 +1,66 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: upgrade.go
+
+// Package upgrade is a generated GoMock package.
+package upgrade
+
+import (
+	context "context"
+	reflect "reflect"
+
+	v1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	module "github.com/HabanaAI/habana-ai-operator/internal/module"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockReconciler is a mock of Reconciler interface.
+type MockReconciler struct {
+	ctrl     *gomock.Controller
+	recorder *MockReconcilerMockRecorder
+}
+
+// MockReconcilerMockRecorder is the mock recorder for MockReconciler.
+type MockReconcilerMockRecorder struct {
+	mock *MockReconciler
+}
+
+// NewMockReconciler creates a new mock instance.
+func NewMockReconciler(ctrl *gomock.Controller) *MockReconciler {
+	mock := &MockReconciler{ctrl: ctrl}
+	mock.recorder = &MockReconcilerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReconciler) EXPECT() *MockReconcilerMockRecorder {
+	return m.recorder
+}
+
+// ClearUpgrade mocks base method.
+func (m *MockReconciler) ClearUpgrade(ctx context.Context, cr *v1beta1.DeviceConfig) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClearUpgrade", ctx, cr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ClearUpgrade indicates an expected call of ClearUpgrade.
+func (mr *MockReconcilerMockRecorder) ClearUpgrade(ctx, cr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearUpgrade", reflect.TypeOf((*MockReconciler)(nil).ClearUpgrade), ctx, cr)
+}
+
+// ReconcileUpgrade mocks base method.
+func (m *MockReconciler) ReconcileUpgrade(ctx context.Context, cr *v1beta1.DeviceConfig, target string) ([]module.Revision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReconcileUpgrade", ctx, cr, target)
+	ret0, _ := ret[0].([]module.Revision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReconcileUpgrade indicates an expected call of ReconcileUpgrade.
+func (mr *MockReconcilerMockRecorder) ReconcileUpgrade(ctx, cr, target interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReconcileUpgrade", reflect.TypeOf((*MockReconciler)(nil).ReconcileUpgrade), ctx, cr, target)
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
+)
+
+const (
+	// RollbackDisabledAnnotation is set on a DeviceConfig to a driver version
+	// that is kept when it fails, e.g. to debug it.
+	RollbackDisabledAnnotation = "habana.ai/driver-rollback-disabled"
+
+	// maxListedFailures is the number of failing nodes detailed in the reason
+	// of a rollback.
+	maxListedFailures = 5
+)
+
+// reconcileRollback records target as the last known-good driver of cr once
+// it is ready on all the nodes, and returns the driver version to move the
+// nodes to. With a rollback policy, it is the last known-good one once target
+// fails on FailedNodesThreshold nodes, which is reported in cr.Status.Rollback.
+func (r *upgradeReconciler) reconcileRollback(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, nodes []corev1.Node, known map[string]module.Revision, target module.Revision, modules []kmmv1beta1.Module) (string, error) {
+	if len(nodes) == 0 || getRollout(cr, target.Version) == nil {
+		return target.Version, nil
+	}
+
+	failing, ready, err := r.getDriverHealth(ctx, cr, nodes, target, modules)
+	if err != nil {
+		return "", err
+	}
+
+	if ready {
+		r.setKnownGood(ctx, cr, nodes, known, target.Version)
+		return target.Version, nil
+	}
+
+	// A version held back by preflight is not the one to roll back, nor is
+	// a new image of the last known-good version.
+	spec := cr.Spec.Driver.Rollback
+	good := cr.Status.LastKnownGoodDriver
+	if spec == nil || good == nil || good.Version == target.Version || target.Version != cr.Spec.Driver.Version || cr.Annotations[RollbackDisabledAnnotation] == target.Version {
+		return target.Version, nil
+	}
+
+	if len(failing) < spec.GetFailedNodesThreshold(len(nodes)) {
+		return target.Version, nil
+	}
+
+	return good.Version, r.rollback(ctx, cr, nodes, known, target, failing)
+}
+
+// cancelRollback clears the rollback of cr once it is disabled for the failed
+// version, which moves the nodes to that version again.
+func (r *upgradeReconciler) cancelRollback(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) {
+	rb := cr.Status.Rollback
+	if !cr.IsDriverRolledBack() || cr.Annotations[RollbackDisabledAnnotation] != rb.FailedVersion {
+		return
+	}
+
+	cr.Status.Rollback = nil
+	r.recorder.Eventf(cr, corev1.EventTypeNormal, "DriverRollbackCancelled",
+		"Rolling out the driver version %s again, its rollback being disabled", rb.FailedVersion)
+	log.FromContext(ctx).Info("Cancelled driver rollback", "version", rb.FailedVersion)
+}
+
+// getDriverHealth returns the nodes failing with the target driver, with the
+// reason, and whether target is ready on all the nodes.
+func (r *upgradeReconciler) getDriverHealth(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, nodes []corev1.Node, target module.Revision, modules []kmmv1beta1.Module) ([]string, bool, error) {
+	current := getRevisionModules(cr, modules, target)
+
+	byRole := map[string]map[string]*corev1.Pod{}
+	for _, c := range components {
+		kmmPods, err := module.ListPods(ctx, r.reader, cr, current, c.role)
+		if err != nil {
+			return nil, false, err
+		}
+		byRole[c.role] = pods.ByNode(kmmPods)
+	}
+
+	failing := []string{}
+	ready := true
+	for i := range nodes {
+		n := &nodes[i]
+
+		// A node failing before its reload, e.g. to be drained, does not
+		// tell anything about target.
+		if n.Labels[module.NodeVersionLabel] != target.Label {
+			ready = false
+			continue
+		}
+
+		if getState(n) == hlaiv1beta1.NodeUpgradeStateFailed {
+			failing = append(failing, fmt.Sprintf("%s (%s)", n.Name, n.Annotations[MessageAnnotation]))
+			ready = false
+			continue
+		}
+
+		reason := ""
+		ready = ready && isUpgraded(n)
+		for _, c := range components {
+			p, ok := byRole[c.role][n.Name]
+			if ok && reason == "" && pods.FailureReason(p) != "" {
+				reason = fmt.Sprintf("%s: %s", c.name, pods.FailureReason(p))
+			}
+			ready = ready && ok && pods.IsReady(p)
+		}
+
+		if reason != "" {
+			failing = append(failing, fmt.Sprintf("%s (%s)", n.Name, reason))
+		}
+	}
+
+	return failing, ready, nil
+}
+
+// setKnownGood records version as the last known-good driver of cr, and its
+// rollout as succeeded.
+func (r *upgradeReconciler) setKnownGood(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, nodes []corev1.Node, known map[string]module.Revision, version string) {
+	image := getAppliedImage(cr)
+	cr.Status.LastKnownGoodDriver = &hlaiv1beta1.KnownGoodDriver{
+		Image:   image,
+		Version: version,
+		Time:    metav1.Now(),
+	}
+
+	if e := getRollout(cr, version); e != nil {
+		completeHistory(e, nodes, known, hlaiv1beta1.DriverOutcomeSucceeded, "")
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, "DriverRolloutSucceeded",
+			"Rolled out the driver version %s on %d nodes", version, len(nodes))
+	}
+
+	log.FromContext(ctx).Info("Recorded last known-good driver", "image", image, "version", version)
+}
+
+// rollback moves the nodes of cr from target back to its last known-good
+// driver. The nodes whose upgrade to target failed are moved back too.
+func (r *upgradeReconciler) rollback(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, nodes []corev1.Node, known map[string]module.Revision, target module.Revision, failing []string) error {
+	good := cr.Status.LastKnownGoodDriver
+	failed := getAppliedImage(cr)
+
+	listed := failing
+	if len(listed) > maxListedFailures {
+		listed = listed[:maxListedFailures]
+	}
+	reason := fmt.Sprintf("failing on %d of %d nodes: %s", len(failing), len(nodes), strings.Join(listed, ", "))
+
+	now := metav1.Now()
+	cr.Status.Rollback = &hlaiv1beta1.DriverRollbackStatus{
+		FailedImage:   failed,
+		FailedVersion: target.Version,
+		Image:         good.Image,
+		Version:       good.Version,
+		Reason:        reason,
+		Time:          now,
+	}
+	cr.Status.Canary = nil
+	if e := getRollout(cr, target.Version); e != nil {
+		completeHistory(e, nodes, known, hlaiv1beta1.DriverOutcomeRolledBack, fmt.Sprintf("rolled back to version %s: %s", good.Version, reason))
+		// The nodes still validating target may be failing too.
+		e.FailedNodes = int32(len(failing))
+	}
+
+	for i := range nodes {
+		n := &nodes[i]
+		if getState(n) == hlaiv1beta1.NodeUpgradeStateFailed && n.Labels[module.NodeVersionLabel] == target.Label {
+			if err := r.setState(ctx, cr, n, hlaiv1beta1.NodeUpgradeStateRequired, ""); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.recorder.Eventf(cr, corev1.EventTypeWarning, "DriverRolledBack",
+		"Rolled back the driver from version %s to %s: %s", target.Version, good.Version, reason)
+	log.FromContext(ctx).Info("Rolled back driver", "from", target.Version, "to", good.Version, "reason", reason)
+
+	return nil
+}
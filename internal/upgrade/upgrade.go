@@ -0,0 +1,659 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/maintenance"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
+)
+
+const (
+	// StateLabel is set on the selected nodes to their upgrade state, so that
+	// an upgrade resumes where it stopped after an operator restart.
+	StateLabel = "habana.ai/driver-upgrade-state"
+
+	// MessageAnnotation details why the upgrade of a node failed.
+	MessageAnnotation = "habana.ai/driver-upgrade-message"
+
+	// stateTimeAnnotation records when a node entered its upgrade state, which
+	// the drain and validation timeouts start from.
+	stateTimeAnnotation = "habana.ai/driver-upgrade-state-time"
+
+	// cordonedAnnotation marks the nodes cordoned by their upgrade, which are
+	// uncordoned once it completes. The nodes cordoned by an administrator
+	// are left cordoned.
+	cordonedAnnotation = "habana.ai/driver-upgrade-cordoned"
+
+	// requeueAfter is how often a node being upgraded is checked, since the
+	// evicted pods and the KMM pods are not watched.
+	requeueAfter = 10 * time.Second
+)
+
+//go:generate mockgen -source=upgrade.go -package=upgrade -destination=mock_upgrade.go
+
+type Reconciler interface {
+	ReconcileUpgrade(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, target string) ([]module.Revision, error)
+	ClearUpgrade(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error
+}
+
+type upgradeReconciler struct {
+	client client.Client
+	// reader lists the pods the manager cache does not hold: the cache is
+	// restricted to the operator namespace, and to the operand pods.
+	reader   client.Reader
+	recorder record.EventRecorder
+}
+
+func NewReconciler(c client.Client, reader client.Reader, recorder record.EventRecorder) Reconciler {
+	return &upgradeReconciler{client: c, reader: reader, recorder: recorder}
+}
+
+// RequeueAfter returns when to check the upgrade of cr again, or 0 if no node
+// is being upgraded nor canary waiting to be promoted.
+func RequeueAfter(cr *hlaiv1beta1.DeviceConfig) time.Duration {
+	if u := cr.Status.Upgrade; u != nil && u.UpgradingNodes > 0 {
+		return requeueAfter
+	}
+
+	if c := cr.Status.Canary; c != nil && c.Phase == hlaiv1beta1.CanaryPhaseInProgress {
+		return requeueAfter
+	}
+
+	return 0
+}
+
+// getState returns the upgrade state of n. A node without state has not been
+// through an upgrade yet.
+func getState(n *corev1.Node) hlaiv1beta1.NodeUpgradeState {
+	return hlaiv1beta1.NodeUpgradeState(n.Labels[StateLabel])
+}
+
+// isUnavailable returns true if n is taken out of service by its upgrade.
+func isUnavailable(state hlaiv1beta1.NodeUpgradeState) bool {
+	switch state {
+	case "", hlaiv1beta1.NodeUpgradeStateDone, hlaiv1beta1.NodeUpgradeStateRequired:
+		return false
+	}
+
+	return true
+}
+
+// ReconcileUpgrade moves the nodes of cr to the target driver version, with
+// the modprobe configuration applied to them, and returns the driver
+// revisions deployed on them, target first. The nodes are labelled with their
+// driver revision, which the Modules select, and with their upgrade state, so
+// that a new modprobe configuration is rolled out like a new version. With an
+// upgrade policy, at most maxUnavailable nodes at a time are cordoned, drained
+// of the pods using their HPUs, moved to target and validated before being
+// uncordoned. Without, all the nodes are moved at once.
+// With a canary, only its nodes are moved until it is promoted. With a
+// rollback policy, the nodes are moved back to the last known-good driver
+// when target fails on too many of them. The progress is reported in
+// cr.Status.Upgrade, cr.Status.Canary and cr.Status.Rollback, and each rollout
+// is recorded in cr.Status.DriverHistory.
+func (r *upgradeReconciler) ReconcileUpgrade(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, version string) ([]module.Revision, error) {
+	r.cancelRollback(ctx, cr)
+
+	if cr.IsDriverRolledBack() {
+		version = cr.Status.Rollback.Version
+	} else {
+		cr.Status.Rollback = nil
+	}
+
+	if err := r.clearOrphanNodes(ctx); err != nil {
+		return nil, err
+	}
+
+	nodes, err := r.listNodes(ctx, cr)
+	if err != nil {
+		return nil, err
+	}
+
+	modules, err := module.ListModules(ctx, r.client, cr)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := module.GetRevision(maintenance.GetAppliedDeviceConfig(cr), modules, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// known maps the revision labels to the deployed driver revisions. A
+	// Module without revision in its selector deploys the driver on all the
+	// nodes, which were not labelled with their revision yet.
+	known := map[string]module.Revision{target.Label: target}
+	legacy := module.Revision{}
+	for i := range modules {
+		rev := module.GetModuleRevision(&modules[i], cr)
+		known[rev.Label] = rev
+		if _, ok := modules[i].Spec.Selector[module.NodeVersionLabel]; !ok {
+			legacy = rev
+		}
+	}
+
+	if len(nodes) > 0 {
+		r.openHistory(ctx, cr, nodes, known, target.Version)
+	}
+
+	maxUnavailable := len(nodes)
+	if p := cr.Spec.Driver.UpgradePolicy; p != nil {
+		maxUnavailable = p.GetMaxUnavailable(len(nodes))
+	}
+
+	unavailable := 0
+	for i := range nodes {
+		if isUnavailable(getState(&nodes[i])) {
+			unavailable++
+		}
+	}
+
+	for i := range nodes {
+		n := &nodes[i]
+
+		// A new node has no workload to drain, and gets the target version
+		// right away.
+		if _, ok := n.Labels[module.NodeVersionLabel]; !ok && getState(n) == "" {
+			rev := target
+			if legacy.Label != "" {
+				rev = legacy
+			}
+			if err := r.setRevision(ctx, n, rev); err != nil {
+				return nil, err
+			}
+			if err := r.setState(ctx, cr, n, hlaiv1beta1.NodeUpgradeStateDone, ""); err != nil {
+				return nil, err
+			}
+		}
+
+		upToDate := n.Labels[module.NodeVersionLabel] == target.Label
+		switch state := getState(n); {
+		case (state == "" || state == hlaiv1beta1.NodeUpgradeStateDone) && !upToDate:
+			if err := r.setState(ctx, cr, n, hlaiv1beta1.NodeUpgradeStateRequired, ""); err != nil {
+				return nil, err
+			}
+		// The target changed back to the revision of the node before its turn.
+		case state == hlaiv1beta1.NodeUpgradeStateRequired && upToDate:
+			if err := r.setState(ctx, cr, n, hlaiv1beta1.NodeUpgradeStateDone, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	allowed, err := r.reconcileCanary(ctx, cr, nodes, target, modules)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range nodes {
+		n := &nodes[i]
+
+		switch getState(n) {
+		case "", hlaiv1beta1.NodeUpgradeStateDone, hlaiv1beta1.NodeUpgradeStateFailed:
+			continue
+		case hlaiv1beta1.NodeUpgradeStateRequired:
+			if allowed != nil && !allowed[n.Name] {
+				continue
+			}
+			if unavailable >= maxUnavailable {
+				continue
+			}
+			unavailable++
+
+			r.recorder.Eventf(cr, corev1.EventTypeNormal, "NodeUpgradeStarted",
+				"Upgrading the driver of node %s to version %s", n.Name, target.Version)
+		}
+
+		if err := r.advance(ctx, cr, n, target, modules); err != nil {
+			return nil, err
+		}
+	}
+
+	rollback, err := r.reconcileRollback(ctx, cr, nodes, known, target, modules)
+	if err != nil {
+		return nil, err
+	}
+	if rollback != target.Version {
+		if target, err = module.GetRevision(maintenance.GetAppliedDeviceConfig(cr), modules, rollback); err != nil {
+			return nil, err
+		}
+	}
+
+	r.setStatus(cr, nodes, target.Version)
+
+	revisions := []module.Revision{target}
+	seen := map[string]bool{target.Label: true}
+	for i := range nodes {
+		if rev, ok := known[nodes[i].Labels[module.NodeVersionLabel]]; ok && !seen[rev.Label] {
+			seen[rev.Label] = true
+			revisions = append(revisions, rev)
+		}
+	}
+	sort.Slice(revisions[1:], func(i, j int) bool {
+		return revisions[1+i].Label < revisions[1+j].Label
+	})
+
+	return revisions, nil
+}
+
+// advance moves n through the upgrade states until it has to wait.
+func (r *upgradeReconciler) advance(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, n *corev1.Node, target module.Revision, modules []kmmv1beta1.Module) error {
+	for {
+		state := getState(n)
+
+		next, message, err := r.step(ctx, cr, n, target, modules)
+		if err != nil {
+			return fmt.Errorf("failed to upgrade node %s: %w", n.Name, err)
+		}
+
+		if next == state {
+			return nil
+		}
+
+		if err := r.setState(ctx, cr, n, next, message); err != nil {
+			return err
+		}
+
+		switch next {
+		case hlaiv1beta1.NodeUpgradeStateDone:
+			r.recorder.Eventf(cr, corev1.EventTypeNormal, "NodeUpgraded",
+				"Upgraded the driver of node %s to version %s", n.Name, target.Version)
+			return nil
+		case hlaiv1beta1.NodeUpgradeStateFailed:
+			r.recorder.Eventf(cr, corev1.EventTypeWarning, "NodeUpgradeFailed",
+				"Failed to upgrade the driver of node %s to version %s: %s", n.Name, target.Version, message)
+			return nil
+		}
+	}
+}
+
+// step performs the action of the upgrade state of n, and returns the next
+// state, or the same one if n has to wait, with a message for a failure.
+func (r *upgradeReconciler) step(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, n *corev1.Node, target module.Revision, modules []kmmv1beta1.Module) (hlaiv1beta1.NodeUpgradeState, string, error) {
+	policy := cr.Spec.Driver.UpgradePolicy
+
+	switch state := getState(n); state {
+	case hlaiv1beta1.NodeUpgradeStateRequired:
+		if policy == nil {
+			return hlaiv1beta1.NodeUpgradeStateDriverReloadRequired, "", nil
+		}
+		return hlaiv1beta1.NodeUpgradeStateCordonRequired, "", nil
+
+	case hlaiv1beta1.NodeUpgradeStateCordonRequired:
+		if err := r.cordon(ctx, n); err != nil {
+			return state, "", err
+		}
+		return hlaiv1beta1.NodeUpgradeStateDrainRequired, "", nil
+
+	case hlaiv1beta1.NodeUpgradeStateDrainRequired:
+		remaining, err := r.drain(ctx, cr, n)
+		if err != nil {
+			return state, "", err
+		}
+		if len(remaining) == 0 {
+			return hlaiv1beta1.NodeUpgradeStateDriverReloadRequired, "", nil
+		}
+		if policy != nil && timedOut(n, policy.GetDrainTimeout()) {
+			return hlaiv1beta1.NodeUpgradeStateFailed,
+				fmt.Sprintf("drain timed out, pods still using HPUs: %s", strings.Join(remaining, ", ")), nil
+		}
+		return state, "", nil
+
+	case hlaiv1beta1.NodeUpgradeStateDriverReloadRequired:
+		reloaded, err := r.reload(ctx, cr, n, target)
+		if err != nil || !reloaded {
+			return state, "", err
+		}
+		return hlaiv1beta1.NodeUpgradeStateValidationRequired, "", nil
+
+	case hlaiv1beta1.NodeUpgradeStateValidationRequired:
+		// The target changed before the previous one was validated.
+		if n.Labels[module.NodeVersionLabel] != target.Label {
+			return hlaiv1beta1.NodeUpgradeStateDriverReloadRequired, "", nil
+		}
+
+		problems, err := r.validate(ctx, cr, n, target, modules)
+		if err != nil {
+			return state, "", err
+		}
+		if len(problems) == 0 {
+			return hlaiv1beta1.NodeUpgradeStateUncordonRequired, "", nil
+		}
+		if policy != nil && timedOut(n, policy.GetValidationTimeout()) {
+			return hlaiv1beta1.NodeUpgradeStateFailed,
+				fmt.Sprintf("validation timed out: %s", strings.Join(problems, ", ")), nil
+		}
+		return state, "", nil
+
+	case hlaiv1beta1.NodeUpgradeStateUncordonRequired:
+		if err := r.uncordon(ctx, n); err != nil {
+			return state, "", err
+		}
+		return hlaiv1beta1.NodeUpgradeStateDone, "", nil
+
+	default:
+		return state, "", nil
+	}
+}
+
+// cordon marks n unschedulable, unless an administrator already did.
+func (r *upgradeReconciler) cordon(ctx context.Context, n *corev1.Node) error {
+	if n.Spec.Unschedulable {
+		return nil
+	}
+
+	patch := client.MergeFrom(n.DeepCopy())
+	n.Spec.Unschedulable = true
+	metav1.SetMetaDataAnnotation(&n.ObjectMeta, cordonedAnnotation, "true")
+	if err := r.client.Patch(ctx, n, patch); err != nil {
+		return fmt.Errorf("failed to cordon node: %w", err)
+	}
+
+	log.FromContext(ctx).Info("Cordoned node", "node", n.Name)
+
+	return nil
+}
+
+// uncordon marks n schedulable again, if its upgrade cordoned it.
+func (r *upgradeReconciler) uncordon(ctx context.Context, n *corev1.Node) error {
+	if _, ok := n.Annotations[cordonedAnnotation]; !ok {
+		return nil
+	}
+
+	patch := client.MergeFrom(n.DeepCopy())
+	n.Spec.Unschedulable = false
+	delete(n.Annotations, cordonedAnnotation)
+	if err := r.client.Patch(ctx, n, patch); err != nil {
+		return fmt.Errorf("failed to uncordon node: %w", err)
+	}
+
+	log.FromContext(ctx).Info("Uncordoned node", "node", n.Name)
+
+	return nil
+}
+
+// drain evicts the pods using the HPUs of n, and returns the ones still
+// running, see pods.Drain.
+func (r *upgradeReconciler) drain(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, n *corev1.Node) ([]string, error) {
+	return pods.Drain(ctx, r.client, r.reader, n.Name, cr.GetDeviceType().GetResourceName(), true)
+}
+
+// reload moves n from its previous driver revision to target. The revision
+// label is removed first, so that KMM unloads the previous driver before the
+// target one is loaded, and it returns true once n is labelled with target.
+func (r *upgradeReconciler) reload(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, n *corev1.Node, target module.Revision) (bool, error) {
+	value, ok := n.Labels[module.NodeVersionLabel]
+	if ok && value == target.Label {
+		return true, nil
+	}
+
+	if ok {
+		return false, r.removeVersion(ctx, n)
+	}
+
+	// The Module of the previous version may already be deleted, along with
+	// its DaemonSets, while the driver is being unloaded by their pods.
+	podList := &corev1.PodList{}
+	opts := []client.ListOption{
+		client.InNamespace(cr.Namespace),
+		client.HasLabels{module.KMMModuleNameLabel},
+	}
+	if err := r.reader.List(ctx, podList, opts...); err != nil {
+		return false, fmt.Errorf("failed to list KMM pods: %w", err)
+	}
+
+	for _, p := range podList.Items {
+		if p.Spec.NodeName == n.Name && module.IsModuleName(cr, p.Labels[module.KMMModuleNameLabel]) {
+			return false, nil
+		}
+	}
+
+	return true, r.setRevision(ctx, n, target)
+}
+
+// components are the KMM pods running the driver on a node.
+var components = []struct {
+	name string
+	role string
+}{
+	{"driver", module.KMMModuleLoaderRole},
+	{"device plugin", module.KMMDevicePluginRole},
+}
+
+// getRevisionModules returns the Modules of cr deploying rev.
+func getRevisionModules(cr *hlaiv1beta1.DeviceConfig, modules []kmmv1beta1.Module, rev module.Revision) []kmmv1beta1.Module {
+	current := []kmmv1beta1.Module{}
+	for i := range modules {
+		if module.GetModuleRevision(&modules[i], cr) == rev && modules[i].DeletionTimestamp.IsZero() {
+			current = append(current, modules[i])
+		}
+	}
+
+	return current
+}
+
+// validate returns why the target driver is not ready on n yet.
+func (r *upgradeReconciler) validate(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, n *corev1.Node, target module.Revision, modules []kmmv1beta1.Module) ([]string, error) {
+	current := getRevisionModules(cr, modules, target)
+
+	problems := []string{}
+	for _, c := range components {
+		kmmPods, err := module.ListPods(ctx, r.reader, cr, current, c.role)
+		if err != nil {
+			return nil, err
+		}
+
+		p, ok := pods.ByNode(kmmPods)[n.Name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("no %s pod", c.name))
+		case pods.FailureReason(p) != "":
+			problems = append(problems, fmt.Sprintf("%s: %s", c.name, pods.FailureReason(p)))
+		case !pods.IsReady(p):
+			problems = append(problems, fmt.Sprintf("%s not ready", c.name))
+		}
+	}
+
+	if q, ok := n.Status.Allocatable[cr.GetDeviceType().GetResourceName()]; !ok || q.IsZero() {
+		problems = append(problems, "no HPU allocatable")
+	}
+
+	return problems, nil
+}
+
+// timedOut returns true if n has been in its upgrade state for longer than
+// timeout. A zero timeout never expires.
+func timedOut(n *corev1.Node, timeout time.Duration) bool {
+	if timeout == 0 {
+		return false
+	}
+
+	since, err := time.Parse(time.RFC3339, n.Annotations[stateTimeAnnotation])
+	if err != nil {
+		return false
+	}
+
+	return time.Since(since) > timeout
+}
+
+func (r *upgradeReconciler) setState(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, n *corev1.Node, state hlaiv1beta1.NodeUpgradeState, message string) error {
+	patch := client.MergeFrom(n.DeepCopy())
+	if n.Labels == nil {
+		n.Labels = map[string]string{}
+	}
+	n.Labels[StateLabel] = string(state)
+	metav1.SetMetaDataAnnotation(&n.ObjectMeta, stateTimeAnnotation, time.Now().UTC().Format(time.RFC3339))
+	if message != "" {
+		metav1.SetMetaDataAnnotation(&n.ObjectMeta, MessageAnnotation, message)
+	} else {
+		delete(n.Annotations, MessageAnnotation)
+	}
+
+	if err := r.client.Patch(ctx, n, patch); err != nil {
+		return fmt.Errorf("failed to set upgrade state of node %s: %w", n.Name, err)
+	}
+
+	log.FromContext(ctx).Info("Updated node upgrade state", "node", n.Name, "state", state, "message", message)
+
+	return nil
+}
+
+func (r *upgradeReconciler) setRevision(ctx context.Context, n *corev1.Node, rev module.Revision) error {
+	patch := client.MergeFrom(n.DeepCopy())
+	if n.Labels == nil {
+		n.Labels = map[string]string{}
+	}
+	n.Labels[module.NodeVersionLabel] = rev.Label
+
+	if err := r.client.Patch(ctx, n, patch); err != nil {
+		return fmt.Errorf("failed to set driver revision of node %s: %w", n.Name, err)
+	}
+
+	log.FromContext(ctx).Info("Updated node driver revision", "node", n.Name, "version", rev.Version, "revision", rev.Label)
+
+	return nil
+}
+
+func (r *upgradeReconciler) removeVersion(ctx context.Context, n *corev1.Node) error {
+	patch := client.MergeFrom(n.DeepCopy())
+	delete(n.Labels, module.NodeVersionLabel)
+
+	if err := r.client.Patch(ctx, n, patch); err != nil {
+		return fmt.Errorf("failed to remove driver revision of node %s: %w", n.Name, err)
+	}
+
+	log.FromContext(ctx).Info("Removed node driver revision", "node", n.Name)
+
+	return nil
+}
+
+// setStatus counts the nodes of cr by upgrade state, and records an Event
+// when an upgrade starts or completes.
+func (r *upgradeReconciler) setStatus(cr *hlaiv1beta1.DeviceConfig, nodes []corev1.Node, target string) {
+	previous := cr.Status.Upgrade
+
+	status := &hlaiv1beta1.DriverUpgradeStatus{TargetVersion: target}
+	for i := range nodes {
+		switch state := getState(&nodes[i]); {
+		case state == "" || state == hlaiv1beta1.NodeUpgradeStateDone:
+			status.UpgradedNodes++
+		case state == hlaiv1beta1.NodeUpgradeStateRequired:
+			status.PendingNodes++
+		case state == hlaiv1beta1.NodeUpgradeStateFailed:
+			status.FailedNodes++
+		default:
+			status.UpgradingNodes++
+		}
+	}
+	cr.Status.Upgrade = status
+
+	switch {
+	case !status.IsComplete() && (previous == nil || previous.TargetVersion != target):
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, "DriverUpgradeStarted",
+			"Upgrading the driver to version %s on %d nodes", target, len(nodes))
+	case status.IsComplete() && previous != nil && !(previous.TargetVersion == target && previous.IsComplete()):
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, "DriverUpgradeCompleted",
+			"Upgraded the driver to version %s on %d nodes", target, len(nodes))
+	}
+}
+
+// ClearUpgrade removes the upgrade labels and annotations of the nodes of cr,
+// and uncordons the ones an upgrade cordoned.
+func (r *upgradeReconciler) ClearUpgrade(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) error {
+	nodes, err := r.listNodes(ctx, cr)
+	if err != nil {
+		return err
+	}
+
+	return r.clearNodes(ctx, nodes)
+}
+
+// clearOrphanNodes clears the nodes no DeviceConfig selects anymore.
+func (r *upgradeReconciler) clearOrphanNodes(ctx context.Context) error {
+	nodeList := &corev1.NodeList{}
+	if err := r.client.List(ctx, nodeList, client.HasLabels{StateLabel}); err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	orphans := []corev1.Node{}
+	for _, n := range nodeList.Items {
+		if _, ok := n.Labels[nodetargets.TargetLabel]; !ok {
+			orphans = append(orphans, n)
+		}
+	}
+
+	return r.clearNodes(ctx, orphans)
+}
+
+func (r *upgradeReconciler) clearNodes(ctx context.Context, nodes []corev1.Node) error {
+	for i := range nodes {
+		n := &nodes[i]
+
+		patch := client.MergeFrom(n.DeepCopy())
+		if _, ok := n.Annotations[cordonedAnnotation]; ok {
+			n.Spec.Unschedulable = false
+		}
+		delete(n.Labels, StateLabel)
+		delete(n.Labels, module.NodeVersionLabel)
+		for _, a := range []string{cordonedAnnotation, stateTimeAnnotation, MessageAnnotation} {
+			delete(n.Annotations, a)
+		}
+
+		if err := r.client.Patch(ctx, n, patch); err != nil {
+			return fmt.Errorf("failed to clear upgrade state of node %s: %w", n.Name, err)
+		}
+
+		log.FromContext(ctx).Info("Cleared node upgrade state", "node", n.Name)
+	}
+
+	return nil
+}
+
+// listNodes returns the nodes of cr sorted by name, which is the order they
+// are upgraded in.
+func (r *upgradeReconciler) listNodes(ctx context.Context, cr *hlaiv1beta1.DeviceConfig) ([]corev1.Node, error) {
+	nodeList := &corev1.NodeList{}
+	opts := []client.ListOption{
+		client.MatchingLabels{nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(cr)},
+	}
+	if err := r.client.List(ctx, nodeList, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	sort.Slice(nodeList.Items, func(i, j int) bool {
+		return nodeList.Items[i].Name < nodeList.Items[j].Name
+	})
+
+	return nodeList.Items, nil
+}
@@ -0,0 +1,917 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	"github.com/HabanaAI/habana-ai-operator/internal/pods"
+	"github.com/HabanaAI/habana-ai-operator/internal/settings"
+	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+)
+
+const (
+	testNamespace = "a-namespace"
+
+	oldVersion = "1.8.0-1"
+	newVersion = "1.9.0-1"
+)
+
+// evictingClient evicts pods by deleting them, which the fake client does not
+// support, unless a PodDisruptionBudget is simulated to deny it.
+type evictingClient struct {
+	ctrlclient.Client
+	denied map[string]bool
+}
+
+func (c *evictingClient) SubResource(subResource string) ctrlclient.SubResourceClient {
+	if subResource != "eviction" {
+		return c.Client.SubResource(subResource)
+	}
+
+	return &evictionClient{SubResourceClient: c.Client.SubResource(subResource), c: c}
+}
+
+type evictionClient struct {
+	ctrlclient.SubResourceClient
+	c *evictingClient
+}
+
+func (e *evictionClient) Create(ctx context.Context, obj ctrlclient.Object, _ ctrlclient.Object, _ ...ctrlclient.SubResourceCreateOption) error {
+	if e.c.denied[obj.GetName()] {
+		return apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+	}
+
+	return e.c.Delete(ctx, obj)
+}
+
+var _ = Describe("UpgradeReconciler", func() {
+	var (
+		ctx      context.Context
+		s        *runtime.Scheme
+		dc       *hlaiv1beta1.DeviceConfig
+		recorder *record.FakeRecorder
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+
+		s = scheme.Scheme
+		Expect(hlaiv1beta1.AddToScheme(s)).To(Succeed())
+		Expect(kmmv1beta1.AddToScheme(s)).To(Succeed())
+
+		dc = &hlaiv1beta1.DeviceConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-device-config", Namespace: testNamespace, UID: "a-uid"},
+			Spec: hlaiv1beta1.DeviceConfigSpec{
+				Driver: hlaiv1beta1.DriverSpec{Version: newVersion},
+			},
+		}
+
+		recorder = record.NewFakeRecorder(20)
+	})
+
+	newReconciler := func(objs ...ctrlclient.Object) (*upgradeReconciler, *evictingClient) {
+		c := &evictingClient{
+			Client: fake.NewClientBuilder().
+				WithScheme(s).
+				WithObjects(objs...).
+				WithIndex(&corev1.Pod{}, pods.NodeNameField, func(o ctrlclient.Object) []string {
+					return []string{o.(*corev1.Pod).Spec.NodeName}
+				}).
+				Build(),
+			denied: map[string]bool{},
+		}
+
+		return &upgradeReconciler{client: c, reader: c, recorder: recorder}, c
+	}
+
+	getNode := func(c ctrlclient.Client, name string) *corev1.Node {
+		n := &corev1.Node{}
+		Expect(c.Get(ctx, ctrlclient.ObjectKey{Name: name}, n)).To(Succeed())
+		return n
+	}
+
+	Describe("ReconcileUpgrade", func() {
+		It("should label new nodes with the target driver version", func() {
+			r, c := newReconciler(makeNode(dc, "node-a", "", ""), makeNode(dc, "node-b", "", ""))
+
+			revisions, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(Equal(getRevisions(newVersion)))
+
+			for _, name := range []string{"node-a", "node-b"} {
+				n := getNode(c, name)
+				Expect(n.Labels).To(HaveKeyWithValue(module.NodeVersionLabel, newVersion))
+				Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDone)))
+			}
+			Expect(dc.Status.Upgrade).To(Equal(&hlaiv1beta1.DriverUpgradeStatus{TargetVersion: newVersion, UpgradedNodes: 2}))
+			Expect(RequeueAfter(dc)).To(BeZero())
+		})
+
+		It("should label the nodes of a Module without version with its version", func() {
+			legacy := makeModule(dc, module.GetModuleName(dc), oldVersion)
+			legacy.Spec.Selector = map[string]string{nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(dc)}
+			r, c := newReconciler(legacy, makeNode(dc, "node-a", "", ""), makeNode(dc, "node-b", "", ""))
+			dc.Spec.Driver.UpgradePolicy = &hlaiv1beta1.DriverUpgradePolicySpec{}
+
+			revisions, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(Equal(getRevisions(newVersion, oldVersion)))
+
+			n := getNode(c, "node-a")
+			Expect(n.Labels).ToNot(HaveKey(module.NodeVersionLabel))
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDriverReloadRequired)))
+			Expect(n.Spec.Unschedulable).To(BeTrue())
+
+			n = getNode(c, "node-b")
+			Expect(n.Labels).To(HaveKeyWithValue(module.NodeVersionLabel, oldVersion))
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateRequired)))
+		})
+
+		It("should upgrade at most maxUnavailable nodes at a time", func() {
+			maxUnavailable := intstr.FromInt(1)
+			dc.Spec.Driver.UpgradePolicy = &hlaiv1beta1.DriverUpgradePolicySpec{MaxUnavailable: &maxUnavailable}
+			r, c := newReconciler(
+				makeModule(dc, module.GetModuleName(dc), oldVersion),
+				makeNode(dc, "node-a", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				makeNode(dc, "node-b", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				makeNode(dc, "node-c", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+			)
+
+			revisions, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(Equal(getRevisions(newVersion, oldVersion)))
+
+			// node-a has no pod to drain, and waits for its driver to unload.
+			n := getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDriverReloadRequired)))
+			Expect(n.Labels).ToNot(HaveKey(module.NodeVersionLabel))
+			Expect(n.Spec.Unschedulable).To(BeTrue())
+			Expect(n.Annotations).To(HaveKey(cordonedAnnotation))
+
+			for _, name := range []string{"node-b", "node-c"} {
+				n := getNode(c, name)
+				Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateRequired)))
+				Expect(n.Labels).To(HaveKeyWithValue(module.NodeVersionLabel, oldVersion))
+				Expect(n.Spec.Unschedulable).To(BeFalse())
+			}
+
+			Expect(dc.Status.Upgrade).To(Equal(&hlaiv1beta1.DriverUpgradeStatus{
+				TargetVersion:  newVersion,
+				UpgradingNodes: 1,
+				PendingNodes:   2,
+			}))
+			Expect(RequeueAfter(dc)).To(Equal(requeueAfter))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverRolloutStarted")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("NodeUpgradeStarted Upgrading the driver of node node-a")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverUpgradeStarted")))
+		})
+
+		It("should move the nodes to a new modprobe configuration like to a new version", func() {
+			maxUnavailable := intstr.FromInt(1)
+			dc.Spec.Driver.UpgradePolicy = &hlaiv1beta1.DriverUpgradePolicySpec{MaxUnavailable: &maxUnavailable}
+			r, c := newReconciler(
+				makeModule(dc, module.GetModuleName(dc), newVersion),
+				makeNode(dc, "node-a", newVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				makeNode(dc, "node-b", newVersion, hlaiv1beta1.NodeUpgradeStateDone),
+			)
+			dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{Parameters: []string{"timeout_locked=30"}}
+			hash, err := module.GetModprobeConfigHash(dc)
+			Expect(err).ToNot(HaveOccurred())
+			target := module.Revision{Version: newVersion, Label: newVersion + "-" + hash[:8]}
+
+			revisions, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(Equal(append([]module.Revision{target}, getRevisions(newVersion)...)))
+
+			n := getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDriverReloadRequired)))
+			Expect(n.Labels).ToNot(HaveKey(module.NodeVersionLabel))
+			Expect(n.Spec.Unschedulable).To(BeTrue())
+
+			n = getNode(c, "node-b")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateRequired)))
+			Expect(n.Labels).To(HaveKeyWithValue(module.NodeVersionLabel, newVersion))
+			Expect(n.Spec.Unschedulable).To(BeFalse())
+
+			_, err = r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(getNode(c, "node-a").Labels).To(HaveKeyWithValue(module.NodeVersionLabel, target.Label))
+		})
+
+		It("should reload the driver of all the nodes at once without upgrade policy", func() {
+			r, c := newReconciler(
+				makeNode(dc, "node-a", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				makeNode(dc, "node-b", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+			)
+
+			revisions, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(Equal(getRevisions(newVersion)))
+
+			for _, name := range []string{"node-a", "node-b"} {
+				n := getNode(c, name)
+				Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDriverReloadRequired)))
+				Expect(n.Labels).ToNot(HaveKey(module.NodeVersionLabel))
+				Expect(n.Spec.Unschedulable).To(BeFalse())
+			}
+		})
+
+		It("should only evict the pods using HPUs", func() {
+			dc.Spec.Driver.UpgradePolicy = &hlaiv1beta1.DriverUpgradePolicySpec{}
+			hpuPod := makePod("hpu", "node-a", true)
+			otherPod := makePod("other", "node-a", false)
+			dsPod := makePod("daemonset", "node-a", true)
+			dsPod.OwnerReferences = []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "a-daemonset", UID: "ds-uid", Controller: pointer.Bool(true)},
+			}
+			r, c := newReconciler(
+				makeNode(dc, "node-a", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				hpuPod, otherPod, dsPod,
+			)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			n := getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDrainRequired)))
+
+			podList := &corev1.PodList{}
+			Expect(c.List(ctx, podList)).To(Succeed())
+			names := []string{}
+			for _, p := range podList.Items {
+				names = append(names, p.Name)
+			}
+			Expect(names).To(ConsistOf("other", "daemonset"))
+
+			_, err = r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			n = getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDriverReloadRequired)))
+		})
+
+		It("should fail the upgrade of a node whose drain times out", func() {
+			dc.Spec.Driver.UpgradePolicy = &hlaiv1beta1.DriverUpgradePolicySpec{}
+			r, c := newReconciler(
+				makeNode(dc, "node-a", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				makeNode(dc, "node-b", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				makePod("protected", "node-a", true),
+			)
+			c.denied["protected"] = true
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			n := getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDrainRequired)))
+
+			patch := ctrlclient.MergeFrom(n.DeepCopy())
+			n.Annotations[stateTimeAnnotation] = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+			Expect(c.Patch(ctx, n, patch)).To(Succeed())
+
+			_, err = r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			n = getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateFailed)))
+			Expect(n.Annotations).To(HaveKeyWithValue(MessageAnnotation, "drain timed out, pods still using HPUs: a-workload-namespace/protected"))
+			Expect(n.Spec.Unschedulable).To(BeTrue())
+
+			// The failed node keeps counting against maxUnavailable.
+			n = getNode(c, "node-b")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateRequired)))
+
+			Expect(dc.Status.Upgrade.FailedNodes).To(Equal(int32(1)))
+			Expect(dc.Status.Upgrade.PendingNodes).To(Equal(int32(1)))
+		})
+
+		It("should wait for the previous driver to be unloaded", func() {
+			kmmPod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "a-module-loader",
+					Namespace: testNamespace,
+					Labels: map[string]string{
+						module.KMMModuleNameLabel: module.GetModuleName(dc),
+						module.KMMRoleLabel:       module.KMMModuleLoaderRole,
+					},
+				},
+				Spec: corev1.PodSpec{NodeName: "node-a"},
+			}
+			r, c := newReconciler(makeNode(dc, "node-a", "", hlaiv1beta1.NodeUpgradeStateDriverReloadRequired), kmmPod)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			n := getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDriverReloadRequired)))
+			Expect(n.Labels).ToNot(HaveKey(module.NodeVersionLabel))
+
+			Expect(c.Delete(ctx, kmmPod)).To(Succeed())
+
+			_, err = r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			n = getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateValidationRequired)))
+			Expect(n.Labels).To(HaveKeyWithValue(module.NodeVersionLabel, newVersion))
+		})
+
+		It("should uncordon a node once the new driver is ready", func() {
+			dc.Spec.Driver.UpgradePolicy = &hlaiv1beta1.DriverUpgradePolicySpec{}
+			dc.Status.Upgrade = &hlaiv1beta1.DriverUpgradeStatus{TargetVersion: newVersion, UpgradingNodes: 1}
+
+			node := makeNode(dc, "node-a", newVersion, hlaiv1beta1.NodeUpgradeStateValidationRequired)
+			node.Spec.Unschedulable = true
+			node.Annotations = map[string]string{cordonedAnnotation: "true"}
+			m := makeModule(dc, module.GetModuleName(dc), newVersion)
+			r, c := newReconciler(node, m,
+				makeKMMPod(m, "a-module-loader", module.KMMModuleLoaderRole, "node-a"),
+				makeKMMPod(m, "a-device-plugin", module.KMMDevicePluginRole, "node-a"),
+			)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			n := getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateValidationRequired)))
+			Expect(n.Annotations).ToNot(HaveKey(MessageAnnotation))
+
+			patch := ctrlclient.MergeFrom(n.DeepCopy())
+			n.Status.Allocatable = corev1.ResourceList{dc.GetDeviceType().GetResourceName(): resource.MustParse("8")}
+			Expect(c.Patch(ctx, n, patch)).To(Succeed())
+
+			_, err = r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			n = getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDone)))
+			Expect(n.Spec.Unschedulable).To(BeFalse())
+			Expect(n.Annotations).ToNot(HaveKey(cordonedAnnotation))
+
+			Expect(dc.Status.Upgrade).To(Equal(&hlaiv1beta1.DriverUpgradeStatus{TargetVersion: newVersion, UpgradedNodes: 1}))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverRolloutStarted")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("NodeUpgraded")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverRolloutSucceeded Rolled out the driver version 1.9.0-1 on 1 nodes")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverUpgradeCompleted")))
+		})
+
+		It("should leave a failed node alone", func() {
+			r, c := newReconciler(
+				makeModule(dc, module.GetModuleName(dc), oldVersion),
+				makeNode(dc, "node-a", oldVersion, hlaiv1beta1.NodeUpgradeStateFailed),
+			)
+
+			revisions, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(Equal(getRevisions(newVersion, oldVersion)))
+
+			n := getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateFailed)))
+			Expect(n.Labels).To(HaveKeyWithValue(module.NodeVersionLabel, oldVersion))
+		})
+
+		It("should clear the nodes no DeviceConfig selects anymore", func() {
+			orphan := makeNode(dc, "orphan", oldVersion, hlaiv1beta1.NodeUpgradeStateDrainRequired)
+			delete(orphan.Labels, nodetargets.TargetLabel)
+			orphan.Spec.Unschedulable = true
+			orphan.Annotations = map[string]string{cordonedAnnotation: "true"}
+			r, c := newReconciler(orphan)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			n := getNode(c, "orphan")
+			Expect(n.Labels).ToNot(HaveKey(StateLabel))
+			Expect(n.Labels).ToNot(HaveKey(module.NodeVersionLabel))
+			Expect(n.Spec.Unschedulable).To(BeFalse())
+		})
+	})
+
+	Describe("canary", func() {
+		var healthyCanaryObjects func() []ctrlclient.Object
+
+		BeforeEach(func() {
+			dc.Spec.Driver.Canary = &hlaiv1beta1.DriverCanarySpec{HealthySeconds: pointer.Int32(0)}
+
+			healthyCanaryObjects = func() []ctrlclient.Object {
+				canary := makeNode(dc, "node-a", newVersion, hlaiv1beta1.NodeUpgradeStateDone)
+				canary.Status.Allocatable = corev1.ResourceList{dc.GetDeviceType().GetResourceName(): resource.MustParse("8")}
+				m := makeModule(dc, module.GetModuleName(dc)+"-1", newVersion)
+
+				return []ctrlclient.Object{
+					canary,
+					makeNode(dc, "node-b", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+					makeModule(dc, module.GetModuleName(dc), oldVersion),
+					m,
+					makeKMMPod(m, "a-module-loader", module.KMMModuleLoaderRole, "node-a"),
+					makeKMMPod(m, "a-device-plugin", module.KMMDevicePluginRole, "node-a"),
+				}
+			}
+		})
+
+		It("should only upgrade the canary nodes", func() {
+			r, c := newReconciler(
+				makeModule(dc, module.GetModuleName(dc), oldVersion),
+				makeNode(dc, "node-a", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				makeNode(dc, "node-b", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				makeNode(dc, "node-c", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+			)
+
+			revisions, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(Equal(getRevisions(newVersion, oldVersion)))
+
+			Expect(dc.Status.Canary.Version).To(Equal(newVersion))
+			Expect(dc.Status.Canary.Phase).To(Equal(hlaiv1beta1.CanaryPhaseInProgress))
+			Expect(dc.Status.Canary.Nodes).To(Equal([]string{"node-a"}))
+			Expect(RequeueAfter(dc)).To(Equal(requeueAfter))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverRolloutStarted")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverCanaryStarted Trying the driver version 1.9.0-1 on nodes node-a")))
+
+			n := getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDriverReloadRequired)))
+			for _, name := range []string{"node-b", "node-c"} {
+				n := getNode(c, name)
+				Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateRequired)))
+			}
+		})
+
+		It("should try the new version on the nodes matching the canary node selector", func() {
+			dc.Spec.Driver.Canary.NodeSelector = map[string]string{"example.com/canary": "true"}
+			canary := makeNode(dc, "node-c", oldVersion, hlaiv1beta1.NodeUpgradeStateDone)
+			canary.Labels["example.com/canary"] = "true"
+			r, _ := newReconciler(
+				makeNode(dc, "node-a", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				makeNode(dc, "node-b", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				canary,
+			)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dc.Status.Canary.Nodes).To(Equal([]string{"node-c"}))
+		})
+
+		It("should promote a healthy canary", func() {
+			dc.Status.Canary = &hlaiv1beta1.DriverCanaryStatus{
+				Version: newVersion,
+				Phase:   hlaiv1beta1.CanaryPhaseInProgress,
+				Nodes:   []string{"node-a"},
+			}
+			r, c := newReconciler(healthyCanaryObjects()...)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dc.Status.Canary.Phase).To(Equal(hlaiv1beta1.CanaryPhasePromoted))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverRolloutStarted")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverCanaryPromoted")))
+
+			n := getNode(c, "node-b")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDriverReloadRequired)))
+		})
+
+		It("should wait for the approval of a manual canary", func() {
+			dc.Spec.Driver.Canary.Promotion = hlaiv1beta1.CanaryPromotionManual
+			dc.Status.Canary = &hlaiv1beta1.DriverCanaryStatus{
+				Version: newVersion,
+				Phase:   hlaiv1beta1.CanaryPhaseInProgress,
+				Nodes:   []string{"node-a"},
+			}
+			r, c := newReconciler(healthyCanaryObjects()...)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dc.Status.Canary.Phase).To(Equal(hlaiv1beta1.CanaryPhaseAwaitingApproval))
+			Expect(RequeueAfter(dc)).To(BeZero())
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverRolloutStarted")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverCanaryAwaitingApproval")))
+			n := getNode(c, "node-b")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateRequired)))
+
+			dc.Annotations = map[string]string{CanaryPromoteAnnotation: newVersion}
+
+			_, err = r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dc.Status.Canary.Phase).To(Equal(hlaiv1beta1.CanaryPhasePromoted))
+			n = getNode(c, "node-b")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDriverReloadRequired)))
+		})
+
+		It("should abort the canary when the upgrade of a canary node fails", func() {
+			dc.Status.Canary = &hlaiv1beta1.DriverCanaryStatus{
+				Version: newVersion,
+				Phase:   hlaiv1beta1.CanaryPhaseInProgress,
+				Nodes:   []string{"node-a"},
+			}
+			failed := makeNode(dc, "node-a", "", hlaiv1beta1.NodeUpgradeStateFailed)
+			failed.Annotations = map[string]string{MessageAnnotation: "validation timed out: no HPU allocatable"}
+			r, c := newReconciler(failed, makeNode(dc, "node-b", oldVersion, hlaiv1beta1.NodeUpgradeStateDone))
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dc.Status.Canary.Phase).To(Equal(hlaiv1beta1.CanaryPhaseAborted))
+			Expect(dc.Status.Canary.Message).To(Equal("the upgrade of node node-a failed: validation timed out: no HPU allocatable"))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverRolloutStarted")))
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning DriverCanaryAborted")))
+
+			n := getNode(c, "node-b")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateRequired)))
+			Expect(n.Labels).To(HaveKeyWithValue(module.NodeVersionLabel, oldVersion))
+		})
+	})
+
+	Describe("rollback", func() {
+		var failingObjects func() []ctrlclient.Object
+
+		BeforeEach(func() {
+			dc.Spec.Driver.Image = "registry.example.com/habanalabs/new-driver"
+			dc.Spec.Driver.UpgradePolicy = &hlaiv1beta1.DriverUpgradePolicySpec{}
+			dc.Spec.Driver.Rollback = &hlaiv1beta1.DriverRollbackSpec{}
+			dc.Status.LastKnownGoodDriver = &hlaiv1beta1.KnownGoodDriver{
+				Image:   "registry.example.com/habanalabs/driver",
+				Version: oldVersion,
+			}
+
+			failingObjects = func() []ctrlclient.Object {
+				m := makeModule(dc, module.GetModuleName(dc)+"-1", newVersion)
+				loader := makeKMMPod(m, "a-module-loader", module.KMMModuleLoaderRole, "node-a")
+				loader.Status.Conditions = nil
+				loader.Status.ContainerStatuses = []corev1.ContainerStatus{{
+					Name:  "a-container",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				}}
+
+				return []ctrlclient.Object{
+					makeNode(dc, "node-a", newVersion, hlaiv1beta1.NodeUpgradeStateValidationRequired),
+					makeNode(dc, "node-b", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+					makeModule(dc, module.GetModuleName(dc), oldVersion),
+					m,
+					loader,
+				}
+			}
+		})
+
+		It("should record the last known-good driver once it is ready on all the nodes", func() {
+			dc.Status.LastKnownGoodDriver = nil
+			node := makeNode(dc, "node-a", newVersion, hlaiv1beta1.NodeUpgradeStateDone)
+			m := makeModule(dc, module.GetModuleName(dc), newVersion)
+			r, _ := newReconciler(node, m,
+				makeKMMPod(m, "a-module-loader", module.KMMModuleLoaderRole, "node-a"),
+				makeKMMPod(m, "a-device-plugin", module.KMMDevicePluginRole, "node-a"),
+			)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dc.Status.LastKnownGoodDriver.Image).To(Equal(dc.Spec.Driver.Image))
+			Expect(dc.Status.LastKnownGoodDriver.Version).To(Equal(newVersion))
+			Expect(dc.Status.DriverHistory).To(HaveLen(1))
+			Expect(dc.Status.DriverHistory[0].Version).To(Equal(newVersion))
+			Expect(dc.Status.DriverHistory[0].Outcome).To(Equal(hlaiv1beta1.DriverOutcomeSucceeded))
+		})
+
+		It("should roll back a driver version failing on the nodes", func() {
+			r, c := newReconciler(failingObjects()...)
+
+			revisions, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(Equal(getRevisions(oldVersion, newVersion)))
+
+			Expect(dc.IsDriverRolledBack()).To(BeTrue())
+			Expect(dc.Status.Rollback.FailedImage).To(Equal("registry.example.com/habanalabs/new-driver"))
+			Expect(dc.Status.Rollback.Image).To(Equal("registry.example.com/habanalabs/driver"))
+			Expect(dc.Status.Rollback.Version).To(Equal(oldVersion))
+			Expect(dc.Status.Rollback.Reason).To(Equal("failing on 1 of 2 nodes: node-a (driver: CrashLoopBackOff)"))
+			Expect(dc.Status.Upgrade.TargetVersion).To(Equal(oldVersion))
+			Expect(dc.Status.DriverHistory).To(HaveLen(1))
+			Expect(dc.Status.DriverHistory[0].Outcome).To(Equal(hlaiv1beta1.DriverOutcomeRolledBack))
+			Expect(dc.Status.DriverHistory[0].Nodes).To(Equal(int32(2)))
+			Expect(dc.Status.DriverHistory[0].FailedNodes).To(Equal(int32(1)))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverRolloutStarted")))
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning DriverRolledBack Rolled back the driver from version 1.9.0-1 to 1.8.0-1")))
+
+			_, err = r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dc.Status.DriverHistory).To(HaveLen(2))
+			Expect(dc.Status.DriverHistory[1].Image).To(Equal("registry.example.com/habanalabs/driver"))
+			Expect(dc.Status.DriverHistory[1].Version).To(Equal(oldVersion))
+			Expect(dc.Status.DriverHistory[1].TriggeredBy).To(Equal("rollback"))
+			Expect(dc.Status.DriverHistory[1].Outcome).To(Equal(hlaiv1beta1.DriverOutcomeInProgress))
+
+			n := getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDriverReloadRequired)))
+			Expect(n.Labels).ToNot(HaveKey(module.NodeVersionLabel))
+			n = getNode(c, "node-b")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateDone)))
+			Expect(n.Labels).To(HaveKeyWithValue(module.NodeVersionLabel, oldVersion))
+		})
+
+		It("should record the failed image resolved for the Modules", func() {
+			settings.Settings.DriverHabanaImageBasename = "registry.example.com/habanalabs/default-driver"
+			DeferCleanup(func() {
+				settings.Settings.DriverHabanaImageBasename = ""
+			})
+			dc.Spec.Driver.Image = ""
+			r, _ := newReconciler(failingObjects()...)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dc.IsDriverRolledBack()).To(BeTrue())
+			Expect(dc.Status.Rollback.FailedImage).To(Equal("registry.example.com/habanalabs/default-driver"))
+			Expect(dc.Status.DriverHistory[0].Image).To(Equal("registry.example.com/habanalabs/default-driver"))
+		})
+
+		It("should move the failed nodes back to the last known-good driver", func() {
+			objs := failingObjects()
+			failed := objs[0].(*corev1.Node)
+			failed.Labels[StateLabel] = string(hlaiv1beta1.NodeUpgradeStateFailed)
+			failed.Annotations = map[string]string{MessageAnnotation: "validation timed out: driver: CrashLoopBackOff"}
+			r, c := newReconciler(objs...)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dc.Status.Rollback.Reason).To(Equal("failing on 1 of 2 nodes: node-a (validation timed out: driver: CrashLoopBackOff)"))
+			n := getNode(c, "node-a")
+			Expect(n.Labels).To(HaveKeyWithValue(StateLabel, string(hlaiv1beta1.NodeUpgradeStateRequired)))
+		})
+
+		It("should not roll back a version whose rollback is disabled", func() {
+			dc.Annotations = map[string]string{RollbackDisabledAnnotation: newVersion}
+			r, _ := newReconciler(failingObjects()...)
+
+			revisions, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(Equal(getRevisions(newVersion, oldVersion)))
+			Expect(dc.Status.Rollback).To(BeNil())
+		})
+
+		It("should move the nodes back to a rolled back version once its rollback is disabled", func() {
+			dc.Annotations = map[string]string{RollbackDisabledAnnotation: newVersion}
+			dc.Status.Rollback = &hlaiv1beta1.DriverRollbackStatus{
+				FailedImage:   dc.Spec.Driver.Image,
+				FailedVersion: newVersion,
+				Image:         "registry.example.com/habanalabs/driver",
+				Version:       oldVersion,
+			}
+			r, _ := newReconciler(makeNode(dc, "node-a", oldVersion, hlaiv1beta1.NodeUpgradeStateDone))
+
+			revisions, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions[0].Version).To(Equal(newVersion))
+			Expect(dc.Status.Rollback).To(BeNil())
+			Expect(dc.IsDriverRolledBack()).To(BeFalse())
+			Expect(recorder.Events).To(Receive(HavePrefix("Normal DriverRollbackCancelled Rolling out the driver version 1.9.0-1 again")))
+		})
+
+		It("should move the nodes to a new version once the rolled back one changed", func() {
+			dc.Status.Rollback = &hlaiv1beta1.DriverRollbackStatus{
+				FailedImage:   dc.Spec.Driver.Image,
+				FailedVersion: newVersion,
+				Image:         "registry.example.com/habanalabs/driver",
+				Version:       oldVersion,
+			}
+			dc.Spec.Driver.Version = "1.10.0-1"
+			r, _ := newReconciler(makeNode(dc, "node-a", oldVersion, hlaiv1beta1.NodeUpgradeStateDone))
+
+			revisions, err := r.ReconcileUpgrade(ctx, dc, "1.10.0-1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions[0].Version).To(Equal("1.10.0-1"))
+			Expect(dc.Status.Rollback).To(BeNil())
+		})
+	})
+
+	Describe("history", func() {
+		It("should record who triggered a rollout and the nodes upgraded once it succeeds", func() {
+			dc.Annotations = map[string]string{ChangedByAnnotation: "an-admin"}
+			m := makeModule(dc, module.GetModuleName(dc), newVersion)
+			r, _ := newReconciler(makeNode(dc, "node-a", newVersion, hlaiv1beta1.NodeUpgradeStateDone), m,
+				makeKMMPod(m, "a-module-loader", module.KMMModuleLoaderRole, "node-a"),
+				makeKMMPod(m, "a-device-plugin", module.KMMDevicePluginRole, "node-a"),
+			)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dc.Status.DriverHistory).To(HaveLen(1))
+			e := dc.Status.DriverHistory[0]
+			Expect(e.Version).To(Equal(newVersion))
+			Expect(e.TriggeredBy).To(Equal("an-admin"))
+			Expect(e.Outcome).To(Equal(hlaiv1beta1.DriverOutcomeSucceeded))
+			Expect(e.StartTime).ToNot(BeNil())
+			Expect(e.CompletionTime).ToNot(BeNil())
+			Expect(e.Nodes).To(Equal(int32(1)))
+			Expect(e.UpgradedNodes).To(Equal(int32(1)))
+			Expect(e.FailedNodes).To(BeZero())
+			Expect(recorder.Events).To(Receive(Equal("Normal DriverRolloutStarted Rolling out the driver version 1.9.0-1 on 1 nodes, triggered by an-admin")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverRolloutSucceeded")))
+
+			_, err = r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dc.Status.DriverHistory).To(HaveLen(1))
+		})
+
+		It("should supersede a rollout still in progress", func() {
+			start := metav1.Now()
+			dc.Status.DriverHistory = []hlaiv1beta1.DriverHistoryEntry{
+				{Version: oldVersion, Outcome: hlaiv1beta1.DriverOutcomeInProgress, StartTime: &start},
+			}
+			r, _ := newReconciler(
+				makeNode(dc, "node-a", oldVersion, hlaiv1beta1.NodeUpgradeStateDone),
+				makeNode(dc, "node-b", oldVersion, hlaiv1beta1.NodeUpgradeStateFailed),
+				makeNode(dc, "node-c", "", hlaiv1beta1.NodeUpgradeStateDriverReloadRequired),
+			)
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dc.Status.DriverHistory).To(HaveLen(2))
+			e := dc.Status.DriverHistory[0]
+			Expect(e.Outcome).To(Equal(hlaiv1beta1.DriverOutcomeSuperseded))
+			Expect(e.Message).To(Equal("superseded by version 1.9.0-1"))
+			Expect(e.CompletionTime).ToNot(BeNil())
+			Expect(e.Nodes).To(Equal(int32(3)))
+			Expect(e.UpgradedNodes).To(Equal(int32(1)))
+			Expect(e.FailedNodes).To(Equal(int32(1)))
+			Expect(dc.Status.DriverHistory[1].Version).To(Equal(newVersion))
+			Expect(dc.Status.DriverHistory[1].Outcome).To(Equal(hlaiv1beta1.DriverOutcomeInProgress))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverRolloutSuperseded Stopped the rollout of the driver version 1.8.0-1 for version 1.9.0-1")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DriverRolloutStarted")))
+		})
+
+		It("should keep the latest entries only", func() {
+			for i := 0; i < hlaiv1beta1.MaxDriverHistory; i++ {
+				dc.Status.DriverHistory = append(dc.Status.DriverHistory, hlaiv1beta1.DriverHistoryEntry{
+					Version: fmt.Sprintf("1.%d.0-0", i),
+					Outcome: hlaiv1beta1.DriverOutcomeSucceeded,
+				})
+			}
+			r, _ := newReconciler(makeNode(dc, "node-a", oldVersion, hlaiv1beta1.NodeUpgradeStateDone))
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dc.Status.DriverHistory).To(HaveLen(hlaiv1beta1.MaxDriverHistory))
+			Expect(dc.Status.DriverHistory[0].Version).To(Equal("1.1.0-0"))
+			Expect(dc.Status.DriverHistory[hlaiv1beta1.MaxDriverHistory-1].Version).To(Equal(newVersion))
+		})
+
+		It("should not record a rollout without nodes", func() {
+			r, _ := newReconciler()
+
+			_, err := r.ReconcileUpgrade(ctx, dc, newVersion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dc.Status.DriverHistory).To(BeEmpty())
+		})
+	})
+
+	Describe("ClearUpgrade", func() {
+		It("should only uncordon the nodes cordoned by their upgrade", func() {
+			cordoned := makeNode(dc, "cordoned", oldVersion, hlaiv1beta1.NodeUpgradeStateDrainRequired)
+			cordoned.Spec.Unschedulable = true
+			cordoned.Annotations = map[string]string{cordonedAnnotation: "true"}
+			byAdmin := makeNode(dc, "by-admin", oldVersion, hlaiv1beta1.NodeUpgradeStateDrainRequired)
+			byAdmin.Spec.Unschedulable = true
+			r, c := newReconciler(cordoned, byAdmin)
+
+			Expect(r.ClearUpgrade(ctx, dc)).To(Succeed())
+
+			n := getNode(c, "cordoned")
+			Expect(n.Labels).ToNot(HaveKey(StateLabel))
+			Expect(n.Labels).ToNot(HaveKey(module.NodeVersionLabel))
+			Expect(n.Annotations).ToNot(HaveKey(cordonedAnnotation))
+			Expect(n.Spec.Unschedulable).To(BeFalse())
+
+			n = getNode(c, "by-admin")
+			Expect(n.Labels).ToNot(HaveKey(StateLabel))
+			Expect(n.Spec.Unschedulable).To(BeTrue())
+		})
+	})
+})
+
+// getRevisions returns the revisions of versions with an unchanged modprobe
+// configuration.
+func getRevisions(versions ...string) []module.Revision {
+	revisions := make([]module.Revision, 0, len(versions))
+	for _, v := range versions {
+		revisions = append(revisions, module.Revision{Version: v, Label: v})
+	}
+
+	return revisions
+}
+
+func makeNode(dc *hlaiv1beta1.DeviceConfig, name, version string, state hlaiv1beta1.NodeUpgradeState) *corev1.Node {
+	n := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(dc)},
+		},
+	}
+	if version != "" {
+		n.Labels[module.NodeVersionLabel] = version
+	}
+	if state != "" {
+		n.Labels[StateLabel] = string(state)
+	}
+
+	return n
+}
+
+func makeModule(dc *hlaiv1beta1.DeviceConfig, name, version string) *kmmv1beta1.Module {
+	hash, err := module.GetModprobeConfigHash(dc)
+	Expect(err).ToNot(HaveOccurred())
+
+	m := &kmmv1beta1.Module{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: dc.Namespace,
+			Annotations: map[string]string{
+				module.DriverVersionAnnotation:  version,
+				module.DriverModprobeAnnotation: hash,
+			},
+		},
+		Spec: kmmv1beta1.ModuleSpec{
+			Selector: map[string]string{
+				nodetargets.TargetLabel: nodetargets.GetTargetLabelValue(dc),
+				module.NodeVersionLabel: version,
+			},
+		},
+	}
+	Expect(controllerutil.SetControllerReference(dc, m, scheme.Scheme)).To(Succeed())
+
+	return m
+}
+
+func makeKMMPod(m *kmmv1beta1.Module, name, role, node string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: m.Namespace,
+			Labels: map[string]string{
+				module.KMMModuleNameLabel: m.Name,
+				module.KMMRoleLabel:       role,
+			},
+		},
+		Spec: corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func makePod(name, node string, hpus bool) *corev1.Pod {
+	p := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "a-workload-namespace"},
+		Spec: corev1.PodSpec{
+			NodeName:   node,
+			Containers: []corev1.Container{{Name: "a-container"}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if hpus {
+		p.Spec.Containers[0].Resources.Limits = corev1.ResourceList{
+			hlaiv1beta1.DeviceTypeGaudi.GetResourceName(): resource.MustParse("1"),
+		}
+	}
+
+	return p
+}
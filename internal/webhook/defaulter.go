@@ -0,0 +1,102 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	s "github.com/HabanaAI/habana-ai-operator/internal/settings"
+	"github.com/HabanaAI/habana-ai-operator/internal/upgrade"
+)
+
+//+kubebuilder:webhook:path=/mutate-habana-ai-v1beta1-deviceconfig,mutating=true,failurePolicy=fail,sideEffects=None,groups=habana.ai,resources=deviceconfigs,verbs=create;update,versions=v1beta1,name=mdeviceconfig.habana.ai,admissionReviewVersions=v1
+
+type defaulter struct{}
+
+func NewDefaulter() *defaulter {
+	return &defaulter{}
+}
+
+// Default fills the fields omitted from a DeviceConfig with the values the
+// operator would use, so that the stored object shows what gets deployed.
+func (d *defaulter) Default(ctx context.Context, obj runtime.Object) error {
+	cr, ok := obj.(*hlaiv1beta1.DeviceConfig)
+	if !ok {
+		return fmt.Errorf("expected a DeviceConfig but got a %T", obj)
+	}
+
+	// Never mutate a DeviceConfig being deleted, only its finalizers change.
+	if !cr.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	if cr.Spec.Driver.Image == "" {
+		cr.Spec.Driver.Image = s.Settings.DriverHabanaImageBasename
+	}
+
+	if len(cr.Spec.Driver.KernelMappings) == 0 {
+		cr.Spec.Driver.KernelMappings = cr.Spec.Driver.GetKernelMappings()
+	}
+
+	// The nodes of a device type are selected by their PCI device labels,
+	// which cannot be written as a node selector.
+	if cr.Spec.NodeSelector == nil && cr.Spec.DeviceType == "" {
+		cr.Spec.NodeSelector = cr.GetNodeSelector()
+	}
+
+	if req, err := admission.RequestFromContext(ctx); err == nil {
+		return setChangedBy(cr, req)
+	}
+
+	return nil
+}
+
+// setChangedBy records in cr the user of req when it creates cr or changes
+// its driver image or version, for the driver history. Otherwise the recorded
+// user is kept, so that it cannot be set by hand.
+func setChangedBy(cr *hlaiv1beta1.DeviceConfig, req admission.Request) error {
+	old := &hlaiv1beta1.DeviceConfig{}
+	if req.Operation == admissionv1.Update {
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return fmt.Errorf("failed to decode the old DeviceConfig: %w", err)
+		}
+	}
+
+	changedBy, ok := old.Annotations[upgrade.ChangedByAnnotation]
+	if req.Operation == admissionv1.Create || old.Spec.Driver.Image != cr.Spec.Driver.Image || old.Spec.Driver.Version != cr.Spec.Driver.Version {
+		changedBy, ok = req.UserInfo.Username, true
+	}
+
+	if !ok {
+		delete(cr.Annotations, upgrade.ChangedByAnnotation)
+		return nil
+	}
+
+	if cr.Annotations == nil {
+		cr.Annotations = map[string]string{}
+	}
+	cr.Annotations[upgrade.ChangedByAnnotation] = changedBy
+
+	return nil
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	s "github.com/HabanaAI/habana-ai-operator/internal/settings"
+	"github.com/HabanaAI/habana-ai-operator/internal/upgrade"
+)
+
+const (
+	testDriverImageBasename = "registry.example.com/habana-ai-driver"
+)
+
+var _ = Describe("Defaulter", func() {
+	var (
+		ctx context.Context
+		d   *defaulter
+		dc  *hlaiv1beta1.DeviceConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		d = NewDefaulter()
+		dc = &hlaiv1beta1.DeviceConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-device-config"},
+			Spec: hlaiv1beta1.DeviceConfigSpec{
+				Driver: hlaiv1beta1.DriverSpec{
+					Version: "1.6.0-439",
+				},
+			},
+		}
+
+		s.Settings.DriverHabanaImageBasename = testDriverImageBasename
+		DeferCleanup(func() {
+			s.Settings.DriverHabanaImageBasename = ""
+		})
+	})
+
+	Describe("Default", func() {
+		Context("with a DeviceConfig only specifying a driver version", func() {
+			BeforeEach(func() {
+				Expect(d.Default(ctx, dc)).To(Succeed())
+			})
+
+			It("should default the driver image from the operator settings", func() {
+				Expect(dc.Spec.Driver.Image).To(Equal(testDriverImageBasename))
+			})
+
+			It("should default the node selector", func() {
+				Expect(dc.Spec.NodeSelector).To(Equal(map[string]string{
+					"feature.node.kubernetes.io/pci-1da3.present": "true",
+				}))
+			})
+
+			It("should default the kernel mappings to the presets", func() {
+				Expect(dc.Spec.Driver.KernelMappings).To(Equal([]hlaiv1beta1.KernelMapping{
+					{Preset: hlaiv1beta1.KernelMappingPresetRHCOS},
+					{Preset: hlaiv1beta1.KernelMappingPresetRHEL},
+					{Preset: hlaiv1beta1.KernelMappingPresetUbuntu},
+				}))
+			})
+
+			It("should not change the driver version", func() {
+				Expect(dc.Spec.Driver.Version).To(Equal("1.6.0-439"))
+			})
+		})
+
+		Context("with a DeviceConfig specifying a device type", func() {
+			It("should not default the node selector", func() {
+				dc.Spec.DeviceType = hlaiv1beta1.DeviceTypeGaudi2
+
+				Expect(d.Default(ctx, dc)).To(Succeed())
+				Expect(dc.Spec.NodeSelector).To(BeNil())
+			})
+		})
+
+		Context("with a fully specified DeviceConfig", func() {
+			It("should not change it", func() {
+				dc.Spec.Driver.Image = "quay.io/habana/driver"
+				dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{{Literal: "5.15.0-76-generic"}}
+				dc.Spec.NodeSelector = map[string]string{"some": "label"}
+				expected := dc.DeepCopy()
+
+				Expect(d.Default(ctx, dc)).To(Succeed())
+				Expect(dc).To(Equal(expected))
+			})
+		})
+
+		Context("with a DeviceConfig being deleted", func() {
+			It("should not change it", func() {
+				now := metav1.NewTime(time.Now())
+				dc.DeletionTimestamp = &now
+				expected := dc.DeepCopy()
+
+				Expect(d.Default(ctx, dc)).To(Succeed())
+				Expect(dc).To(Equal(expected))
+			})
+		})
+
+		Context("with an admission request", func() {
+			var old *hlaiv1beta1.DeviceConfig
+
+			withRequest := func(op admissionv1.Operation) context.Context {
+				req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: op,
+					UserInfo:  authenticationv1.UserInfo{Username: "an-admin"},
+				}}
+				if op == admissionv1.Update {
+					raw, err := json.Marshal(old)
+					Expect(err).ToNot(HaveOccurred())
+					req.OldObject = runtime.RawExtension{Raw: raw}
+				}
+
+				return admission.NewContextWithRequest(ctx, req)
+			}
+
+			BeforeEach(func() {
+				old = dc.DeepCopy()
+				old.Spec.Driver.Image = testDriverImageBasename
+				old.Annotations = map[string]string{upgrade.ChangedByAnnotation: "another-admin"}
+			})
+
+			It("should record who created the DeviceConfig", func() {
+				Expect(d.Default(withRequest(admissionv1.Create), dc)).To(Succeed())
+				Expect(dc.Annotations).To(HaveKeyWithValue(upgrade.ChangedByAnnotation, "an-admin"))
+			})
+
+			It("should record who changed the driver version", func() {
+				dc.Spec.Driver.Version = "1.7.0-1"
+
+				Expect(d.Default(withRequest(admissionv1.Update), dc)).To(Succeed())
+				Expect(dc.Annotations).To(HaveKeyWithValue(upgrade.ChangedByAnnotation, "an-admin"))
+			})
+
+			It("should keep who changed the driver on other changes", func() {
+				dc.Spec.NodeSelector = map[string]string{"some": "label"}
+				dc.Annotations = map[string]string{upgrade.ChangedByAnnotation: "someone-else"}
+
+				Expect(d.Default(withRequest(admissionv1.Update), dc)).To(Succeed())
+				Expect(dc.Annotations).To(HaveKeyWithValue(upgrade.ChangedByAnnotation, "another-admin"))
+			})
+		})
+
+		Context("with an object that is not a DeviceConfig", func() {
+			It("should return an error", func() {
+				Expect(d.Default(ctx, &corev1.Node{})).To(HaveOccurred())
+			})
+		})
+	})
+})
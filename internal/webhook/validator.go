@@ -0,0 +1,600 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/maintenance"
+	"github.com/HabanaAI/habana-ai-operator/internal/module"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodeselector"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	"github.com/HabanaAI/habana-ai-operator/internal/upgrade"
+)
+
+var (
+	// driverImageRegexp matches an image repository without tag nor digest,
+	// since both are computed from the driver and kernel versions.
+	driverImageRegexp = regexp.MustCompile(
+		`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?/)?` +
+			`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`,
+	)
+
+	// imageRegexp matches a full image reference, with optional tag and digest.
+	imageRegexp = regexp.MustCompile(
+		strings.TrimSuffix(driverImageRegexp.String(), "$") +
+			`(?::[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?(?:@[a-zA-Z][a-zA-Z0-9]*(?:[-_+.][a-zA-Z][a-zA-Z0-9]*)*:[0-9a-fA-F]{32,})?$`,
+	)
+
+	// driverVersionRegexp matches the prefix of an image tag.
+	driverVersionRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+
+	// modprobeParameterRegexp matches a kernel module parameter.
+	modprobeParameterRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*=[a-zA-Z0-9_.,:/+=-]*$`)
+
+	// modprobeArgRegexp matches a modprobe argument or path. KMM runs modprobe
+	// in a shell, so that whitespace and shell metacharacters are rejected.
+	modprobeArgRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.,:/+=-]+$`)
+
+	// reservedNodeSelectorKeyPrefixes are the label prefixes managed by the
+	// operator, and its dependencies, as a consequence of a DeviceConfig.
+	// Selecting nodes on them would make the DeviceConfig depend on itself.
+	reservedNodeSelectorKeyPrefixes = []string{
+		"kmm.node.kubernetes.io/",
+		module.NodeVersionLabel,
+		upgrade.StateLabel,
+	}
+)
+
+//+kubebuilder:webhook:path=/validate-habana-ai-v1beta1-deviceconfig,mutating=false,failurePolicy=fail,sideEffects=None,groups=habana.ai,resources=deviceconfigs,verbs=create;update,versions=v1beta1,name=vdeviceconfig.habana.ai,admissionReviewVersions=v1
+
+type validator struct {
+	nsv nodeselector.Validator
+}
+
+func NewValidator(nsv nodeselector.Validator) *validator {
+	return &validator{nsv: nsv}
+}
+
+func (v *validator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	cr, ok := obj.(*hlaiv1beta1.DeviceConfig)
+	if !ok {
+		return fmt.Errorf("expected a DeviceConfig but got a %T", obj)
+	}
+
+	return v.validate(ctx, cr, true)
+}
+
+func (v *validator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	oldCR, ok := oldObj.(*hlaiv1beta1.DeviceConfig)
+	if !ok {
+		return fmt.Errorf("expected a DeviceConfig but got a %T", oldObj)
+	}
+
+	cr, ok := newObj.(*hlaiv1beta1.DeviceConfig)
+	if !ok {
+		return fmt.Errorf("expected a DeviceConfig but got a %T", newObj)
+	}
+
+	// Never block the finalizer removal of a DeviceConfig being deleted.
+	if !cr.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	// Conflicts may appear because of node labels changes, so only check
+	// them when the DeviceConfig node selection is the change being applied.
+	checkConflicts := oldCR.Spec.DeviceType != cr.Spec.DeviceType ||
+		!reflect.DeepEqual(oldCR.GetNodeSelector(), cr.GetNodeSelector()) ||
+		!reflect.DeepEqual(oldCR.Spec.NodeSelectorExpressions, cr.Spec.NodeSelectorExpressions) ||
+		!reflect.DeepEqual(oldCR.Spec.NodeAffinity, cr.Spec.NodeAffinity)
+
+	return v.validate(ctx, cr, checkConflicts)
+}
+
+func (v *validator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (v *validator) validate(ctx context.Context, cr *hlaiv1beta1.DeviceConfig, checkConflicts bool) error {
+	errs := validateDeviceConfigSpec(cr)
+
+	if checkConflicts && len(errs) == 0 {
+		if err := v.nsv.CheckDeviceConfigForConflictingNodeSelector(ctx, cr); err != nil {
+			path := field.NewPath("spec", "nodeSelector")
+			if !errors.Is(err, nodeselector.ErrConflictingNodeSelector) {
+				return apierrors.NewInternalError(err)
+			}
+			errs = append(errs, field.Forbidden(path, err.Error()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(hlaiv1beta1.GroupVersion.WithKind("DeviceConfig").GroupKind(), cr.Name, errs)
+	}
+
+	return nil
+}
+
+func validateDeviceConfigSpec(cr *hlaiv1beta1.DeviceConfig) field.ErrorList {
+	errs := field.ErrorList{}
+	specPath := field.NewPath("spec")
+
+	// An empty driver image is defaulted from the operator settings.
+	imagePath := specPath.Child("driver", "image")
+	if cr.Spec.Driver.Image != "" && !driverImageRegexp.MatchString(cr.Spec.Driver.Image) {
+		errs = append(errs, field.Invalid(imagePath, cr.Spec.Driver.Image,
+			"must be an image repository without tag nor digest, e.g. registry.example.com/habana-ai-driver"))
+	}
+
+	versionPath := specPath.Child("driver", "version")
+	switch {
+	case cr.Spec.Driver.Version == "":
+		errs = append(errs, field.Required(versionPath, "a driver version is required"))
+	case !driverVersionRegexp.MatchString(cr.Spec.Driver.Version):
+		errs = append(errs, field.Invalid(versionPath, cr.Spec.Driver.Version,
+			"must be a valid image tag prefix, e.g. 1.6.0-439"))
+	}
+
+	if cr.Spec.DeviceType != "" {
+		supported := []string{}
+		found := false
+		for _, t := range hlaiv1beta1.DeviceTypes {
+			supported = append(supported, string(t))
+			found = found || t == cr.Spec.DeviceType
+		}
+		if !found {
+			errs = append(errs, field.NotSupported(specPath.Child("deviceType"), cr.Spec.DeviceType, supported))
+		}
+	}
+
+	// Empty operand images are replaced by the operator settings.
+	operandImages := []struct {
+		path  *field.Path
+		image string
+	}{
+		{specPath.Child("devicePlugin", "image"), cr.Spec.DevicePlugin.Image},
+		{specPath.Child("nodeLabeler", "image"), cr.Spec.NodeLabeler.Image},
+		{specPath.Child("nodeMetrics", "image"), cr.Spec.NodeMetrics.Image},
+	}
+	for _, o := range operandImages {
+		if o.image != "" && !imageRegexp.MatchString(o.image) {
+			errs = append(errs, field.Invalid(o.path, o.image,
+				"must be a valid image reference, e.g. registry.example.com/habana-ai/device-plugin:1.6.0"))
+		}
+	}
+
+	errs = append(errs, validateKernelMappings(cr.Spec.Driver.KernelMappings, specPath.Child("driver", "kernelMappings"))...)
+	if cr.Spec.Driver.Build != nil {
+		errs = append(errs, validateDriverBuild(*cr.Spec.Driver.Build, specPath.Child("driver", "build"))...)
+	}
+	if cr.Spec.Driver.Sign != nil {
+		errs = append(errs, validateDriverSign(cr.Spec.Driver, specPath.Child("driver", "sign"))...)
+	}
+	if cr.Spec.Driver.Modprobe != nil {
+		errs = append(errs, validateDriverModprobe(*cr.Spec.Driver.Modprobe, specPath.Child("driver", "modprobe"))...)
+	}
+	errs = append(errs, validateCompanionModules(cr, specPath.Child("driver", "companionModules"))...)
+	if cr.Spec.Driver.UpgradePolicy != nil {
+		errs = append(errs, validateDriverUpgradePolicy(*cr.Spec.Driver.UpgradePolicy, specPath.Child("driver", "upgradePolicy"))...)
+	}
+	if cr.Spec.Driver.Canary != nil {
+		errs = append(errs, validateDriverCanary(*cr.Spec.Driver.Canary, specPath.Child("driver", "canary"))...)
+	}
+	if r := cr.Spec.Driver.Rollback; r != nil && r.FailedNodesThreshold != nil {
+		errs = append(errs, validateNodeCount(*r.FailedNodesThreshold, specPath.Child("driver", "rollback", "failedNodesThreshold"))...)
+	}
+	if cr.Spec.Driver.ImageRepoSecret != nil && cr.Spec.Driver.ImageRepoSecret.Name == "" {
+		errs = append(errs, field.Required(specPath.Child("driver", "imageRepoSecret", "name"), "a secret name is required"))
+	}
+	errs = append(errs, validateNodeSelector(cr.Spec.NodeSelector, specPath.Child("nodeSelector"))...)
+	errs = append(errs, validateNodeSelectorExpressions(cr.Spec.NodeSelectorExpressions, specPath.Child("nodeSelectorExpressions"))...)
+	errs = append(errs, validateNodeAffinity(cr.Spec.NodeAffinity, specPath.Child("nodeAffinity"))...)
+	errs = append(errs, validateTolerations(cr.Spec.Tolerations, specPath.Child("tolerations"))...)
+	errs = append(errs, validateMaintenanceWindows(cr.Spec.MaintenanceWindows, specPath.Child("maintenanceWindows"))...)
+
+	return errs
+}
+
+func validateKernelMappings(mappings []hlaiv1beta1.KernelMapping, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	for i, m := range mappings {
+		mPath := path.Index(i)
+
+		set := 0
+		for _, v := range []string{string(m.Preset), m.Regexp, m.Literal} {
+			if v != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			errs = append(errs, field.Invalid(mPath, m, "exactly one of preset, regexp and literal must be set"))
+		}
+
+		if m.Preset != "" {
+			if _, ok := hlaiv1beta1.KernelMappingPresetRegexps[m.Preset]; !ok {
+				supported := []string{}
+				for _, p := range hlaiv1beta1.DefaultKernelMappingPresets {
+					supported = append(supported, string(p))
+				}
+				errs = append(errs, field.NotSupported(mPath.Child("preset"), m.Preset, supported))
+			}
+		}
+
+		if m.Regexp != "" {
+			if _, err := regexp.Compile(m.Regexp); err != nil {
+				errs = append(errs, field.Invalid(mPath.Child("regexp"), m.Regexp, err.Error()))
+			}
+		}
+
+		if m.Build != nil {
+			errs = append(errs, validateDriverBuild(*m.Build, mPath.Child("build"))...)
+		}
+	}
+
+	return errs
+}
+
+func validateDriverBuild(b hlaiv1beta1.DriverBuildSpec, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	// A build without Dockerfile ConfigMap uses the operator one.
+	if b.DockerfileConfigMap != nil && b.DockerfileConfigMap.Name == "" {
+		errs = append(errs, field.Required(path.Child("dockerfileConfigMap", "name"), "a Dockerfile ConfigMap name is required"))
+	}
+
+	for i, a := range b.BuildArgs {
+		if a.Name == "" {
+			errs = append(errs, field.Required(path.Child("buildArgs").Index(i).Child("name"), "a build argument name is required"))
+		}
+	}
+
+	for i, secret := range b.Secrets {
+		if secret.Name == "" {
+			errs = append(errs, field.Required(path.Child("secrets").Index(i).Child("name"), "a secret name is required"))
+		}
+	}
+
+	return errs
+}
+
+func validateDriverSign(d hlaiv1beta1.DriverSpec, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	// KMM signs the built images, the others are pulled from the unsigned image.
+	if d.Sign.UnsignedImage == "" && !d.IsBuilt() {
+		errs = append(errs, field.Required(path.Child("unsignedImage"), "an unsigned image is required for the driver images that are not built"))
+	}
+
+	if d.Sign.KeySecret.Name == "" {
+		errs = append(errs, field.Required(path.Child("keySecret", "name"), "a secret name is required"))
+	}
+	if d.Sign.CertSecret.Name == "" {
+		errs = append(errs, field.Required(path.Child("certSecret", "name"), "a secret name is required"))
+	}
+
+	for i, f := range d.Sign.FilesToSign {
+		if f == "" {
+			errs = append(errs, field.Required(path.Child("filesToSign").Index(i), "a file path is required"))
+		}
+	}
+
+	return errs
+}
+
+func validateDriverModprobe(mp hlaiv1beta1.DriverModprobeSpec, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	for i, p := range mp.Parameters {
+		if !modprobeParameterRegexp.MatchString(p) {
+			errs = append(errs, field.Invalid(path.Child("parameters").Index(i), p, "must be a module parameter in the key=value form"))
+		}
+	}
+
+	for _, args := range []struct {
+		args *hlaiv1beta1.ModprobeArgs
+		path *field.Path
+	}{
+		{mp.Args, path.Child("args")},
+		{mp.RawArgs, path.Child("rawArgs")},
+	} {
+		if args.args == nil {
+			continue
+		}
+		for _, list := range []struct {
+			values []string
+			path   *field.Path
+		}{
+			{args.args.Load, args.path.Child("load")},
+			{args.args.Unload, args.path.Child("unload")},
+		} {
+			for i, a := range list.values {
+				if !modprobeArgRegexp.MatchString(a) {
+					errs = append(errs, field.Invalid(list.path.Index(i), a, "must be a modprobe argument without whitespace nor shell metacharacters"))
+				}
+			}
+		}
+	}
+
+	if mp.RawArgs != nil && (len(mp.Parameters) > 0 || mp.Args != nil || mp.DirName != "") {
+		errs = append(errs, field.Invalid(path.Child("rawArgs"), mp.RawArgs, "replaces the parameters, args and dirName, which must not be set"))
+	}
+
+	for _, dir := range []struct {
+		value string
+		path  *field.Path
+	}{
+		{mp.DirName, path.Child("dirName")},
+		{mp.FirmwarePath, path.Child("firmwarePath")},
+	} {
+		if dir.value != "" && (!strings.HasPrefix(dir.value, "/") || !modprobeArgRegexp.MatchString(dir.value)) {
+			errs = append(errs, field.Invalid(dir.path, dir.value, "must be an absolute path without whitespace nor shell metacharacters"))
+		}
+	}
+
+	return errs
+}
+
+func validateCompanionModules(cr *hlaiv1beta1.DeviceConfig, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	modules := cr.Spec.Driver.CompanionModules
+	if len(modules) == 0 {
+		return errs
+	}
+
+	supported := []string{}
+	for _, m := range hlaiv1beta1.CompanionModules {
+		supported = append(supported, string(m))
+	}
+	for i, m := range modules {
+		known := false
+		for _, s := range hlaiv1beta1.CompanionModules {
+			known = known || m == s
+		}
+		if !known {
+			errs = append(errs, field.NotSupported(path.Index(i), m, supported))
+		}
+	}
+
+	if cr.Spec.DeviceType == hlaiv1beta1.DeviceTypeGaudi {
+		errs = append(errs, field.Invalid(path, modules, "the companion modules require Gaudi2 or later"))
+	}
+
+	// Prebuilt images would silently load the habanalabs module alone.
+	if !cr.Spec.Driver.IsBuilt() {
+		errs = append(errs, field.Invalid(path, modules, "the companion modules are declared in the built driver images, so that they require a build of all of them"))
+	}
+
+	return errs
+}
+
+func validateDriverUpgradePolicy(p hlaiv1beta1.DriverUpgradePolicySpec, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if p.MaxUnavailable != nil {
+		errs = append(errs, validateNodeCount(*p.MaxUnavailable, path.Child("maxUnavailable"))...)
+	}
+
+	return errs
+}
+
+func validateDriverCanary(c hlaiv1beta1.DriverCanarySpec, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if c.Nodes != nil {
+		errs = append(errs, validateNodeCount(*c.Nodes, path.Child("nodes"))...)
+	}
+	errs = append(errs, validateNodeSelector(c.NodeSelector, path.Child("nodeSelector"))...)
+
+	return errs
+}
+
+// validateNodeCount checks that v is a number of nodes or a percentage of
+// the selected nodes.
+func validateNodeCount(v intstr.IntOrString, path *field.Path) field.ErrorList {
+	valid := false
+	switch v.Type {
+	case intstr.Int:
+		valid = v.IntVal >= 1
+	case intstr.String:
+		if n, err := strconv.Atoi(strings.TrimSuffix(v.StrVal, "%")); err == nil && strings.HasSuffix(v.StrVal, "%") {
+			valid = n >= 1 && n <= 100
+		}
+	}
+	if !valid {
+		return field.ErrorList{field.Invalid(path, v.String(),
+			"must be a number of nodes greater than 0 or a percentage between 1% and 100%")}
+	}
+
+	return nil
+}
+
+func validateNodeSelector(nodeSelector map[string]string, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	for k, v := range nodeSelector {
+		keyPath := path.Key(k)
+
+		for _, msg := range validation.IsQualifiedName(k) {
+			errs = append(errs, field.Invalid(keyPath, k, msg))
+		}
+
+		for _, msg := range validation.IsValidLabelValue(v) {
+			errs = append(errs, field.Invalid(keyPath, v, msg))
+		}
+
+		errs = append(errs, validateReservedKey(k, keyPath)...)
+	}
+
+	return errs
+}
+
+func validateNodeSelectorExpressions(reqs []metav1.LabelSelectorRequirement, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	for i, r := range reqs {
+		reqPath := path.Index(i)
+
+		if _, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{r},
+		}); err != nil {
+			errs = append(errs, field.Invalid(reqPath, r, err.Error()))
+		}
+
+		errs = append(errs, validateReservedKey(r.Key, reqPath.Child("key"))...)
+	}
+
+	return errs
+}
+
+func validateNodeAffinity(affinity *corev1.NodeAffinity, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if affinity == nil || affinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return errs
+	}
+
+	termsPath := path.Child("requiredDuringSchedulingIgnoredDuringExecution", "nodeSelectorTerms")
+	terms := affinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) == 0 {
+		return append(errs, field.Required(termsPath, "at least one node selector term is required"))
+	}
+
+	for i, term := range terms {
+		termPath := termsPath.Index(i)
+
+		if err := nodeselector.ValidateNodeSelectorTerm(term); err != nil {
+			errs = append(errs, field.Invalid(termPath, term, err.Error()))
+		}
+
+		for j, r := range term.MatchExpressions {
+			errs = append(errs, validateReservedKey(r.Key, termPath.Child("matchExpressions").Index(j).Child("key"))...)
+		}
+	}
+
+	return errs
+}
+
+func validateMaintenanceWindows(windows []hlaiv1beta1.MaintenanceWindow, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	for i, w := range windows {
+		wPath := path.Index(i)
+
+		s, err := maintenance.ParseSchedule(w.Schedule, "UTC")
+		switch {
+		case err != nil:
+			errs = append(errs, field.Invalid(wPath.Child("schedule"), w.Schedule, err.Error()))
+		case s.Next(time.Now()).IsZero():
+			errs = append(errs, field.Invalid(wPath.Child("schedule"), w.Schedule, "must match a time, e.g. not February 30"))
+		}
+		if _, err := time.LoadLocation(w.GetTimeZone()); err != nil {
+			errs = append(errs, field.Invalid(wPath.Child("timeZone"), w.TimeZone, "must be an IANA time zone, e.g. Europe/Paris"))
+		}
+		if w.DurationSeconds < 60 {
+			errs = append(errs, field.Invalid(wPath.Child("durationSeconds"), w.DurationSeconds, "must be at least 60"))
+		}
+	}
+
+	return errs
+}
+
+func validateTolerations(tolerations []corev1.Toleration, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	for i, t := range tolerations {
+		tPath := path.Index(i)
+
+		if t.Key != "" {
+			for _, msg := range validation.IsQualifiedName(t.Key) {
+				errs = append(errs, field.Invalid(tPath.Child("key"), t.Key, msg))
+			}
+		}
+
+		switch t.Operator {
+		case corev1.TolerationOpEqual, "":
+			if t.Key == "" {
+				errs = append(errs, field.Invalid(tPath.Child("operator"), t.Operator,
+					"must be Exists when the key is empty"))
+			}
+			for _, msg := range validation.IsValidLabelValue(t.Value) {
+				errs = append(errs, field.Invalid(tPath.Child("value"), t.Value, msg))
+			}
+		case corev1.TolerationOpExists:
+			if t.Value != "" {
+				errs = append(errs, field.Invalid(tPath.Child("value"), t.Value,
+					"must be empty when the operator is Exists"))
+			}
+		default:
+			errs = append(errs, field.NotSupported(tPath.Child("operator"), t.Operator,
+				[]string{string(corev1.TolerationOpEqual), string(corev1.TolerationOpExists)}))
+		}
+
+		switch t.Effect {
+		case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			errs = append(errs, field.NotSupported(tPath.Child("effect"), t.Effect, []string{
+				string(corev1.TaintEffectNoSchedule),
+				string(corev1.TaintEffectPreferNoSchedule),
+				string(corev1.TaintEffectNoExecute),
+			}))
+		}
+
+		if t.TolerationSeconds != nil && t.Effect != corev1.TaintEffectNoExecute {
+			errs = append(errs, field.Invalid(tPath.Child("tolerationSeconds"), *t.TolerationSeconds,
+				"must only be set when the effect is NoExecute"))
+		}
+	}
+
+	return errs
+}
+
+// validateReservedKey forbids selecting nodes on the labels set as a
+// consequence of a DeviceConfig.
+func validateReservedKey(k string, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if k == nodetargets.TargetLabel {
+		errs = append(errs, field.Forbidden(path, fmt.Sprintf("label key %q is reserved", k)))
+	}
+
+	for _, prefix := range reservedNodeSelectorKeyPrefixes {
+		if strings.HasPrefix(k, prefix) {
+			errs = append(errs, field.Forbidden(path,
+				fmt.Sprintf("label keys prefixed with %q are reserved", prefix)))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,569 @@
+/*
+Copyright 2022.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	gomock "github.com/golang/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodeselector"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+)
+
+var _ = Describe("Validator", func() {
+	var (
+		ctx context.Context
+		nsv *nodeselector.MockValidator
+		v   *validator
+		dc  *hlaiv1beta1.DeviceConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		nsv = nodeselector.NewMockValidator(gomock.NewController(GinkgoT()))
+		v = NewValidator(nsv)
+		dc = &hlaiv1beta1.DeviceConfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "a-device-config",
+				Namespace: "a-namespace",
+			},
+			Spec: hlaiv1beta1.DeviceConfigSpec{
+				Driver: hlaiv1beta1.DriverSpec{
+					Image:   "registry.example.com:5000/habana-ai/driver",
+					Version: "1.6.0-439",
+				},
+				NodeSelector: map[string]string{"habana.ai/hpu.gaudi.present": "true"},
+			},
+		}
+	})
+
+	Describe("ValidateCreate", func() {
+		Context("with a valid DeviceConfig", func() {
+			It("should not return an error", func() {
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with a DeviceConfig without driver image", func() {
+			It("should not return an error", func() {
+				dc.Spec.Driver.Image = ""
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with a DeviceConfig overriding the operand images", func() {
+			It("should not return an error", func() {
+				dc.Spec.DevicePlugin.Image = "registry.example.com:5000/habana-ai/device-plugin:1.6.0"
+				dc.Spec.NodeLabeler.Image = "registry.example.com/habana-ai/node-labeler"
+				dc.Spec.NodeMetrics.Image = "registry.example.com/habana-ai/node-metrics@sha256:" +
+					"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with an object that is not a DeviceConfig", func() {
+			It("should return an error", func() {
+				Expect(v.ValidateCreate(ctx, &corev1.Node{})).To(HaveOccurred())
+			})
+		})
+
+		DescribeTable("with an invalid spec",
+			func(mutate func(*hlaiv1beta1.DeviceConfig), field string) {
+				mutate(dc)
+
+				err := v.ValidateCreate(ctx, dc)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring(field))
+			},
+			Entry("tagged driver image",
+				func(dc *hlaiv1beta1.DeviceConfig) { dc.Spec.Driver.Image = "quay.io/habana/driver:1.6.0" }, "spec.driver.image"),
+			Entry("driver image with digest",
+				func(dc *hlaiv1beta1.DeviceConfig) { dc.Spec.Driver.Image = "quay.io/habana/driver@sha256:abcd" }, "spec.driver.image"),
+			Entry("empty driver version",
+				func(dc *hlaiv1beta1.DeviceConfig) { dc.Spec.Driver.Version = "" }, "spec.driver.version"),
+			Entry("malformed driver version",
+				func(dc *hlaiv1beta1.DeviceConfig) { dc.Spec.Driver.Version = "-1.6.0/439" }, "spec.driver.version"),
+			Entry("malformed device plugin image",
+				func(dc *hlaiv1beta1.DeviceConfig) { dc.Spec.DevicePlugin.Image = "Quay.io/habana/Plugin:1.6.0" }, "spec.devicePlugin.image"),
+			Entry("malformed node labeler image",
+				func(dc *hlaiv1beta1.DeviceConfig) { dc.Spec.NodeLabeler.Image = "quay.io/habana/labeler:" }, "spec.nodeLabeler.image"),
+			Entry("malformed node metrics image",
+				func(dc *hlaiv1beta1.DeviceConfig) { dc.Spec.NodeMetrics.Image = "quay.io/habana/metrics@sha256:xyz" }, "spec.nodeMetrics.image"),
+			Entry("invalid node selector key",
+				func(dc *hlaiv1beta1.DeviceConfig) { dc.Spec.NodeSelector = map[string]string{"a/b/c": "true"} }, "spec.nodeSelector[a/b/c]"),
+			Entry("invalid node selector value",
+				func(dc *hlaiv1beta1.DeviceConfig) { dc.Spec.NodeSelector = map[string]string{"key": "not valid"} }, "spec.nodeSelector[key]"),
+			Entry("reserved node selector key",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.NodeSelector = map[string]string{"kmm.node.kubernetes.io/ns.module.ready": ""}
+				}, "reserved"),
+			Entry("node selector key on the driver version",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.NodeSelector = map[string]string{"habana.ai/driver-version": "1.11.0-587"}
+				}, "spec.nodeSelector[habana.ai/driver-version]"),
+			Entry("unsupported device type",
+				func(dc *hlaiv1beta1.DeviceConfig) { dc.Spec.DeviceType = "goya" }, "spec.deviceType"),
+			Entry("invalid node selector expression",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.NodeSelectorExpressions = []metav1.LabelSelectorRequirement{
+						{Key: "zone", Operator: metav1.LabelSelectorOpIn},
+					}
+				}, "spec.nodeSelectorExpressions[0]"),
+			Entry("reserved node selector expression key",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.NodeSelectorExpressions = []metav1.LabelSelectorRequirement{
+						{Key: nodetargets.TargetLabel, Operator: metav1.LabelSelectorOpExists},
+					}
+				}, "spec.nodeSelectorExpressions[0].key"),
+			Entry("node selector expression key on the driver upgrade state",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.NodeSelectorExpressions = []metav1.LabelSelectorRequirement{
+						{Key: "habana.ai/driver-upgrade-state", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"failed"}},
+					}
+				}, "spec.nodeSelectorExpressions[0].key"),
+			Entry("node affinity without terms",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.NodeAffinity = &corev1.NodeAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{},
+					}
+				}, "spec.nodeAffinity.requiredDuringSchedulingIgnoredDuringExecution.nodeSelectorTerms"),
+			Entry("node affinity on an unsupported field",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.NodeAffinity = &corev1.NodeAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+							NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+								MatchFields: []corev1.NodeSelectorRequirement{
+									{Key: "spec.unschedulable", Operator: corev1.NodeSelectorOpIn, Values: []string{"false"}},
+								},
+							}},
+						},
+					}
+				}, "spec.nodeAffinity.requiredDuringSchedulingIgnoredDuringExecution.nodeSelectorTerms[0]"),
+			Entry("toleration with a value and the Exists operator",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Tolerations = []corev1.Toleration{
+						{Key: "dedicated", Operator: corev1.TolerationOpExists, Value: "hpu"},
+					}
+				}, "spec.tolerations[0].value"),
+			Entry("toleration with an unknown effect",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Tolerations = []corev1.Toleration{
+						{Key: "dedicated", Value: "hpu", Effect: "NoScheduling"},
+					}
+				}, "spec.tolerations[0].effect"),
+			Entry("kernel mapping without kernel",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{{ContainerImage: "quay.io/habana/driver:1.6.0"}}
+				}, "spec.driver.kernelMappings[0]"),
+			Entry("kernel mapping with a preset and a regexp",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{
+						{Preset: hlaiv1beta1.KernelMappingPresetUbuntu, Regexp: "^.*$"},
+					}
+				}, "spec.driver.kernelMappings[0]"),
+			Entry("unsupported kernel mapping preset",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{{Preset: "debian"}}
+				}, "spec.driver.kernelMappings[0].preset"),
+			Entry("invalid kernel mapping regexp",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{{Regexp: "^5.15.0-(76$"}}
+				}, "spec.driver.kernelMappings[0].regexp"),
+			Entry("kernel mapping build with an unnamed Dockerfile ConfigMap",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{
+						{Literal: "5.15.0-76-generic", Build: &hlaiv1beta1.DriverBuildSpec{
+							DockerfileConfigMap: &corev1.LocalObjectReference{},
+						}},
+					}
+				}, "spec.driver.kernelMappings[0].build.dockerfileConfigMap.name"),
+			Entry("driver build argument without name",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.Build = &hlaiv1beta1.DriverBuildSpec{BuildArgs: []hlaiv1beta1.BuildArg{{Value: "value"}}}
+				}, "spec.driver.build.buildArgs[0].name"),
+			Entry("unnamed image repository secret",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.ImageRepoSecret = &corev1.LocalObjectReference{}
+				}, "spec.driver.imageRepoSecret.name"),
+			Entry("driver signing without unsigned image nor build",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.Sign = &hlaiv1beta1.DriverSignSpec{
+						KeySecret:  corev1.LocalObjectReference{Name: "signing-key"},
+						CertSecret: corev1.LocalObjectReference{Name: "signing-cert"},
+					}
+				}, "spec.driver.sign.unsignedImage"),
+			Entry("driver signing without key secret",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.Sign = &hlaiv1beta1.DriverSignSpec{
+						UnsignedImage: "${DRIVER_IMAGE}:${DRIVER_VERSION}-${KERNEL_FULL_VERSION}-unsigned",
+						CertSecret:    corev1.LocalObjectReference{Name: "signing-cert"},
+					}
+				}, "spec.driver.sign.keySecret.name"),
+			Entry("driver signing of an empty file path",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.Build = &hlaiv1beta1.DriverBuildSpec{}
+					dc.Spec.Driver.Sign = &hlaiv1beta1.DriverSignSpec{
+						KeySecret:   corev1.LocalObjectReference{Name: "signing-key"},
+						CertSecret:  corev1.LocalObjectReference{Name: "signing-cert"},
+						FilesToSign: []string{""},
+					}
+				}, "spec.driver.sign.filesToSign[0]"),
+			Entry("modprobe parameter without value",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{Parameters: []string{"timeout_locked"}}
+				}, "spec.driver.modprobe.parameters[0]"),
+			Entry("modprobe parameter with shell metacharacters",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{Parameters: []string{"timeout_locked=30;reboot"}}
+				}, "spec.driver.modprobe.parameters[0]"),
+			Entry("modprobe argument with whitespace",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{
+						Args: &hlaiv1beta1.ModprobeArgs{Unload: []string{"-r -v"}},
+					}
+				}, "spec.driver.modprobe.args.unload[0]"),
+			Entry("modprobe raw arguments with parameters",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{
+						Parameters: []string{"timeout_locked=30"},
+						RawArgs:    &hlaiv1beta1.ModprobeArgs{Load: []string{"habanalabs"}},
+					}
+				}, "spec.driver.modprobe.rawArgs"),
+			Entry("relative firmware path",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{FirmwarePath: "lib/firmware"}
+				}, "spec.driver.modprobe.firmwarePath"),
+			Entry("unsupported companion module",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.CompanionModules = []hlaiv1beta1.CompanionModule{"habanalabs_xx"}
+				}, "spec.driver.companionModules[0]"),
+			Entry("companion modules on Gaudi",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.DeviceType = hlaiv1beta1.DeviceTypeGaudi
+					dc.Spec.Driver.CompanionModules = []hlaiv1beta1.CompanionModule{hlaiv1beta1.CompanionModuleCN}
+				}, "spec.driver.companionModules"),
+			Entry("companion modules on prebuilt images",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.DeviceType = hlaiv1beta1.DeviceTypeGaudi2
+					dc.Spec.Driver.CompanionModules = []hlaiv1beta1.CompanionModule{hlaiv1beta1.CompanionModuleCN}
+					dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{
+						{Literal: "5.15.0-76-generic", Build: &hlaiv1beta1.DriverBuildSpec{}},
+						{Literal: "5.15.0-78-generic"},
+					}
+				}, "spec.driver.companionModules"),
+			Entry("no unavailable node during upgrades",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					maxUnavailable := intstr.FromInt(0)
+					dc.Spec.Driver.UpgradePolicy = &hlaiv1beta1.DriverUpgradePolicySpec{MaxUnavailable: &maxUnavailable}
+				}, "spec.driver.upgradePolicy.maxUnavailable"),
+			Entry("invalid percentage of unavailable nodes during upgrades",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					maxUnavailable := intstr.FromString("150%")
+					dc.Spec.Driver.UpgradePolicy = &hlaiv1beta1.DriverUpgradePolicySpec{MaxUnavailable: &maxUnavailable}
+				}, "spec.driver.upgradePolicy.maxUnavailable"),
+			Entry("no canary node",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					nodes := intstr.FromString("0%")
+					dc.Spec.Driver.Canary = &hlaiv1beta1.DriverCanarySpec{Nodes: &nodes}
+				}, "spec.driver.canary.nodes"),
+			Entry("invalid canary node selector",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.Driver.Canary = &hlaiv1beta1.DriverCanarySpec{NodeSelector: map[string]string{"canary": "not valid"}}
+				}, "spec.driver.canary.nodeSelector"),
+			Entry("no failed node to roll back",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					threshold := intstr.FromInt(0)
+					dc.Spec.Driver.Rollback = &hlaiv1beta1.DriverRollbackSpec{FailedNodesThreshold: &threshold}
+				}, "spec.driver.rollback.failedNodesThreshold"),
+			Entry("invalid maintenance window schedule",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.MaintenanceWindows = []hlaiv1beta1.MaintenanceWindow{{Schedule: "0 2 * *", DurationSeconds: 3600}}
+				}, "spec.maintenanceWindows[0].schedule"),
+			Entry("maintenance window that never opens",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.MaintenanceWindows = []hlaiv1beta1.MaintenanceWindow{{Schedule: "0 0 30 2 *", DurationSeconds: 3600}}
+				}, "spec.maintenanceWindows[0].schedule"),
+			Entry("unknown maintenance window time zone",
+				func(dc *hlaiv1beta1.DeviceConfig) {
+					dc.Spec.MaintenanceWindows = []hlaiv1beta1.MaintenanceWindow{
+						{Schedule: "0 2 * * 6", DurationSeconds: 3600, TimeZone: "Mars/Olympus_Mons"},
+					}
+				}, "spec.maintenanceWindows[0].timeZone"),
+		)
+
+		Context("with a canary", func() {
+			It("should not return an error", func() {
+				nodes := intstr.FromInt(2)
+				dc.Spec.Driver.Canary = &hlaiv1beta1.DriverCanarySpec{
+					Nodes:        &nodes,
+					NodeSelector: map[string]string{"example.com/canary": "true"},
+					Promotion:    hlaiv1beta1.CanaryPromotionManual,
+				}
+
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with maintenance windows", func() {
+			It("should not return an error", func() {
+				dc.Spec.MaintenanceWindows = []hlaiv1beta1.MaintenanceWindow{
+					{Schedule: "0 2 * * 6", DurationSeconds: 4 * 3600, TimeZone: "Europe/Paris"},
+					{Schedule: "@monthly", DurationSeconds: 3600},
+				}
+
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with a rollback policy", func() {
+			It("should not return an error", func() {
+				threshold := intstr.FromString("10%")
+				dc.Spec.Driver.Rollback = &hlaiv1beta1.DriverRollbackSpec{FailedNodesThreshold: &threshold}
+
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with an upgrade policy", func() {
+			It("should not return an error", func() {
+				maxUnavailable := intstr.FromString("25%")
+				dc.Spec.Driver.UpgradePolicy = &hlaiv1beta1.DriverUpgradePolicySpec{MaxUnavailable: &maxUnavailable}
+
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with companion modules", func() {
+			It("should not return an error", func() {
+				dc.Spec.DeviceType = hlaiv1beta1.DeviceTypeGaudi2
+				dc.Spec.Driver.CompanionModules = []hlaiv1beta1.CompanionModule{
+					hlaiv1beta1.CompanionModuleEN, hlaiv1beta1.CompanionModuleIB,
+				}
+				dc.Spec.Driver.Build = &hlaiv1beta1.DriverBuildSpec{}
+				dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{
+					Parameters:   []string{"timeout_locked=30"},
+					Args:         &hlaiv1beta1.ModprobeArgs{Load: []string{"-v"}, Unload: []string{"-rv"}},
+					FirmwarePath: "/opt/lib/firmware",
+				}
+
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with a modprobe configuration", func() {
+			It("should not return an error", func() {
+				dc.Spec.Driver.Modprobe = &hlaiv1beta1.DriverModprobeSpec{
+					Parameters:   []string{"timeout_locked=30", "reset_on_lockup=0"},
+					Args:         &hlaiv1beta1.ModprobeArgs{Load: []string{"-v", "--first-time"}, Unload: []string{"-rv"}},
+					DirName:      "/opt",
+					FirmwarePath: "/opt/lib/firmware",
+				}
+
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with driver signing of built images", func() {
+			It("should not require an unsigned image", func() {
+				dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{
+					{Literal: "5.15.0-76-generic", Build: &hlaiv1beta1.DriverBuildSpec{}},
+				}
+				dc.Spec.Driver.Sign = &hlaiv1beta1.DriverSignSpec{
+					KeySecret:  corev1.LocalObjectReference{Name: "signing-key"},
+					CertSecret: corev1.LocalObjectReference{Name: "signing-cert"},
+				}
+
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with kernel mappings", func() {
+			It("should not return an error", func() {
+				dc.Spec.Driver.KernelMappings = []hlaiv1beta1.KernelMapping{
+					{Preset: hlaiv1beta1.KernelMappingPresetRHCOS},
+					{Regexp: `^5\.15\.0-\d+-generic$`, ContainerImage: "${DRIVER_IMAGE}:${DRIVER_VERSION}-jammy"},
+					{
+						Literal: "6.5.0-1015-aws",
+						Build: &hlaiv1beta1.DriverBuildSpec{
+							DockerfileConfigMap: &corev1.LocalObjectReference{Name: "dockerfile"},
+							BuildArgs:           []hlaiv1beta1.BuildArg{{Name: "KEY", Value: "value"}},
+						},
+					},
+				}
+				dc.Spec.Driver.Build = &hlaiv1beta1.DriverBuildSpec{
+					BuildArgs: []hlaiv1beta1.BuildArg{{Name: "BUILDER_IMAGE", Value: "registry.example.com/builder"}},
+				}
+				dc.Spec.Driver.ImageRepoSecret = &corev1.LocalObjectReference{Name: "registry-credentials"}
+
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc).Return(nil)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with node selector expressions, a node affinity and tolerations", func() {
+			It("should not return an error", func() {
+				dc.Spec.NodeSelectorExpressions = []metav1.LabelSelectorRequirement{
+					{Key: "zone", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"b"}},
+				}
+				dc.Spec.NodeAffinity = &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+							MatchExpressions: []corev1.NodeSelectorRequirement{
+								{Key: "node-role.kubernetes.io/worker", Operator: corev1.NodeSelectorOpExists},
+							},
+						}},
+					},
+				}
+				dc.Spec.Tolerations = []corev1.Toleration{
+					{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "hpu", Effect: corev1.TaintEffectNoSchedule},
+				}
+				nsv.EXPECT().CheckDeviceConfigForConflictingNodeSelector(ctx, dc)
+
+				Expect(v.ValidateCreate(ctx, dc)).To(Succeed())
+			})
+		})
+
+		Context("with a conflicting node selector", func() {
+			It("should return an invalid error naming the conflict", func() {
+				nsv.EXPECT().
+					CheckDeviceConfigForConflictingNodeSelector(ctx, dc).
+					Return(fmt.Errorf("%w: test-node", nodeselector.ErrConflictingNodeSelector))
+
+				err := v.ValidateCreate(ctx, dc)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(And(
+					ContainSubstring("spec.nodeSelector"),
+					ContainSubstring("test-node"),
+				))
+			})
+		})
+
+		Context("with a node selector validation error", func() {
+			It("should return an internal error", func() {
+				nsv.EXPECT().
+					CheckDeviceConfigForConflictingNodeSelector(ctx, dc).
+					Return(errors.New("some-error"))
+
+				err := v.ValidateCreate(ctx, dc)
+				Expect(apierrors.IsInternalError(err)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("ValidateUpdate", func() {
+		var oldDC *hlaiv1beta1.DeviceConfig
+
+		BeforeEach(func() {
+			oldDC = dc.DeepCopy()
+		})
+
+		Context("without a node selector change", func() {
+			It("should not check for conflicts", func() {
+				dc.Spec.Driver.Version = "1.7.0-100"
+
+				Expect(v.ValidateUpdate(ctx, oldDC, dc)).To(Succeed())
+			})
+
+			It("should still reject an invalid spec", func() {
+				dc.Spec.Driver.Version = ""
+
+				Expect(apierrors.IsInvalid(v.ValidateUpdate(ctx, oldDC, dc))).To(BeTrue())
+			})
+		})
+
+		Context("with a node selector change", func() {
+			It("should check for conflicts", func() {
+				dc.Spec.NodeSelector = map[string]string{"other": "label"}
+				nsv.EXPECT().
+					CheckDeviceConfigForConflictingNodeSelector(ctx, dc).
+					Return(nodeselector.ErrConflictingNodeSelector)
+
+				Expect(apierrors.IsInvalid(v.ValidateUpdate(ctx, oldDC, dc))).To(BeTrue())
+			})
+		})
+
+		Context("with a device type change", func() {
+			It("should check for conflicts", func() {
+				dc.Spec.DeviceType = hlaiv1beta1.DeviceTypeGaudi3
+				nsv.EXPECT().
+					CheckDeviceConfigForConflictingNodeSelector(ctx, dc).
+					Return(nodeselector.ErrConflictingNodeSelector)
+
+				Expect(apierrors.IsInvalid(v.ValidateUpdate(ctx, oldDC, dc))).To(BeTrue())
+			})
+		})
+
+		Context("with a node selector expressions change", func() {
+			It("should check for conflicts", func() {
+				dc.Spec.NodeSelectorExpressions = []metav1.LabelSelectorRequirement{
+					{Key: "zone", Operator: metav1.LabelSelectorOpExists},
+				}
+				nsv.EXPECT().
+					CheckDeviceConfigForConflictingNodeSelector(ctx, dc).
+					Return(nodeselector.ErrConflictingNodeSelector)
+
+				Expect(apierrors.IsInvalid(v.ValidateUpdate(ctx, oldDC, dc))).To(BeTrue())
+			})
+		})
+
+		Context("with a DeviceConfig being deleted", func() {
+			It("should not return an error", func() {
+				now := metav1.NewTime(time.Now())
+				dc.DeletionTimestamp = &now
+				dc.Spec.Driver.Version = ""
+
+				Expect(v.ValidateUpdate(ctx, oldDC, dc)).To(Succeed())
+			})
+		})
+	})
+
+	Describe("ValidateDelete", func() {
+		It("should not return an error", func() {
+			Expect(v.ValidateDelete(ctx, dc)).To(Succeed())
+		})
+	})
+})
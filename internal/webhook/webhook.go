@@ -0,0 +1,34 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodeselector"
+)
+
+// SetupWebhookWithManager registers the DeviceConfig admission webhooks
+// with the Manager's webhook server.
+func SetupWebhookWithManager(mgr ctrl.Manager, nsv nodeselector.Validator) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&hlaiv1beta1.DeviceConfig{}).
+		WithDefaulter(NewDefaulter()).
+		WithValidator(NewValidator(nsv)).
+		Complete()
+}
@@ -20,28 +20,45 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	// The time zones of the maintenance windows are loaded from the binary,
+	// since the base image has no time zone database.
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/klog/v2"
 	"k8s.io/klog/v2/klogr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 
 	kmmv1beta1 "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
 
 	hlaiv1alpha1 "github.com/HabanaAI/habana-ai-operator/api/v1alpha1"
+	hlaiv1beta1 "github.com/HabanaAI/habana-ai-operator/api/v1beta1"
 	"github.com/HabanaAI/habana-ai-operator/controllers"
 	"github.com/HabanaAI/habana-ai-operator/internal/conditions"
+	"github.com/HabanaAI/habana-ai-operator/internal/constants"
+	"github.com/HabanaAI/habana-ai-operator/internal/deletion"
 	"github.com/HabanaAI/habana-ai-operator/internal/finalizers"
+	"github.com/HabanaAI/habana-ai-operator/internal/instance"
 	"github.com/HabanaAI/habana-ai-operator/internal/module"
 	nodeLabeler "github.com/HabanaAI/habana-ai-operator/internal/node/labeler"
 	nodeMetrics "github.com/HabanaAI/habana-ai-operator/internal/node/metrics"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodeselector"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodestatus"
+	"github.com/HabanaAI/habana-ai-operator/internal/nodetargets"
+	"github.com/HabanaAI/habana-ai-operator/internal/preflight"
+	"github.com/HabanaAI/habana-ai-operator/internal/prepull"
+	"github.com/HabanaAI/habana-ai-operator/internal/upgrade"
+	"github.com/HabanaAI/habana-ai-operator/internal/webhook"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -53,6 +70,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(hlaiv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(hlaiv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 
 	utilruntime.Must(kmmv1beta1.AddToScheme(scheme))
@@ -108,6 +126,13 @@ func main() {
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "c572fd62.habana.ai",
 		Namespace:              watchNamespace,
+		// Only the operand pods are watched and cached. The KMM pods, and
+		// the workloads drained from the nodes, are read from the API server.
+		NewCache: cache.BuilderWithOptions(cache.Options{
+			SelectorsByObject: cache.SelectorsByObject{
+				&corev1.Pod{}: {Label: labels.SelectorFromSet(labels.Set{instance.NameLabel: constants.HabanaAIOperatorName})},
+			},
+		}),
 	})
 	if err != nil {
 		setupLogger.Error(err, "unable to start manager")
@@ -116,19 +141,33 @@ func main() {
 
 	c := mgr.GetClient()
 	s := mgr.GetScheme()
+	recorder := mgr.GetEventRecorderFor("deviceconfig-controller")
 
 	mr := module.NewReconciler(c, s)
-	nmr := nodeMetrics.NewReconciler(c, s)
-	nlr := nodeLabeler.NewReconciler(c, s)
+	pr := preflight.NewReconciler(c, s, mr)
+	ppr := prepull.NewReconciler(c, s, recorder)
+	ur := upgrade.NewReconciler(c, mgr.GetAPIReader(), recorder)
+	nmr := nodeMetrics.NewReconciler(c, s, recorder)
+	nlr := nodeLabeler.NewReconciler(c, s, recorder)
+	dr := deletion.NewReconciler(c, mgr.GetAPIReader(), recorder, mr, nlr, nmr)
 	fu := finalizers.NewUpdater(c)
 	cu := conditions.NewUpdater(c)
-	nsv := controllers.NewNodeSelectorValidator(c)
-	dcc := controllers.NewReconciler(c, s, mgr.GetEventRecorderFor("deviceconfig-controller"), mr, nmr, nlr, fu, cu, nsv)
+	nsv := nodeselector.NewValidator(c)
+	nsu := nodestatus.NewUpdater(c, mgr.GetAPIReader())
+	ntu := nodetargets.NewUpdater(c, mgr.GetAPIReader(), recorder)
+	dcc := controllers.NewReconciler(c, s, recorder, mr, pr, ppr, ur, nmr, nlr, dr, fu, cu, nsv, nsu, ntu)
 
 	if err := dcc.SetupWithManager(mgr); err != nil {
 		setupLogger.Error(err, "unable to create controller", "controller", "DeviceConfig")
 		os.Exit(1)
 	}
+
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err := webhook.SetupWebhookWithManager(mgr, nsv); err != nil {
+			setupLogger.Error(err, "unable to create webhook", "webhook", "DeviceConfig")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {